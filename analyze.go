@@ -0,0 +1,101 @@
+package bmp
+
+import (
+	"image"
+	"image/color"
+)
+
+// Analyze scans img and returns the number of distinct colors, whether any
+// pixel is translucent and whether every pixel is a shade of gray. It
+// helps deciding on a format or a depth; DepthAuto is based on it.
+func Analyze(img image.Image) (uniqueColors int, hasAlpha bool, isGray bool) {
+	s := analyze(img, -1)
+	return s.unique, s.alpha, s.gray
+}
+
+// colorStats summarizes the colors of an image.
+type colorStats struct {
+	// colors holds the distinct colors in the order they first appear, nil
+	// if there are more than the limit given to analyze
+	colors color.Palette
+	unique int
+	alpha  bool
+	gray   bool
+}
+
+// analyze computes the color statistics of m, counting distinct colors up
+// to limit + 1 if limit is not negative.
+func analyze(m image.Image, limit int) colorStats {
+	s := colorStats{gray: true}
+	seen := make(map[color.RGBA]struct{})
+
+	add := func(c color.RGBA) {
+		if c.A != 0xff {
+			s.alpha = true
+		}
+		if c.R != c.G || c.G != c.B {
+			s.gray = false
+		}
+
+		if seen == nil {
+			return
+		}
+		if _, ok := seen[c]; ok {
+			return
+		}
+		if limit >= 0 && len(seen) == limit {
+			// stop tracking colors past the limit
+			seen, s.colors = nil, nil
+			s.unique = limit + 1
+			return
+		}
+		seen[c] = struct{}{}
+		s.colors = append(s.colors, c)
+		s.unique++
+	}
+
+	b := m.Bounds()
+	if p, ok := m.(*image.Paletted); ok {
+		// visit the colors of the used entries once
+		var used [256]bool
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for _, idx := range p.Pix[p.PixOffset(b.Min.X, y):p.PixOffset(b.Max.X, y)] {
+				if !used[idx] {
+					used[idx] = true
+					var c color.RGBA
+					if int(idx) < len(p.Palette) {
+						c = color.RGBAModel.Convert(p.Palette[idx]).(color.RGBA)
+					}
+					add(c)
+				}
+			}
+		}
+		return s
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			add(color.RGBAModel.Convert(m.At(x, y)).(color.RGBA))
+		}
+	}
+
+	return s
+}
+
+// autoDepth returns the smallest depth representing m exactly, with the
+// palette to use for paletted depths.
+func autoDepth(m image.Image) (int, color.Palette) {
+	s := analyze(m, 256)
+	switch {
+	case s.alpha:
+		return 32, nil
+	case s.colors == nil:
+		return 24, nil
+	case len(s.colors) <= 2:
+		return 1, s.colors
+	case len(s.colors) <= 16:
+		return 4, s.colors
+	}
+
+	return 8, s.colors
+}
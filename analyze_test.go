@@ -0,0 +1,41 @@
+package bmp
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i % 5)
+	}
+
+	translucent := testImage(3, 1)
+	translucent.SetNRGBA(2, 0, color.NRGBA{0, 0, 0, 0x80})
+
+	paletted := testPaletted(4, 1, 16)
+	paletted.Palette[1] = paletted.Palette[0]
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		unique int
+		alpha  bool
+		gray   bool
+	}{
+		{"gray", gray, 5, false, true},
+		{"true color", testImage(20, 20), 400, false, false},
+		{"translucent", translucent, 3, true, false},
+		// indices 0 and 1 share a color
+		{"paletted", paletted, 1, false, false},
+	}
+
+	for _, tt := range tests {
+		unique, alpha, isGray := Analyze(tt.img)
+		if unique != tt.unique || alpha != tt.alpha || isGray != tt.gray {
+			t.Errorf("%s: Analyze = %d, %v, %v, expected %d, %v, %v", tt.name, unique, alpha, isGray, tt.unique, tt.alpha, tt.gray)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+// arrayHeaderLen is the length of the header of each image of an OS/2
+// bitmap array (BITMAPARRAYFILEHEADER), without the file header following
+// it.
+const arrayHeaderLen = 14
+
+// EncodeAll writes images to w as an OS/2 bitmap array ("BA"), the
+// container of multi-resolution icons and pointers of OS/2, each image being
+// encoded by an Encoder configured by opts. HeaderCore is the header
+// version read by most consumers of bitmap arrays.
+//
+// Each image is written as a bitmap array header, chained to the next one,
+// followed by the file header and the DIB header of the image. As in the
+// file headers of OS/2, the size field holds the length of the headers and
+// the pixel offset is counted from the start of the array.
+func EncodeAll(w io.Writer, images []image.Image, opts ...EncodeOption) error {
+	if len(images) == 0 {
+		return errors.New("bmp: bitmap array requires at least one image")
+	}
+
+	var buf bytes.Buffer
+	files := make([][]byte, len(images))
+	for i, m := range images {
+		buf.Reset()
+		e := NewEncoder(&buf)
+		for _, opt := range opts {
+			opt(e)
+		}
+		if err := e.Encode(m); err != nil {
+			return err
+		}
+		files[i] = append([]byte(nil), buf.Bytes()...)
+	}
+
+	pos := 0
+	for i, b := range files {
+		start := pos + arrayHeaderLen
+		pos = start + len(b)
+		next := pos
+		if i == len(files)-1 {
+			next = 0
+		}
+
+		const fileHeaderLen = 14
+		dibLen := int(binary.LittleEndian.Uint32(b[fileHeaderLen:]))
+
+		var h [arrayHeaderLen]byte
+		copy(h[0:2], "BA")
+		binary.LittleEndian.PutUint32(h[2:6], uint32(arrayHeaderLen+fileHeaderLen+dibLen))
+		binary.LittleEndian.PutUint32(h[6:10], uint32(next))
+		// cxDisplay and cyDisplay are zero for device independent images
+
+		binary.LittleEndian.PutUint32(b[2:6], uint32(fileHeaderLen+dibLen))
+		offset := binary.LittleEndian.Uint32(b[10:14])
+		binary.LittleEndian.PutUint32(b[10:14], offset+uint32(start))
+
+		if _, err := w.Write(h[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
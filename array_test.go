@@ -0,0 +1,64 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestEncodeAll(t *testing.T) {
+	images := []image.Image{testPaletted(32, 32, 16), testPaletted(16, 16, 16)}
+
+	var buf bytes.Buffer
+	err := EncodeAll(&buf, images, func(e *Encoder) {
+		e.SetDepth(4)
+		e.SetHeader(HeaderCore)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	pos := 0
+	for i, m := range images {
+		h := b[pos:]
+		if string(h[:2]) != "BA" {
+			t.Fatalf("image %d: signature %q, want BA", i, h[:2])
+		}
+		if n := binary.LittleEndian.Uint32(h[2:6]); n != 14+14+12 {
+			t.Errorf("image %d: header size %d, want 40", i, n)
+		}
+		if string(h[14:16]) != "BM" {
+			t.Fatalf("image %d: file signature %q, want BM", i, h[14:16])
+		}
+
+		// the pixel offset is counted from the start of the array
+		file := append([]byte(nil), h[14:]...)
+		offset := binary.LittleEndian.Uint32(file[10:14])
+		binary.LittleEndian.PutUint32(file[10:14], offset-uint32(pos+14))
+		got, err := Decode(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("image %d: %v", i, err)
+		}
+		if !sameImage(got, m) {
+			t.Errorf("image %d differs", i)
+		}
+
+		next := int(binary.LittleEndian.Uint32(h[6:10]))
+		if i == len(images)-1 {
+			if next != 0 {
+				t.Errorf("last image: next offset %d, want 0", next)
+			}
+			break
+		}
+		if next <= pos || next >= len(b) {
+			t.Fatalf("image %d: next offset %d out of range", i, next)
+		}
+		pos = next
+	}
+
+	if err := EncodeAll(&buf, nil); err == nil {
+		t.Error("empty array: no error")
+	}
+}
@@ -0,0 +1,151 @@
+package bmp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Job is a conversion run by a Batch: it decodes the BMP image of Src with
+// Options and writes it to Dst with an Encoder configured by Encode.
+type Job struct {
+	// Name identifies the job in errors, such as the name of the file.
+	Name    string
+	Src     io.Reader
+	Dst     io.Writer
+	Options []Option
+	Encode  []EncodeOption
+}
+
+// JobError reports the failure of a job.
+type JobError struct {
+	// Index is the position of the job in the Batch.
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e *JobError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by Batch.Run when jobs failed, in the order of the
+// jobs.
+type BatchError []*JobError
+
+func (e BatchError) Error() string {
+	if len(e) == 1 {
+		return fmt.Sprintf("bmp: 1 job failed: %v", e[0])
+	}
+
+	return fmt.Sprintf("bmp: %d jobs failed, first: %v", len(e), e[0])
+}
+
+// BatchProgress is the aggregated progress of a Batch.
+type BatchProgress struct {
+	// Done is the number of finished jobs, including the Failed ones.
+	Done, Failed, Total int
+}
+
+// Batch runs conversion jobs on a pool of goroutines.
+type Batch struct {
+	// Workers is the number of jobs run in parallel, or GOMAXPROCS if not
+	// positive.
+	Workers int
+	// Progress, if set, is called after each finished job. Calls are
+	// serialized.
+	Progress func(p BatchProgress)
+
+	jobs []Job
+}
+
+// Add queues j.
+func (b *Batch) Add(j Job) {
+	b.jobs = append(b.jobs, j)
+}
+
+// Run runs the queued jobs and empties the queue. Jobs not started when ctx
+// is done fail with the error of ctx. It returns a BatchError if any job
+// failed.
+func (b *Batch) Run(ctx context.Context) error {
+	jobs := b.jobs
+	b.jobs = nil
+
+	workers := b.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		progress = BatchProgress{Total: len(jobs)}
+	)
+	errs := make([]error, len(jobs))
+	next := make(chan int)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for n := range next {
+				err := ctx.Err()
+				if err == nil {
+					err = jobs[n].run()
+				}
+				errs[n] = err
+
+				mu.Lock()
+				progress.Done++
+				if err != nil {
+					progress.Failed++
+				}
+				if b.Progress != nil {
+					b.Progress(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for n := range jobs {
+		next <- n
+	}
+	close(next)
+	wg.Wait()
+
+	var failed BatchError
+	for n, err := range errs {
+		if err != nil {
+			failed = append(failed, &JobError{Index: n, Name: jobs[n].Name, Err: err})
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+
+	return nil
+}
+
+func (j *Job) run() error {
+	m, err := Decode(j.Src, j.Options...)
+	if err != nil {
+		return err
+	}
+
+	e := NewEncoder(j.Dst)
+	for _, opt := range j.Encode {
+		opt(e)
+	}
+
+	return e.Encode(m)
+}
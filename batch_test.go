@@ -0,0 +1,72 @@
+package bmp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	src := encodeRGBA(t, 3, 2)
+
+	var (
+		b     Batch
+		dst   [5]bytes.Buffer
+		calls []BatchProgress
+	)
+	b.Workers = 2
+	b.Progress = func(p BatchProgress) {
+		calls = append(calls, p)
+	}
+	for i := range dst {
+		j := Job{Name: string(rune('a' + i)), Src: bytes.NewReader(src), Dst: &dst[i]}
+		if i == 3 {
+			j.Src = bytes.NewReader(src[:30])
+		}
+		j.Encode = []EncodeOption{func(e *Encoder) { e.SetDepth(32) }}
+		b.Add(j)
+	}
+
+	err := b.Run(context.Background())
+	var be BatchError
+	if !errors.As(err, &be) || len(be) != 1 || be[0].Index != 3 || be[0].Name != "d" || !errors.Is(be[0], io.ErrUnexpectedEOF) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(calls) != 5 || calls[4] != (BatchProgress{Done: 5, Failed: 1, Total: 5}) {
+		t.Errorf("unexpected progress: %+v", calls)
+	}
+	for i := range dst {
+		if i == 3 {
+			continue
+		}
+		m, err := Decode(&dst[i])
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if m.Bounds() != image.Rect(0, 0, 3, 2) {
+			t.Errorf("%d: bounds %v", i, m.Bounds())
+		}
+	}
+
+	// the queue is empty after Run
+	if err := b.Run(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatchCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var b Batch
+	b.Add(Job{Name: "a", Src: bytes.NewReader(encodeRGBA(t, 1, 1)), Dst: ioutil.Discard})
+	err := b.Run(ctx)
+	if !errors.Is(err.(BatchError)[0], context.Canceled) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
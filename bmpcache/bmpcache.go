@@ -0,0 +1,138 @@
+// Package bmpcache memoizes decoded BMP images, for servers decoding the
+// same files over and over.
+package bmpcache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/entooone/go-bmp"
+)
+
+// key identifies an input by the hash of its content or, for files, by
+// their path, modification time and size.
+type key struct {
+	hash    [sha256.Size]byte
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+type entry struct {
+	key key
+	img image.Image
+}
+
+// Cache is a least recently used cache of decoded images. The cached
+// images are shared between the callers, which must not modify them. It is
+// safe for concurrent use.
+type Cache struct {
+	max  int
+	opts []bmp.Option
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[key]*list.Element
+}
+
+// New returns a Cache holding up to max images, decoded with opts.
+func New(max int, opts ...bmp.Option) *Cache {
+	return &Cache{
+		max:   max,
+		opts:  opts,
+		lru:   list.New(),
+		items: make(map[key]*list.Element),
+	}
+}
+
+// Decode reads r to the end and returns the image of the content, decoding
+// it only if no image of the same content is cached.
+func (c *Cache) Decode(r io.Reader) (image.Image, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.load(key{hash: sha256.Sum256(b)}, func() (image.Image, error) {
+		return bmp.Decode(bytes.NewReader(b), c.opts...)
+	})
+}
+
+// DecodeFile returns the image of the named file, decoding it only if it
+// was modified since it was cached. Files are not read, nor hashed, on a
+// hit.
+func (c *Cache) DecodeFile(name string) (image.Image, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.load(key{path: name, modTime: fi.ModTime(), size: fi.Size()}, func() (image.Image, error) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return bmp.Decode(f, c.opts...)
+	})
+}
+
+// load returns the image cached under k, or the one returned by decode
+// otherwise. Failures are not cached.
+func (c *Cache) load(k key, decode func() (image.Image, error)) (image.Image, error) {
+	c.mu.Lock()
+	if e, ok := c.items[k]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*entry).img, nil
+	}
+	c.mu.Unlock()
+
+	// decoded without the lock, so that a concurrent miss on the same key
+	// decodes it twice
+	m, err := decode()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[k]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*entry).img, nil
+	}
+	c.items[k] = c.lru.PushFront(&entry{key: k, img: m})
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.items, e.Value.(*entry).key)
+	}
+
+	return m, nil
+}
+
+// Len returns the number of cached images.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Purge removes every cached image.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.items = make(map[key]*list.Element)
+}
@@ -0,0 +1,103 @@
+package bmpcache
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/entooone/go-bmp"
+)
+
+func testBMP(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := bmp.NewEncoder(&buf).Encode(image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	c := New(2)
+	a, b, d := testBMP(t, 1, 1), testBMP(t, 2, 1), testBMP(t, 3, 1)
+
+	m1, err := c.Decode(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := c.Decode(bytes.NewReader(append([]byte(nil), a...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m1 != m2 {
+		t.Error("identical content decoded twice")
+	}
+
+	// a is more recently used than b and survives d
+	for _, in := range [][]byte{b, a, d} {
+		if _, err := c.Decode(bytes.NewReader(in)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", c.Len())
+	}
+	if m, _ := c.Decode(bytes.NewReader(a)); m != m1 {
+		t.Error("least recently used image evicted")
+	}
+
+	if _, err := c.Decode(bytes.NewReader(a[:20])); err == nil {
+		t.Error("expected error")
+	}
+	if c.Len() != 2 {
+		t.Errorf("failure cached")
+	}
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d after Purge", c.Len())
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bmpcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "a.bmp")
+	if err := ioutil.WriteFile(name, testBMP(t, 2, 2), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(4)
+	m1, err := c.DecodeFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2, _ := c.DecodeFile(name); m1 != m2 {
+		t.Error("unmodified file decoded twice")
+	}
+
+	if err := ioutil.WriteFile(name, testBMP(t, 3, 2), 0666); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+	m3, err := c.DecodeFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m3.Bounds().Dx() != 3 {
+		t.Errorf("stale image returned: %v", m3.Bounds())
+	}
+}
@@ -0,0 +1,137 @@
+// Package bmphttp serves and accepts BMP images over HTTP.
+package bmphttp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/entooone/go-bmp"
+)
+
+// ErrUnsupportedType is returned by DecodeRequest when the request body is
+// not a BMP image.
+var ErrUnsupportedType = errors.New("bmphttp: request body is not a BMP image")
+
+// MaxBodySize is the largest request body DecodeRequest reads, the
+// MaxFileSize of bmp.UntrustedLimits.
+const MaxBodySize = 1 << 29
+
+// DecodeRequest decodes the BMP image in the body of r, of at most
+// MaxBodySize bytes, with bmp.WithUntrusted followed by opts, which can
+// relax it. The Content-Type header must be image/bmp or image/x-bmp, or be
+// missing or generic, in which case the body is sniffed.
+func DecodeRequest(r *http.Request, opts ...bmp.Option) (image.Image, error) {
+	opts = append([]bmp.Option{bmp.WithUntrusted()}, opts...)
+	body := http.MaxBytesReader(nil, r.Body, MaxBodySize)
+
+	typ, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch typ {
+	case bmp.ContentTypeBMP, "image/x-bmp":
+		return bmp.Decode(body, opts...)
+	case "", "application/octet-stream":
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	var prefix [2]byte
+	n, err := io.ReadFull(body, prefix[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if typ, _ := bmp.SniffContentType(prefix[:n]); typ != bmp.ContentTypeBMP {
+		return nil, ErrUnsupportedType
+	}
+
+	return bmp.Decode(io.MultiReader(bytes.NewReader(prefix[:n]), body), opts...)
+}
+
+// bodyTooLarge reports whether err comes from a body read past the limit
+// of http.MaxBytesReader.
+func bodyTooLarge(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err.Error() == "http: request body too large" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StatusCode returns the HTTP status reporting err, as returned by
+// DecodeRequest.
+func StatusCode(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, bmp.ErrLimitExceeded), bodyTooLarge(err):
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+// Upload returns a handler decoding the uploaded BMP image like
+// DecodeRequest before calling fn. Requests that cannot be decoded are answered with the
+// status of StatusCode.
+func Upload(fn func(w http.ResponseWriter, r *http.Request, m image.Image), opts ...bmp.Option) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m, err := DecodeRequest(r, opts...)
+		if err != nil {
+			http.Error(w, err.Error(), StatusCode(err))
+			return
+		}
+
+		fn(w, r, m)
+	})
+}
+
+// AcceptsBMP reports whether the Accept header of r explicitly asks for
+// image/bmp or image/x-bmp.
+func AcceptsBMP(r *http.Request) bool {
+	for _, accept := range r.Header["Accept"] {
+		for _, part := range strings.Split(accept, ",") {
+			typ, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || (typ != bmp.ContentTypeBMP && typ != "image/x-bmp") {
+				continue
+			}
+
+			if q, ok := params["q"]; ok {
+				if v, err := strconv.ParseFloat(q, 64); err != nil || v <= 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// WriteImage writes m as the response to r: as a BMP image if the client
+// asks for it (see AcceptsBMP), as a PNG image otherwise. If configure is
+// not nil, it is called to set up the BMP encoder.
+func WriteImage(w http.ResponseWriter, r *http.Request, m image.Image, configure func(e *bmp.Encoder)) error {
+	w.Header().Add("Vary", "Accept")
+
+	if !AcceptsBMP(r) {
+		w.Header().Set("Content-Type", "image/png")
+		return png.Encode(w, m)
+	}
+
+	enc := bmp.NewEncoder(w)
+	if configure != nil {
+		configure(enc)
+	}
+	w.Header().Set("Content-Type", bmp.ContentTypeBMP)
+
+	return enc.Encode(m)
+}
@@ -0,0 +1,118 @@
+package bmphttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/entooone/go-bmp"
+)
+
+func testBMP(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range m.Pix {
+		m.Pix[i] = 0xff
+	}
+
+	var buf bytes.Buffer
+	if err := bmp.NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestUpload(t *testing.T) {
+	h := Upload(func(w http.ResponseWriter, r *http.Request, m image.Image) {
+		w.Write([]byte(m.Bounds().String()))
+	}, bmp.WithLimits(bmp.Limits{MaxPixels: 100}))
+
+	tests := []struct {
+		typ    string
+		body   []byte
+		status int
+	}{
+		{"image/bmp", testBMP(t, 4, 3), http.StatusOK},
+		{"", testBMP(t, 4, 3), http.StatusOK},
+		{"application/octet-stream", []byte("GIF89a"), http.StatusUnsupportedMediaType},
+		{"image/png", testBMP(t, 4, 3), http.StatusUnsupportedMediaType},
+		{"image/bmp", testBMP(t, 20, 20), http.StatusRequestEntityTooLarge},
+		{"image/bmp", []byte("BM"), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(tt.body))
+		if tt.typ != "" {
+			req.Header.Set("Content-Type", tt.typ)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%q: status = %d, expected %d (%s)", tt.typ, rec.Code, tt.status, rec.Body)
+		}
+		if tt.status == http.StatusOK && rec.Body.String() != "(0,0)-(4,3)" {
+			t.Errorf("%q: body = %q", tt.typ, rec.Body)
+		}
+	}
+}
+
+func TestUploadUntrusted(t *testing.T) {
+	h := Upload(func(w http.ResponseWriter, r *http.Request, m image.Image) {})
+
+	// a header claiming 10000×10000 pixels, past bmp.UntrustedLimits
+	b := testBMP(t, 4, 3)
+	binary.LittleEndian.PutUint32(b[18:22], 10000)
+	binary.LittleEndian.PutUint32(b[22:26], 10000)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", bytes.NewReader(b)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, expected %d (%s)", rec.Code, http.StatusRequestEntityTooLarge, rec.Body)
+	}
+
+	_, err := ioutil.ReadAll(http.MaxBytesReader(nil, ioutil.NopCloser(bytes.NewReader(b)), 10))
+	if code := StatusCode(err); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("StatusCode of a body too large = %d, expected %d", code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestWriteImage(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	m.Set(1, 1, color.White)
+
+	tests := []struct {
+		accept string
+		typ    string
+	}{
+		{"", "image/png"},
+		{"image/*", "image/png"},
+		{"image/png, image/bmp;q=0", "image/png"},
+		{"image/webp, image/bmp;q=0.5", bmp.ContentTypeBMP},
+		{"image/x-bmp", bmp.ContentTypeBMP},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		if err := WriteImage(rec, req, m, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if typ := rec.Header().Get("Content-Type"); typ != tt.typ {
+			t.Errorf("%q: Content-Type = %q, expected %q", tt.accept, typ, tt.typ)
+		}
+		if typ := http.DetectContentType(rec.Body.Bytes()); typ != tt.typ {
+			t.Errorf("%q: body is %q, expected %q", tt.accept, typ, tt.typ)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package bmp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// TestBMPSuite runs the decoder over the bmpsuite corpus
+// (https://entropymine.com/jason/bmpsuite/) if BMPSUITE is set to the
+// directory holding its g, q and b subdirectories. Good files must decode,
+// questionable files must not panic and bad files must be rejected; the
+// outcome of each file and the conformance score are logged.
+func TestBMPSuite(t *testing.T) {
+	dir := os.Getenv("BMPSUITE")
+	if dir == "" {
+		t.Skip("BMPSUITE is not set")
+	}
+
+	var passed, total int
+	for _, set := range []struct {
+		dir    string
+		expect string
+	}{
+		{"g", "decoded"},
+		{"q", ""},
+		{"b", "rejected"},
+	} {
+		names, err := filepath.Glob(filepath.Join(dir, set.dir, "*.bmp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) == 0 {
+			t.Errorf("no files in %s", filepath.Join(dir, set.dir))
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			outcome, detail := decodeOutcome(name)
+			total++
+
+			ok := outcome == set.expect || set.expect == "" && outcome != "panicked"
+			if ok {
+				passed++
+			}
+			t.Logf("%s/%s: %s%s", set.dir, filepath.Base(name), outcome, detail)
+			if !ok && set.dir == "g" {
+				t.Errorf("%s/%s: %s%s", set.dir, filepath.Base(name), outcome, detail)
+			}
+		}
+	}
+
+	t.Logf("conformance: %d of %d files (%.1f%%)", passed, total, 100*float64(passed)/float64(total))
+}
+
+// decodeOutcome decodes the named file and returns "decoded", "rejected"
+// or "panicked", with the error or the panic value as detail.
+func decodeOutcome(name string) (outcome, detail string) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "rejected", fmt.Sprintf(" (%v)", err)
+	}
+	defer f.Close()
+
+	defer func() {
+		if r := recover(); r != nil {
+			outcome, detail = "panicked", fmt.Sprintf(" (%v)", r)
+		}
+	}()
+
+	if _, err := Decode(f); err != nil {
+		return "rejected", fmt.Sprintf(" (%v)", err)
+	}
+
+	return "decoded", ""
+}
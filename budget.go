@@ -0,0 +1,149 @@
+package bmp
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+// Strategy is the way DecodeAuto decodes an image to fit in the memory
+// budget set by WithMemoryBudget.
+type Strategy int
+
+// Decoding strategies, from the preferred one.
+const (
+	// StrategyWhole decodes the whole image at once.
+	StrategyWhole Strategy = iota
+	// StrategyBands decodes bands of full rows, one at a time, in the order
+	// they are stored.
+	StrategyBands
+	// StrategySubsampled decodes every Factor-th pixel of every Factor-th
+	// row of the image, at once.
+	StrategySubsampled
+)
+
+// String returns the name of the strategy.
+func (s Strategy) String() string {
+	switch s {
+	case StrategyWhole:
+		return "whole"
+	case StrategyBands:
+		return "bands"
+	case StrategySubsampled:
+		return "subsampled"
+	}
+
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// Part is a part of an image decoded by DecodeAuto.
+type Part struct {
+	// Strategy is the strategy chosen for the image.
+	Strategy Strategy
+	// Rect is the area of the image covered by the part, in the
+	// coordinates of the whole image.
+	Rect image.Rectangle
+	// Image holds the pixels of Rect. Its bounds are Rect, unless the image
+	// is subsampled, in which case they start at (0, 0) and are Factor
+	// times smaller, rounded up.
+	Image image.Image
+	// Factor is the subsampling factor, 1 unless the image is subsampled.
+	Factor int
+}
+
+// DecodeAuto reads a BMP image from r and calls fn with the parts of the
+// image fitting in the memory budget set by WithMemoryBudget, choosing the
+// strategy from the dimensions of the image: the whole image if it fits,
+// otherwise bands of as many full rows as fit, otherwise the whole image
+// subsampled by the smallest factor that fits. Without a budget, the whole
+// image is decoded.
+//
+// The budget bounds the pixels of each part, which are not reused between
+// parts, not the buffer of a single stored row. WithColorModel only applies
+// to whole images: the pixels of bands and subsampled images are the ones
+// of RowReader rows.
+func DecodeAuto(r io.Reader, fn func(p Part) error, opts ...Option) (err error) {
+	d := NewDecoder(r, opts...)
+	d.path = "auto"
+	defer func() { d.report("auto", err) }()
+	if _, err := d.Config(); err != nil {
+		return err
+	}
+
+	bounds := image.Rect(0, 0, d.width, d.height)
+	budget := d.opts.budget
+	rowLen := int64(d.rowLen())
+	switch {
+	case budget <= 0 || rowLen*int64(d.height) <= budget:
+		m, err := d.Image()
+		if err != nil {
+			return err
+		}
+		return fn(Part{Strategy: StrategyWhole, Rect: bounds, Image: m, Factor: 1})
+	case rowLen <= budget:
+		return d.decodeBands(int(budget/rowLen), fn)
+	}
+
+	pixelLen := int64(d.pixelLen())
+	for k := int64(2); k <= int64(d.width) || k <= int64(d.height); k++ {
+		w, h := (int64(d.width)+k-1)/k, (int64(d.height)+k-1)/k
+		if w*h*pixelLen <= budget {
+			return d.decodeSubsampled(int(k), fn)
+		}
+	}
+
+	return fmt.Errorf("bmp: memory budget is too small for a pixel (got: %d bytes)", budget)
+}
+
+// decodeBands calls fn with bands of n stored rows.
+func (d *Decoder) decodeBands(n int, fn func(p Part) error) error {
+	rr := d.Rows()
+	for lo := 0; lo < d.height; lo += n {
+		hi := lo + n
+		if hi > d.height {
+			hi = d.height
+		}
+		rect := image.Rect(0, lo, d.width, hi)
+		if !d.topDown {
+			rect = image.Rect(0, d.height-hi, d.width, d.height-lo)
+		}
+
+		m, pix, stride := d.newImage(rect, 0)
+		for i := lo; i < hi; i++ {
+			if !rr.Next() {
+				return rr.Err()
+			}
+			copy(pix[(rr.Y()-rect.Min.Y)*stride:], rr.Row())
+		}
+
+		if err := fn(Part{Strategy: StrategyBands, Rect: rect, Image: m, Factor: 1}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeSubsampled calls fn with the image subsampled by k.
+func (d *Decoder) decodeSubsampled(k int, fn func(p Part) error) error {
+	w, h := (d.width+k-1)/k, (d.height+k-1)/k
+	m, pix, stride := d.newImage(image.Rect(0, 0, w, h), 0)
+	n := d.pixelLen()
+
+	rr := d.Rows()
+	for rr.Next() {
+		y := rr.Y()
+		if y%k != 0 {
+			continue
+		}
+		row, dst := rr.Row(), pix[y/k*stride:]
+		for x := 0; x < w; x++ {
+			copy(dst[x*n:(x+1)*n], row[x*k*n:])
+		}
+	}
+	if err := rr.Err(); err != nil {
+		return err
+	}
+
+	return fn(Part{Strategy: StrategySubsampled, Rect: image.Rect(0, 0, d.width, d.height), Image: m, Factor: k})
+}
@@ -0,0 +1,73 @@
+package bmp
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"testing"
+)
+
+func TestDecodeAuto(t *testing.T) {
+	src := testImage(10, 6)
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	// decoded rows take 40 bytes, the whole image 240
+	for _, tc := range []struct {
+		budget   int64
+		strategy Strategy
+		parts    int
+	}{
+		{0, StrategyWhole, 1},
+		{240, StrategyWhole, 1},
+		{100, StrategyBands, 3},
+		{40, StrategyBands, 6},
+		{30, StrategySubsampled, 1},
+	} {
+		dst := image.NewRGBA(src.Bounds())
+		var parts []Part
+		err := DecodeAuto(bytes.NewReader(buf.Bytes()), func(p Part) error {
+			parts = append(parts, p)
+			if p.Factor == 1 {
+				draw.Draw(dst, p.Rect, p.Image, p.Rect.Min, draw.Src)
+			}
+			return nil
+		}, WithMemoryBudget(tc.budget))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(parts) != tc.parts || parts[0].Strategy != tc.strategy {
+			t.Errorf("budget %d: %d parts, strategy %v, want %d parts, strategy %v", tc.budget, len(parts), parts[0].Strategy, tc.parts, tc.strategy)
+			continue
+		}
+		if tc.strategy != StrategySubsampled {
+			if !sameImage(dst, src) {
+				t.Errorf("budget %d: parts differ from the image", tc.budget)
+			}
+			continue
+		}
+
+		// 3x2 pixels of 4 bytes for a factor of 4
+		p := parts[0]
+		if p.Factor != 4 || p.Image.Bounds() != image.Rect(0, 0, 3, 2) || p.Rect != src.Bounds() {
+			t.Fatalf("subsampled part: factor %d, bounds %v, rect %v", p.Factor, p.Image.Bounds(), p.Rect)
+		}
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 3; x++ {
+				r0, g0, b0, _ := p.Image.At(x, y).RGBA()
+				r1, g1, b1, _ := src.At(x*4, y*4).RGBA()
+				if r0 != r1 || g0 != g1 || b0 != b1 {
+					t.Errorf("subsampled pixel (%d, %d) = %v, want %v", x, y, p.Image.At(x, y), src.At(x*4, y*4))
+				}
+			}
+		}
+	}
+
+	err := DecodeAuto(bytes.NewReader(buf.Bytes()), func(Part) error { return nil }, WithMemoryBudget(2))
+	if err == nil {
+		t.Error("budget smaller than a pixel: no error")
+	}
+}
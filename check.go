@@ -0,0 +1,120 @@
+package bmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidHeader is wrapped by the errors of CheckHeader.
+var ErrInvalidHeader = errors.New("bmp: invalid header")
+
+// errors of CheckHeader, allocated once so that it never allocates
+var (
+	errHeaderTruncated   = fmt.Errorf("%w: truncated", ErrInvalidHeader)
+	errHeaderSignature   = fmt.Errorf("%w: signature is not BM", ErrInvalidHeader)
+	errHeaderLength      = fmt.Errorf("%w: unsupported DIB header length", ErrInvalidHeader)
+	errHeaderBPP         = fmt.Errorf("%w: unsupported number of bits per pixel", ErrInvalidHeader)
+	errHeaderCompression = fmt.Errorf("%w: unsupported compression for the number of bits per pixel", ErrInvalidHeader)
+	errHeaderDimensions  = fmt.Errorf("%w: width must be greater than zero and height must be non-zero", ErrInvalidHeader)
+	errHeaderPixels      = fmt.Errorf("%w: image has too many pixels", ErrLimitExceeded)
+	errHeaderOffset      = fmt.Errorf("%w: pixel data overlaps the headers", ErrInvalidHeader)
+)
+
+// CheckHeader validates the file and DIB headers at the start of b: the
+// signature, the header length, the number of bits per pixel, the
+// compression and the dimensions, which must fit the default limits. b must
+// hold at least the file header and the DIB header, i.e. 26 bytes for core
+// headers and 54 bytes otherwise.
+//
+// CheckHeader does not allocate, so that it can gate uploads on hot paths.
+// It does not guarantee that the image decodes. The errors wrap
+// ErrInvalidHeader, or ErrLimitExceeded for images above the pixel limit.
+func CheckHeader(b []byte) error {
+	const fileHeaderLen = 14
+
+	if len(b) < fileHeaderLen+coreHeaderLen {
+		return errHeaderTruncated
+	}
+	if b[0] != 'B' || b[1] != 'M' {
+		return errHeaderSignature
+	}
+
+	var width, height int64
+	var bpp int
+	compression := CompressionRGB
+
+	dibLen := binary.LittleEndian.Uint32(b[14:18])
+	switch dibLen {
+	case coreHeaderLen:
+		width = int64(binary.LittleEndian.Uint16(b[18:20]))
+		height = int64(binary.LittleEndian.Uint16(b[20:22]))
+		bpp = int(binary.LittleEndian.Uint16(b[24:26]))
+	case infoHeaderLen, 52, 56, 60, 96, 108, 112, 120, 124:
+		if len(b) < fileHeaderLen+infoHeaderLen {
+			return errHeaderTruncated
+		}
+		width = int64(int32(binary.LittleEndian.Uint32(b[18:22])))
+		height = int64(int32(binary.LittleEndian.Uint32(b[22:26])))
+		bpp = int(binary.LittleEndian.Uint16(b[28:30]))
+		compression = Compression(binary.LittleEndian.Uint32(b[30:34]))
+	default:
+		return errHeaderLength
+	}
+
+	// compressions registered by RegisterCompression and
+	// RegisterDecompressor are not checked
+	_, codec := lookupCompression(compression)
+	custom := codec
+	switch dec, _ := lookupDecompressor(compression, bpp); dec.(type) {
+	case nil, plainDecompressor, rleDecompressor:
+	default:
+		custom = true
+	}
+
+	switch bpp {
+	case 1, 2, 4, 8, 16, 24, 32:
+	case 0:
+		// the depth of JPEG and PNG compressed images is in their stream
+		if !codec {
+			return errHeaderBPP
+		}
+	default:
+		return errHeaderBPP
+	}
+
+	switch {
+	case custom:
+	case compression == CompressionRGB:
+	case compression == CompressionRLE8:
+		if bpp != 8 {
+			return errHeaderCompression
+		}
+	case compression == CompressionRLE4:
+		if bpp != 4 {
+			return errHeaderCompression
+		}
+	case compression == CompressionBitfields, compression == CompressionAlphaBitfields:
+		if bpp != 16 && bpp != 32 {
+			return errHeaderCompression
+		}
+	default:
+		return errHeaderCompression
+	}
+
+	if height < 0 {
+		height = -height
+	}
+	if width <= 0 || height == 0 {
+		return errHeaderDimensions
+	}
+	if max := DefaultLimits().MaxPixels; max > 0 && width*height > max {
+		return errHeaderPixels
+	}
+
+	if binary.LittleEndian.Uint32(b[10:14]) < fileHeaderLen+dibLen {
+		return errHeaderOffset
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestCheckHeader(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(testImage(4, 3)); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()[:54]
+
+	if err := CheckHeader(valid); err != nil {
+		t.Fatalf("valid header: %v", err)
+	}
+	if n := testing.AllocsPerRun(100, func() { CheckHeader(valid) }); n != 0 {
+		t.Errorf("CheckHeader allocates %v times, expected 0", n)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+		err    error
+	}{
+		{"truncated", func(b []byte) []byte { return b[:30] }, ErrInvalidHeader},
+		{"signature", func(b []byte) []byte { b[0] = 'X'; return b }, ErrInvalidHeader},
+		{"header length", func(b []byte) []byte { b[14] = 41; return b }, ErrInvalidHeader},
+		{"bpp", func(b []byte) []byte { b[28] = 7; return b }, ErrInvalidHeader},
+		{"compression", func(b []byte) []byte { b[30] = byte(CompressionRLE8); return b }, ErrInvalidHeader},
+		{"width", func(b []byte) []byte { binary.LittleEndian.PutUint32(b[18:], 0); return b }, ErrInvalidHeader},
+		{"offset", func(b []byte) []byte { b[10] = 20; return b }, ErrInvalidHeader},
+		{"pixels", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[18:], 1<<20)
+			binary.LittleEndian.PutUint32(b[22:], 1<<20)
+			return b
+		}, ErrLimitExceeded},
+	}
+
+	defer SetDefaultLimits(DefaultLimits())
+	SetDefaultLimits(Limits{MaxPixels: 1 << 30})
+
+	for _, tt := range tests {
+		b := tt.modify(append([]byte(nil), valid...))
+		if err := CheckHeader(b); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error = %v, expected %v", tt.name, err, tt.err)
+		}
+	}
+}
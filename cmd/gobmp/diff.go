@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+func init() {
+	commands["diff"] = &command{
+		usage: "[-o diff.bmp] a.bmp b.bmp",
+		short: "compare two images pixel by pixel",
+		run:   runDiff,
+	}
+}
+
+func runDiff(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	out := fs.String("o", "", "write an image marking differing pixels in red to `file`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usageError("expected two files")
+	}
+
+	a, ma, err := decodeFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, mb, err := decodeFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	differ := false
+	if ma.BitsPerPixel != mb.BitsPerPixel {
+		fmt.Fprintf(stdout, "depth: %d bpp != %d bpp\n", ma.BitsPerPixel, mb.BitsPerPixel)
+		differ = true
+	}
+	if a.Bounds().Size() != b.Bounds().Size() {
+		fmt.Fprintf(stdout, "size: %v != %v\n", a.Bounds().Size(), b.Bounds().Size())
+		return errFailed
+	}
+
+	n, m := diffImages(a, b)
+	if n > 0 {
+		size := a.Bounds().Size()
+		fmt.Fprintf(stdout, "pixels: %d of %d differ\n", n, size.X*size.Y)
+		differ = true
+	}
+
+	if *out != "" {
+		if err := encodeFile(*out, m, nil); err != nil {
+			return err
+		}
+	}
+
+	if differ {
+		return errFailed
+	}
+
+	return nil
+}
+
+// diffImages returns the number of pixels whose non-premultiplied colors
+// differ between a and b, which have the same size, and an image showing
+// them in red over a faded grayscale copy of a.
+func diffImages(a, b image.Image) (int, *image.RGBA) {
+	ra, rb := a.Bounds(), b.Bounds()
+	m := image.NewRGBA(image.Rect(0, 0, ra.Dx(), ra.Dy()))
+
+	n := 0
+	for y := 0; y < ra.Dy(); y++ {
+		for x := 0; x < ra.Dx(); x++ {
+			ca := color.NRGBAModel.Convert(a.At(ra.Min.X+x, ra.Min.Y+y))
+			cb := color.NRGBAModel.Convert(b.At(rb.Min.X+x, rb.Min.Y+y))
+			if ca != cb {
+				n++
+				m.SetRGBA(x, y, color.RGBA{0xff, 0, 0, 0xff})
+				continue
+			}
+
+			g := color.GrayModel.Convert(ca).(color.Gray)
+			v := 0xc0 + g.Y/4
+			m.SetRGBA(x, y, color.RGBA{v, v, v, 0xff})
+		}
+	}
+
+	return n, m
+}
+
+// decodeFile decodes the BMP image in the named file.
+func decodeFile(name string) (image.Image, bmp.Metadata, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, bmp.Metadata{}, err
+	}
+	defer f.Close()
+
+	d := bmp.NewDecoder(f)
+	meta, err := d.Metadata()
+	if err != nil {
+		return nil, meta, fmt.Errorf("%s: %v", name, err)
+	}
+	m, err := d.Image()
+	if err != nil {
+		return nil, meta, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return m, meta, nil
+}
+
+// encodeFile writes m to the named file, calling configure first if it is
+// not nil.
+func encodeFile(name string, m image.Image, configure func(*bmp.Encoder)) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	e := bmp.NewEncoder(f)
+	if configure != nil {
+		configure(e)
+	}
+	if err := e.Encode(m); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+func init() {
+	commands["info"] = &command{
+		usage: "file.bmp...",
+		short: "print the headers and the known writer quirks",
+		run:   runInfo,
+	}
+}
+
+func runInfo(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError("expected at least one file")
+	}
+
+	failed := false
+	for _, name := range fs.Args() {
+		if err := printInfo(stdout, name); err != nil {
+			fmt.Fprintf(stdout, "%s: %v\n", name, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return errFailed
+	}
+
+	return nil
+}
+
+// printInfo prints the headers of the named file and the writer quirks it
+// matches. The file is read leniently, so that the quirks are detected.
+func printInfo(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d := bmp.NewDecoder(f, bmp.WithLenient(true))
+	cfg, err := d.Config()
+	if err != nil {
+		return err
+	}
+	m, err := d.Metadata()
+	if err != nil {
+		return err
+	}
+
+	order := "bottom-up"
+	if m.TopDown {
+		order = "top-down"
+	}
+	fmt.Fprintf(w, "%s: %dx%d, %d bpp, compression %d, %d-byte header, %s\n",
+		name, cfg.Width, cfg.Height, m.BitsPerPixel, m.Compression, m.HeaderSize, order)
+	for _, q := range m.Quirks {
+		fmt.Fprintf(w, "  matches %s quirk %s: %s\n", q.Writer(), q, q.Description())
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	clean := writeImage(t, dir, "clean.bmp", gradient(4, 3))
+	paint := writeImage(t, dir, "paint.bmp", gradient(4, 3))
+	b, err := ioutil.ReadFile(paint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(b[2:6], 54)
+	if err := ioutil.WriteFile(paint, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"info", clean, paint}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit status %d: %s", code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected output: %q", stdout.String())
+	}
+	if !strings.HasSuffix(lines[0], "clean.bmp: 4x3, 24 bpp, compression 0, 40-byte header, bottom-up") {
+		t.Errorf("clean file: %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[2], "  matches Paint quirk header-file-size: ") {
+		t.Errorf("quirk line: %q", lines[2])
+	}
+
+	stdout.Reset()
+	if code := run([]string{"info", clean[:len(clean)-4]}, &stdout, &stderr); code != 1 {
+		t.Errorf("missing file: exit status %d, expected 1", code)
+	}
+	if code := run([]string{"info"}, &stdout, &stderr); code != 2 {
+		t.Errorf("no files: exit status %d, expected 2", code)
+	}
+}
@@ -0,0 +1,102 @@
+// Command gobmp inspects and converts BMP images.
+//
+// Usage:
+//
+//	gobmp <command> [flags] [arguments]
+//
+// Run gobmp help to list the commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// errFailed is returned by commands which already reported why they failed,
+// such as differing images, to exit with status 1 without another message.
+var errFailed = errors.New("failed")
+
+// errWarnings is returned by commands which only reported warnings, to exit
+// with status 3.
+var errWarnings = errors.New("warnings")
+
+type command struct {
+	usage string
+	short string
+	run   func(fs *flag.FlagSet, args []string, stdout io.Writer) error
+}
+
+var commands = map[string]*command{}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command named by args[0] and returns the exit status: 0
+// on success, 1 if the command failed, 2 on usage errors and 3 if it only
+// reported warnings.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		printUsage(stderr)
+		return 2
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "gobmp: unknown command %q\n", args[0])
+		printUsage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: gobmp %s %s\n", args[0], cmd.usage)
+		fs.PrintDefaults()
+	}
+
+	if err := cmd.run(fs, args[1:], stdout); err != nil {
+		switch err {
+		case flag.ErrHelp:
+			return 2
+		case errFailed:
+			return 1
+		case errWarnings:
+			return 3
+		}
+		if _, ok := err.(usageError); ok {
+			fmt.Fprintf(stderr, "gobmp %s: %v\n", args[0], err)
+			fs.Usage()
+			return 2
+		}
+		fmt.Fprintf(stderr, "gobmp %s: %v\n", args[0], err)
+		return 1
+	}
+
+	return 0
+}
+
+// usageError reports invalid arguments.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: gobmp <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].short)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeImage writes m as a 24 bpp BMP file named name in dir.
+func writeImage(t *testing.T, dir, name string, m image.Image) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := encodeFile(path, m, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// tempDir creates a temporary directory, removed by calling the returned
+// function.
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "gobmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func gradient(w, h int) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{uint8(x * 10), uint8(y * 10), 0x80, 0xff})
+		}
+	}
+
+	return m
+}
+
+func TestRunUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("exit status %d, expected 2", code)
+	}
+	if !strings.Contains(stderr.String(), "diff") {
+		t.Errorf("usage does not list the commands: %q", stderr.String())
+	}
+
+	if code := run([]string{"nope"}, &stdout, &stderr); code != 2 {
+		t.Errorf("exit status %d for an unknown command, expected 2", code)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	m := gradient(4, 3)
+	a := writeImage(t, dir, "a.bmp", m)
+	same := writeImage(t, dir, "same.bmp", m)
+
+	m.SetNRGBA(1, 1, color.NRGBA{0, 0, 0, 0xff})
+	m.SetNRGBA(2, 2, color.NRGBA{0, 0, 0, 0xff})
+	changed := writeImage(t, dir, "changed.bmp", m)
+	small := writeImage(t, dir, "small.bmp", gradient(2, 2))
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"diff", a, same}, &stdout, &stderr); code != 0 {
+		t.Errorf("identical images: exit status %d: %s%s", code, stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	out := filepath.Join(dir, "diff.bmp")
+	if code := run([]string{"diff", "-o", out, a, changed}, &stdout, &stderr); code != 1 {
+		t.Errorf("different images: exit status %d, expected 1", code)
+	}
+	if !strings.Contains(stdout.String(), "2 of 12 differ") {
+		t.Errorf("unexpected report: %q", stdout.String())
+	}
+
+	d, _, err := decodeFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.RGBAModel.Convert(d.At(1, 1)); c != (color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("differing pixel drawn as %v", c)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"diff", a, small}, &stdout, &stderr); code != 1 {
+		t.Errorf("different sizes: exit status %d, expected 1", code)
+	}
+	if !strings.Contains(stdout.String(), "size") {
+		t.Errorf("unexpected report: %q", stdout.String())
+	}
+}
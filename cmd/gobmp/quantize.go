@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	bmp "github.com/entooone/go-bmp"
+	"github.com/entooone/go-bmp/quantize"
+)
+
+func init() {
+	commands["quantize"] = &command{
+		usage: "[-colors n] [-dither] in.bmp out.bmp",
+		short: "reduce an image to a paletted one",
+		run:   runQuantize,
+	}
+}
+
+func runQuantize(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	colors := fs.Int("colors", 256, "number of colors, from 2 to 256")
+	dither := fs.Bool("dither", false, "apply Floyd-Steinberg error diffusion")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usageError("expected an input and an output file")
+	}
+	if *colors < 2 || *colors > 256 {
+		return usageError(fmt.Sprintf("number of colors out of range (got: %d)", *colors))
+	}
+
+	m, _, err := decodeFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	p := quantize.Octree{}.Quantize(make(color.Palette, 0, *colors), m)
+	dst := image.NewPaletted(m.Bounds(), p)
+	var drawer draw.Drawer = draw.Src
+	if *dither {
+		drawer = draw.FloydSteinberg
+	}
+	drawer.Draw(dst, dst.Rect, m, m.Bounds().Min)
+
+	return encodeFile(fs.Arg(1), dst, func(e *bmp.Encoder) {
+		e.SetDepth(depthFor(*colors))
+	})
+}
+
+// depthFor returns the smallest paletted depth holding n colors.
+func depthFor(n int) int {
+	switch {
+	case n <= 2:
+		return 1
+	case n <= 16:
+		return 4
+	}
+
+	return 8
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+func readMetadata(name string) (bmp.Metadata, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return bmp.Metadata{}, err
+	}
+	defer f.Close()
+
+	return bmp.NewDecoder(f).Metadata()
+}
+
+func TestQuantize(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	in := writeImage(t, dir, "in.bmp", gradient(16, 16))
+
+	tests := []struct {
+		args   []string
+		depth  int
+		colors int
+	}{
+		{[]string{"-colors", "2"}, 1, 2},
+		{[]string{"-colors", "16", "-dither"}, 4, 16},
+		{nil, 8, 256},
+	}
+
+	for _, tt := range tests {
+		out := filepath.Join(dir, "out.bmp")
+		var stdout, stderr bytes.Buffer
+		args := append(append([]string{"quantize"}, tt.args...), in, out)
+		if code := run(args, &stdout, &stderr); code != 0 {
+			t.Errorf("%v: exit status %d: %s", tt.args, code, stderr.String())
+			continue
+		}
+
+		meta, err := readMetadata(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.BitsPerPixel != tt.depth || meta.ColorsUsed > tt.colors {
+			t.Errorf("%v: %d bpp with %d colors, expected %d bpp with at most %d colors", tt.args, meta.BitsPerPixel, meta.ColorsUsed, tt.depth, tt.colors)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"quantize", "-colors", "1", in, "x.bmp"}, &stdout, &stderr); code != 2 {
+		t.Errorf("1 color: exit status %d, expected 2", code)
+	}
+}
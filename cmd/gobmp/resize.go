@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	bmp "github.com/entooone/go-bmp"
+	"golang.org/x/image/draw"
+)
+
+var filters = map[string]draw.Interpolator{
+	"nearest":    draw.NearestNeighbor,
+	"bilinear":   draw.BiLinear,
+	"catmullrom": draw.CatmullRom,
+}
+
+func init() {
+	commands["resize"] = &command{
+		usage: "[-w width] [-h height] [-filter name] [-linear] in.bmp out.bmp",
+		short: "resize an image",
+		run:   runResize,
+	}
+}
+
+func runResize(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	w := fs.Int("w", 0, "width of the output, scaled from the height if 0")
+	h := fs.Int("h", 0, "height of the output, scaled from the width if 0")
+	filter := fs.String("filter", "catmullrom", "resampling filter: nearest, bilinear or catmullrom")
+	linear := fs.Bool("linear", false, "filter in linear light")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usageError("expected an input and an output file")
+	}
+	if *w < 0 || *h < 0 || *w == 0 && *h == 0 {
+		return usageError("expected a positive width or height")
+	}
+	kernel, ok := filters[*filter]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown filter %q", *filter))
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d := bmp.NewDecoder(f)
+	cfg, err := d.Config()
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	meta, err := d.Metadata()
+	if err != nil {
+		return err
+	}
+
+	// keep the aspect ratio for a missing dimension
+	if *w == 0 {
+		*w = max1(*h * cfg.Width / cfg.Height)
+	}
+	if *h == 0 {
+		*h = max1(*w * cfg.Height / cfg.Width)
+	}
+
+	// decode again from the start
+	m, err := bmp.DecodeScaled(io.NewSectionReader(f, 0, 1<<62), *w, *h, kernel, bmp.WithLinearLight(*linear))
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+
+	return encodeFile(fs.Arg(1), m, func(e *bmp.Encoder) {
+		if meta.BitsPerPixel == 32 {
+			e.SetDepth(32)
+		}
+	})
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+
+	return n
+}
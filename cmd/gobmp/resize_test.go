@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"path/filepath"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	in := writeImage(t, dir, "in.bmp", gradient(8, 4))
+
+	tests := []struct {
+		args []string
+		size image.Point
+	}{
+		{[]string{"-w", "4", "-h", "3"}, image.Pt(4, 3)},
+		{[]string{"-w", "2"}, image.Pt(2, 1)},
+		{[]string{"-h", "8", "-filter", "nearest"}, image.Pt(16, 8)},
+	}
+
+	for _, tt := range tests {
+		out := filepath.Join(dir, "out.bmp")
+		var stdout, stderr bytes.Buffer
+		args := append(append([]string{"resize"}, tt.args...), in, out)
+		if code := run(args, &stdout, &stderr); code != 0 {
+			t.Errorf("%v: exit status %d: %s", tt.args, code, stderr.String())
+			continue
+		}
+
+		m, _, err := decodeFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Bounds().Size(); got != tt.size {
+			t.Errorf("%v: size %v, expected %v", tt.args, got, tt.size)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"resize", "-filter", "lanczos", "-w", "2", in, "x.bmp"}, &stdout, &stderr); code != 2 {
+		t.Errorf("unknown filter: exit status %d, expected 2", code)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+func init() {
+	commands["strip"] = &command{
+		usage: "[-d dir] file.bmp...",
+		short: "remove data hidden outside of the pixels",
+		run:   runStrip,
+	}
+}
+
+func runStrip(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	dir := fs.String("d", "", "write the stripped files to `dir` instead of replacing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError("expected at least one file")
+	}
+
+	failed := false
+	for _, name := range fs.Args() {
+		out := name
+		if *dir != "" {
+			out = filepath.Join(*dir, filepath.Base(name))
+		}
+		if err := stripFile(out, name); err != nil {
+			fmt.Fprintf(stdout, "%s: %v\n", name, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return errFailed
+	}
+
+	return nil
+}
+
+// stripFile writes the sanitized image in the named file to out through a
+// temporary file, so that out may be the input and is left untouched on
+// errors.
+func stripFile(out, name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(out), ".gobmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// keep the permissions of the input rather than those of temporary files
+	if fi, err := in.Stat(); err == nil {
+		if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := bmp.Sanitize(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	in.Close()
+
+	return os.Rename(tmp.Name(), out)
+}
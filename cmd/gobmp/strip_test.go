@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStrip(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	name := writeImage(t, dir, "a.bmp", gradient(3, 2))
+	clean, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// trailing bytes are dropped
+	if err := ioutil.WriteFile(name, append(append([]byte(nil), clean...), "hidden"...), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"strip", "-d", out, name}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit status %d: %s%s", code, stdout.String(), stderr.String())
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(out, "a.bmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, clean) {
+		t.Errorf("stripped file differs from a clean encoding")
+	}
+
+	// in place
+	if code := run([]string{"strip", name}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit status %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	if b, _ := ioutil.ReadFile(name); !bytes.Equal(b, clean) {
+		t.Errorf("file stripped in place differs from a clean encoding")
+	}
+}
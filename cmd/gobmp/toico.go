@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	bmp "github.com/entooone/go-bmp"
+	"github.com/entooone/go-bmp/ico"
+)
+
+func init() {
+	commands["to-ico"] = &command{
+		usage: "[-sizes 16,32,48,256] in.bmp out.ico",
+		short: "build a multi-resolution icon",
+		run:   runToICO,
+	}
+}
+
+func runToICO(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	list := fs.String("sizes", "16,32,48,256", "comma-separated `sizes` of the icon images, up to 256")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usageError("expected an input and an output file")
+	}
+
+	var sizes []int
+	for _, s := range strings.Split(*list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 || n > 256 {
+			return usageError(fmt.Sprintf("invalid size %q", s))
+		}
+		sizes = append(sizes, n)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m, err := bmp.Decode(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	b, err := ico.BuildIcon(m, sizes)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fs.Arg(1), b, 0666)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestToICO(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	in := writeImage(t, dir, "in.bmp", gradient(20, 10))
+	out := filepath.Join(dir, "out.ico")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"to-ico", "-sizes", "16, 32,256", in, out}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit status %d: %s", code, stderr.String())
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := binary.LittleEndian.Uint16(b[4:6]); n != 3 {
+		t.Fatalf("%d images, expected 3", n)
+	}
+	for i, size := range []byte{16, 32, 0} {
+		if e := b[6+16*i:]; e[0] != size || e[1] != size {
+			t.Errorf("image %d is %dx%d, expected %d", i, e[0], e[1], size)
+		}
+	}
+
+	if code := run([]string{"to-ico", "-sizes", "512", in, out}, &stdout, &stderr); code != 2 {
+		t.Errorf("size 512: exit status %d, expected 2", code)
+	}
+}
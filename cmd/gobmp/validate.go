@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+func init() {
+	commands["validate"] = &command{
+		usage: "[-untrusted] [-v] file.bmp...",
+		short: "check that images decode and report format violations",
+		run:   runValidate,
+	}
+}
+
+func runValidate(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	untrusted := fs.Bool("untrusted", false, "apply the strict checks and limits for untrusted input")
+	verbose := fs.Bool("v", false, "also print the files without problems")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError("expected at least one file")
+	}
+
+	var opts []bmp.Option
+	if *untrusted {
+		opts = append(opts, bmp.WithUntrusted())
+	}
+
+	failed, warned := false, false
+	for _, name := range fs.Args() {
+		warnings, err := validateFile(name, opts)
+		for _, w := range warnings {
+			fmt.Fprintf(stdout, "%s: warning: %v\n", name, w)
+		}
+		switch {
+		case err != nil:
+			fmt.Fprintf(stdout, "%s: %v\n", name, err)
+			failed = true
+		case len(warnings) > 0:
+			warned = true
+		case *verbose:
+			fmt.Fprintf(stdout, "%s: ok\n", name)
+		}
+	}
+
+	switch {
+	case failed:
+		return errFailed
+	case warned:
+		return errWarnings
+	}
+
+	return nil
+}
+
+// validateFile decodes the named file, returning the first problem found,
+// and lints the files which decode, returning the violations of the format
+// the decoder tolerated, as found by bmp.Lint.
+func validateFile(name string, opts []bmp.Option) ([]bmp.Warning, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := bmp.NewDecoder(f, opts...).Image(); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return bmp.Lint(f)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	good := writeImage(t, dir, "good.bmp", gradient(3, 3))
+	b, err := ioutil.ReadFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.bmp")
+	if err := ioutil.WriteFile(bad, b[:len(b)-10], 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"validate", "-v", good}, &stdout, &stderr); code != 0 {
+		t.Errorf("valid file: exit status %d: %s", code, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "good.bmp: ok") {
+		t.Errorf("unexpected output: %q", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"validate", good, bad}, &stdout, &stderr); code != 1 {
+		t.Errorf("truncated file: exit status %d, expected 1", code)
+	}
+	if out := stdout.String(); !strings.Contains(out, "bad.bmp: ") || !strings.HasSuffix(out, "unexpected EOF\n") || strings.Contains(out, "good.bmp") {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	// a non-zero reserved field decodes, with a warning
+	b[6] = 1
+	warned := filepath.Join(dir, "warned.bmp")
+	if err := ioutil.WriteFile(warned, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if code := run([]string{"validate", "-v", good, warned}, &stdout, &stderr); code != 3 {
+		t.Errorf("file with warnings: exit status %d, expected 3", code)
+	}
+	if out := stdout.String(); !strings.Contains(out, "warned.bmp: warning: offset 6: ") || strings.Contains(out, "warned.bmp: ok") {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"validate", warned, bad}, &stdout, &stderr); code != 1 {
+		t.Errorf("file with warnings and truncated file: exit status %d, expected 1", code)
+	}
+
+	if code := run([]string{"validate"}, &stdout, &stderr); code != 2 {
+		t.Errorf("no files: exit status %d, expected 2", code)
+	}
+}
@@ -0,0 +1,108 @@
+package bmp
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sync"
+)
+
+// CompressionDecoder decodes pixel data compressed with a method the
+// package does not support, such as the vendor-specific codecs found in
+// some files.
+type CompressionDecoder interface {
+	// Decode decodes the pixel data of p, whose image must have the
+	// dimensions of p.
+	Decode(p CompressedPixels) (image.Image, error)
+}
+
+// CompressedPixels is the pixel data of an image passed to a
+// CompressionDecoder.
+type CompressedPixels struct {
+	// Width and Height are the dimensions of the image.
+	Width, Height int
+	// Metadata holds the header fields.
+	Metadata Metadata
+	// Palette is the color table, nil if the header declares none.
+	Palette color.Palette
+	// Data reads the pixel data, up to Metadata.ImageSize bytes unless it
+	// is zero.
+	Data io.Reader
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[Compression]CompressionDecoder{}
+)
+
+// RegisterCompression registers dec for the pixel data compressed with c,
+// so that images using it can be decoded by Decode and Decoder.Image. The
+// row based APIs do not support them, unlike the decompressors registered
+// with RegisterDecompressor, which suit the methods producing rows of
+// pixels. It panics if c is one of the compression methods decoded by the
+// package or if a decoder is already registered for c.
+func RegisterCompression(c Compression, dec CompressionDecoder) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	switch c {
+	case CompressionRGB, CompressionRLE8, CompressionRLE4, CompressionBitfields:
+		panic(fmt.Sprintf("bmp: RegisterCompression called for built-in compression %d", c))
+	}
+	if _, ok := codecs[c]; ok {
+		panic(fmt.Sprintf("bmp: RegisterCompression called twice for compression %d", c))
+	}
+	codecs[c] = dec
+}
+
+// lookupCompression returns the decoder registered for c.
+func lookupCompression(c Compression) (CompressionDecoder, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	dec, ok := codecs[c]
+	return dec, ok
+}
+
+// errCodecRows is returned by the row based APIs for images decoded by a
+// CompressionDecoder.
+func (d *Decoder) errCodecRows() error {
+	return fmt.Errorf("bmp: pixel data compressed with method %d can only be decoded as a whole image", d.meta.Compression)
+}
+
+// decodeCodec decodes the pixel data with the registered
+// CompressionDecoder.
+func (d *Decoder) decodeCodec() error {
+	r := d.r
+	if n := d.meta.ImageSize; n > 0 {
+		r = io.LimitReader(r, int64(n))
+	}
+
+	var p color.Palette
+	if len(d.palette) > 0 {
+		p = d.palette
+	}
+	m, err := d.codec.Decode(CompressedPixels{
+		Width:    d.width,
+		Height:   d.height,
+		Metadata: d.meta,
+		Palette:  p,
+		Data:     r,
+	})
+	if err != nil {
+		return err
+	}
+	if size := m.Bounds().Size(); size != image.Pt(d.width, d.height) {
+		return fmt.Errorf("bmp: decoded image is %dx%d, header says %dx%d", size.X, size.Y, d.width, d.height)
+	}
+
+	if d.opts.model != nil {
+		if m, err = convert(m, d.opts.model); err != nil {
+			return err
+		}
+	}
+	d.image = m
+
+	return nil
+}
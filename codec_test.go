@@ -0,0 +1,71 @@
+package bmp
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// grayCodec decodes pixel data made of one gray byte per pixel, stored
+// top-down.
+type grayCodec struct{}
+
+func (grayCodec) Decode(p CompressedPixels) (image.Image, error) {
+	m := image.NewGray(image.Rect(0, 0, p.Width, p.Height))
+	if _, err := io.ReadFull(p.Data, m.Pix); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+const compressionGray Compression = 0x79617267 // 'gray'
+
+func TestRegisterCompression(t *testing.T) {
+	if _, ok := lookupCompression(compressionGray); !ok {
+		RegisterCompression(compressionGray, grayCodec{})
+	}
+
+	pix := []byte{0, 1, 2, 3, 4, 5}
+	data := rawFile(UnpackerKey{BitsPerPixel: 24, Compression: compressionGray}, 3, 2, nil, pix)
+
+	if err := CheckHeader(data); err != nil {
+		t.Errorf("CheckHeader: %v", err)
+	}
+
+	m, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := m.(*image.Gray)
+	if !ok {
+		t.Fatalf("image type = %T, expected *image.Gray", m)
+	}
+	if !bytes.Equal(g.Pix, pix) {
+		t.Errorf("pixels = %v, expected %v", g.Pix, pix)
+	}
+
+	if _, err := Decode(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("decoding truncated pixel data succeeded")
+	}
+
+	if err := Sanitize(ioutil.Discard, bytes.NewReader(data)); err == nil {
+		t.Error("sanitizing an image of a registered compression succeeded")
+	}
+	if rr := NewDecoder(bytes.NewReader(data)).Rows(); rr.Next() || rr.Err() == nil {
+		t.Error("reading the rows of an image of a registered compression succeeded")
+	}
+
+	for _, c := range []Compression{CompressionRLE8, compressionGray} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering compression %d did not panic", c)
+				}
+			}()
+			RegisterCompression(c, grayCodec{})
+		}()
+	}
+}
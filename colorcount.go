@@ -0,0 +1,143 @@
+package bmp
+
+import (
+	"image/color"
+	"sync"
+)
+
+// ColorCounter counts the distinct colors of the rows decoded with
+// WithColorCounter, up to a limit, as they are read, so that the image
+// need not be kept. The colors of all the images decoded with a counter
+// are counted together. It is safe for concurrent use.
+type ColorCounter struct {
+	mu     sync.Mutex
+	limit  int
+	seen   map[color.NRGBA]struct{}
+	colors color.Palette
+	alpha  bool
+}
+
+// NewColorCounter returns a ColorCounter tracking at most limit distinct
+// colors, past which it only reports that there are more. A limit of 256
+// is enough to choose the depth of an image.
+func NewColorCounter(limit int) *ColorCounter {
+	return &ColorCounter{
+		limit: limit,
+		seen:  make(map[color.NRGBA]struct{}),
+	}
+}
+
+// add counts the color c.
+func (c *ColorCounter) add(nc color.NRGBA) {
+	if nc.A != 0xff {
+		c.alpha = true
+	}
+
+	if c.seen == nil {
+		return
+	}
+	if _, ok := c.seen[nc]; ok {
+		return
+	}
+	if len(c.seen) == c.limit {
+		// stop tracking colors past the limit
+		c.seen, c.colors = nil, nil
+		return
+	}
+	c.seen[nc] = struct{}{}
+	c.colors = append(c.colors, nc)
+}
+
+// Count returns the number of distinct colors counted, or the limit + 1 if
+// there are more than the limit.
+func (c *ColorCounter) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.seen == nil {
+		return c.limit + 1
+	}
+
+	return len(c.colors)
+}
+
+// Exceeded reports whether there are more distinct colors than the limit.
+func (c *ColorCounter) Exceeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.seen == nil
+}
+
+// HasAlpha reports whether any counted color is translucent.
+func (c *ColorCounter) HasAlpha() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.alpha
+}
+
+// Palette returns the distinct colors in the order they were first read,
+// or nil if there are more than the limit.
+func (c *ColorCounter) Palette() color.Palette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append(color.Palette(nil), c.colors...)
+}
+
+// Depth returns the depth DepthAuto would choose for an image of the
+// counted colors: 32 bpp if any is translucent, 1, 4 or 8 bpp for at most
+// 2, 16 or 256 colors and 24 bpp otherwise. The counter's palette holds
+// the colors to encode at a paletted depth. With a limit below 256, images
+// of more colors than the limit are given 24 bpp.
+func (c *ColorCounter) Depth() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.alpha:
+		return 32
+	case c.seen == nil || len(c.colors) > 256:
+		return 24
+	case len(c.colors) <= 2:
+		return 1
+	case len(c.colors) <= 16:
+		return 4
+	}
+
+	return 8
+}
+
+// countRow counts the colors of an unpacked row for WithColorCounter. The
+// colors of palette indices are only counted the first time the index is
+// read.
+func (d *Decoder) countRow(row []byte) {
+	c := d.opts.counter
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d.palette != nil {
+		for _, idx := range row {
+			if d.counted[idx] {
+				continue
+			}
+			d.counted[idx] = true
+
+			var nc color.NRGBA
+			if int(idx) < len(d.palette) {
+				nc = color.NRGBAModel.Convert(d.palette[idx]).(color.NRGBA)
+			}
+			c.add(nc)
+		}
+		return
+	}
+
+	for i := 0; i+3 < len(row); i += 4 {
+		c.add(color.NRGBA{row[i], row[i+1], row[i+2], row[i+3]})
+	}
+}
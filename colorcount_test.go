@@ -0,0 +1,76 @@
+package bmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestColorCounter(t *testing.T) {
+	src := testImage(5, 3)
+	unique, _, _ := Analyze(src)
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewColorCounter(256)
+	if _, err := Decode(bytes.NewReader(buf.Bytes()), WithColorCounter(c)); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Count(); n != unique {
+		t.Errorf("Count = %d, want %d", n, unique)
+	}
+	if c.Exceeded() || c.HasAlpha() {
+		t.Errorf("Exceeded = %v, HasAlpha = %v, want false", c.Exceeded(), c.HasAlpha())
+	}
+	if d, _ := autoDepth(src); c.Depth() != d {
+		t.Errorf("Depth = %d, want %d", c.Depth(), d)
+	}
+	if p := c.Palette(); len(p) != unique {
+		t.Errorf("palette of %d colors, want %d", len(p), unique)
+	}
+
+	c = NewColorCounter(4)
+	if _, err := Decode(bytes.NewReader(buf.Bytes()), WithColorCounter(c)); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Exceeded() || c.Count() != 5 || c.Palette() != nil || c.Depth() != 24 {
+		t.Errorf("limit 4: Count = %d, Exceeded = %v, Depth = %d", c.Count(), c.Exceeded(), c.Depth())
+	}
+
+	// paletted images count the colors of the used entries, rows streamed
+	// with Rows included
+	for _, depth := range []int{1, 4} {
+		buf.Reset()
+		e := NewEncoder(&buf)
+		e.SetDepth(depth)
+		want, wantDepth := 3, 4
+		if depth == 1 {
+			want, wantDepth = 2, 1
+		}
+		if err := e.Encode(testPaletted(9, 2, want)); err != nil {
+			t.Fatal(err)
+		}
+
+		c = NewColorCounter(256)
+		rr := NewDecoder(bytes.NewReader(buf.Bytes()), WithColorCounter(c), WithBitmap(true)).Rows()
+		for rr.Next() {
+		}
+		if err := rr.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if c.Count() != want || c.Depth() != wantDepth {
+			t.Errorf("%d bpp: Count = %d, Depth = %d, want %d and %d", depth, c.Count(), c.Depth(), want, wantDepth)
+		}
+
+		// WithBitmap decodes 1 bpp images without unpacking the rows
+		c = NewColorCounter(256)
+		if _, err := Decode(bytes.NewReader(buf.Bytes()), WithColorCounter(c), WithBitmap(true)); err != nil {
+			t.Fatal(err)
+		}
+		if c.Count() != want {
+			t.Errorf("%d bpp decoded: Count = %d, want %d", depth, c.Count(), want)
+		}
+	}
+}
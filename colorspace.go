@@ -0,0 +1,200 @@
+package bmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// ColorSpaceType is the color space of an image (bV4CSType).
+type ColorSpaceType uint32
+
+// Color space types defined by the V4 and V5 headers.
+const (
+	ColorSpaceCalibratedRGB   ColorSpaceType = 0
+	ColorSpaceSRGB            ColorSpaceType = 0x73524742 // 'sRGB'
+	ColorSpaceWindows         ColorSpaceType = 0x57696e20 // 'Win '
+	ColorSpaceProfileLinked   ColorSpaceType = 0x4c494e4b // 'LINK'
+	ColorSpaceProfileEmbedded ColorSpaceType = 0x4d424544 // 'MBED'
+)
+
+// CIEXYZ is a color in the CIE 1931 XYZ color space.
+type CIEXYZ struct {
+	X, Y, Z float64
+}
+
+// ColorSpace holds the color space fields of V4 and V5 headers.
+type ColorSpace struct {
+	Type ColorSpaceType
+	// Endpoints are the XYZ coordinates of the red, green and blue
+	// primaries. They are only meaningful for ColorSpaceCalibratedRGB.
+	Endpoints [3]CIEXYZ
+	// Gamma is the tone response of the red, green and blue channels.
+	Gamma [3]float64
+	// ProfileOffset and ProfileSize locate the profile of V5 headers,
+	// embedded or linked, from the start of the DIB header.
+	ProfileOffset, ProfileSize uint32
+}
+
+// readColorSpace parses the color space fields of a V4 header starting at
+// b[0], the bV4CSType field.
+func readColorSpace(b []byte) *ColorSpace {
+	cs := &ColorSpace{Type: ColorSpaceType(binary.LittleEndian.Uint32(b[0:4]))}
+
+	// endpoints are FXPT2DOT30 and gammas 16.16 fixed point values
+	for i := range cs.Endpoints {
+		e := b[4+12*i:]
+		cs.Endpoints[i] = CIEXYZ{
+			X: float64(binary.LittleEndian.Uint32(e[0:4])) / (1 << 30),
+			Y: float64(binary.LittleEndian.Uint32(e[4:8])) / (1 << 30),
+			Z: float64(binary.LittleEndian.Uint32(e[8:12])) / (1 << 30),
+		}
+		cs.Gamma[i] = float64(binary.LittleEndian.Uint32(b[40+4*i:])) / (1 << 16)
+	}
+
+	return cs
+}
+
+// writeColorSpace stores the color space fields of cs in a V4 header
+// starting at b[0], the bV4CSType field. The profile location is not
+// written.
+func writeColorSpace(b []byte, cs ColorSpace) {
+	binary.LittleEndian.PutUint32(b[0:4], uint32(cs.Type))
+
+	for i, xyz := range cs.Endpoints {
+		e := b[4+12*i:]
+		binary.LittleEndian.PutUint32(e[0:4], uint32(math.Round(xyz.X*(1<<30))))
+		binary.LittleEndian.PutUint32(e[4:8], uint32(math.Round(xyz.Y*(1<<30))))
+		binary.LittleEndian.PutUint32(e[8:12], uint32(math.Round(xyz.Z*(1<<30))))
+		binary.LittleEndian.PutUint32(b[40+4*i:], uint32(math.Round(cs.Gamma[i]*(1<<16))))
+	}
+}
+
+// checkWritable fails if cs cannot be stored by writeColorSpace: profiles
+// are not part of the color space fields, and the endpoints and gammas
+// must fit their fixed point fields.
+func (cs ColorSpace) checkWritable() error {
+	if cs.Type == ColorSpaceProfileLinked || cs.Type == ColorSpaceProfileEmbedded {
+		return errors.New("bmp: color profiles must be set with SetProfile")
+	}
+
+	for i, e := range cs.Endpoints {
+		for _, v := range []float64{e.X, e.Y, e.Z} {
+			if !(v >= 0 && v < 2) {
+				return fmt.Errorf("bmp: endpoint %d is out of range (got: %+v)", i, e)
+			}
+		}
+		if g := cs.Gamma[i]; !(g >= 0 && g < 1<<16) {
+			return fmt.Errorf("bmp: gamma %d is out of range (got: %v)", i, g)
+		}
+	}
+
+	return nil
+}
+
+// Calibrated reports whether cs describes custom primaries.
+func (cs ColorSpace) Calibrated() bool {
+	return cs.Type == ColorSpaceCalibratedRGB && cs.Endpoints != [3]CIEXYZ{}
+}
+
+// xyzToSRGB converts XYZ (D65) to linear sRGB.
+var xyzToSRGB = [3][3]float64{
+	{3.2404542, -1.5371385, -0.4985314},
+	{-0.9692660, 1.8760108, 0.0415560},
+	{0.0556434, -0.2040259, 1.0572252},
+}
+
+// SRGBMatrix returns the matrix converting linear RGB values using the
+// endpoints of cs to linear sRGB values.
+func (cs ColorSpace) SRGBMatrix() [3][3]float64 {
+	var m [3][3]float64
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			e := cs.Endpoints[j]
+			m[i][j] = xyzToSRGB[i][0]*e.X + xyzToSRGB[i][1]*e.Y + xyzToSRGB[i][2]*e.Z
+		}
+	}
+
+	return m
+}
+
+// srgbToLinear and linearToSRGB implement the sRGB transfer function.
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) float64 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1:
+		return 1
+	case v <= 0.0031308:
+		return v * 12.92
+	}
+
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// srgbConverter converts colors from a calibrated color space to sRGB.
+type srgbConverter struct {
+	m   [3][3]float64
+	lut [3][256]float64
+}
+
+// newSRGBConverter returns a converter for cs. Channels without a gamma
+// are assumed to use the sRGB transfer function.
+func newSRGBConverter(cs *ColorSpace) *srgbConverter {
+	c := &srgbConverter{m: cs.SRGBMatrix()}
+
+	for i := range c.lut {
+		for v := range c.lut[i] {
+			if cs.Gamma[i] == 0 {
+				c.lut[i][v] = srgbToLinear(float64(v) / 0xff)
+			} else {
+				c.lut[i][v] = math.Pow(float64(v)/0xff, cs.Gamma[i])
+			}
+		}
+	}
+
+	return c
+}
+
+func (c *srgbConverter) convert(r, g, b uint8) (uint8, uint8, uint8) {
+	lr, lg, lb := c.lut[0][r], c.lut[1][g], c.lut[2][b]
+
+	var out [3]uint8
+	for i := range out {
+		v := c.m[i][0]*lr + c.m[i][1]*lg + c.m[i][2]*lb
+		out[i] = uint8(linearToSRGB(v)*0xff + 0.5)
+	}
+
+	return out[0], out[1], out[2]
+}
+
+// convertRow converts a row of 8-bit RGBA samples in place.
+func (c *srgbConverter) convertRow(p []byte) {
+	for i := 0; i+3 < len(p); i += 4 {
+		p[i], p[i+1], p[i+2] = c.convert(p[i], p[i+1], p[i+2])
+	}
+}
+
+// convertPalette converts the colors of p in place.
+func (c *srgbConverter) convertPalette(p color.Palette) {
+	for i, col := range p {
+		n := color.NRGBAModel.Convert(col).(color.NRGBA)
+		n.R, n.G, n.B = c.convert(n.R, n.G, n.B)
+		if n.A == 0xff {
+			p[i] = color.RGBA{n.R, n.G, n.B, 0xff}
+		} else {
+			p[i] = n
+		}
+	}
+}
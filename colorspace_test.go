@@ -0,0 +1,105 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+)
+
+// v4Image returns a 24 bpp BMP with a V4 header using cs, holding a single
+// row of pixels given as RGB triples.
+func v4Image(cs ColorSpace, pixels ...[3]uint8) []byte {
+	const offset = 14 + 108
+
+	stride := (len(pixels)*3 + 3) &^ 3
+	b := make([]byte, offset+stride)
+	copy(b[0:2], "BM")
+	binary.LittleEndian.PutUint32(b[2:6], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[10:14], offset)
+	binary.LittleEndian.PutUint32(b[14:18], 108)
+	binary.LittleEndian.PutUint32(b[18:22], uint32(len(pixels)))
+	binary.LittleEndian.PutUint32(b[22:26], 1)
+	binary.LittleEndian.PutUint16(b[26:28], 1)
+	binary.LittleEndian.PutUint16(b[28:30], 24)
+	binary.LittleEndian.PutUint32(b[70:74], uint32(cs.Type))
+	for i, e := range cs.Endpoints {
+		binary.LittleEndian.PutUint32(b[74+12*i:], uint32(e.X*(1<<30)))
+		binary.LittleEndian.PutUint32(b[78+12*i:], uint32(e.Y*(1<<30)))
+		binary.LittleEndian.PutUint32(b[82+12*i:], uint32(e.Z*(1<<30)))
+		binary.LittleEndian.PutUint32(b[110+4*i:], uint32(cs.Gamma[i]*(1<<16)))
+	}
+	for i, p := range pixels {
+		// BGR order
+		b[offset+3*i], b[offset+3*i+1], b[offset+3*i+2] = p[2], p[1], p[0]
+	}
+
+	return b
+}
+
+var (
+	srgbSpace = ColorSpace{
+		Endpoints: [3]CIEXYZ{{0.4124, 0.2126, 0.0193}, {0.3576, 0.7152, 0.1192}, {0.1805, 0.0722, 0.9505}},
+	}
+	adobeSpace = ColorSpace{
+		Endpoints: [3]CIEXYZ{{0.5767, 0.2973, 0.0270}, {0.1856, 0.6274, 0.0707}, {0.1882, 0.0753, 0.9911}},
+		Gamma:     [3]float64{2.2, 2.2, 2.2},
+	}
+)
+
+func TestColorSpaceMetadata(t *testing.T) {
+	meta, err := NewDecoder(bytes.NewReader(v4Image(adobeSpace, [3]uint8{}))).Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := meta.ColorSpace
+	if cs == nil || !cs.Calibrated() {
+		t.Fatalf("color space = %+v, expected calibrated", cs)
+	}
+	if math.Abs(cs.Endpoints[0].X-0.5767) > 1e-6 || math.Abs(cs.Gamma[2]-2.2) > 1e-4 {
+		t.Errorf("unexpected color space %+v", cs)
+	}
+
+	m := srgbSpace.SRGBMatrix()
+	for i := range m {
+		for j := range m[i] {
+			expected := 0.0
+			if i == j {
+				expected = 1
+			}
+			if math.Abs(m[i][j]-expected) > 1e-3 {
+				t.Errorf("sRGB matrix[%d][%d] = %f, expected %f", i, j, m[i][j], expected)
+			}
+		}
+	}
+}
+
+func TestSRGBConversion(t *testing.T) {
+	pixels := [][3]uint8{{200, 100, 50}, {128, 128, 128}}
+
+	img, err := Decode(bytes.NewReader(v4Image(srgbSpace, pixels...)), WithSRGBConversion(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, p := range pixels {
+		c := img.(*image.RGBA).RGBAAt(x, 0)
+		for i, v := range []uint8{c.R, c.G, c.B} {
+			if d := int(v) - int(p[i]); d < -1 || d > 1 {
+				t.Errorf("sRGB endpoints: pixel %d = %v, expected %v", x, c, p)
+			}
+		}
+	}
+
+	img, err = Decode(bytes.NewReader(v4Image(adobeSpace, pixels...)), WithSRGBConversion(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := img.(*image.RGBA).RGBAAt(0, 0); c.R < 210 {
+		t.Errorf("wide gamut color was not converted (got: %v)", c)
+	}
+	if c := img.(*image.RGBA).RGBAAt(1, 0); c.R != c.G || c.G != c.B {
+		t.Errorf("gray is not gray after conversion (got: %v)", c)
+	}
+}
@@ -0,0 +1,120 @@
+package bmp
+
+import (
+	"image"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// DecodeConcurrent reads a BMP image from r, decoding bands of rows in
+// parallel on up to workers goroutines, or GOMAXPROCS if workers is not
+// positive. Each goroutine reads the bytes of its own band, located from
+// the stride, and decodes them into the shared image.
+//
+// The pixel data must be uncompressed. WithProgress callbacks are
+// serialized but rows complete out of order. Images decoded to a type of
+// their own, by WithBitmap, WithAlphaMask or as the image.Gray16 of a 16
+// bpp gray image, are decoded sequentially like by Decode.
+func DecodeConcurrent(r io.ReaderAt, workers int, opts ...Option) (_ image.Image, err error) {
+	d := NewDecoder(io.NewSectionReader(r, 0, math.MaxInt64), opts...)
+	d.path = "concurrent"
+	defer func() { d.report("concurrent", err) }()
+	if _, err := d.Config(); err != nil {
+		return nil, err
+	}
+
+	if d.compressed() {
+		return nil, ErrCompressed
+	}
+	if err := d.resolveAlpha(); err != nil {
+		return nil, err
+	}
+	if d.ownTarget() {
+		if err := d.advance(stageImage); err != nil {
+			return nil, err
+		}
+		return d.image, nil
+	}
+
+	stride, err := d.stride(d.rowLen(), 1)
+	if err != nil {
+		return nil, err
+	}
+	img, pix, stride := d.newImage(image.Rect(0, 0, d.width, d.height), stride)
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > d.height {
+		workers = d.height
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		done int
+	)
+	errs := make([]error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// bands of stored rows, read in chunks of about 256 KiB
+			lo, hi := d.height*i/workers, d.height*(i+1)/workers
+			chunk := 1 << 18 / len(d.row)
+			if chunk < 1 {
+				chunk = 1
+			}
+			if chunk > hi-lo {
+				chunk = hi - lo
+			}
+			src := make([]byte, chunk*len(d.row))
+
+			for n := lo; n < hi; n += chunk {
+				if n+chunk > hi {
+					chunk = hi - n
+				}
+				b := src[:chunk*len(d.row)]
+				if k, err := r.ReadAt(b, d.dataOffset+int64(n)*int64(len(d.row))); k < len(b) {
+					if err == io.EOF {
+						err = io.ErrUnexpectedEOF
+					}
+					errs[i] = err
+					return
+				}
+
+				for j := 0; j < chunk; j++ {
+					y := d.rowY(n + j)
+					if err := d.convertRow(pix[y*stride:y*stride+d.rowLen()], b[j*len(d.row):(j+1)*len(d.row)]); err != nil {
+						errs[i] = err
+						return
+					}
+				}
+
+				if d.opts.progress != nil {
+					mu.Lock()
+					done += chunk
+					d.rowDone(done)
+					mu.Unlock()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	d.extraRead += int64(d.height) * int64(len(d.row))
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if d.opts.model != nil {
+		return convert(img, d.opts.model)
+	}
+
+	return img, nil
+}
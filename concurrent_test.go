@@ -0,0 +1,81 @@
+package bmp
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeConcurrent(t *testing.T) {
+	src := testImage(9, 13)
+	for _, depth := range []int{24, 32} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(depth)
+		if err := e.Encode(src); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, workers := range []int{0, 1, 4, 20} {
+			var last int
+			img, err := DecodeConcurrent(bytes.NewReader(buf.Bytes()), workers, WithProgress(func(rows, height int) {
+				last = rows
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameImage(img, src) {
+				t.Errorf("%d bpp, %d workers: image differs from the source", depth, workers)
+			}
+			if last != 13 {
+				t.Errorf("%d bpp, %d workers: last progress = %d, expected 13", depth, workers, last)
+			}
+		}
+
+		truncated := buf.Bytes()[:buf.Len()-10]
+		if _, err := DecodeConcurrent(bytes.NewReader(truncated), 4); err != io.ErrUnexpectedEOF {
+			t.Errorf("%d bpp: error = %v, expected %v", depth, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestDecodeConcurrentOwnTarget(t *testing.T) {
+	var bitmap bytes.Buffer
+	e := NewEncoder(&bitmap)
+	e.SetDepth(1)
+	if err := e.Encode(testPaletted(9, 5, 2)); err != nil {
+		t.Fatal(err)
+	}
+	var mask bytes.Buffer
+	e = NewEncoder(&mask)
+	e.SetDepth(8)
+	if err := e.Encode(testPaletted(9, 5, 7)); err != nil {
+		t.Fatal(err)
+	}
+	gray := rawFile(UnpackerKey{16, CompressionBitfields, 0xffff, 0xffff, 0xffff, 0}, 2, 2, nil, []byte{0x34, 0x12, 0xff, 0xff, 0, 0, 1, 0})
+
+	tests := []struct {
+		file []byte
+		opts []Option
+	}{
+		{bitmap.Bytes(), []Option{WithBitmap(true)}},
+		{mask.Bytes(), []Option{WithAlphaMask(true)}},
+		{gray, nil},
+	}
+	for _, tt := range tests {
+		want, err := Decode(bytes.NewReader(tt.file), tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeConcurrent(bytes.NewReader(tt.file), 4, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(want) {
+			t.Errorf("image type = %T, expected %T", got, want)
+		} else if !sameImage(got, want) {
+			t.Errorf("%T: image differs from the one of Decode", want)
+		}
+	}
+}
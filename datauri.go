@@ -0,0 +1,52 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"mime"
+	"strings"
+)
+
+// EncodeOption configures the Encoder used by the encoding helpers through
+// its setters, for instance func(e *bmp.Encoder) { e.SetDepth(8) }.
+type EncodeOption func(*Encoder)
+
+// dataURIPrefix starts the data URIs of BMP images.
+const dataURIPrefix = "data:image/bmp;base64,"
+
+// EncodeDataURI encodes m and returns it as a data URI, to be inlined in
+// HTML or JSON.
+func EncodeDataURI(m image.Image, opts ...EncodeOption) (string, error) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, opt := range opts {
+		opt(e)
+	}
+	if err := e.Encode(m); err != nil {
+		return "", err
+	}
+
+	return dataURIPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeDataURI decodes the BMP image of a base64 data URI of type image/bmp
+// or image/x-bmp, such as the ones returned by EncodeDataURI.
+func DecodeDataURI(s string, opts ...Option) (image.Image, error) {
+	i := strings.IndexByte(s, ',')
+	if !strings.HasPrefix(s, "data:") || i < 0 {
+		return nil, fmt.Errorf("bmp: not a data URI")
+	}
+
+	params := s[len("data:"):i]
+	if !strings.HasSuffix(params, ";base64") {
+		return nil, fmt.Errorf("bmp: data URI is not base64 encoded")
+	}
+	typ, _, err := mime.ParseMediaType(strings.TrimSuffix(params, ";base64"))
+	if err != nil || (typ != ContentTypeBMP && typ != "image/x-bmp") {
+		return nil, fmt.Errorf("bmp: unsupported media type of data URI (got: %q)", strings.TrimSuffix(params, ";base64"))
+	}
+
+	return Decode(base64.NewDecoder(base64.StdEncoding, strings.NewReader(s[i+1:])), opts...)
+}
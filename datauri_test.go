@@ -0,0 +1,69 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDataURI(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	m.SetNRGBA(1, 0, color.NRGBA{0x10, 0x20, 0x30, 0x80})
+
+	s, err := EncodeDataURI(m, func(e *Encoder) { e.SetDepth(32) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s, "data:image/bmp;base64,") {
+		t.Fatalf("unexpected prefix: %.30s", s)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "data:image/bmp;base64,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.NRGBAModel.Convert(got.At(1, 0)); c != (color.NRGBA{0x10, 0x20, 0x30, 0x80}) {
+		t.Errorf("got %v", c)
+	}
+}
+
+func TestDecodeDataURI(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	m.SetNRGBA(2, 0, color.NRGBA{0xff, 0, 0, 0xff})
+
+	s, err := EncodeDataURI(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uri := range []string{s, strings.Replace(s, "image/bmp", "image/x-bmp", 1)} {
+		got, err := DecodeDataURI(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Bounds() != m.Bounds() || color.NRGBAModel.Convert(got.At(2, 0)) != m.At(2, 0) {
+			t.Errorf("unexpected image %v", got.Bounds())
+		}
+	}
+}
+
+func TestDecodeDataURIErrors(t *testing.T) {
+	for _, s := range []string{
+		"image/bmp;base64,Qk0=",
+		"data:image/bmp,BM",
+		"data:image/png;base64,iVBORw0KGgo=",
+		"data:;base64,Qk0=",
+		"data:image/bmp;base64,!!!",
+	} {
+		if _, err := DecodeDataURI(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -1,48 +1,221 @@
 package bmp
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"io"
+	"io/ioutil"
+	"time"
 )
 
-type decoder struct {
-	r        io.Reader
-	image    image.Image
-	config   image.Config
-	tmp      [3 * 256]byte
-	topDown  bool
-	bpp      int
-	numColor int
-	width    int
-	height   int
-}
-
-func (d *decoder) readHeader() error {
-	const (
-		fileHeaderLen = 14
-		infoHeaderLen = 40
-	)
+// limitedReader fails with ErrLimitExceeded once more than n bytes are read.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
 
-	// read file header and DIB header length
-	if _, err := io.ReadFull(d.r, d.tmp[:fileHeaderLen+4]); err != nil {
-		if err == io.EOF {
-			err = io.ErrUnexpectedEOF
-		}
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// read one byte more than allowed to detect the overflow
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		n = int(l.n)
+		l.n = 0
+		return n, fmt.Errorf("%w: file is larger than the maximum size", ErrLimitExceeded)
+	}
+	l.n -= int64(n)
+
+	return n, err
+}
+
+// Decoder reads a BMP image in stages, so that the header can be inspected
+// before deciding whether to decode the pixel data.
+type Decoder struct {
+	r          io.Reader
+	src        io.Reader
+	opts       options
+	image      image.Image
+	config     image.Config
+	meta       Metadata
+	tmp        [4 * 256]byte
+	row        []byte
+	key        UnpackerKey
+	unpacker   unpackerEntry
+	topDown    bool
+	mirrored   bool
+	bpp        int
+	numColor   int
+	entryLen   int
+	width      int
+	height     int
+	gap        int
+	masksLen   int
+	dib        bool
+	dataOffset int64
+	palette    color.Palette
+	stage      int
+	err        error
+	srgb       *srgbConverter
+	rle        *rleReader
+	codec      CompressionDecoder
+	// decompressor is nil for uncompressed pixel data
+	decompressor Decompressor
+	profile      []byte
+	// origin is the position of src when reading started, if it is an
+	// io.Seeker, for Retry
+	origin   int64
+	originOK bool
+	// counted marks the palette indices counted by WithColorCounter
+	counted [256]bool
+	// warnings are the violations tolerated by WithLenient
+	warnings []Warning
+	// gray is the mask of 16 bpp images of a single channel, decoded to
+	// image.Gray16
+	gray uint16
+	// partial is set when src only holds the start of the input, as fed to
+	// a Parser, whose length is then not the one of the input
+	partial bool
+	// alphaResolved is set once the pixel data has told whether the
+	// reserved byte of the pixels is alpha, for AlphaAuto
+	alphaResolved bool
+	// headerOnly is set by DecodeConfig, which does not need the pixel
+	// data to be there
+	headerOnly bool
+
+	// measurements for WithMetrics and WithTracer
+	counter   *countingReader
+	extraRead int64
+	start     time.Time
+	path      string
+	reported  bool
+}
+
+// stages of a Decoder
+const (
+	stageNone = iota
+	stageConfig
+	stagePixels
+	stageImage
+)
+
+// lengths of the DIB headers
+const (
+	coreHeaderLen = 12
+	infoHeaderLen = 40
+	v4HeaderLen   = 108
+	v5HeaderLen   = 124
+)
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader, opts ...Option) *Decoder {
+	d := &Decoder{opts: newOptions(opts)}
+	d.init(r)
+
+	return d
+}
+
+// Reset discards the state of d and makes it read a new image from r, with
+// the same options, keeping the buffers allocated for the previous images
+// so that decoding many images with one Decoder allocates little more than
+// the images.
+func (d *Decoder) Reset(r io.Reader) {
+	*d = Decoder{
+		opts: d.opts,
+		dib:  d.dib,
+		row:  d.row[:0],
+	}
+	d.init(r)
+}
 
+// Retry makes d decode the image again with opts instead of its options,
+// for instance WithLenient after a strict decode failed, seeking the reader
+// back to where d started reading. The reader must be an io.Seeker.
+func (d *Decoder) Retry(opts ...Option) error {
+	s, ok := d.src.(io.Seeker)
+	if !ok || !d.originOK {
+		return errors.New("bmp: retry requires a reader implementing io.Seeker")
+	}
+	if _, err := s.Seek(d.origin, io.SeekStart); err != nil {
 		return err
 	}
 
-	if string(d.tmp[:2]) != "BM" {
-		return fmt.Errorf("bmp: invalid file signature (got: %q)", d.tmp[:2])
+	d.opts = newOptions(opts)
+	d.Reset(d.src)
+
+	return nil
+}
+
+// init makes d read from r.
+func (d *Decoder) init(r io.Reader) {
+	d.r, d.src = r, r
+	if s, ok := r.(io.Seeker); ok {
+		pos, err := s.Seek(0, io.SeekCurrent)
+		d.origin, d.originOK = pos, err == nil
+	}
+
+	if max := d.opts.limits.MaxFileSize; max > 0 {
+		d.r = &limitedReader{r: r, n: max}
+	}
+
+	if d.opts.metrics != nil {
+		d.counter = &countingReader{r: d.r}
+		d.r = d.counter
+	}
+	if d.opts.metrics != nil || d.opts.tracer != nil {
+		d.start = time.Now()
+	}
+}
+
+// rowDone reports the progress after n rows have been decoded.
+func (d *Decoder) rowDone(n int) {
+	if d.opts.progress != nil {
+		d.opts.progress(n, d.height)
+	}
+}
+
+func (d *Decoder) readHeader() error {
+	const fileHeaderLen = 14
+
+	if d.dib {
+		// headerless bitmaps start with the DIB header, the pixel data
+		// following the color table
+		for i := range d.tmp[:fileHeaderLen] {
+			d.tmp[i] = 0
+		}
+		if _, err := io.ReadFull(d.r, d.tmp[fileHeaderLen:fileHeaderLen+4]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return err
+		}
+	} else {
+		// read file header and DIB header length
+		if _, err := io.ReadFull(d.r, d.tmp[:fileHeaderLen+4]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return err
+		}
+
+		if string(d.tmp[:2]) != "BM" {
+			return fmt.Errorf("bmp: invalid file signature (got: %q)", d.tmp[:2])
+		}
 	}
 
 	dibLen := binary.LittleEndian.Uint32(d.tmp[fileHeaderLen : fileHeaderLen+4])
 	switch dibLen {
 	// support these DIB header length
-	case 40, 52, 60, 96, 108, 112, 120, 124:
+	case coreHeaderLen, 40, 52, 56, 60, 96, 108, 112, 120, 124:
 	default:
 		return fmt.Errorf("bmp: unsupported DIB header length (got: %d)", dibLen)
 	}
@@ -55,62 +228,264 @@ func (d *decoder) readHeader() error {
 		return err
 	}
 
+	if dibLen == coreHeaderLen {
+		d.readCoreHeader()
+	} else if err := d.readInfoHeader(dibLen); err != nil {
+		return err
+	}
+
+	if d.width <= 0 || d.height == 0 {
+		return fmt.Errorf("bmp: width must be greater than zero and height must be non-zero (width: %d, height: %d)", d.width, d.height)
+	}
+
+	if max := d.opts.limits.MaxPixels; max > 0 && int64(d.width)*int64(d.height) > max {
+		return fmt.Errorf("%w: image has %d pixels (max: %d)", ErrLimitExceeded, int64(d.width)*int64(d.height), max)
+	}
+
+	offset := binary.LittleEndian.Uint32(d.tmp[10:14])
+
+	extra := 0
+	if d.bpp <= 8 && d.numColor > 1<<uint(d.bpp) {
+		if !d.opts.lenient {
+			return fmt.Errorf("bmp: too many colors for %d bpp (got: %d)", d.bpp, d.numColor)
+		}
+		// the extra entries are skipped like a gap
+		extra = (d.numColor - 1<<uint(d.bpp)) * d.entryLen
+		d.numColor = 1 << uint(d.bpp)
+	}
+
+	if max := d.opts.limits.MaxPaletteEntries; max > 0 && d.numColor > max {
+		return fmt.Errorf("%w: color table has %d entries (max: %d)", ErrLimitExceeded, d.numColor, max)
+	}
+
+	if d.quirk(Quirk16BitAlphaMask) {
+		d.key.AlphaMask = 0
+	}
+
+	u, ok := reservedAlphaUnpacker(d.key, d.opts.alpha)
+	if !ok {
+		u, ok = lookupUnpacker(d.key)
+	}
+	if mask, isGray := grayMask(d.key); !ok && isGray {
+		u, ok = unpackerEntry{grayUnpacker(mask), true}, true
+		d.gray = mask
+	}
+	codec, isCodec := lookupCompression(d.key.Compression)
+	switch {
+	case ok:
+		d.unpacker = u
+	case isCodec:
+		d.codec = codec
+	case d.key.Compression == CompressionRGB:
+		return fmt.Errorf("bmp: unsupported the number of bits per pixel (got: %d)", d.bpp)
+	case d.key.Compression == CompressionBitfields:
+		return fmt.Errorf("bmp: unsupported bit fields for %d bpp (red: %#x, green: %#x, blue: %#x, alpha: %#x)", d.bpp, d.key.RedMask, d.key.GreenMask, d.key.BlueMask, d.key.AlphaMask)
+	default:
+		return fmt.Errorf("bmp: unsupported compression method (got: %d)", d.key.Compression)
+	}
+
+	// color tables of true color images are optional hints, skipped
+	expected := fileHeaderLen + int(dibLen) + d.masksLen + d.numColor*d.entryLen
+
+	if offset == 0 && (d.opts.lenient || d.dib) {
+		// some writers leave the offset zero, assume the standard layout
+		offset = uint32(expected + extra)
+	}
+
+	if int(offset) != expected {
+		// lenient mode skips the gap between the headers and the pixel
+		// data, which may otherwise only hold an embedded profile
+		if int(offset) < expected || !d.opts.lenient && !d.profileInGap(int64(expected), int64(offset)) {
+			return fmt.Errorf("bmp: offset should be %d (got: %d)", expected, offset)
+		}
+		d.gap = int(offset) - expected
+	}
+	d.dataOffset = int64(expected + d.gap)
+
+	return nil
+}
+
+// readCoreHeader parses a BITMAPCOREHEADER, which stores 16-bit dimensions
+// and is followed by a full color table of 3-byte entries.
+func (d *Decoder) readCoreHeader() {
+	d.width = int(binary.LittleEndian.Uint16(d.tmp[18:20]))
+	d.height = int(binary.LittleEndian.Uint16(d.tmp[20:22]))
+	d.bpp = int(binary.LittleEndian.Uint16(d.tmp[24:26]))
+	d.key = UnpackerKey{BitsPerPixel: d.bpp}
+	d.entryLen = 3
+
+	d.numColor = 0
+	if d.bpp <= 8 {
+		d.numColor = 1 << uint(d.bpp)
+	}
+
+	d.meta = Metadata{
+		FileSize:         binary.LittleEndian.Uint32(d.tmp[2:6]),
+		Reserved:         binary.LittleEndian.Uint32(d.tmp[6:10]),
+		PixelOffset:      binary.LittleEndian.Uint32(d.tmp[10:14]),
+		HeaderSize:       coreHeaderLen,
+		Height:           int32(d.height),
+		BitsPerPixel:     d.bpp,
+		ColorsUsed:       d.numColor,
+		TransparentIndex: -1,
+	}
+}
+
+// readInfoHeader parses a BITMAPINFOHEADER or one of its extensions.
+func (d *Decoder) readInfoHeader(dibLen uint32) error {
 	d.width = int(int32(binary.LittleEndian.Uint32(d.tmp[18:22])))
 	d.height = int(int32(binary.LittleEndian.Uint32(d.tmp[22:26])))
 
+	d.meta = Metadata{
+		FileSize:         binary.LittleEndian.Uint32(d.tmp[2:6]),
+		Reserved:         binary.LittleEndian.Uint32(d.tmp[6:10]),
+		PixelOffset:      binary.LittleEndian.Uint32(d.tmp[10:14]),
+		HeaderSize:       int(dibLen),
+		Height:           int32(d.height),
+		BitsPerPixel:     int(binary.LittleEndian.Uint16(d.tmp[28:30])),
+		Compression:      Compression(binary.LittleEndian.Uint32(d.tmp[30:34])),
+		ImageSize:        binary.LittleEndian.Uint32(d.tmp[34:38]),
+		XPelsPerMeter:    int32(binary.LittleEndian.Uint32(d.tmp[38:42])),
+		YPelsPerMeter:    int32(binary.LittleEndian.Uint32(d.tmp[42:46])),
+		ColorsUsed:       int(binary.LittleEndian.Uint32(d.tmp[46:50])),
+		ColorsImportant:  int(binary.LittleEndian.Uint32(d.tmp[50:54])),
+		TransparentIndex: -1,
+	}
+	// the file size is not relied on, the quirk is only reported
+	d.quirk(QuirkHeaderFileSize)
+
 	if d.height < 0 {
 		d.height, d.topDown = -d.height, true
+		d.meta.TopDown = true
 	}
-
-	if d.width <= 0 || d.height == 0 {
-		return fmt.Errorf("bmp: width must be greater than zero and height must be non-zero (width: %d, height: %d)", d.width, d.height)
+	if d.width < 0 && d.opts.lenient {
+		d.width, d.mirrored = -d.width, true
+		d.meta.Mirrored = true
 	}
 
 	d.bpp = int(binary.LittleEndian.Uint16(d.tmp[28:30]))
-	compression := binary.LittleEndian.Uint16(d.tmp[30:34])
+	d.key = UnpackerKey{BitsPerPixel: d.bpp, Compression: d.meta.Compression}
 
-	rm, gm, bm := binary.LittleEndian.Uint32(d.tmp[54:58]), binary.LittleEndian.Uint32(d.tmp[58:62]), binary.LittleEndian.Uint32(d.tmp[62:66])
-	mask := rm ^ gm ^ bm
-	if compression == 3 && dibLen > infoHeaderLen &&
-		(d.bpp == 16 && mask == 0x7fff) ||
-		(d.bpp == 32 && mask == 0x00ffffff) {
+	dec, _ := lookupDecompressor(d.meta.Compression, d.bpp)
+	switch dec.(type) {
+	case nil:
+		// left to the unpackers and RegisterCompression
+	case rleDecompressor:
+		if d.topDown && !d.quirk(QuirkTopDownRLE) {
+			return fmt.Errorf("bmp: RLE compressed images cannot be top-down")
+		}
+		if err := d.checkRLESize(); err != nil {
+			return err
+		}
+		// the decompressed indices are unpacked like plain ones
+		d.decompressor = dec
+		d.key.Compression = CompressionRGB
+	case plainDecompressor:
+		if d.meta.Compression == CompressionBitfields {
+			if err := d.readMasks(dibLen); err != nil {
+				return err
+			}
+		}
+	default:
+		d.decompressor = dec
+		d.key.Compression = CompressionRGB
+	}
 
-		compression = 0
+	d.numColor = int(binary.LittleEndian.Uint32(d.tmp[46:50]))
+	if d.numColor == 0 && d.bpp > 0 && d.bpp <= 8 {
+		// zero means the maximum number of colors
+		d.numColor = 1 << uint(d.bpp)
 	}
+	d.entryLen = 4
 
-	if compression != 0 {
-		return fmt.Errorf("bmp: unsupported compression method (got: %d)", compression)
+	if dibLen >= 108 {
+		d.meta.ColorSpace = readColorSpace(d.tmp[14+56:])
+	}
+	if dibLen >= 120 {
+		d.meta.ColorSpace.ProfileOffset = binary.LittleEndian.Uint32(d.tmp[14+112:])
+		d.meta.ColorSpace.ProfileSize = binary.LittleEndian.Uint32(d.tmp[14+116:])
 	}
 
-	offset := binary.LittleEndian.Uint32(d.tmp[10:14])
-	d.numColor = int(binary.LittleEndian.Uint32(d.tmp[46:50]))
+	return nil
+}
 
-	switch d.bpp {
-	case 1, 4, 8:
-		if int(offset) != fileHeaderLen+int(dibLen)+d.numColor*4 {
-			return fmt.Errorf("bmp: offset should be %d (got: %d)", fileHeaderLen+int(dibLen)+d.numColor*4, offset)
+// readMasks parses the channel masks of bit fields compressed images,
+// which follow a BITMAPINFOHEADER.
+func (d *Decoder) readMasks(dibLen uint32) error {
+	if dibLen == infoHeaderLen {
+		d.masksLen = 12
+		if _, err := io.ReadFull(d.r, d.tmp[54:66]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return err
 		}
-	case 16, 24, 32:
-		if int(offset) != fileHeaderLen+int(dibLen) {
-			return fmt.Errorf("bmp: offset should be %d (got: %d)", fileHeaderLen+int(dibLen), offset)
+	}
+
+	d.key.RedMask = binary.LittleEndian.Uint32(d.tmp[54:58])
+	d.key.GreenMask = binary.LittleEndian.Uint32(d.tmp[58:62])
+	d.key.BlueMask = binary.LittleEndian.Uint32(d.tmp[62:66])
+	if dibLen >= 56 {
+		d.key.AlphaMask = binary.LittleEndian.Uint32(d.tmp[66:70])
+	}
+
+	return nil
+}
+
+// skipGap discards the bytes between the color table and the pixel data,
+// keeping the embedded profile if it lies there.
+func (d *Decoder) skipGap() error {
+	gapStart := d.dataOffset - int64(d.gap)
+	start, end, ok := d.profileRange()
+	if !ok || start < gapStart || end > d.dataOffset {
+		return d.discard(int64(d.gap))
+	}
+
+	if err := d.discard(start - gapStart); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.r, end-start); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
-	default:
-		return fmt.Errorf("bmp: unsupported the number of bits per pixel (got: %d)", d.bpp)
+
+		return err
+	}
+	d.profile = buf.Bytes()
+
+	return d.discard(d.dataOffset - end)
+}
+
+// discard skips n bytes of the input.
+func (d *Decoder) discard(n int64) error {
+	if n == 0 {
+		return nil
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, d.r, n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return err
 	}
 
 	return nil
 }
 
-func (d *decoder) decodeConfig() error {
+func (d *Decoder) decodeConfig() error {
 	if err := d.readHeader(); err != nil {
 		return err
 	}
 
 	var model color.Model
 
-	switch d.bpp {
-	case 1, 4, 8:
-		if _, err := io.ReadFull(d.r, d.tmp[:d.numColor*4]); err != nil {
+	if d.bpp <= 8 {
+		table := d.tmp[:d.numColor*d.entryLen]
+		if _, err := io.ReadFull(d.r, table); err != nil {
 			if err == io.EOF {
 				err = io.ErrUnexpectedEOF
 			}
@@ -119,194 +494,687 @@ func (d *decoder) decodeConfig() error {
 		}
 
 		colorTable := make(color.Palette, d.numColor)
+		alpha := d.opts.paletteAlpha && d.entryLen == 4 && hasPaletteAlpha(table)
+		if d.entryLen == 4 {
+			d.meta.TransparentIndex = transparentIndex(table)
+		}
 		for i := range colorTable {
 			// BGR order
-			colorTable[i] = color.RGBA{d.tmp[4*i+2], d.tmp[4*i+1], d.tmp[4*i], 0xff}
+			e := table[i*d.entryLen:]
+			if alpha {
+				colorTable[i] = color.NRGBA{e[2], e[1], e[0], e[3]}
+				continue
+			}
+			colorTable[i] = color.RGBA{e[2], e[1], e[0], 0xff}
 		}
 		model = colorTable
-	case 16, 24, 32:
+		d.palette = colorTable
+	} else {
+		model = d.rowModel()
+		if d.gray != 0 {
+			model = color.Gray16Model
+		}
+		d.gap += d.numColor * d.entryLen
+	}
+
+	if cs := d.meta.ColorSpace; d.opts.toSRGB && cs != nil && cs.Calibrated() {
+		d.srgb = newSRGBConverter(cs)
+		if d.palette != nil {
+			d.srgb.convertPalette(d.palette)
+		}
+	}
+
+	if d.codec != nil && len(d.palette) == 0 {
+		// the color model is only known once decoded
 		model = color.RGBAModel
 	}
 
 	d.config = image.Config{ColorModel: model, Width: d.width, Height: d.height}
+	if d.opts.model != nil {
+		d.config.ColorModel = d.opts.model
+	}
+	if d.alphaMask() {
+		d.config.ColorModel = color.AlphaModel
+	}
 
-	return nil
-}
+	// row data must be an integer multiple of 4 bytes
+	if n := (d.width*d.bpp + 31) / 32 * 4; cap(d.row) >= n {
+		d.row = d.row[:n]
+	} else {
+		d.row = make([]byte, n)
+	}
 
-func (d *decoder) decodePalleted() error {
-	paletted := image.NewPaletted(image.Rect(0, 0, d.width, d.height), d.config.ColorModel.(color.Palette))
+	if err := d.skipGap(); err != nil {
+		return err
+	}
+	if err := d.inferStride(); err != nil {
+		return err
+	}
 
-	y0, y1, dy := d.height-1, -1, -1
-	if d.topDown {
-		y0, y1, dy = 0, d.height, 1
+	if t := d.opts.transformer; t != nil && d.palette != nil {
+		transformPalette(t, d.colorSource(), d.palette)
 	}
 
-	for y := y0; y != y1; y += dy {
-		// row data must be an integer multiple of 4 bytes
-		if _, err := io.ReadFull(d.r, d.tmp[:(d.width*d.bpp+31)/32*4]); err != nil {
-			if err == io.EOF {
-				return io.ErrUnexpectedEOF
-			}
+	switch dec := d.decompressor.(type) {
+	case nil:
+	case rleDecompressor:
+		d.rle = newRLEReader(d.r, d.meta.ImageSize, d.bpp, d.width, d.height)
+	default:
+		r, err := dec.NewReader(d.r, d.width, d.height, d.meta)
+		if err != nil {
 			return err
 		}
+		d.r = r
+	}
 
-		p := paletted.Pix[y*paletted.Stride : (y+1)*paletted.Stride]
+	return nil
+}
 
-		if d.width < 8/d.bpp {
-			for j := 0; j < d.width; j++ {
-				p[j] = (d.tmp[0] & (0xff &^ (0xff >> d.bpp) >> (d.bpp * j))) >> (8 - (d.bpp * (j + 1)))
-			}
-			continue
+// hasPaletteAlpha reports whether the reserved byte of any color table
+// entry is non-zero.
+func hasPaletteAlpha(table []byte) bool {
+	for i := 3; i < len(table); i += 4 {
+		if table[i] != 0 {
+			return true
 		}
+	}
 
-		for i := 0; i < ((d.width+1)*d.bpp)/8; i++ {
-			// e.g. d.bpp = 4:
-			// j=0 => p[i*2] = (d.tmp[i] & 0xf0) >> 4
-			// j=1 => p[i*2+1] = d.tmp[i] & 0xf
-			for j := 0; j < (8 / d.bpp); j++ {
-				p[i*2+j] = (d.tmp[i] & (0xff &^ (0xff >> d.bpp) >> (d.bpp * j))) >> (8 - (d.bpp * (j + 1)))
+	return false
+}
+
+// transparentIndex returns the index of the only entry of the color table
+// whose reserved byte is zero, the others being 0xff, or -1.
+func transparentIndex(table []byte) int {
+	idx := -1
+	for i := 3; i < len(table); i += 4 {
+		switch table[i] {
+		case 0xff:
+		case 0:
+			if idx >= 0 {
+				return -1
 			}
+			idx = i / 4
+		default:
+			return -1
 		}
 	}
 
-	d.image = paletted
-
-	return nil
+	return idx
 }
 
-func (d *decoder) decode16() error {
-	rgba := image.NewRGBA(image.Rect(0, 0, d.width, d.height))
+// readRow reads the next stored row and unpacks it into dst.
+func (d *Decoder) readRow(dst []byte) error {
+	if d.codec != nil {
+		return d.errCodecRows()
+	}
+	if d.rle != nil {
+		if err := d.rle.readRow(dst); err != nil {
+			return err
+		}
+		if d.mirrored {
+			d.mirrorRow(dst)
+		}
+		d.countRow(dst)
+		return d.checkIndices(dst)
+	}
 
-	y0, y1, dy := d.height-1, -1, -1
-	if d.topDown {
-		y0, y1, dy = 0, d.height, 1
+	if _, err := io.ReadFull(d.r, d.row); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
 	}
 
-	for y := y0; y != y1; y += dy {
-		if _, err := io.ReadFull(d.r, d.tmp[:(d.width*2+3)&^3]); err != nil {
-			if err == io.EOF {
-				return io.ErrUnexpectedEOF
-			}
-			return err
+	return d.convertRow(dst, d.row)
+}
+
+// readRow24 reads the next stored 24 bpp row directly into dst and expands
+// it in place, saving the copy from the row buffer.
+func (d *Decoder) readRow24(dst []byte) error {
+	// a padded 24 bpp row is never longer than the unpacked one
+	if _, err := io.ReadFull(d.r, dst[:len(d.row)]); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
 		}
+		return err
+	}
 
-		p := rgba.Pix[y*rgba.Stride : (y+1)*rgba.Stride]
+	// expand from the end so that pixels are not overwritten before they
+	// are read
+	for i, j := len(dst)-4, len(dst)/4*3-3; i >= 0; i, j = i-4, j-3 {
+		// BGR order
+		src, p := dst[j:j+3:j+3], dst[i:i+4:i+4]
+		p[3], p[2], p[1], p[0] = 0xff, src[0], src[1], src[2]
+	}
 
-		for i, j := 0, 0; i < d.width*4; i, j = i+4, j+2 {
-			// BGR order
-			p[i] = (d.tmp[j+1] & 0x3e) >> 1
-			p[i+1] = ((d.tmp[j] & 0x07) << 3) | ((d.tmp[j+1] & 0xc0) >> 6)
-			p[i+2] = (d.tmp[j] & 0xf8) >> 3
-			p[i+3] = 0xff
+	if d.mirrored {
+		d.mirrorRow(dst)
+	}
+	if d.srgb != nil {
+		d.srgb.convertRow(dst)
+	}
+	if t := d.opts.transformer; t != nil {
+		t.TransformRow(d.colorSource(), dst)
+	}
+	d.countRow(dst)
+
+	return nil
+}
+
+// convertRow unpacks the stored row src into dst, in the order of the
+// pixels of the image.
+func (d *Decoder) convertRow(dst, src []byte) error {
+	if err := d.unpackRow(dst, src); err != nil {
+		return err
+	}
+	if d.mirrored {
+		d.mirrorRow(dst)
+	}
+	d.countRow(dst)
+
+	return nil
+}
+
+// mirrorRow reverses the order of the unpacked pixels of row.
+func (d *Decoder) mirrorRow(row []byte) {
+	n := d.pixelLen()
+	for i, j := 0, len(row)-n; i < j; i, j = i+n, j-n {
+		for k := 0; k < n; k++ {
+			row[i+k], row[j+k] = row[j+k], row[i+k]
 		}
 	}
+}
+
+// unpackRow unpacks the stored pixels in src into dst, whose length
+// determines the number of pixels, in stored order.
+func (d *Decoder) unpackRow(dst, src []byte) error {
+	d.unpacker.u.Unpack(dst, src)
+	if d.palette != nil {
+		return d.checkIndices(dst)
+	}
+
+	if d.srgb != nil {
+		d.srgb.convertRow(dst)
+	}
+	if t := d.opts.transformer; t != nil {
+		t.TransformRow(d.colorSource(), dst)
+	}
 
 	return nil
 }
 
-func (d *decoder) decode24() error {
-	rgba := image.NewRGBA(image.Rect(0, 0, d.width, d.height))
+// checkIndices fails on palette indices past the color table if
+// WithUntrusted is set.
+func (d *Decoder) checkIndices(row []byte) error {
+	if !d.opts.strictPalette {
+		return nil
+	}
+
+	for x, idx := range row {
+		if int(idx) >= len(d.palette) {
+			return fmt.Errorf("bmp: palette index out of range at x = %d (got: %d, colors: %d)", x, idx, len(d.palette))
+		}
+	}
 
-	y0, y1, dy := d.height-1, -1, -1
+	return nil
+}
+
+// rowY returns the y coordinate of the n-th stored row.
+func (d *Decoder) rowY(n int) int {
 	if d.topDown {
-		y0, y1, dy = 0, d.height, 1
+		return n
 	}
 
-	for y := y0; y != y1; y += dy {
-		if _, err := io.ReadFull(d.r, d.tmp[:(d.width*3+3)&^3]); err != nil {
-			if err == io.EOF {
-				return io.ErrUnexpectedEOF
-			}
-			return err
+	return d.height - 1 - n
+}
+
+// rowLen returns the length of an unpacked row.
+func (d *Decoder) rowLen() int {
+	return d.width * d.pixelLen()
+}
+
+// plain24 reports whether the pixels are stored as plain 24 bpp BGR
+// samples, which rows can be expanded from in place.
+func (d *Decoder) plain24() bool {
+	return d.key == UnpackerKey{BitsPerPixel: 24}
+}
+
+// pixelLen returns the length of an unpacked pixel.
+func (d *Decoder) pixelLen() int {
+	if d.palette != nil {
+		return 1
+	}
+
+	return 4
+}
+
+// newImage allocates an image covering r the pixel data is decoded into,
+// with the given stride or packed rows if stride is zero.
+func (d *Decoder) newImage(r image.Rectangle, stride int) (img image.Image, pix []byte, _ int) {
+	if stride == 0 {
+		stride = r.Dx() * d.pixelLen()
+	}
+	pix = make([]byte, stride*r.Dy())
+
+	switch {
+	case d.palette != nil:
+		return &image.Paletted{Pix: pix, Stride: stride, Rect: r, Palette: d.palette}, pix, stride
+	case d.rowModel() == color.NRGBAModel:
+		return &image.NRGBA{Pix: pix, Stride: stride, Rect: r}, pix, stride
+	default:
+		return &image.RGBA{Pix: pix, Stride: stride, Rect: r}, pix, stride
+	}
+}
+
+// stride returns the stride of the decoded buffers whose rows take rowLen
+// bytes, as set with WithStride or WithRowAlignment. align is the row
+// alignment used if neither is set.
+func (d *Decoder) stride(rowLen, align int) (int, error) {
+	if s := d.opts.stride; s != 0 {
+		if s < rowLen {
+			return 0, fmt.Errorf("bmp: stride is smaller than a row of %d bytes (got: %d)", rowLen, s)
 		}
+		return s, nil
+	}
 
-		p := rgba.Pix[y*rgba.Stride : (y+1)*rgba.Stride]
+	if d.opts.align != 0 {
+		align = d.opts.align
+	}
+	if align <= 0 || align&(align-1) != 0 {
+		return 0, fmt.Errorf("bmp: row alignment must be a power of two (got: %d)", align)
+	}
 
-		for i, j := 0, 0; i < d.width*4; i, j = i+4, j+3 {
-			// BGR order
-			p[i] = d.tmp[j+2]
-			p[i+1] = d.tmp[j+1]
-			p[i+2] = d.tmp[j]
-			p[i+3] = 0xff
+	return (rowLen + align - 1) &^ (align - 1), nil
+}
+
+// compressed reports whether the pixel data is compressed, which rules out
+// random access to the rows.
+func (d *Decoder) compressed() bool {
+	return d.decompressor != nil || d.codec != nil
+}
+
+// inferStride shortens the rows of uncompressed images to the length
+// implied by the pixel data in lenient mode, when it is too short for rows
+// padded to 4 bytes but fits rows padded to 2 bytes or not padded at all,
+// as written by a few embedded writers. The length of the pixel data is
+// biSizeImage, or the rest of the input if known.
+func (d *Decoder) inferStride() error {
+	if !d.opts.lenient || d.compressed() {
+		return nil
+	}
+
+	need := int64(len(d.row)) * int64(d.height)
+	n := int64(d.meta.ImageSize)
+	if n == 0 || n >= need {
+		rem, ok, err := d.remaining()
+		if err != nil {
+			return err
+		}
+		if !ok || rem >= need {
+			return nil
 		}
+		n = rem
+	}
+
+	bits := d.width * d.bpp
+	switch s := int(n / int64(d.height)); s {
+	case (bits + 15) / 16 * 2, (bits + 7) / 8:
+		d.row = d.row[:s]
 	}
 
 	return nil
 }
 
-func (d *decoder) decode32() error {
-	rgba := image.NewNRGBA(image.Rect(0, 0, d.width, d.height))
+// checkLength fails if the uncompressed pixel data cannot fit in the rest
+// of the input, when its length is known, so that forged dimensions are
+// rejected before the image is allocated.
+func (d *Decoder) checkLength() error {
+	if d.compressed() {
+		return nil
+	}
 
-	y0, y1, dy := d.height-1, -1, -1
-	if d.topDown {
-		y0, y1, dy = 0, d.height, 1
+	n, ok, err := d.remaining()
+	if err != nil {
+		return err
+	}
+	if need := int64(len(d.row)) * int64(d.height); ok && n < need {
+		return fmt.Errorf("bmp: pixel data needs %d bytes but the input has %d left: %w", need, n, io.ErrUnexpectedEOF)
 	}
 
-	for y := y0; y != y1; y += dy {
-		p := rgba.Pix[y*rgba.Stride : (y+1)*rgba.Stride]
+	return nil
+}
 
-		if _, err := io.ReadFull(d.r, p[y*rgba.Stride:y*(rgba.Stride+1)]); err != nil {
-			if err == io.EOF {
-				return io.ErrUnexpectedEOF
-			}
-			return err
+// remaining returns the number of bytes of the input after the headers,
+// from WithInputSize, the Len method of readers such as bytes.Reader or by
+// seeking to the end. ok is false if it is unknown, such as when the source
+// is partial.
+func (d *Decoder) remaining() (n int64, ok bool, _ error) {
+	if size := d.opts.inputSize; size > 0 {
+		read := d.dataOffset
+		if d.dib {
+			// the offset accounts for the missing file header
+			read -= 14
 		}
+		return size - read, true, nil
+	}
+	if d.partial {
+		return 0, false, nil
+	}
 
-		for i := 0; i < d.width*4; i++ {
-			// BGRA order
-			p[i], p[i+2] = p[i+2], p[i]
+	switch r := d.src.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len()), true, nil
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			// not seekable after all, such as a pipe
+			return 0, false, nil
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false, nil
+		}
+		if _, err := r.Seek(cur, io.SeekStart); err != nil {
+			return 0, false, err
 		}
+		return end - cur, true, nil
 	}
 
-	return nil
+	return 0, false, nil
 }
 
-func (d *decoder) decode() error {
-	if err := d.decodeConfig(); err != nil {
+func (d *Decoder) decodePixels() error {
+	if d.codec != nil {
+		return d.decodeCodec()
+	}
+	if d.bitmap() {
+		return d.decodeBitmap()
+	}
+	if d.alphaMask() {
+		return d.decodeAlphaMask()
+	}
+	if d.gray != 0 {
+		return d.decodeGray16()
+	}
+
+	if err := d.resolveAlpha(); err != nil {
+		return err
+	}
+
+	// the alpha of targets is premultiplied as rows are read
+	t, err := d.nativeTarget(image.Rect(0, 0, d.width, d.height))
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		return d.decodeTarget(t)
+	}
+
+	stride, err := d.stride(d.rowLen(), 1)
+	if err != nil {
 		return err
 	}
 
-	var err error
-	switch d.bpp {
-	case 1, 4, 8:
-		err = d.decodePalleted()
-	case 16:
-		err = d.decode16()
-	case 24:
-		err = d.decode24()
-	case 32:
-		err = d.decode32()
+	img, pix, stride := d.newImage(image.Rect(0, 0, d.width, d.height), stride)
+	readRow := d.readRow
+	if d.plain24() {
+		readRow = d.readRow24
+	}
+
+	for n := 0; n < d.height; n++ {
+		y := d.rowY(n)
+		if err := readRow(pix[y*stride : y*stride+d.rowLen()]); err != nil {
+			return err
+		}
+		d.rowDone(n + 1)
+	}
+
+	d.image = img
+	if d.opts.model != nil {
+		d.image, err = convert(d.image, d.opts.model)
 	}
 
 	return err
 }
 
-// Decode reads a BMP image form io.Reader and returns an image.Image
-func Decode(r io.Reader) (image.Image, error) {
-	d := &decoder{
-		r: r,
+// ownTarget reports whether decodePixels decodes the pixels into an image
+// type of their own rather than the one of newImage.
+func (d *Decoder) ownTarget() bool {
+	return d.bitmap() || d.alphaMask() || d.gray != 0
+}
+
+// convert returns a copy of src using the color model m.
+func convert(src image.Image, m color.Model) (image.Image, error) {
+	if src.ColorModel() == m {
+		return src, nil
 	}
 
-	if err := d.decode(); err != nil {
-		return nil, err
+	// indices past a short color table convert to opaque black
+	if p, ok := src.(*image.Paletted); ok && len(p.Palette) < 256 {
+		q := *p
+		q.Palette = make(color.Palette, 256)
+		copy(q.Palette, p.Palette)
+		for i := len(p.Palette); i < len(q.Palette); i++ {
+			q.Palette[i] = color.Black
+		}
+		src = &q
 	}
 
-	return d.image, nil
+	b := src.Bounds()
+	var dst draw.Image
+	switch m {
+	case color.RGBAModel:
+		dst = image.NewRGBA(b)
+	case color.RGBA64Model:
+		dst = image.NewRGBA64(b)
+	case color.NRGBAModel:
+		dst = image.NewNRGBA(b)
+	case color.NRGBA64Model:
+		dst = image.NewNRGBA64(b)
+	case color.GrayModel:
+		dst = image.NewGray(b)
+	case color.Gray16Model:
+		dst = image.NewGray16(b)
+	case color.AlphaModel:
+		dst = image.NewAlpha(b)
+	case color.Alpha16Model:
+		dst = image.NewAlpha16(b)
+	case color.CMYKModel:
+		dst = image.NewCMYK(b)
+	case RGBModel:
+		dst = NewRGB24(b)
+	case BGRAModel:
+		dst = NewBGRA32(b)
+	case RGB565Model:
+		dst = NewRGB16(b)
+	default:
+		p, ok := m.(color.Palette)
+		if !ok {
+			return nil, fmt.Errorf("bmp: unsupported color model (got: %T)", m)
+		}
+		dst = image.NewPaletted(b, p)
+	}
+
+	draw.Draw(dst, b, src, b.Min, draw.Src)
+
+	return dst, nil
 }
 
-// DecodeConfig reads a BMP image from io.Reader and returns an image.Config
-func DecodeConfig(r io.Reader) (image.Config, error) {
-	d := &decoder{
-		r: r,
+// advance moves the decoder to the given stage, running the steps in
+// between.
+func (d *Decoder) advance(stage int) error {
+	if d.err != nil {
+		return d.err
+	}
+
+	if d.stage < stageConfig && stage >= stageConfig {
+		if d.err = d.decodeConfig(); d.err != nil {
+			return d.err
+		}
+		// the entry points reading the pixel data size their buffers from
+		// the header
+		if !d.headerOnly {
+			if d.err = d.checkLength(); d.err != nil {
+				return d.err
+			}
+		}
+		d.headerWarnings()
+		d.stage = stageConfig
 	}
 
-	if err := d.decodeConfig(); err != nil {
+	if stage == stageImage && d.stage != stageImage {
+		if d.stage == stagePixels {
+			return fmt.Errorf("bmp: pixel data has already been read by Rows")
+		}
+
+		if d.err = d.decodePixels(); d.err != nil {
+			return d.err
+		}
+		d.stage = stageImage
+	}
+
+	return nil
+}
+
+// Config returns the color model and dimensions of the image.
+func (d *Decoder) Config() (image.Config, error) {
+	if err := d.advance(stageConfig); err != nil {
 		return image.Config{}, err
 	}
 
 	return d.config, nil
 }
 
+// Metadata returns the header fields of the image.
+func (d *Decoder) Metadata() (Metadata, error) {
+	if err := d.advance(stageConfig); err != nil {
+		return Metadata{}, err
+	}
+
+	return d.meta, nil
+}
+
+// Image decodes the pixel data and returns the image. It fails if the pixel
+// data has already been read by Rows. 16 bpp images whose bit fields
+// define a single channel, such as depth maps, are returned as an
+// *image.Gray16 keeping the precision of the channel; their rows hold 8-bit
+// gray RGBA samples.
+func (d *Decoder) Image() (image.Image, error) {
+	err := d.advance(stageImage)
+	d.report("image", err)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.image, nil
+}
+
+// Rows returns a RowReader reading the pixel data row by row, without
+// allocating the whole image.
+func (d *Decoder) Rows() *RowReader {
+	rr := &RowReader{d: d, y: -1}
+
+	if err := d.advance(stageConfig); err != nil {
+		rr.err = err
+		return rr
+	}
+
+	if d.stage != stageConfig {
+		rr.err = fmt.Errorf("bmp: pixel data has already been read")
+		return rr
+	}
+	d.stage = stagePixels
+	if err := d.resolveAlpha(); err != nil {
+		rr.err = err
+		return rr
+	}
+	rr.buf = make([]byte, d.rowLen())
+
+	return rr
+}
+
+// RowReader reads the rows of an image in the order they are stored in the
+// file, which is bottom-up for most BMP images.
+type RowReader struct {
+	d   *Decoder
+	n   int
+	y   int
+	buf []byte
+	err error
+}
+
+// Next reads the next row. It returns false when there are no more rows or
+// an error occurred.
+func (rr *RowReader) Next() bool {
+	if rr.err != nil || rr.n >= rr.d.height {
+		rr.d.report("rows", rr.err)
+		return false
+	}
+
+	if rr.err = rr.d.readRow(rr.buf); rr.err != nil {
+		rr.d.report("rows", rr.err)
+		return false
+	}
+
+	rr.y = rr.d.rowY(rr.n)
+	rr.n++
+	rr.d.rowDone(rr.n)
+
+	return true
+}
+
+// Y returns the y coordinate of the current row.
+func (rr *RowReader) Y() int {
+	return rr.y
+}
+
+// Row returns the pixels of the current row: palette indices for paletted
+// images, 8-bit RGBA samples otherwise (non-premultiplied for 32 bpp). The
+// slice is overwritten by the next call to Next. WithColorModel does not
+// apply to rows.
+func (rr *RowReader) Row() []byte {
+	return rr.buf
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (rr *RowReader) Err() error {
+	return rr.err
+}
+
+// Decode reads a BMP image form io.Reader and returns an image.Image
+func Decode(r io.Reader, opts ...Option) (image.Image, error) {
+	return NewDecoder(r, opts...).Image()
+}
+
+// DecodeWithFallback reads a BMP image from r with opts and, if that fails,
+// decodes it again from the same position with WithLenient appended to
+// opts, without reopening the input. Images exceeding the limits are not
+// retried.
+func DecodeWithFallback(r io.ReadSeeker, opts ...Option) (image.Image, error) {
+	d := NewDecoder(r, opts...)
+	m, err := d.Image()
+	if err == nil || errors.Is(err, ErrLimitExceeded) {
+		return m, err
+	}
+
+	if err := d.Retry(append(opts[:len(opts):len(opts)], WithLenient(true))...); err != nil {
+		return nil, err
+	}
+
+	return d.Image()
+}
+
+// DecodeConfig reads a BMP image from io.Reader and returns an image.Config
+func DecodeConfig(r io.Reader, opts ...Option) (image.Config, error) {
+	d := NewDecoder(r, opts...)
+	d.headerOnly = true
+
+	return d.Config()
+}
+
 func init() {
-	image.RegisterFormat("bmp", "BM", Decode, DecodeConfig)
+	image.RegisterFormat("bmp", "BM",
+		func(r io.Reader) (image.Image, error) { return Decode(r) },
+		func(r io.Reader) (image.Config, error) { return DecodeConfig(r) },
+	)
 }
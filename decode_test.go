@@ -1,10 +1,14 @@
 package bmp
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"image"
 	"image/color"
+	"io"
+	"io/ioutil"
 	"os"
 	"testing"
 )
@@ -67,3 +71,643 @@ func TestDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeOptions(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/sample.bmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []int
+	img, err := Decode(bytes.NewReader(data),
+		WithProgress(func(n, height int) { rows = append(rows, n) }),
+		WithColorModel(color.GrayModel),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*image.Gray); !ok {
+		t.Errorf("image type = %T, expected *image.Gray", img)
+	}
+	if len(rows) != 5 || rows[4] != 5 {
+		t.Errorf("progress = %v, expected 5 calls ending at 5", rows)
+	}
+
+	limits := []Limits{
+		{MaxPixels: 24},
+		{MaxPaletteEntries: 1},
+		{MaxFileSize: int64(len(data)) - 1},
+	}
+	for _, l := range limits {
+		if _, err := Decode(bytes.NewReader(data), WithLimits(l)); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("limits %+v: err = %v, expected ErrLimitExceeded", l, err)
+		}
+	}
+}
+
+func TestDecodeLenientGap(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/sample.bmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// insert a 4 byte gap in front of the pixel data
+	offset := binary.LittleEndian.Uint32(data[10:14])
+	gapped := append(append(append([]byte{}, data[:offset]...), 0, 0, 0, 0), data[offset:]...)
+	binary.LittleEndian.PutUint32(gapped[10:14], offset+4)
+
+	if _, err := Decode(bytes.NewReader(gapped)); err == nil {
+		t.Error("strict decode of gapped file succeeded")
+	}
+
+	img, err := Decode(bytes.NewReader(gapped), WithLenient(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPix(img.(*image.Paletted).Pix, expectedImages["sample.bmp"].(*image.Paletted).Pix); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeLenientZeroOffset(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/sample.bmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zeroed := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(zeroed[10:14], 0)
+
+	if _, err := Decode(bytes.NewReader(zeroed)); err == nil {
+		t.Error("strict decode of a zero offset succeeded")
+	}
+
+	img, err := Decode(bytes.NewReader(zeroed), WithLenient(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPix(img.(*image.Paletted).Pix, expectedImages["sample.bmp"].(*image.Paletted).Pix); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeLenientStride(t *testing.T) {
+	tests := []struct {
+		depth  int
+		stride int
+		sized  bool
+	}{
+		{24, 15, false},
+		{24, 15, true},
+		{8, 5, true},
+		{8, 6, false},
+		{1, 1, false},
+	}
+
+	for _, tt := range tests {
+		src := testPaletted(5, 3, 2)
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(tt.depth)
+		if err := e.Encode(src); err != nil {
+			t.Fatal(err)
+		}
+
+		// repack the rows with the stride of the writer
+		b := buf.Bytes()
+		offset := binary.LittleEndian.Uint32(b[10:14])
+		padded := (len(b) - int(offset)) / 3
+		data := append([]byte(nil), b[:offset]...)
+		for n := 0; n < 3; n++ {
+			row := make([]byte, tt.stride)
+			copy(row, b[int(offset)+n*padded:int(offset)+n*padded+padded])
+			data = append(data, row...)
+		}
+		binary.LittleEndian.PutUint32(data[2:6], uint32(len(data)))
+		size := 0
+		if tt.sized {
+			size = 3 * tt.stride
+		}
+		binary.LittleEndian.PutUint32(data[34:38], uint32(size))
+
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Errorf("%d bpp, %d-byte rows: strict decode succeeded", tt.depth, tt.stride)
+		}
+		d := NewDecoder(bytes.NewReader(data), WithLenient(true))
+		m, err := d.Image()
+		if err != nil {
+			t.Fatalf("%d bpp, %d-byte rows: %v", tt.depth, tt.stride, err)
+		}
+		if !sameImage(m, src) {
+			t.Errorf("%d bpp, %d-byte rows: decoded image differs", tt.depth, tt.stride)
+		}
+		if warnings, _ := d.Warnings(); len(warnings) != 1 {
+			t.Errorf("%d bpp, %d-byte rows: warnings = %v", tt.depth, tt.stride, warnings)
+		}
+	}
+}
+
+func TestDecodeLenientMirrored(t *testing.T) {
+	for _, src := range []image.Image{testImage(7, 5), testPaletted(7, 5, 16)} {
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(src); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		width := int32(-7)
+		binary.LittleEndian.PutUint32(data[18:22], uint32(width))
+
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Error("strict decode of a negative width succeeded")
+		}
+
+		// the stored rows hold the mirrored image
+		want := image.NewNRGBA(src.Bounds())
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 7; x++ {
+				want.Set(6-x, y, src.At(x, y))
+			}
+		}
+
+		img, err := Decode(bytes.NewReader(data), WithLenient(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameImage(img, want) {
+			t.Errorf("%T: decoded image is not mirrored", src)
+		}
+
+		err = DecodeTiles(bytes.NewReader(data), 3, 2, func(rect image.Rectangle, tile image.Image) error {
+			if !sameImage(tile, want.SubImage(rect)) {
+				t.Errorf("%T: tile %v differs", src, rect)
+			}
+			return nil
+		}, WithLenient(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeInputLength(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(testImage(7, 5)); err != nil {
+		t.Fatal(err)
+	}
+	forged := append([]byte{}, buf.Bytes()...)
+	binary.LittleEndian.PutUint32(forged[22:26], 1<<20)
+
+	tests := []struct {
+		name string
+		r    io.Reader
+		opts []Option
+	}{
+		{"bytes.Reader", bytes.NewReader(forged), nil},
+		{"seeker", struct{ io.ReadSeeker }{bytes.NewReader(forged)}, nil},
+		{"input size", io.MultiReader(bytes.NewReader(forged)), []Option{WithInputSize(int64(len(forged)))}},
+	}
+	for _, tt := range tests {
+		var rows int
+		opts := append(tt.opts, WithProgress(func(n, _ int) { rows = n }))
+		if _, err := Decode(tt.r, opts...); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: err = %v, want io.ErrUnexpectedEOF", tt.name, err)
+		}
+		if rows != 0 {
+			t.Errorf("%s: %d rows decoded before failing", tt.name, rows)
+		}
+	}
+
+	// the entry points not decoding the whole image check it too
+	entries := map[string]func(r io.Reader, opts ...Option) error{
+		"DecodeRaw": func(r io.Reader, opts ...Option) error {
+			_, err := DecodeRaw(r, 1, opts...)
+			return err
+		},
+		"Sanitize": func(r io.Reader, opts ...Option) error {
+			return Sanitize(ioutil.Discard, r, opts...)
+		},
+		"Lint": func(r io.Reader, _ ...Option) error {
+			_, err := Lint(r)
+			return err
+		},
+		"DecodeHistogram": func(r io.Reader, opts ...Option) error {
+			_, err := DecodeHistogram(r, opts...)
+			return err
+		},
+	}
+	for name, fn := range entries {
+		var rows int
+		if err := fn(bytes.NewReader(forged), WithProgress(func(n, _ int) { rows = n })); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: err = %v, want io.ErrUnexpectedEOF", name, err)
+		}
+		if rows != 0 {
+			t.Errorf("%s: %d rows decoded before failing", name, rows)
+		}
+	}
+
+	// the input length is exactly enough for the original file
+	if _, err := Decode(io.MultiReader(bytes.NewReader(buf.Bytes())), WithInputSize(int64(buf.Len()))); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetDefaultLimits(t *testing.T) {
+	defer SetDefaultLimits(DefaultLimits())
+	SetDefaultLimits(Limits{MaxPixels: 24})
+
+	f, err := os.Open("testdata/sample.bmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, _, err := image.Decode(f); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("image.Decode: err = %v, expected ErrLimitExceeded", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(f, WithLimits(Limits{})); err != nil {
+		t.Errorf("Decode with explicit limits: %v", err)
+	}
+}
+
+func TestDefaultMaxPixels(t *testing.T) {
+	if max := DefaultLimits().MaxPixels; max != DefaultMaxPixels {
+		t.Fatalf("default MaxPixels = %d, expected %d", max, DefaultMaxPixels)
+	}
+
+	// 65536×65536 pixels announced by a 54 byte header
+	header := make([]byte, 54)
+	copy(header, "BM")
+	binary.LittleEndian.PutUint32(header[10:], 54)
+	binary.LittleEndian.PutUint32(header[14:], 40)
+	binary.LittleEndian.PutUint32(header[18:], 1<<16)
+	binary.LittleEndian.PutUint32(header[22:], 1<<16)
+	binary.LittleEndian.PutUint16(header[28:], 24)
+
+	if _, _, err := image.Decode(bytes.NewReader(header)); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("image.Decode: err = %v, expected ErrLimitExceeded", err)
+	}
+	if _, err := DecodeConfig(bytes.NewReader(header), WithLimits(Limits{})); err != nil {
+		t.Errorf("DecodeConfig without limits: %v", err)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	f, err := os.Open("testdata/sample.bmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	d := NewDecoder(f)
+
+	cfg, err := d.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 5 || cfg.Height != 5 {
+		t.Errorf("config = %dx%d, expected 5x5", cfg.Width, cfg.Height)
+	}
+
+	meta, err := d.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.HeaderSize != 108 || meta.BitsPerPixel != 1 || meta.Compression != CompressionRGB || meta.XPelsPerMeter != 11811 ||
+		meta.Height != 5 || meta.TopDown {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+
+	expected := expectedImages["sample.bmp"].(*image.Paletted).Pix
+	rows := d.Rows()
+	var n int
+	for rows.Next() {
+		y := rows.Y()
+		if y != 4-n {
+			t.Errorf("row %d: y = %d, expected %d", n, y, 4-n)
+		}
+		if err := checkPix(rows.Row(), expected[y*5:(y+1)*5]); err != nil {
+			t.Errorf("row %d: %s", y, err)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("read %d rows, expected 5", n)
+	}
+
+	if _, err := d.Image(); err == nil {
+		t.Error("Image after Rows succeeded")
+	}
+}
+
+func TestMetadataTopDown(t *testing.T) {
+	for _, topDown := range []bool{false, true} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetTopDown(topDown)
+		if err := e.Encode(testImage(2, 3)); err != nil {
+			t.Fatal(err)
+		}
+
+		meta, err := NewDecoder(&buf).Metadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		height := int32(3)
+		if topDown {
+			height = -3
+		}
+		if meta.TopDown != topDown || meta.Height != height {
+			t.Errorf("top-down %v: TopDown = %v, Height = %d, expected %d", topDown, meta.TopDown, meta.Height, height)
+		}
+	}
+}
+
+func TestDecodePaletteAlpha(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/sample.bmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the color table follows the 108 byte V4 header
+	data = append([]byte{}, data...)
+	data[14+108+3] = 0x00
+	data[14+108+4+3] = 0x80
+
+	cfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := cfg.ColorModel.(color.Palette)[1].RGBA(); a != 0xffff {
+		t.Errorf("alpha without option = %#x, expected 0xffff", a)
+	}
+
+	cfg, err = DecodeConfig(bytes.NewReader(data), WithPaletteAlpha(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := cfg.ColorModel.(color.Palette)
+	if c := p[0].(color.NRGBA); c.A != 0 {
+		t.Errorf("palette[0] = %v, expected transparent", c)
+	}
+	if c := p[1].(color.NRGBA); c != (color.NRGBA{0xff, 0xff, 0xff, 0x80}) {
+		t.Errorf("palette[1] = %v, expected translucent white", c)
+	}
+}
+
+func TestDecodeCoreHeader(t *testing.T) {
+	sample, err := ioutil.ReadFile("testdata/sample.bmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sample image with a BITMAPCOREHEADER and an RGBTRIPLE palette
+	data := []byte{
+		'B', 'M', 0, 0, 0, 0, 0, 0, 0, 0, 14 + 12 + 2*3, 0, 0, 0,
+		12, 0, 0, 0, 5, 0, 5, 0, 1, 0, 1, 0,
+		0x00, 0x00, 0x00, 0xff, 0x80, 0x00,
+	}
+	data = append(data, sample[len(sample)-20:]...)
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := img.(*image.Paletted)
+	if c := m.Palette[1]; c != (color.RGBA{0x00, 0x80, 0xff, 0xff}) {
+		t.Errorf("palette[1] = %v, expected {0x00 0x80 0xff 0xff}", c)
+	}
+	if err := checkPix(m.Pix, expectedImages["sample.bmp"].(*image.Paletted).Pix); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeStride(t *testing.T) {
+	src := testImage(5, 3)
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts   []Option
+		stride int
+	}{
+		{nil, 20},
+		{[]Option{WithStride(25)}, 25},
+		{[]Option{WithRowAlignment(64)}, 64},
+		{[]Option{WithRowAlignment(64), WithStride(30)}, 30},
+		{[]Option{WithRowAlignment(16), WithColorModel(RGBModel)}, 16},
+	}
+
+	for _, tt := range tests {
+		img, err := Decode(bytes.NewReader(buf.Bytes()), tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stride int
+		switch m := img.(type) {
+		case *image.RGBA:
+			stride = m.Stride
+		case *RGB24:
+			stride = m.Stride
+		}
+		if stride != tt.stride {
+			t.Errorf("stride = %d, expected %d", stride, tt.stride)
+		}
+		if !sameImage(img, src) {
+			t.Errorf("stride %d: image differs from the source", tt.stride)
+		}
+	}
+
+	for _, opt := range []Option{WithStride(19), WithRowAlignment(3)} {
+		if _, err := Decode(bytes.NewReader(buf.Bytes()), opt); err == nil {
+			t.Error("expected an error for an invalid stride")
+		}
+	}
+}
+
+func TestDecodeUntrusted(t *testing.T) {
+	m := image.NewPaletted(image.Rect(0, 0, 4, 2), color.Palette{color.Black, color.White})
+	m.Pix[5] = 9
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(8)
+	e.SetCompression(CompressionRLE8)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Errorf("default options: %v", err)
+	}
+	if _, err := Decode(bytes.NewReader(buf.Bytes()), WithUntrusted()); err == nil {
+		t.Error("expected an error for an index past the color table")
+	}
+
+	// a valid image with a large but cheap header
+	header := make([]byte, 54)
+	copy(header, "BM")
+	binary.LittleEndian.PutUint32(header[10:], 54)
+	binary.LittleEndian.PutUint32(header[14:], 40)
+	binary.LittleEndian.PutUint32(header[18:], 1<<14)
+	binary.LittleEndian.PutUint32(header[22:], 1<<14)
+	binary.LittleEndian.PutUint16(header[28:], 24)
+	if _, err := DecodeConfig(bytes.NewReader(header), WithUntrusted()); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("error = %v, expected %v", err, ErrLimitExceeded)
+	}
+
+	// the reserved byte of 32 bpp pixels is alpha, even if zero
+	zero := rawFile(UnpackerKey{BitsPerPixel: 32}, 2, 1, nil, make([]byte, 8))
+	img, err := Decode(bytes.NewReader(zero), WithUntrusted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("alpha = %#x, expected 0", a)
+	}
+	if img, err = Decode(bytes.NewReader(zero), WithUntrusted(), WithAlphaMode(AlphaAuto)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0xffff {
+		t.Errorf("AlphaAuto after WithUntrusted: alpha = %#x, expected 0xffff", a)
+	}
+}
+
+func BenchmarkDecode24(b *testing.B) {
+	m := image.NewRGBA(image.Rect(0, 0, 1024, 768))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i)
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(m); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(m.Pix)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	var a, b bytes.Buffer
+	if err := NewEncoder(&a).Encode(testImage(16, 16)); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEncoder(&b)
+	e.SetDepth(4)
+	if err := e.Encode(testPaletted(5, 3, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(bytes.NewReader(a.Bytes()), WithLenient(true))
+	if _, err := d.Image(); err != nil {
+		t.Fatal(err)
+	}
+	d.Reset(bytes.NewReader(b.Bytes()))
+	m, err := d.Image()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(m, testPaletted(5, 3, 16)) {
+		t.Error("image decoded after Reset differs")
+	}
+	if !d.opts.lenient {
+		t.Error("Reset dropped the options")
+	}
+
+	// only the image is allocated once the buffers are large enough
+	r := bytes.NewReader(a.Bytes())
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(a.Bytes())
+		d.Reset(r)
+		if _, err := d.Image(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 2 {
+		t.Errorf("%v allocations per image, want at most 2", allocs)
+	}
+}
+
+func TestDecodeWithFallback(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(testImage(5, 3)); err != nil {
+		t.Fatal(err)
+	}
+	// a zero pixel offset is only accepted in lenient mode, after a prefix
+	// the decoder does not know about
+	b := append([]byte("junk"), buf.Bytes()...)
+	binary.LittleEndian.PutUint32(b[4+10:], 0)
+
+	r := bytes.NewReader(b)
+	r.Seek(4, io.SeekStart)
+	if _, err := Decode(r); err == nil {
+		t.Fatal("strict decode succeeded")
+	}
+
+	r.Seek(4, io.SeekStart)
+	m, err := DecodeWithFallback(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(m, testImage(5, 3)) {
+		t.Error("image decoded by the fallback differs")
+	}
+
+	// Retry takes other options
+	r.Seek(4, io.SeekStart)
+	d := NewDecoder(r)
+	if _, err := d.Image(); err == nil {
+		t.Fatal("strict decode succeeded")
+	}
+	if err := d.Retry(WithLenient(true), WithColorModel(color.GrayModel)); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := d.Image(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := m.(*image.Gray); !ok {
+		t.Errorf("image type = %T after Retry, want *image.Gray", m)
+	}
+
+	if err := NewDecoder(bytes.NewBuffer(b)).Retry(); err == nil {
+		t.Error("Retry without an io.Seeker succeeded")
+	}
+}
+
+func TestDecodeShortPaletteModel(t *testing.T) {
+	// the pixels use index 1 of a color table of a single entry
+	b := rawFile(UnpackerKey{BitsPerPixel: 1}, 8, 1, color.Palette{color.White}, []byte{0xff, 0, 0, 0})
+
+	for _, m := range []color.Model{color.GrayModel, color.NRGBAModel} {
+		m1, err := Decode(bytes.NewReader(b), WithColorModel(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m2, err := DecodeConcurrent(bytes.NewReader(b), 2, WithColorModel(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, img := range []image.Image{m1, m2} {
+			if r, g, b, a := img.At(0, 0).RGBA(); r != 0 || g != 0 || b != 0 || a != 0xffff {
+				t.Errorf("%T: got %v, want opaque black", img, img.At(0, 0))
+			}
+		}
+	}
+}
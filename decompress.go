@@ -0,0 +1,125 @@
+package bmp
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Decompressor decompresses pixel data into the layout of uncompressed
+// pixel data, so that compression methods the package does not support,
+// such as the OS/2 and vendor-specific ones, can be decoded without
+// changing the decoder.
+type Decompressor interface {
+	// NewReader returns a reader of the decompressed pixel data of an
+	// image of the given dimensions and header fields, reading the
+	// compressed data from r. The decompressed rows are in stored order,
+	// hold pixels of meta.BitsPerPixel bits unpacked by the PixelUnpacker
+	// of CompressionRGB and are padded to a multiple of 4 bytes.
+	NewReader(r io.Reader, width, height int, meta Metadata) (io.Reader, error)
+}
+
+// DecompressorKey identifies the compression methods of the pixel data.
+// The compression values of OS/2 headers overlap the Windows ones, which
+// the number of bits per pixel tells apart.
+type DecompressorKey struct {
+	Compression Compression
+	// BitsPerPixel is zero for the decompressors of any depth.
+	BitsPerPixel int
+}
+
+// plainDecompressor is the decompressor of the uncompressed pixel data of
+// CompressionRGB and CompressionBitfields.
+type plainDecompressor struct{}
+
+func (plainDecompressor) NewReader(r io.Reader, _, _ int, _ Metadata) (io.Reader, error) {
+	return r, nil
+}
+
+// rleDecompressor is the decompressor of CompressionRLE8 and
+// CompressionRLE4. The decoder uses rleReader directly.
+type rleDecompressor struct{}
+
+func (rleDecompressor) NewReader(r io.Reader, width, height int, meta Metadata) (io.Reader, error) {
+	bpp := meta.BitsPerPixel
+	return &rlePacker{
+		rr:      newRLEReader(r, meta.ImageSize, bpp, width, height),
+		indices: make([]byte, width),
+		row:     make([]byte, (width*bpp+31)/32*4),
+	}, nil
+}
+
+// rlePacker packs the indices decompressed by an rleReader into stored
+// rows.
+type rlePacker struct {
+	rr      *rleReader
+	indices []byte
+	row     []byte
+	pending []byte
+}
+
+func (p *rlePacker) Read(b []byte) (int, error) {
+	if len(p.pending) == 0 {
+		if p.rr.n == p.rr.height {
+			return 0, io.EOF
+		}
+		if err := p.rr.readRow(p.indices); err != nil {
+			return 0, err
+		}
+
+		for i := range p.row {
+			p.row[i] = 0
+		}
+		for x, idx := range p.indices {
+			if p.rr.bpp == 4 {
+				p.row[x/2] |= idx & 0x0f << uint(4-4*(x%2))
+			} else {
+				p.row[x] = idx
+			}
+		}
+		p.pending = p.row
+	}
+
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+var (
+	decompressorsMu sync.RWMutex
+	decompressors   = map[DecompressorKey]Decompressor{
+		{CompressionRGB, 0}:       plainDecompressor{},
+		{CompressionBitfields, 0}: plainDecompressor{},
+		{CompressionRLE8, 8}:      rleDecompressor{},
+		{CompressionRLE4, 4}:      rleDecompressor{},
+	}
+)
+
+// RegisterDecompressor registers dec for the pixel data identified by key.
+// The decompressed pixels are then unpacked like uncompressed ones. It
+// panics if a decompressor is already registered for key, including the
+// built-in ones of CompressionRGB, CompressionRLE8, CompressionRLE4 and
+// CompressionBitfields.
+func RegisterDecompressor(key DecompressorKey, dec Decompressor) {
+	decompressorsMu.Lock()
+	defer decompressorsMu.Unlock()
+
+	if _, ok := decompressors[key]; ok {
+		panic(fmt.Sprintf("bmp: RegisterDecompressor called twice for %+v", key))
+	}
+	decompressors[key] = dec
+}
+
+// lookupDecompressor returns the decompressor registered for the given
+// compression and number of bits per pixel, or for any number of bits per
+// pixel.
+func lookupDecompressor(c Compression, bpp int) (Decompressor, bool) {
+	decompressorsMu.RLock()
+	defer decompressorsMu.RUnlock()
+
+	if dec, ok := decompressors[DecompressorKey{c, bpp}]; ok {
+		return dec, true
+	}
+	dec, ok := decompressors[DecompressorKey{c, 0}]
+	return dec, ok
+}
@@ -0,0 +1,107 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// invertDecompressor "decompresses" pixel data stored with inverted bits.
+type invertDecompressor struct{}
+
+func (invertDecompressor) NewReader(r io.Reader, _, _ int, _ Metadata) (io.Reader, error) {
+	return invertReader{r}, nil
+}
+
+type invertReader struct{ r io.Reader }
+
+func (r invertReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for i := range p[:n] {
+		p[i] = ^p[i]
+	}
+	return n, err
+}
+
+func TestRegisterDecompressor(t *testing.T) {
+	// an OS/2 compression value overlapping CompressionJPEG
+	key := DecompressorKey{CompressionJPEG, 24}
+	if _, ok := lookupDecompressor(key.Compression, key.BitsPerPixel); !ok {
+		RegisterDecompressor(key, invertDecompressor{})
+	}
+
+	src := testImage(3, 2)
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data[30:34], uint32(CompressionJPEG))
+	offset := binary.LittleEndian.Uint32(data[10:14])
+	for i := offset; i < uint32(len(data)); i++ {
+		data[i] = ^data[i]
+	}
+
+	if err := CheckHeader(data); err != nil {
+		t.Errorf("CheckHeader: %v", err)
+	}
+	m, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(m, src) {
+		t.Error("decoded image differs")
+	}
+
+	err = DecodeTiles(bytes.NewReader(data), 2, 2, func(image.Rectangle, image.Image) error { return nil })
+	if err != ErrCompressed {
+		t.Errorf("DecodeTiles: err = %v, want ErrCompressed", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a built-in decompressor did not panic")
+		}
+	}()
+	RegisterDecompressor(DecompressorKey{CompressionRLE8, 8}, invertDecompressor{})
+}
+
+func TestRLEDecompressor(t *testing.T) {
+	for _, depth := range []int{4, 8} {
+		src := testPaletted(7, 3, 5)
+
+		var plain, rle bytes.Buffer
+		e := NewEncoder(&plain)
+		e.SetDepth(depth)
+		if err := e.Encode(src); err != nil {
+			t.Fatal(err)
+		}
+		e = NewEncoder(&rle)
+		e.SetDepth(depth)
+		e.SetCompression(map[int]Compression{4: CompressionRLE4, 8: CompressionRLE8}[depth])
+		if err := e.Encode(src); err != nil {
+			t.Fatal(err)
+		}
+
+		d := NewDecoder(bytes.NewReader(rle.Bytes()))
+		meta, err := d.Metadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, _ := lookupDecompressor(meta.Compression, depth)
+		r, err := dec.NewReader(bytes.NewReader(rle.Bytes()[meta.PixelOffset:]), 7, 3, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := plain.Bytes()[meta.PixelOffset:]; !bytes.Equal(got, want) {
+			t.Errorf("%d bpp: decompressed %x, want %x", depth, got, want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package bmp
+
+import (
+	"image"
+	"io"
+)
+
+// NewDIBDecoder returns a Decoder reading a headerless bitmap from r: the
+// DIB header directly followed by the color table and the pixel data, as
+// stored on the clipboard (CF_DIB), in executable resources and in AVI
+// streams. Metadata reports zero FileSize and PixelOffset.
+func NewDIBDecoder(r io.Reader, opts ...Option) *Decoder {
+	d := NewDecoder(r, opts...)
+	d.dib = true
+
+	return d
+}
+
+// DecodeDIB reads a headerless bitmap from r and returns it. See
+// NewDIBDecoder.
+func DecodeDIB(r io.Reader, opts ...Option) (image.Image, error) {
+	return NewDIBDecoder(r, opts...).Image()
+}
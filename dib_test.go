@@ -0,0 +1,67 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDecodeDIB(t *testing.T) {
+	m := testImage(3, 2)
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the file header
+	got, err := DecodeDIB(bytes.NewReader(buf.Bytes()[14:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(got, m) {
+		t.Errorf("unexpected image")
+	}
+}
+
+func TestDecodeDIBDefaults(t *testing.T) {
+	// 1 bpp without biClrUsed, the color table having 2 entries
+	b := make([]byte, 40+2*4+4)
+	binary.LittleEndian.PutUint32(b[0:], 40)
+	binary.LittleEndian.PutUint32(b[4:], 2)
+	binary.LittleEndian.PutUint32(b[8:], 1)
+	binary.LittleEndian.PutUint16(b[12:], 1)
+	binary.LittleEndian.PutUint16(b[14:], 1)
+	copy(b[44:], []byte{0xff, 0xff, 0xff, 0})
+	b[48] = 0x40
+
+	got, err := DecodeDIB(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := got.(*image.Paletted)
+	if !ok || len(p.Palette) != 2 || !bytes.Equal(p.Pix, []byte{0, 1}) {
+		t.Errorf("unexpected image: %#v", got)
+	}
+
+	// 16 bpp 5-5-5 bit fields following a BITMAPINFOHEADER
+	b = make([]byte, 40+12+4)
+	binary.LittleEndian.PutUint32(b[0:], 40)
+	binary.LittleEndian.PutUint32(b[4:], 1)
+	binary.LittleEndian.PutUint32(b[8:], 1)
+	binary.LittleEndian.PutUint16(b[12:], 1)
+	binary.LittleEndian.PutUint16(b[14:], 16)
+	binary.LittleEndian.PutUint32(b[16:], uint32(CompressionBitfields))
+	binary.LittleEndian.PutUint32(b[40:], 0x7c00)
+	binary.LittleEndian.PutUint32(b[44:], 0x03e0)
+	binary.LittleEndian.PutUint32(b[48:], 0x001f)
+
+	got, err = DecodeDIB(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.RGBAModel.Convert(got.At(0, 0)); c != (color.RGBA{0, 0, 0, 0xff}) {
+		t.Errorf("unexpected color %v", c)
+	}
+}
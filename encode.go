@@ -0,0 +1,1223 @@
+package bmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/entooone/go-bmp/quantize"
+)
+
+// Encoder writes BMP images. The zero configuration writes uncompressed
+// 24 bpp bottom-up images.
+type Encoder struct {
+	w           io.Writer
+	depth       int
+	compression Compression
+	xRes, yRes  int32
+	topDown     bool
+	header      Header
+
+	premultiplied bool
+	alphaFields   bool
+	rgb565        bool
+	paletteAlpha  bool
+	dedup         bool
+	autoRLE       bool
+	workers       int
+	transparent   int
+	tracer        Tracer
+	colorSpace    *ColorSpace
+	profile       []byte
+	// grayMask is the channel mask of the 16 bpp single channel images
+	// written by Sanitize, whose rows are stored samples
+	grayMask uint16
+
+	width   int
+	height  int
+	palette color.Palette
+	nearest *nearest
+	// ownPalette is set when palette is the one of the encoded image
+	ownPalette bool
+	// remap maps the indices of the given palette to the ones of palette
+	// when its duplicate colors have been merged, nil otherwise
+	remap   []byte
+	mapped  []byte
+	rows    int
+	buf     []byte
+	started bool
+
+	// seeker is set when the header can be patched after the pixel data
+	// has been written
+	seeker  io.WriteSeeker
+	start   int64
+	offset  int
+	written int
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:           w,
+		depth:       24,
+		transparent: -1,
+		workers:     1,
+	}
+}
+
+// Encode writes m to w as an uncompressed 24 bpp bottom-up BMP with a
+// BITMAPINFOHEADER, which every reader supports. Translucent pixels are
+// stored without their alpha. Use an Encoder for the other formats.
+func Encode(w io.Writer, m image.Image) error {
+	return NewEncoder(w).Encode(m)
+}
+
+// EncodePaletted writes m to w as an 8 bpp BMP whose color table is the
+// palette of m and whose pixels are the indices of m, byte for byte, so
+// that the indices survive the round trip. Palettes with translucent colors
+// are stored as BGRA entries, as SetPaletteAlpha writes them. An option
+// setting DepthAuto writes the smallest of 1, 4 and 8 bpp holding the
+// palette instead, such as 4 bpp for palettes of at most 16 colors, packing
+// two indices per byte, for the targets only reading those.
+func EncodePaletted(w io.Writer, m *image.Paletted, opts ...EncodeOption) error {
+	e := NewEncoder(w)
+	e.SetDepth(8)
+	for _, c := range m.Palette {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			e.SetPaletteAlpha(true)
+			break
+		}
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	if e.depth == DepthAuto {
+		switch n := len(m.Palette); {
+		case n <= 2:
+			e.SetDepth(1)
+		case n <= 16:
+			e.SetDepth(4)
+		default:
+			e.SetDepth(8)
+		}
+	}
+
+	return e.Encode(m)
+}
+
+// EncodeMonochrome writes m to w as a 1 bpp BMP whose color table holds
+// black and white, such as a mask. Pixels whose luminance is at least half
+// the maximum are white, the others black; translucent pixels are
+// thresholded premultiplied, so transparent ones are black. Rows are packed
+// 8 pixels per byte, the leftmost in the most significant bit.
+func EncodeMonochrome(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	pm := image.NewPaletted(b, color.Palette{color.Black, color.White})
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.Gray16Model.Convert(m.At(x, y)).(color.Gray16).Y >= 0x8000 {
+				pm.Pix[pm.PixOffset(x, y)] = 1
+			}
+		}
+	}
+
+	e := NewEncoder(w)
+	e.SetDepth(1)
+	return e.Encode(pm)
+}
+
+// EncodeOptions are the options of EncodeWithOptions.
+type EncodeOptions struct {
+	// BitsPerPixel is the depth of the file, as set by Encoder.SetDepth:
+	// 1, 4 or 8 bpp, quantizing the images without a palette, 16 bpp with
+	// 5 bits per channel unless RGB565 is set, 24 or 32 bpp with alpha.
+	// The zero value is 24 bpp, like Encode.
+	BitsPerPixel int
+	// AutoDepth picks the smallest depth representing the image exactly,
+	// like DepthAuto, instead of BitsPerPixel.
+	AutoDepth bool
+	// AlphaBitfields declares the alpha channel of 32 bpp images with an
+	// alpha mask, as set by Encoder.SetAlphaBitfields.
+	AlphaBitfields bool
+	// RGB565 stores 16 bpp images as 5-6-5 samples declared with bit
+	// field masks, as set by Encoder.SetRGB565, rather than 5-5-5 ones.
+	RGB565 bool
+}
+
+// EncodeWithOptions writes m to w as an uncompressed bottom-up BMP with the
+// options o, trading size for compatibility: readers support 1, 4, 8 and
+// 24 bpp more widely than 16 and 32 bpp. Nil or zero options write the
+// 24 bpp files of Encode.
+func EncodeWithOptions(w io.Writer, m image.Image, o *EncodeOptions) error {
+	e := NewEncoder(w)
+	if o != nil {
+		switch {
+		case o.AutoDepth:
+			e.SetDepth(DepthAuto)
+		case o.BitsPerPixel != 0:
+			e.SetDepth(o.BitsPerPixel)
+		}
+		e.SetAlphaBitfields(o.AlphaBitfields)
+		e.SetRGB565(o.RGB565)
+	}
+
+	return e.Encode(m)
+}
+
+// DepthAuto makes Encode pick the smallest depth representing the image
+// exactly: 1, 4 or 8 bpp for images of at most 2, 16 or 256 colors, 32 bpp
+// for translucent images and 24 bpp otherwise. It requires Encode.
+const DepthAuto = 0
+
+// SetDepth sets the number of bits per pixel: 1, 2, 4 or 8 for paletted
+// images, 16 (5-5-5, or 5-6-5 with SetRGB565), 24 or 32 for true color
+// images, or DepthAuto. Images without a palette are quantized when encoded
+// at a paletted depth. 2 bpp images, from Windows CE, are not read as
+// widely as the others.
+func (e *Encoder) SetDepth(bpp int) {
+	e.depth = bpp
+}
+
+// SetCompression sets the compression method: CompressionRGB, or
+// CompressionRLE8 and CompressionRLE4 for 8 and 4 bpp images respectively.
+func (e *Encoder) SetCompression(c Compression) {
+	e.compression = c
+	e.autoRLE = false
+}
+
+// SetResolution sets the resolution of the image in dots per inch.
+func (e *Encoder) SetResolution(xdpi, ydpi int) {
+	e.xRes = dpiToPPM(xdpi)
+	e.yRes = dpiToPPM(ydpi)
+}
+
+// SetTopDown makes the encoder store rows from top to bottom. RLE
+// compressed images cannot be stored top-down.
+func (e *Encoder) SetTopDown(topDown bool) {
+	e.topDown = topDown
+}
+
+// SetHeader sets the version of the DIB header. HeaderCore produces the
+// smallest files readable by the oldest consumers, but only supports
+// uncompressed bottom-up images of 1, 4, 8 and 24 bpp up to 65535 pixels
+// wide and high, and does not store the resolution.
+func (e *Encoder) SetHeader(h Header) {
+	e.header = h
+}
+
+// SetColorSpace sets the color space stored in HeaderV4 and HeaderV5
+// headers, which is ColorSpaceSRGB by default so that color-managed
+// consumers do not take the samples as device RGB, and sets the header to
+// HeaderV4 unless HeaderV5 is set. ColorSpaceCalibratedRGB stores the
+// endpoints and gammas of cs, which must be less than 2 and 65536
+// respectively. Profiles are embedded with SetProfile, so the profile types
+// and the profile location of cs are not supported.
+func (e *Encoder) SetColorSpace(cs ColorSpace) {
+	e.colorSpace = &cs
+	if e.header != HeaderV5 {
+		e.header = HeaderV4
+	}
+}
+
+// SetProfile embeds the ICC profile icc after the pixel data, with the
+// color space type ColorSpaceProfileEmbedded, and sets the header to
+// HeaderV5, the only one able to locate it. A nil profile removes it.
+// Streamed RLE compressed images require an io.WriteSeeker to embed a
+// profile, as its location is only known after the last row.
+func (e *Encoder) SetProfile(icc []byte) {
+	e.profile = icc
+	if icc != nil {
+		e.header = HeaderV5
+	}
+}
+
+// SetColorSource sets the color space of the image to the one of a decoded
+// image, as returned by Decoder.ColorSource, so that transcoding keeps its
+// colors: the embedded profile if any, otherwise the color space of V4 and
+// V5 headers, except the profile types whose profile is not available and
+// the color spaces SetColorSpace does not support, which are dropped.
+func (e *Encoder) SetColorSource(src ColorSource) {
+	switch cs := src.ColorSpace; {
+	case src.Profile != nil:
+		e.SetProfile(src.Profile)
+	case cs != nil:
+		c := *cs
+		c.ProfileOffset, c.ProfileSize = 0, 0
+		if c.checkWritable() == nil {
+			e.SetColorSpace(c)
+		}
+	}
+}
+
+// SetPremultiplied makes 32 bpp images store premultiplied samples, as
+// expected by APIs such as AlphaBlend, instead of the straight alpha of the
+// format. By default, premultiplied images such as image.RGBA are
+// un-premultiplied so that translucent pixels keep their colors.
+func (e *Encoder) SetPremultiplied(premultiplied bool) {
+	e.premultiplied = premultiplied
+}
+
+// SetAlphaBitfields makes 32 bpp images declare their alpha channel with
+// the bit fields compression and an alpha mask, in a V4 header unless
+// HeaderV5 is set, rather than only store it in the fourth byte of the
+// pixels, which the format reserves and many readers ignore. With
+// DepthAuto, translucent images are written so while the others keep their
+// smaller depth.
+func (e *Encoder) SetAlphaBitfields(enabled bool) {
+	e.alphaFields = enabled
+}
+
+// SetRGB565 makes 16 bpp images store 5 bits of red, 6 of green and 5 of
+// blue, declared with the bit fields compression and their masks, instead
+// of the 5-5-5 samples the format defaults to, which need no masks.
+func (e *Encoder) SetRGB565(enabled bool) {
+	e.rgb565 = enabled
+}
+
+// alphaBitfields reports whether the image is written with an alpha mask.
+func (e *Encoder) alphaBitfields() bool {
+	return e.alphaFields && e.depth == 32
+}
+
+// SetDedupPalette makes the encoder merge the duplicate colors of the
+// palette, remapping the indices of the rows to the first occurrence of
+// their color, so that the color table holds each color once. Colors are
+// compared as stored, with alpha only if SetPaletteAlpha is set.
+func (e *Encoder) SetDedupPalette(dedup bool) {
+	e.dedup = dedup
+}
+
+// SetPaletteAlpha makes the encoder store the alpha of the palette colors
+// in the fourth byte of the color table entries, which makes BGRA entries
+// read back by WithPaletteAlpha, instead of leaving it zero as the format
+// reserves it. It applies to paletted depths, requires a header other
+// than HeaderCore and excludes SetTransparentIndex.
+func (e *Encoder) SetPaletteAlpha(enabled bool) {
+	e.paletteAlpha = enabled
+}
+
+// SetTransparentIndex marks the color at index i of the palette as
+// transparent, storing zero in the fourth byte of its color table entry and
+// 0xff in the one of the others, as read by WithPaletteAlpha and reported
+// by Metadata.TransparentIndex. A negative index, the default, leaves the
+// fourth bytes zero. It requires a paletted depth and a header other than
+// HeaderCore, whose entries have no fourth byte. The transparent color is
+// never merged by SetDedupPalette. Images which are not paletted map their
+// pixels less than half opaque to the transparent color, and the others to
+// the nearest color of the other entries, weighted by their alpha, so that
+// anti-aliased edges keep their color.
+func (e *Encoder) SetTransparentIndex(i int) {
+	e.transparent = i
+}
+
+// SetConcurrency makes Encode compress the rows of RLE compressed images
+// in bands on up to workers goroutines, or GOMAXPROCS if workers is not
+// positive, rather than on the calling goroutine. The output is the same.
+// The rows of m are then read concurrently, so m must be safe for
+// concurrent reads, as the images of the standard library are.
+func (e *Encoder) SetConcurrency(workers int) {
+	e.workers = workers
+}
+
+// SetTracer records a span to t for each image written by Encode.
+func (e *Encoder) SetTracer(t Tracer) {
+	e.tracer = t
+}
+
+func (e *Encoder) trace(start time.Time, b image.Rectangle, err error) {
+	e.tracer.Span(Span{
+		Name:         "bmp.Encode",
+		Start:        start,
+		End:          time.Now(),
+		Width:        b.Dx(),
+		Height:       b.Dy(),
+		BitsPerPixel: e.depth,
+		Compression:  e.compression,
+		Err:          err,
+	})
+}
+
+// dpiToPPM converts dots per inch to pixels per meter.
+func dpiToPPM(dpi int) int32 {
+	return int32(float64(dpi)/0.0254 + 0.5)
+}
+
+func (e *Encoder) rle() bool {
+	return e.compression == CompressionRLE8 || e.compression == CompressionRLE4
+}
+
+// stride returns the length of an uncompressed stored row.
+func (e *Encoder) stride() int {
+	return (e.width*e.depth + 31) / 32 * 4
+}
+
+// rowLen returns the length of a row passed to WriteRow.
+func (e *Encoder) rowLen() int {
+	if e.depth <= 8 {
+		return e.width
+	}
+	if e.depth == 16 && e.grayMask != 0 {
+		return e.width * 2
+	}
+
+	return e.width * 4
+}
+
+// rowY returns the y coordinate of the n-th stored row.
+func (e *Encoder) rowY(n int) int {
+	if e.topDown {
+		return n
+	}
+
+	return e.height - 1 - n
+}
+
+func (e *Encoder) begin(width, height int, p color.Palette) error {
+	if e.started {
+		return errors.New("bmp: header has already been written")
+	}
+
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("bmp: width and height must be greater than zero (width: %d, height: %d)", width, height)
+	}
+
+	e.remap = nil
+	switch e.depth {
+	case 1, 2, 4, 8:
+		if p == nil {
+			return fmt.Errorf("bmp: %d bpp requires a palette", e.depth)
+		}
+		if e.transparent >= len(p) {
+			return fmt.Errorf("bmp: transparent index out of range (got: %d, colors: %d)", e.transparent, len(p))
+		}
+		if e.transparent >= 0 && e.paletteAlpha {
+			return errors.New("bmp: transparent index and palette alpha both use the fourth byte of the color table")
+		}
+		if e.dedup {
+			p, e.remap = dedupPalette(p, e.transparent, e.paletteAlpha)
+		}
+		if len(p) > 1<<e.depth {
+			return fmt.Errorf("bmp: too many colors for %d bpp (got: %d)", e.depth, len(p))
+		}
+	case 16, 24, 32:
+		if e.transparent >= 0 {
+			return fmt.Errorf("bmp: transparent index requires a paletted depth (got: %d bpp)", e.depth)
+		}
+		p = nil
+	case DepthAuto, depthTrueColor:
+		return errors.New("bmp: automatic depth is only supported by Encode")
+	default:
+		return fmt.Errorf("bmp: unsupported the number of bits per pixel (got: %d)", e.depth)
+	}
+
+	switch {
+	case e.compression == CompressionRGB:
+	case e.compression == CompressionRLE8 && e.depth == 8,
+		e.compression == CompressionRLE4 && e.depth == 4:
+		if e.topDown {
+			return errors.New("bmp: RLE compressed images cannot be top-down")
+		}
+	default:
+		return fmt.Errorf("bmp: unsupported compression method for %d bpp (got: %d)", e.depth, e.compression)
+	}
+
+	if e.profile != nil && e.header != HeaderV5 {
+		return errors.New("bmp: color profiles require a V5 header")
+	}
+
+	switch e.header {
+	case HeaderInfo:
+		if e.colorSpace != nil {
+			return errors.New("bmp: color spaces require a V4 or V5 header")
+		}
+	case HeaderV4, HeaderV5:
+		if cs := e.colorSpace; cs != nil {
+			if err := cs.checkWritable(); err != nil {
+				return err
+			}
+		}
+	case HeaderCore:
+		if e.colorSpace != nil {
+			return errors.New("bmp: color spaces require a V4 or V5 header")
+		}
+		if e.depth == 2 || e.depth == 16 || e.depth == 32 || e.compression != CompressionRGB || e.topDown {
+			return errors.New("bmp: core header only supports uncompressed bottom-up images of 1, 4, 8 or 24 bpp")
+		}
+		if e.transparent >= 0 {
+			return errors.New("bmp: core header does not support a transparent index")
+		}
+		if e.paletteAlpha && e.depth <= 8 {
+			return errors.New("bmp: core header does not support palette alpha")
+		}
+		if width > 0xffff || height > 0xffff {
+			return fmt.Errorf("bmp: image is too large for a core header (width: %d, height: %d)", width, height)
+		}
+		if p != nil {
+			// the color table of a core header has a fixed size
+			p = append(color.Palette(nil), p...)
+			for len(p) < 1<<e.depth {
+				p = append(p, color.Black)
+			}
+		}
+	default:
+		return fmt.Errorf("bmp: unsupported header (got: %d)", e.header)
+	}
+
+	e.width, e.height, e.palette = width, height, p
+	e.nearest = nil
+	e.rows, e.written = 0, 0
+	e.seeker = nil
+	e.started = true
+
+	return nil
+}
+
+// seekable reports whether the header can be patched once the pixel data
+// has been written, recording the position of the header if so.
+func (e *Encoder) seekable() bool {
+	ws, ok := e.w.(io.WriteSeeker)
+	if !ok {
+		return false
+	}
+
+	pos, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// e.g. a pipe
+		return false
+	}
+	e.seeker, e.start = ws, pos
+
+	return true
+}
+
+// patchHeader writes the file size and the image size into the header
+// once the pixel data has been written, then seeks back to the end.
+func (e *Encoder) patchHeader() error {
+	var b [4]byte
+
+	fields := []struct {
+		offset int64
+		value  int
+	}{
+		{2, e.offset + e.written + len(e.profile)},
+		{34, e.written},
+	}
+	if e.profile != nil {
+		// the profile follows the pixel data, from the start of the DIB
+		// header
+		fields = append(fields, struct {
+			offset int64
+			value  int
+		}{14 + 112, e.offset - 14 + e.written})
+	}
+	for _, f := range fields {
+		if _, err := e.seeker.Seek(e.start+f.offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		binary.LittleEndian.PutUint32(b[:], uint32(f.value))
+		if _, err := e.seeker.Write(b[:]); err != nil {
+			return err
+		}
+	}
+
+	_, err := e.seeker.Seek(e.start+int64(e.offset+e.written+len(e.profile)), io.SeekStart)
+	return err
+}
+
+// writeHeader writes the file header, the DIB header and the color table.
+// A negative imageSize means the size of the pixel data is not known yet.
+func (e *Encoder) writeHeader(imageSize int) error {
+	const fileHeaderLen = 14
+
+	if e.header == HeaderCore {
+		return e.writeCoreHeader(imageSize)
+	}
+
+	header, compression := e.header, e.compression
+	masks := [4]uint32{0x00ff0000, 0x0000ff00, 0x000000ff, 0xff000000}
+	if e.alphaBitfields() {
+		// the alpha mask is part of V4 headers
+		compression = CompressionBitfields
+		if header == HeaderInfo {
+			header = HeaderV4
+		}
+	}
+	if e.rgb565 && e.depth == 16 {
+		compression = CompressionBitfields
+		masks = [4]uint32{0xf800, 0x07e0, 0x001f, 0}
+	}
+	if e.grayMask != 0 && e.depth == 16 {
+		compression = CompressionBitfields
+		m := uint32(e.grayMask)
+		masks = [4]uint32{m, m, m, 0}
+	}
+
+	dibLen, masksLen := infoHeaderLen, 0
+	switch header {
+	case HeaderV4:
+		dibLen = v4HeaderLen
+	case HeaderV5:
+		dibLen = v5HeaderLen
+	default:
+		if compression == CompressionBitfields {
+			// the 3 masks follow a BITMAPINFOHEADER
+			masksLen = 12
+		}
+	}
+
+	offset := fileHeaderLen + dibLen + masksLen + len(e.palette)*4
+	b := make([]byte, offset)
+	e.offset = offset
+
+	fileSize, profileOffset := 0, 0
+	if imageSize < 0 {
+		imageSize = 0
+	} else {
+		fileSize = offset + imageSize + len(e.profile)
+		profileOffset = offset - fileHeaderLen + imageSize
+	}
+
+	height := int32(e.height)
+	if e.topDown {
+		height = -height
+	}
+
+	copy(b[0:2], "BM")
+	binary.LittleEndian.PutUint32(b[2:6], uint32(fileSize))
+	binary.LittleEndian.PutUint32(b[10:14], uint32(offset))
+	binary.LittleEndian.PutUint32(b[14:18], uint32(dibLen))
+	binary.LittleEndian.PutUint32(b[18:22], uint32(e.width))
+	binary.LittleEndian.PutUint32(b[22:26], uint32(height))
+	binary.LittleEndian.PutUint16(b[26:28], 1)
+	binary.LittleEndian.PutUint16(b[28:30], uint16(e.depth))
+	binary.LittleEndian.PutUint32(b[30:34], uint32(compression))
+	binary.LittleEndian.PutUint32(b[34:38], uint32(imageSize))
+	binary.LittleEndian.PutUint32(b[38:42], uint32(e.xRes))
+	binary.LittleEndian.PutUint32(b[42:46], uint32(e.yRes))
+	binary.LittleEndian.PutUint32(b[46:50], uint32(len(e.palette)))
+
+	if compression == CompressionBitfields {
+		n := 4
+		if masksLen != 0 {
+			n = 3
+		}
+		for i, mask := range masks[:n] {
+			binary.LittleEndian.PutUint32(b[fileHeaderLen+40+4*i:], mask)
+		}
+	}
+	if dibLen >= v4HeaderLen {
+		cs := ColorSpace{Type: ColorSpaceSRGB}
+		if e.colorSpace != nil {
+			cs = *e.colorSpace
+		}
+		if e.profile != nil {
+			cs = ColorSpace{Type: ColorSpaceProfileEmbedded}
+		}
+		writeColorSpace(b[fileHeaderLen+56:], cs)
+	}
+	if dibLen >= v5HeaderLen {
+		// LCS_GM_IMAGES, the perceptual intent
+		binary.LittleEndian.PutUint32(b[fileHeaderLen+108:], 4)
+		if e.profile != nil {
+			binary.LittleEndian.PutUint32(b[fileHeaderLen+112:], uint32(profileOffset))
+			binary.LittleEndian.PutUint32(b[fileHeaderLen+116:], uint32(len(e.profile)))
+		}
+	}
+
+	transparent := e.transparentEntry()
+	for i, c := range e.palette {
+		entry := b[offset-len(e.palette)*4+4*i:]
+		if e.paletteAlpha {
+			// BGRA order, with straight colors
+			nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+			entry[0], entry[1], entry[2], entry[3] = nc.B, nc.G, nc.R, nc.A
+			continue
+		}
+
+		r, g, bl, _ := c.RGBA()
+		// BGR order
+		entry[0], entry[1], entry[2] = uint8(bl>>8), uint8(g>>8), uint8(r>>8)
+		if transparent >= 0 && i != transparent {
+			entry[3] = 0xff
+		}
+	}
+
+	_, err := e.w.Write(b)
+	return err
+}
+
+// writeCoreHeader writes the file header, a BITMAPCOREHEADER and a color
+// table of 3-byte entries.
+func (e *Encoder) writeCoreHeader(imageSize int) error {
+	const fileHeaderLen = 14
+
+	offset := fileHeaderLen + coreHeaderLen + len(e.palette)*3
+	b := make([]byte, offset)
+	e.offset = offset
+
+	copy(b[0:2], "BM")
+	binary.LittleEndian.PutUint32(b[2:6], uint32(offset+imageSize))
+	binary.LittleEndian.PutUint32(b[10:14], uint32(offset))
+	binary.LittleEndian.PutUint32(b[14:18], coreHeaderLen)
+	binary.LittleEndian.PutUint16(b[18:20], uint16(e.width))
+	binary.LittleEndian.PutUint16(b[20:22], uint16(e.height))
+	binary.LittleEndian.PutUint16(b[22:24], 1)
+	binary.LittleEndian.PutUint16(b[24:26], uint16(e.depth))
+
+	for i, c := range e.palette {
+		r, g, bl, _ := c.RGBA()
+		// BGR order
+		b[fileHeaderLen+coreHeaderLen+3*i] = uint8(bl >> 8)
+		b[fileHeaderLen+coreHeaderLen+3*i+1] = uint8(g >> 8)
+		b[fileHeaderLen+coreHeaderLen+3*i+2] = uint8(r >> 8)
+	}
+
+	_, err := e.w.Write(b)
+	return err
+}
+
+// appendRow appends the stored representation of row to dst.
+func (e *Encoder) appendRow(dst, row []byte) []byte {
+	if e.rle() {
+		return appendRLE(dst, row[:e.width], e.depth)
+	}
+
+	n := len(dst)
+	for i := 0; i < e.stride(); i++ {
+		dst = append(dst, 0)
+	}
+	p := dst[n:]
+
+	switch e.depth {
+	case 1, 2, 4:
+		ppb := 8 / e.depth
+		for x, idx := range row[:e.width] {
+			p[x/ppb] |= idx << uint(8-e.depth*(x%ppb+1))
+		}
+	case 8:
+		copy(p, row[:e.width])
+	case 16:
+		if e.grayMask != 0 {
+			copy(p, row[:e.width*2])
+			break
+		}
+		for i, j := 0, 0; i < e.width*4; i, j = i+4, j+2 {
+			// little-endian 5-5-5, the top bit being unused
+			v := reduce5(row[i])<<10 | reduce5(row[i+1])<<5 | reduce5(row[i+2])
+			if e.rgb565 {
+				v = reduce5(row[i])<<11 | reduce6(row[i+1])<<5 | reduce5(row[i+2])
+			}
+			p[j], p[j+1] = uint8(v), uint8(v>>8)
+		}
+	case 24:
+		for i, j := 0, 0; i < e.width*4; i, j = i+4, j+3 {
+			// BGR order
+			p[j], p[j+1], p[j+2] = row[i+2], row[i+1], row[i]
+		}
+	case 32:
+		for i := 0; i < e.width*4; i += 4 {
+			// BGRA order
+			p[i], p[i+1], p[i+2], p[i+3] = row[i+2], row[i+1], row[i], row[i+3]
+		}
+	}
+
+	return dst
+}
+
+// reduce5 scales an 8-bit sample to 5 bits, rounding to the nearest.
+func reduce5(v uint8) uint16 {
+	return (uint16(v)*0x1f + 0x7f) / 0xff
+}
+
+// reduce6 scales an 8-bit sample to 6 bits, rounding to the nearest.
+func reduce6(v uint8) uint16 {
+	return (uint16(v)*0x3f + 0x7f) / 0xff
+}
+
+// appendRLE appends a row compressed with RLE8 or RLE4, followed by an
+// end-of-line marker.
+func appendRLE(dst, row []byte, bpp int) []byte {
+	// literal appends row[i:j] in absolute mode
+	literal := func(dst []byte, i, j int) []byte {
+		for j-i > 0 {
+			n := j - i
+			if n > 255 {
+				n = 255
+			}
+
+			if n < 3 {
+				// absolute mode needs at least 3 pixels
+				for _, idx := range row[i : i+n] {
+					dst = append(dst, 1, runByte(idx, bpp))
+				}
+				i += n
+				continue
+			}
+
+			dst = append(dst, 0, byte(n))
+			start := len(dst)
+			if bpp == 8 {
+				dst = append(dst, row[i:i+n]...)
+			} else {
+				for k := 0; k < n; k += 2 {
+					b := row[i+k] << 4
+					if k+1 < n {
+						b |= row[i+k+1] & 0x0f
+					}
+					dst = append(dst, b)
+				}
+			}
+			// absolute runs are padded to a 16-bit boundary
+			if (len(dst)-start)%2 != 0 {
+				dst = append(dst, 0)
+			}
+			i += n
+		}
+
+		return dst
+	}
+
+	lit := 0
+	for i := 0; i < len(row); {
+		n := 1
+		for i+n < len(row) && n < 255 && row[i+n] == row[i] {
+			n++
+		}
+
+		if n < 3 {
+			i += n
+			continue
+		}
+
+		dst = literal(dst, lit, i)
+		dst = append(dst, byte(n), runByte(row[i], bpp))
+		i += n
+		lit = i
+	}
+	dst = literal(dst, lit, len(row))
+
+	// end of line
+	return append(dst, 0, 0)
+}
+
+// runByte returns the byte repeated by an encoded run of idx.
+func runByte(idx byte, bpp int) byte {
+	if bpp == 4 {
+		return idx<<4 | idx&0x0f
+	}
+
+	return idx
+}
+
+// WriteHeader starts a streamed image of the given size. The palette is
+// required for paletted depths and ignored otherwise. The rows must then be
+// written with WriteRow in the order they are stored: from bottom to top,
+// or from top to bottom if SetTopDown was called.
+//
+// The file size and the image size of RLE compressed images are not known
+// in advance. If the destination is an io.WriteSeeker they are patched into
+// the header after the last row, otherwise they are written as zero.
+func (e *Encoder) WriteHeader(width, height int, p color.Palette) error {
+	if err := e.begin(width, height, p); err != nil {
+		return err
+	}
+
+	return e.startImage()
+}
+
+// startImage writes the header of a streamed image.
+func (e *Encoder) startImage() error {
+	imageSize := -1
+	if !e.rle() {
+		imageSize = e.stride() * e.height
+	} else if !e.seekable() && e.profile != nil {
+		e.started = false
+		return errors.New("bmp: embedding a profile in a streamed RLE image requires an io.WriteSeeker")
+	}
+
+	return e.writeHeader(imageSize)
+}
+
+// WriteRow writes the next row of a streamed image: palette indices for
+// paletted depths, 8-bit RGBA samples otherwise (non-premultiplied for
+// 32 bpp, unless SetPremultiplied is set). Rows are stored as given.
+func (e *Encoder) WriteRow(row []byte) error {
+	if !e.started {
+		return errors.New("bmp: WriteHeader must be called before WriteRow")
+	}
+
+	if e.rows >= e.height {
+		return errors.New("bmp: all rows have already been written")
+	}
+
+	if len(row) < e.rowLen() {
+		return fmt.Errorf("bmp: row is too short (got: %d, expected: %d)", len(row), e.rowLen())
+	}
+
+	if e.remap != nil {
+		// the row of the caller is left untouched
+		e.mapped = append(e.mapped[:0], row[:e.width]...)
+		e.remapRow(e.mapped)
+		row = e.mapped
+	}
+
+	return e.writeRow(row)
+}
+
+func (e *Encoder) writeRow(row []byte) error {
+	e.buf = e.appendRow(e.buf[:0], row)
+	e.rows++
+	last := e.rows == e.height
+	if last {
+		e.started = false
+		if e.rle() {
+			// end of bitmap
+			e.buf = append(e.buf, 0, 1)
+		}
+	}
+
+	n, err := e.w.Write(e.buf)
+	e.written += n
+	if err != nil {
+		return err
+	}
+
+	if last && e.profile != nil {
+		if _, err := e.w.Write(e.profile); err != nil {
+			return err
+		}
+	}
+	if last && e.seeker != nil {
+		return e.patchHeader()
+	}
+
+	return nil
+}
+
+// paletteOf returns the palette used to encode m at a paletted depth: its
+// own palette, or one built by quantize.Octree for other images. The
+// transparent index, if any, is a color of its own, which the pixels less
+// than half opaque map to.
+func (e *Encoder) paletteOf(m image.Image) (color.Palette, error) {
+	if e.depth > 8 {
+		return nil, nil
+	}
+
+	if p, ok := m.ColorModel().(color.Palette); ok {
+		return p, nil
+	}
+
+	n := 1 << uint(e.depth)
+	if e.transparent < 0 {
+		return quantize.Octree{}.Quantize(make(color.Palette, 0, n), m), nil
+	}
+
+	p := quantize.Octree{Transparent: true}.Quantize(make(color.Palette, 0, n-1), m)
+	if e.transparent > len(p) {
+		// out of range, as reported by WriteHeader
+		return p, nil
+	}
+	p = append(p, nil)
+	copy(p[e.transparent+1:], p[e.transparent:])
+	p[e.transparent] = color.Transparent
+
+	return p, nil
+}
+
+// dedupPalette returns p without its duplicate colors, as stored, and the
+// table mapping the indices of p to the ones of the returned palette.
+// Indices past p are mapped to themselves. The color at index keep, if not
+// negative, is neither merged nor merged into.
+func dedupPalette(p color.Palette, keep int, alpha bool) (color.Palette, []byte) {
+	remap := make([]byte, 256)
+	for i := range remap {
+		remap[i] = byte(i)
+	}
+
+	var merged color.Palette
+	seen := make(map[[4]uint8]byte, len(p))
+	for i, c := range p {
+		r, g, b, a := c.RGBA()
+		key := [4]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+		if alpha {
+			key[3] = uint8(a >> 8)
+		}
+		if i == keep {
+			remap[i] = byte(len(merged))
+			merged = append(merged, c)
+			continue
+		}
+		if j, ok := seen[key]; ok {
+			remap[i] = j
+			continue
+		}
+		seen[key] = byte(len(merged))
+		remap[i] = byte(len(merged))
+		merged = append(merged, c)
+	}
+
+	return merged, remap
+}
+
+// transparentEntry returns the index of the transparent color in the
+// deduplicated palette, or -1.
+func (e *Encoder) transparentEntry() int {
+	if e.transparent >= 0 && e.remap != nil {
+		return int(e.remap[e.transparent])
+	}
+
+	return e.transparent
+}
+
+// remapRow maps the indices of row to the ones of the deduplicated
+// palette, if any.
+func (e *Encoder) remapRow(row []byte) {
+	if e.remap == nil {
+		return
+	}
+	for i, idx := range row {
+		row[i] = e.remap[idx]
+	}
+}
+
+// imageRow converts the row y of m to the format accepted by WriteRow.
+func (e *Encoder) imageRow(m image.Image, y int, dst []byte) {
+	b := m.Bounds()
+
+	if e.palette != nil {
+		// the indices of the image are only valid with its own palette
+		switch p := m.(type) {
+		case *image.Paletted:
+			if !e.ownPalette {
+				break
+			}
+			copy(dst, p.Pix[p.PixOffset(b.Min.X, y):p.PixOffset(b.Max.X, y)])
+			e.remapRow(dst)
+			return
+		case *Bitmap:
+			if !e.ownPalette {
+				break
+			}
+			for x := b.Min.X; x < b.Max.X; x++ {
+				dst[x-b.Min.X] = p.ColorIndexAt(x, y)
+			}
+			e.remapRow(dst)
+			return
+		}
+
+		if e.nearest == nil {
+			e.nearest = newNearest(e.palette, e.transparentEntry())
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst[x-b.Min.X] = e.nearest.index(color.RGBAModel.Convert(m.At(x, y)).(color.RGBA))
+		}
+		return
+	}
+
+	// rows of these images are in the format of WriteRow already, at their
+	// own offset and stride
+	switch m := m.(type) {
+	case *image.NRGBA:
+		if e.depth == 32 && !e.premultiplied {
+			copy(dst, m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)])
+			return
+		}
+	case *image.RGBA:
+		src := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+		if e.depth != 32 || e.premultiplied {
+			copy(dst, src)
+			return
+		}
+		unpremultiply(dst, src)
+		return
+	case *RGB24:
+		src := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+		for i, j := 0, 0; j < len(src); i, j = i+4, j+3 {
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = src[j], src[j+1], src[j+2], 0xff
+		}
+		return
+	}
+
+	for x, i := b.Min.X, 0; x < b.Max.X; x, i = x+1, i+4 {
+		if e.depth == 32 && !e.premultiplied {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = c.R, c.G, c.B, c.A
+		} else {
+			c := color.RGBAModel.Convert(m.At(x, y)).(color.RGBA)
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
+
+// unpremultiply converts premultiplied RGBA samples to non-premultiplied
+// ones, as color.NRGBAModel does.
+func unpremultiply(dst, src []byte) {
+	for i := 0; i+3 < len(src); i += 4 {
+		a := uint32(src[i+3])
+		switch a {
+		case 0:
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = 0, 0, 0, 0
+		case 0xff:
+			copy(dst[i:i+4], src[i:i+4])
+		default:
+			a *= 0x101
+			dst[i] = uint8(uint32(src[i]) * 0x101 * 0xffff / a >> 8)
+			dst[i+1] = uint8(uint32(src[i+1]) * 0x101 * 0xffff / a >> 8)
+			dst[i+2] = uint8(uint32(src[i+2]) * 0x101 * 0xffff / a >> 8)
+			dst[i+3] = src[i+3]
+		}
+	}
+}
+
+// rleData returns the RLE compressed pixel data of m, using row as a
+// buffer.
+func (e *Encoder) rleData(m image.Image, row []byte) []byte {
+	workers := e.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > e.height {
+		workers = e.height
+	}
+	if workers <= 1 {
+		data := e.appendRLERows(nil, m, row, 0, e.height)
+		// end of bitmap
+		return append(data, 0, 1)
+	}
+
+	// each row ends with its own end-of-line marker, so the bands of rows
+	// are compressed independently and concatenated
+	bands := make([][]byte, workers)
+	var wg sync.WaitGroup
+	for i := range bands {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// the nearest color cache is not shared
+			be := *e
+			be.nearest = nil
+			lo, hi := e.height*i/workers, e.height*(i+1)/workers
+			bands[i] = be.appendRLERows(nil, m, make([]byte, len(row)), lo, hi)
+		}(i)
+	}
+	wg.Wait()
+
+	var data []byte
+	for _, band := range bands {
+		data = append(data, band...)
+	}
+	// end of bitmap
+	return append(data, 0, 1)
+}
+
+// appendRLERows appends the compressed stored rows lo to hi of m to dst,
+// using row as a buffer.
+func (e *Encoder) appendRLERows(dst []byte, m image.Image, row []byte, lo, hi int) []byte {
+	b := m.Bounds()
+	for n := lo; n < hi; n++ {
+		e.imageRow(m, b.Min.Y+e.rowY(n), row)
+		dst = e.appendRow(dst, row)
+	}
+
+	return dst
+}
+
+// writeRLE writes the header and the RLE compressed pixel data.
+func (e *Encoder) writeRLE(data []byte) error {
+	if err := e.writeHeader(len(data)); err != nil {
+		return err
+	}
+
+	_, err := e.w.Write(append(data, e.profile...))
+	return err
+}
+
+// Encode writes the image m. The bounds of m need not start at (0, 0), so
+// sub-images can be encoded without copying them first.
+func (e *Encoder) Encode(m image.Image) (err error) {
+	var start time.Time
+	if e.tracer != nil {
+		start = time.Now()
+	}
+
+	var p color.Palette
+	switch e.depth {
+	case DepthAuto:
+		e.depth, p = autoDepth(m)
+		e.ownPalette = false
+		defer func() {
+			e.depth = DepthAuto
+		}()
+	case depthTrueColor:
+		e.depth = trueColorDepth(m.ColorModel())
+		defer func() {
+			e.depth = depthTrueColor
+		}()
+	default:
+		var err error
+		if p, err = e.paletteOf(m); err != nil {
+			return err
+		}
+		_, e.ownPalette = m.ColorModel().(color.Palette)
+	}
+	if e.autoRLE {
+		defer func() {
+			e.compression = CompressionRGB
+		}()
+	}
+	if e.tracer != nil {
+		// registered after resetting DepthAuto to run before it
+		defer func() { e.trace(start, m.Bounds(), err) }()
+	}
+
+	b := m.Bounds()
+	if err := e.begin(b.Dx(), b.Dy(), p); err != nil {
+		return err
+	}
+	defer func() {
+		e.started = false
+	}()
+
+	row := make([]byte, e.rowLen())
+
+	if e.autoRLE && (e.depth == 4 || e.depth == 8) && !e.topDown && e.header != HeaderCore {
+		// RLE is kept if it makes the pixel data smaller
+		e.compression = CompressionRLE8
+		if e.depth == 4 {
+			e.compression = CompressionRLE4
+		}
+		if data := e.rleData(m, row); len(data) < e.stride()*e.height {
+			return e.writeRLE(data)
+		}
+		e.compression = CompressionRGB
+	}
+
+	if e.rle() && (e.workers != 1 || !e.seekable()) {
+		// the compressed size must be known before writing the header
+		// unless it can be patched, and concurrent bands are only known
+		// once all compressed
+		return e.writeRLE(e.rleData(m, row))
+	}
+
+	if err := e.startImage(); err != nil {
+		return err
+	}
+
+	for n := 0; n < e.height; n++ {
+		e.imageRow(m, b.Min.Y+e.rowY(n), row)
+		if err := e.writeRow(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
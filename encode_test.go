@@ -0,0 +1,1185 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+func testImage(w, h int) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{uint8(x * 40), uint8(y * 40), uint8(x + y), 0xff})
+		}
+	}
+
+	return m
+}
+
+func testPaletted(w, h, colors int) *image.Paletted {
+	p := make(color.Palette, colors)
+	for i := range p {
+		p[i] = color.RGBA{uint8(i * 16), uint8(255 - i*16), uint8(i), 0xff}
+	}
+
+	m := image.NewPaletted(image.Rect(0, 0, w, h), p)
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i/3) % uint8(colors)
+	}
+
+	return m
+}
+
+func sameImage(a, b image.Image) bool {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+
+	ab, bb := a.Bounds(), b.Bounds()
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r0, g0, b0, a0 := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r1, g1, b1, a1 := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func TestEncode(t *testing.T) {
+	src := image.NewRGBA(image.Rect(2, 3, 7, 6))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	for i := 3; i < len(src.Pix); i += 4 {
+		src.Pix[i] = 0xff
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if dibLen := binary.LittleEndian.Uint32(b[14:18]); dibLen != 40 {
+		t.Errorf("DIB header of %d bytes, expected 40", dibLen)
+	}
+	if bpp := binary.LittleEndian.Uint16(b[28:30]); bpp != 24 {
+		t.Errorf("%d bpp, expected 24", bpp)
+	}
+	if h := int32(binary.LittleEndian.Uint32(b[22:26])); h != 3 {
+		t.Errorf("height %d, expected 3 for bottom-up rows", h)
+	}
+
+	m, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(m, src) {
+		t.Error("decoded image differs")
+	}
+}
+
+func TestEncodeWithOptions(t *testing.T) {
+	tests := []struct {
+		src   image.Image
+		depth int
+		bpp   int
+	}{
+		{testPaletted(5, 3, 2), 1, 1},
+		{testPaletted(5, 3, 16), 4, 4},
+		{testPaletted(5, 3, 16), 8, 8},
+		{testImage(5, 3), 24, 24},
+		{testImage(5, 3), 32, 32},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, tt.src, &EncodeOptions{BitsPerPixel: tt.depth}); err != nil {
+			t.Fatal(err)
+		}
+		if bpp := binary.LittleEndian.Uint16(buf.Bytes()[28:30]); int(bpp) != tt.bpp {
+			t.Errorf("depth %d: %d bpp, expected %d", tt.depth, bpp, tt.bpp)
+		}
+		m, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameImage(m, tt.src) {
+			t.Errorf("depth %d: decoded image differs", tt.depth)
+		}
+	}
+
+	// 16 bpp keeps 5 bits per channel
+	src := testImage(7, 7)
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, src, &EncodeOptions{BitsPerPixel: 16}); err != nil {
+		t.Fatal(err)
+	}
+	if n := buf.Len(); n != 54+7*16 {
+		t.Errorf("16 bpp file of %d bytes, expected %d", n, 54+7*16)
+	}
+	m, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 7; x++ {
+			c0 := src.NRGBAAt(x, y)
+			c1 := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			for _, d := range []int{int(c0.R) - int(c1.R), int(c0.G) - int(c1.G), int(c0.B) - int(c1.B)} {
+				if d < -4 || d > 4 {
+					t.Fatalf("pixel (%d, %d) = %v, expected about %v", x, y, c1, c0)
+				}
+			}
+		}
+	}
+
+	if err := EncodeWithOptions(&buf, src, &EncodeOptions{BitsPerPixel: 12}); err == nil {
+		t.Error("expected an error for 12 bpp")
+	}
+
+	// zero options write 24 bpp, the automatic depth is asked for
+	for _, tt := range []struct {
+		o   *EncodeOptions
+		bpp uint16
+	}{
+		{nil, 24},
+		{&EncodeOptions{}, 24},
+		{&EncodeOptions{AutoDepth: true}, 4},
+	} {
+		buf.Reset()
+		if err := EncodeWithOptions(&buf, testPaletted(5, 3, 16), tt.o); err != nil {
+			t.Fatal(err)
+		}
+		if bpp := binary.LittleEndian.Uint16(buf.Bytes()[28:30]); bpp != tt.bpp {
+			t.Errorf("%+v: %d bpp, expected %d", tt.o, bpp, tt.bpp)
+		}
+	}
+}
+
+func TestEncoderAlphaBitfields(t *testing.T) {
+	src := testImage(5, 3)
+	src.SetNRGBA(1, 1, color.NRGBA{0x10, 0x20, 0x30, 0x40})
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(DepthAuto)
+	e.SetAlphaBitfields(true)
+	if err := e.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if dibLen := binary.LittleEndian.Uint32(b[14:18]); dibLen != 108 {
+		t.Errorf("DIB header of %d bytes, expected 108", dibLen)
+	}
+	if c := Compression(binary.LittleEndian.Uint32(b[30:34])); c != CompressionBitfields {
+		t.Errorf("compression %d, expected bit fields", c)
+	}
+	if a := binary.LittleEndian.Uint32(b[14+52:]); a != 0xff000000 {
+		t.Errorf("alpha mask %#x", a)
+	}
+
+	// the alpha mask is not the reserved byte ignored by AlphaIgnore
+	m, err := Decode(bytes.NewReader(b), WithAlphaMode(AlphaIgnore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(m, src) {
+		t.Error("decoded image differs")
+	}
+
+	// opaque images keep their depth
+	buf.Reset()
+	if err := e.Encode(testImage(5, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if bpp := binary.LittleEndian.Uint16(buf.Bytes()[28:30]); bpp == 32 {
+		t.Errorf("opaque image written with %d bpp", bpp)
+	}
+}
+
+func TestEncoderRGB565(t *testing.T) {
+	src := testImage(7, 7)
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(16)
+	e.SetRGB565(true)
+	if err := e.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if c := binary.LittleEndian.Uint32(b[30:34]); c != uint32(CompressionBitfields) {
+		t.Errorf("compression = %d, expected bit fields", c)
+	}
+	// the masks follow the BITMAPINFOHEADER
+	for i, want := range []uint32{0xf800, 0x07e0, 0x001f} {
+		if mask := binary.LittleEndian.Uint32(b[54+4*i:]); mask != want {
+			t.Errorf("mask %d = %#x, expected %#x", i, mask, want)
+		}
+	}
+	if offset := binary.LittleEndian.Uint32(b[10:14]); offset != 66 {
+		t.Errorf("pixel data offset = %d, expected 66", offset)
+	}
+	if n := len(b); n != 66+7*16 {
+		t.Errorf("file of %d bytes, expected %d", n, 66+7*16)
+	}
+
+	m, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 7; x++ {
+			c0 := src.NRGBAAt(x, y)
+			c1 := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			// 5 bits of red and blue, 6 of green
+			for _, d := range []int{(int(c0.R) - int(c1.R)) / 2, int(c0.G) - int(c1.G), (int(c0.B) - int(c1.B)) / 2} {
+				if d < -2 || d > 2 {
+					t.Fatalf("pixel (%d, %d) = %v, expected about %v", x, y, c1, c0)
+				}
+			}
+		}
+	}
+
+	// V4 headers hold the masks
+	buf.Reset()
+	e.SetHeader(HeaderV4)
+	if err := e.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	b = buf.Bytes()
+	if mask := binary.LittleEndian.Uint32(b[14+44:]); mask != 0x07e0 {
+		t.Errorf("V4 green mask = %#x, expected 0x7e0", mask)
+	}
+	if offset := binary.LittleEndian.Uint32(b[10:14]); offset != 14+108 {
+		t.Errorf("V4 pixel data offset = %d, expected %d", offset, 14+108)
+	}
+	if _, err := Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodePaletted(t *testing.T) {
+	// duplicate and translucent colors, which must keep their indices
+	p := color.Palette{
+		color.RGBA{0x10, 0x20, 0x30, 0xff},
+		color.RGBA{0x10, 0x20, 0x30, 0xff},
+		color.NRGBA{0xff, 0, 0, 0x80},
+		color.NRGBA{0, 0, 0, 0},
+	}
+	src := image.NewPaletted(image.Rect(0, 0, 5, 3), p)
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i % len(p))
+	}
+
+	var buf bytes.Buffer
+	if err := EncodePaletted(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	if bpp := binary.LittleEndian.Uint16(buf.Bytes()[28:30]); bpp != 8 {
+		t.Errorf("%d bpp, expected 8", bpp)
+	}
+
+	m, err := Decode(&buf, WithPaletteAlpha(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, ok := m.(*image.Paletted)
+	if !ok {
+		t.Fatalf("decoded a %T", m)
+	}
+	if !bytes.Equal(pm.Pix, src.Pix) {
+		t.Errorf("indices %v, expected %v", pm.Pix, src.Pix)
+	}
+	for i, c := range p {
+		if got, want := color.NRGBAModel.Convert(pm.Palette[i]), color.NRGBAModel.Convert(c); got != want {
+			t.Errorf("palette entry %d = %v, expected %v", i, got, want)
+		}
+	}
+
+	// DepthAuto picks the smallest depth holding the palette
+	auto := func(e *Encoder) { e.SetDepth(DepthAuto) }
+	for _, tt := range []struct{ colors, bpp int }{{2, 1}, {3, 4}, {16, 4}, {17, 8}} {
+		src = testPaletted(5, 3, tt.colors)
+		buf.Reset()
+		if err := EncodePaletted(&buf, src, auto); err != nil {
+			t.Fatal(err)
+		}
+		if bpp := binary.LittleEndian.Uint16(buf.Bytes()[28:30]); int(bpp) != tt.bpp {
+			t.Errorf("%d colors: %d bpp, expected %d", tt.colors, bpp, tt.bpp)
+		}
+		if m, err = Decode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if pm, ok := m.(*image.Paletted); !ok || !bytes.Equal(pm.Pix, src.Pix) {
+			t.Errorf("%d colors: indices differ", tt.colors)
+		}
+	}
+
+	e := NewEncoder(&buf)
+	e.SetDepth(8)
+	e.SetPaletteAlpha(true)
+	e.SetTransparentIndex(3)
+	if err := e.Encode(src); err == nil {
+		t.Error("expected an error for palette alpha with a transparent index")
+	}
+}
+
+func TestEncodeMonochrome(t *testing.T) {
+	// 33 pixels take 5 bytes, padded to 8
+	src := image.NewGray(image.Rect(0, 0, 33, 2))
+	for i := range src.Pix {
+		if i%3 == 0 {
+			src.Pix[i] = 0xc0
+		} else {
+			src.Pix[i] = 0x40
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeMonochrome(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if bpp := binary.LittleEndian.Uint16(b[28:30]); bpp != 1 {
+		t.Errorf("%d bpp, expected 1", bpp)
+	}
+	// the headers, 2 color table entries and 2 rows of 8 bytes
+	if n := 14 + 40 + 2*4 + 2*8; len(b) != n {
+		t.Errorf("file size = %d, expected %d", len(b), n)
+	}
+	if last := b[len(b)-8:]; !bytes.Equal(last, []byte{0x92, 0x49, 0x24, 0x92, 0x00, 0, 0, 0}) {
+		// bottom-up, the last row stored is the top one
+		t.Errorf("top row = %x", last)
+	}
+
+	m, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 33; x++ {
+			want := color.Gray{0}
+			if src.GrayAt(x, y).Y >= 0x80 {
+				want = color.Gray{0xff}
+			}
+			if got := color.GrayModel.Convert(m.At(x, y)); got != want {
+				t.Errorf("pixel (%d, %d) = %v, expected %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	tests := []struct {
+		depth   int
+		topDown bool
+		img     image.Image
+	}{
+		{24, false, testImage(7, 5)},
+		{24, true, testImage(7, 5)},
+		{32, false, testImage(3, 4)},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(tt.depth)
+		e.SetTopDown(tt.topDown)
+		e.SetResolution(300, 72)
+		if err := e.Encode(tt.img); err != nil {
+			t.Errorf("%d bpp: %v", tt.depth, err)
+			continue
+		}
+
+		d := NewDecoder(bytes.NewReader(buf.Bytes()))
+		meta, err := d.Metadata()
+		if err != nil {
+			t.Errorf("%d bpp: %v", tt.depth, err)
+			continue
+		}
+		if meta.BitsPerPixel != tt.depth || meta.XPelsPerMeter != 11811 || meta.YPelsPerMeter != 2835 {
+			t.Errorf("%d bpp: unexpected metadata %+v", tt.depth, meta)
+		}
+		if int(meta.FileSize) != buf.Len() {
+			t.Errorf("%d bpp: file size = %d, expected %d", tt.depth, meta.FileSize, buf.Len())
+		}
+
+		img, err := d.Image()
+		if err != nil {
+			t.Errorf("%d bpp: %v", tt.depth, err)
+			continue
+		}
+		if !sameImage(img, tt.img) {
+			t.Errorf("%d bpp: decoded image differs", tt.depth)
+		}
+	}
+}
+
+func TestEncoderPaletted(t *testing.T) {
+	tests := []struct {
+		depth int
+		img   *image.Paletted
+		row   []byte // last row as stored
+	}{
+		{8, testPaletted(5, 2, 200), []byte{1, 2, 2, 2, 3, 0, 0, 0}},
+		{4, testPaletted(5, 2, 16), []byte{0x12, 0x22, 0x30, 0}},
+		{1, testPaletted(11, 1, 2), []byte{0x1c, 0x60, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(tt.depth)
+		if err := e.Encode(tt.img); err != nil {
+			t.Errorf("%d bpp: %v", tt.depth, err)
+			continue
+		}
+
+		d := NewDecoder(bytes.NewReader(buf.Bytes()))
+		cfg, err := d.Config()
+		if err != nil {
+			t.Errorf("%d bpp: %v", tt.depth, err)
+			continue
+		}
+		if p := cfg.ColorModel.(color.Palette); len(p) != len(tt.img.Palette) {
+			t.Errorf("%d bpp: palette has %d colors, expected %d", tt.depth, len(p), len(tt.img.Palette))
+		}
+
+		// the first stored row is the last row of the image
+		offset := 14 + 40 + len(tt.img.Palette)*4
+		if row := buf.Bytes()[offset : offset+len(tt.row)]; !bytes.Equal(row, tt.row) {
+			t.Errorf("%d bpp: stored row = %#v, expected %#v", tt.depth, row, tt.row)
+		}
+	}
+}
+
+func TestEncoderRLE(t *testing.T) {
+	p := color.Palette{color.Black, color.White, color.Gray{0x80}}
+	m := image.NewPaletted(image.Rect(0, 0, 8, 1), p)
+	copy(m.Pix, []uint8{1, 1, 1, 1, 0, 2, 0, 2})
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(8)
+	e.SetCompression(CompressionRLE8)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		4, 1, // run of four 1
+		0, 4, 0, 2, 0, 2, // absolute run
+		0, 0, // end of line
+		0, 1, // end of bitmap
+	}
+	data := buf.Bytes()[14+40+3*4:]
+	if !bytes.Equal(data, expected) {
+		t.Errorf("RLE8 data = %v, expected %v", data, expected)
+	}
+
+	header := buf.Bytes()
+	if c := binary.LittleEndian.Uint32(header[30:34]); Compression(c) != CompressionRLE8 {
+		t.Errorf("compression = %d, expected %d", c, CompressionRLE8)
+	}
+	if n := binary.LittleEndian.Uint32(header[34:38]); int(n) != len(expected) {
+		t.Errorf("image size = %d, expected %d", n, len(expected))
+	}
+}
+
+func TestEncoderStream(t *testing.T) {
+	m := testImage(6, 4)
+
+	var encoded bytes.Buffer
+	if err := NewEncoder(&encoded).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	var streamed bytes.Buffer
+	e := NewEncoder(&streamed)
+	if err := e.WriteHeader(6, 4, nil); err != nil {
+		t.Fatal(err)
+	}
+	for y := 3; y >= 0; y-- {
+		if err := e.WriteRow(m.Pix[y*m.Stride : (y+1)*m.Stride]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.WriteRow(m.Pix[:m.Stride]); err == nil {
+		t.Error("WriteRow after the last row succeeded")
+	}
+
+	if !bytes.Equal(encoded.Bytes(), streamed.Bytes()) {
+		t.Error("streamed image differs from encoded image")
+	}
+}
+
+func TestEncoderPatchHeader(t *testing.T) {
+	m := testPaletted(40, 6, 4)
+
+	var buffered bytes.Buffer
+	e := NewEncoder(&buffered)
+	e.SetDepth(8)
+	e.SetCompression(CompressionRLE8)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "bmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	e = NewEncoder(f)
+	e.SetDepth(8)
+	e.SetCompression(CompressionRLE8)
+	if err := e.WriteHeader(40, 6, m.Palette); err != nil {
+		t.Fatal(err)
+	}
+	for y := 5; y >= 0; y-- {
+		if err := e.WriteRow(m.Pix[y*m.Stride : (y+1)*m.Stride]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	streamed, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffered.Bytes(), streamed) {
+		t.Error("streamed RLE image differs from buffered image")
+	}
+}
+
+func TestEncoderCoreHeader(t *testing.T) {
+	tests := []struct {
+		depth int
+		img   image.Image
+		size  int
+	}{
+		{24, testImage(3, 2), 14 + 12 + 2*12},
+		{4, testPaletted(4, 2, 3), 14 + 12 + 16*3 + 2*4},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(tt.depth)
+		e.SetHeader(HeaderCore)
+		if err := e.Encode(tt.img); err != nil {
+			t.Errorf("%d bpp: %v", tt.depth, err)
+			continue
+		}
+		if buf.Len() != tt.size {
+			t.Errorf("%d bpp: file size = %d, expected %d", tt.depth, buf.Len(), tt.size)
+		}
+
+		img, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("%d bpp: %v", tt.depth, err)
+			continue
+		}
+		if !sameImage(img, tt.img) {
+			t.Errorf("%d bpp: decoded image differs", tt.depth)
+		}
+	}
+
+	e := NewEncoder(ioutil.Discard)
+	e.SetDepth(32)
+	e.SetHeader(HeaderCore)
+	if err := e.Encode(testImage(1, 1)); err == nil {
+		t.Error("encoding 32 bpp with a core header succeeded")
+	}
+}
+
+func TestEncoderColorSpace(t *testing.T) {
+	calibrated := ColorSpace{
+		Type:      ColorSpaceCalibratedRGB,
+		Endpoints: [3]CIEXYZ{{0.64, 0.33, 0.03}, {0.3, 0.6, 0.1}, {0.15, 0.06, 0.79}},
+		Gamma:     [3]float64{2.2, 2.2, 2.2},
+	}
+
+	for _, h := range []Header{HeaderV4, HeaderV5} {
+		for _, cs := range []*ColorSpace{nil, &calibrated} {
+			var buf bytes.Buffer
+			e := NewEncoder(&buf)
+			e.SetHeader(h)
+			if cs != nil {
+				e.SetColorSpace(*cs)
+			}
+			src := testImage(3, 2)
+			if err := e.Encode(src); err != nil {
+				t.Fatal(err)
+			}
+
+			d := NewDecoder(bytes.NewReader(buf.Bytes()))
+			meta, err := d.Metadata()
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := d.Image()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameImage(img, src) {
+				t.Errorf("header %d: decoded image differs", h)
+			}
+
+			want := ColorSpace{Type: ColorSpaceSRGB}
+			if cs != nil {
+				want = *cs
+			}
+			got := meta.ColorSpace
+			if got == nil || got.Type != want.Type {
+				t.Fatalf("header %d: color space = %+v, expected %+v", h, got, want)
+			}
+			for i := range want.Endpoints {
+				if math.Abs(got.Endpoints[i].X-want.Endpoints[i].X) > 1e-6 || math.Abs(got.Gamma[i]-want.Gamma[i]) > 1e-4 {
+					t.Errorf("header %d: color space = %+v, expected %+v", h, got, want)
+				}
+			}
+		}
+	}
+
+	e := NewEncoder(ioutil.Discard)
+	e.SetHeader(HeaderV5)
+	e.SetColorSpace(ColorSpace{Type: ColorSpaceProfileEmbedded})
+	if err := e.Encode(testImage(1, 1)); err == nil {
+		t.Error("encoding an embedded profile succeeded")
+	}
+}
+
+func TestEncoderCalibratedRGB(t *testing.T) {
+	cs := ColorSpace{
+		Type:      ColorSpaceCalibratedRGB,
+		Endpoints: [3]CIEXYZ{{0.7, 0.3, 0}, {0.2, 0.7, 0.1}, {0.1, 0.05, 0.85}},
+		Gamma:     [3]float64{1.8, 2.2, 2.4},
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetColorSpace(cs)
+	if err := e.Encode(testImage(3, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(bytes.NewReader(buf.Bytes()))
+	meta, err := d.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.HeaderSize != 108 || meta.ColorSpace == nil || !meta.ColorSpace.Calibrated() {
+		t.Fatalf("header size %d, color space %+v, expected a calibrated V4 header", meta.HeaderSize, meta.ColorSpace)
+	}
+	for i, g := range meta.ColorSpace.Gamma {
+		if math.Abs(g-cs.Gamma[i]) > 1e-4 {
+			t.Errorf("gamma %d = %v, expected %v", i, g, cs.Gamma[i])
+		}
+	}
+
+	invalid := []ColorSpace{
+		{Type: ColorSpaceCalibratedRGB, Endpoints: [3]CIEXYZ{{X: -0.1}}},
+		{Type: ColorSpaceCalibratedRGB, Endpoints: [3]CIEXYZ{{Y: math.NaN()}}},
+		{Type: ColorSpaceCalibratedRGB, Gamma: [3]float64{0, 1 << 16, 0}},
+	}
+	for _, cs := range invalid {
+		e := NewEncoder(ioutil.Discard)
+		e.SetColorSpace(cs)
+		if err := e.Encode(testImage(1, 1)); err == nil {
+			t.Errorf("encoding %+v succeeded", cs)
+		}
+	}
+
+	e = NewEncoder(ioutil.Discard)
+	e.SetColorSpace(cs)
+	e.SetHeader(HeaderInfo)
+	if err := e.Encode(testImage(1, 1)); err == nil {
+		t.Error("encoding a color space with an info header succeeded")
+	}
+}
+
+func TestEncoderProfile(t *testing.T) {
+	icc := []byte("not really an ICC profile")
+
+	tests := []struct {
+		depth       int
+		compression Compression
+		img         image.Image
+	}{
+		{24, CompressionRGB, testImage(3, 2)},
+		{8, CompressionRLE8, testPaletted(5, 3, 7)},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(tt.depth)
+		e.SetCompression(tt.compression)
+		e.SetProfile(icc)
+		if err := e.Encode(tt.img); err != nil {
+			t.Fatal(err)
+		}
+
+		data := buf.Bytes()
+		if !bytes.HasSuffix(data, icc) {
+			t.Errorf("%d bpp: profile is not appended", tt.depth)
+		}
+		if size := binary.LittleEndian.Uint32(data[2:6]); int(size) != len(data) {
+			t.Errorf("%d bpp: file size = %d, expected %d", tt.depth, size, len(data))
+		}
+
+		d := NewDecoder(bytes.NewReader(data))
+		meta, err := d.Metadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs := meta.ColorSpace
+		if meta.HeaderSize != 124 || cs == nil || cs.Type != ColorSpaceProfileEmbedded {
+			t.Fatalf("%d bpp: header size %d, color space %+v", tt.depth, meta.HeaderSize, cs)
+		}
+		if start := 14 + int(cs.ProfileOffset); start != len(data)-len(icc) || int(cs.ProfileSize) != len(icc) {
+			t.Errorf("%d bpp: profile at %d, %d bytes, expected at %d, %d bytes", tt.depth, start, cs.ProfileSize, len(data)-len(icc), len(icc))
+		}
+		img, err := d.Image()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameImage(img, tt.img) {
+			t.Errorf("%d bpp: decoded image differs", tt.depth)
+		}
+	}
+
+	// the location of the profile of a streamed RLE image is only known
+	// once the rows are written
+	e := NewEncoder(ioutil.Discard)
+	e.SetDepth(8)
+	e.SetCompression(CompressionRLE8)
+	e.SetProfile(icc)
+	if err := e.WriteHeader(1, 1, testPaletted(1, 1, 2).Palette); err == nil {
+		t.Error("streaming an RLE image with a profile to a writer succeeded")
+	}
+}
+
+func TestEncoderSubImage(t *testing.T) {
+	src := testImage(7, 5)
+	rgba := image.NewRGBA(src.Bounds())
+	rgb := NewRGB24(src.Bounds())
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 7; x++ {
+			rgba.Set(x, y, src.At(x, y))
+			rgb.Set(x, y, src.At(x, y))
+		}
+	}
+
+	r := image.Rect(2, 1, 6, 4)
+	subs := []image.Image{
+		src.SubImage(r),
+		rgba.SubImage(r),
+		rgb.SubImage(r),
+		ToBGRA32(src).SubImage(r),
+	}
+
+	for _, sub := range subs {
+		for _, depth := range []int{24, 32} {
+			var buf bytes.Buffer
+			e := NewEncoder(&buf)
+			e.SetDepth(depth)
+			if err := e.Encode(sub); err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds() != image.Rect(0, 0, 4, 3) {
+				t.Errorf("%T at %d bpp: bounds = %v", sub, depth, img.Bounds())
+			}
+			if !sameImage(img, sub) {
+				t.Errorf("%T at %d bpp: decoded image differs from the sub image", sub, depth)
+			}
+		}
+	}
+}
+
+func TestEncoderQuantize(t *testing.T) {
+	// fewer distinct colors than the palette size are kept exactly
+	m := testImage(6, 3)
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(8)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	colors := int(binary.LittleEndian.Uint32(b[46:50]))
+	if colors == 0 || colors > 18 {
+		t.Fatalf("biClrUsed = %d, expected at most 18", colors)
+	}
+
+	offset := int(binary.LittleEndian.Uint32(b[10:14]))
+	for y := 0; y < 3; y++ {
+		row := b[offset+(2-y)*8:]
+		for x := 0; x < 6; x++ {
+			entry := b[54+int(row[x])*4:]
+			got := color.NRGBA{entry[2], entry[1], entry[0], 0xff}
+			if expected := m.NRGBAAt(x, y); got != expected {
+				t.Errorf("pixel (%d, %d) = %v, expected %v", x, y, got, expected)
+			}
+		}
+	}
+}
+
+func TestEncoderPremultiplied(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		// a translucent edge of a white shape
+		m.SetRGBA(x, 0, color.RGBA{uint8(x), uint8(x / 2), 0, uint8(x)})
+	}
+
+	for _, premultiplied := range []bool{false, true} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(32)
+		e.SetPremultiplied(premultiplied)
+		if err := e.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+
+		pix := buf.Bytes()[54:]
+		for x := 0; x < 256; x++ {
+			var expected color.NRGBA
+			if premultiplied {
+				c := m.RGBAAt(x, 0)
+				expected = color.NRGBA{c.R, c.G, c.B, c.A}
+			} else {
+				expected = color.NRGBAModel.Convert(m.At(x, 0)).(color.NRGBA)
+			}
+
+			// BGRA order
+			got := color.NRGBA{pix[x*4+2], pix[x*4+1], pix[x*4], pix[x*4+3]}
+			if got != expected {
+				t.Errorf("premultiplied %v: pixel %d = %v, expected %v", premultiplied, x, got, expected)
+			}
+		}
+	}
+}
+
+func TestEncoderAutoDepth(t *testing.T) {
+	twoColors := image.NewNRGBA(image.Rect(0, 0, 9, 2))
+	for i := range twoColors.Pix {
+		twoColors.Pix[i] = 0xff
+	}
+	twoColors.SetNRGBA(3, 1, color.NRGBA{0x10, 0x20, 0x30, 0xff})
+
+	translucent := testImage(3, 3)
+	translucent.SetNRGBA(1, 1, color.NRGBA{1, 2, 3, 4})
+
+	tests := []struct {
+		name  string
+		img   image.Image
+		depth int
+	}{
+		{"two colors", twoColors, 1},
+		{"few palette entries used", testPaletted(5, 2, 200), 4},
+		{"200 colors", testPaletted(30, 20, 200), 8},
+		{"true color", testImage(20, 20), 24},
+		{"translucent", translucent, 32},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(DepthAuto)
+		if err := e.Encode(tt.img); err != nil {
+			t.Fatal(err)
+		}
+
+		if depth := int(binary.LittleEndian.Uint16(buf.Bytes()[28:30])); depth != tt.depth {
+			t.Errorf("%s: depth = %d, expected %d", tt.name, depth, tt.depth)
+			continue
+		}
+		if tt.depth <= 8 && tt.depth > 1 {
+			continue
+		}
+
+		img, err := Decode(&buf, WithBitmap(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameImage(img, tt.img) {
+			t.Errorf("%s: decoded image differs from the source", tt.name)
+		}
+	}
+
+	e := NewEncoder(ioutil.Discard)
+	e.SetDepth(DepthAuto)
+	if err := e.WriteHeader(1, 1, nil); err == nil {
+		t.Error("expected an error for a streamed image with automatic depth")
+	}
+}
+
+func TestEncoderDedupPalette(t *testing.T) {
+	red, green, blue := color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0xff, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff}
+	p := color.Palette{red, blue, red, green, blue}
+	m := image.NewPaletted(image.Rect(0, 0, 5, 2), p)
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i % len(p))
+	}
+
+	for _, c := range []Compression{CompressionRGB, CompressionRLE8} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(8)
+		e.SetCompression(c)
+		e.SetDedupPalette(true)
+		if err := e.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+
+		d := NewDecoder(bytes.NewReader(buf.Bytes()))
+		img, err := d.Image()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pal := img.ColorModel().(color.Palette); len(pal) != 3 {
+			t.Errorf("compression %d: %d colors, want 3", c, len(pal))
+		}
+		if !sameImage(img, m) {
+			t.Errorf("compression %d: decoded image differs", c)
+		}
+	}
+
+	// streamed rows are remapped without being modified
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(4)
+	e.SetDedupPalette(true)
+	if err := e.WriteHeader(5, 1, p); err != nil {
+		t.Fatal(err)
+	}
+	row := []byte{0, 1, 2, 3, 4}
+	if err := e.WriteRow(row); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(row, []byte{0, 1, 2, 3, 4}) {
+		t.Errorf("row = %v, modified by WriteRow", row)
+	}
+	img, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := img.(*image.Paletted)
+	if want := []uint8{0, 1, 0, 2, 1}; !bytes.Equal(pm.Pix, want) {
+		t.Errorf("indices = %v, want %v", pm.Pix, want)
+	}
+}
+
+func TestEncoderTransparentIndex(t *testing.T) {
+	red, blue := color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff}
+	m := image.NewPaletted(image.Rect(0, 0, 3, 1), color.Palette{red, blue, red})
+	m.Pix = []uint8{0, 1, 2}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(8)
+	e.SetDedupPalette(true)
+	e.SetTransparentIndex(2)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(bytes.NewReader(buf.Bytes()), WithPaletteAlpha(true))
+	img, err := d.Image()
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := d.Metadata()
+	if meta.TransparentIndex != 2 {
+		t.Errorf("TransparentIndex = %d, want 2", meta.TransparentIndex)
+	}
+	// the transparent red is not merged with the opaque one
+	want := []color.NRGBA{{0xff, 0, 0, 0xff}, {0, 0, 0xff, 0xff}, {0xff, 0, 0, 0}}
+	for x, c := range want {
+		if got := color.NRGBAModel.Convert(img.At(x, 0)); got != c {
+			t.Errorf("pixel %d = %v, want %v", x, got, c)
+		}
+	}
+
+	buf.Reset()
+	e = NewEncoder(&buf)
+	e.SetDepth(1)
+	if err := e.Encode(testPaletted(3, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err := NewDecoder(bytes.NewReader(buf.Bytes())).Metadata(); err != nil {
+		t.Fatal(err)
+	} else if meta.TransparentIndex != -1 {
+		t.Errorf("TransparentIndex = %d without transparent entry, want -1", meta.TransparentIndex)
+	}
+
+	for _, tc := range []struct {
+		depth  int
+		header Header
+		index  int
+	}{
+		{8, HeaderInfo, 3},
+		{24, HeaderInfo, 0},
+		{8, HeaderCore, 0},
+	} {
+		e := NewEncoder(ioutil.Discard)
+		e.SetDepth(tc.depth)
+		e.SetHeader(tc.header)
+		e.SetTransparentIndex(tc.index)
+		if err := e.Encode(m); err == nil {
+			t.Errorf("%+v: no error", tc)
+		}
+	}
+}
+
+func TestEncoderTransparentQuantized(t *testing.T) {
+	// 255 opaque colors and a transparent pixel fill a 256-color palette
+	m := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < 255; i++ {
+		m.SetNRGBA(i%16, i/16, color.NRGBA{uint8(i), uint8(i * 7), uint8(255 - i), 0xff})
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(8)
+	e.SetTransparentIndex(3)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Decode(bytes.NewReader(buf.Bytes()), WithPaletteAlpha(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if got, want := color.NRGBAModel.Convert(img.At(x, y)), m.NRGBAAt(x, y); got != want {
+				t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestEncoderPreset(t *testing.T) {
+	flat := image.NewGray(image.Rect(0, 0, 64, 4))
+	for i := range flat.Pix {
+		flat.Pix[i] = uint8(i / 86 * 0x40)
+	}
+	noisy := testPaletted(64, 4, 200)
+	for i := range noisy.Pix {
+		noisy.Pix[i] = uint8(i * 7 % 200)
+	}
+
+	for _, tc := range []struct {
+		preset      Preset
+		m           image.Image
+		depth       int
+		compression Compression
+	}{
+		{PresetFast, flat, 24, CompressionRGB},
+		{PresetFast, testImage(3, 2), 32, CompressionRGB},
+		{PresetFast, noisy, 24, CompressionRGB},
+		{PresetSmallest, flat, 4, CompressionRLE4},
+		{PresetSmallest, noisy, 8, CompressionRGB},
+		{PresetSmallest, testImage(3, 2), 4, CompressionRGB},
+		{PresetDefault, flat, 24, CompressionRGB},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetPreset(tc.preset)
+		if err := e.Encode(tc.m); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		depth, c := int(binary.LittleEndian.Uint16(b[28:30])), Compression(binary.LittleEndian.Uint32(b[30:34]))
+		if depth != tc.depth || c != tc.compression {
+			t.Errorf("preset %d, %T: %d bpp, compression %d, want %d bpp, compression %d", tc.preset, tc.m, depth, c, tc.depth, tc.compression)
+		}
+
+		m, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameImage(m, tc.m) {
+			t.Errorf("preset %d, %T: decoded image differs", tc.preset, tc.m)
+		}
+
+		// the settings are kept for the next image
+		buf.Reset()
+		if err := e.Encode(tc.m); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), b) {
+			t.Errorf("preset %d, %T: second encoding differs", tc.preset, tc.m)
+		}
+	}
+
+	// later settings take precedence
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetPreset(PresetSmallest)
+	e.SetCompression(CompressionRGB)
+	if err := e.Encode(flat); err != nil {
+		t.Fatal(err)
+	}
+	if c := Compression(binary.LittleEndian.Uint32(buf.Bytes()[30:34])); c != CompressionRGB {
+		t.Errorf("compression %d after SetCompression, want %d", c, CompressionRGB)
+	}
+}
+
+func TestEncoderConcurrentRLE(t *testing.T) {
+	m := testPaletted(300, 97, 200)
+	for i := range m.Pix {
+		if i%5 != 0 {
+			m.Pix[i] = m.Pix[i-i%5]
+		}
+	}
+
+	for _, c := range []Compression{CompressionRLE8, CompressionRLE4} {
+		// the 4 bpp image is quantized, its colors found concurrently
+		depth, m := 8, image.Image(m)
+		if c == CompressionRLE4 {
+			depth, m = 4, testImage(300, 97)
+		}
+
+		var want bytes.Buffer
+		e := NewEncoder(&want)
+		e.SetDepth(depth)
+		e.SetCompression(c)
+		if err := e.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, workers := range []int{0, 2, 7, 200} {
+			var buf bytes.Buffer
+			e := NewEncoder(&buf)
+			e.SetDepth(depth)
+			e.SetCompression(c)
+			e.SetConcurrency(workers)
+			if err := e.Encode(m); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want.Bytes()) {
+				t.Errorf("%d bpp, %d workers: output differs from the sequential one", depth, workers)
+			}
+		}
+	}
+}
@@ -0,0 +1,4 @@
+// Package gdi moves images to and from Windows GDI bitmaps, such as the
+// ones of screen captures and window contents, without cgo. It only has
+// functions on Windows.
+package gdi
@@ -0,0 +1,169 @@
+//go:build windows
+// +build windows
+
+package gdi
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"syscall"
+	"unsafe"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+var (
+	gdi32  = syscall.NewLazyDLL("gdi32.dll")
+	user32 = syscall.NewLazyDLL("user32.dll")
+
+	procCreateDIBSection = gdi32.NewProc("CreateDIBSection")
+	procDeleteObject     = gdi32.NewProc("DeleteObject")
+	procGetDIBits        = gdi32.NewProc("GetDIBits")
+	procGetObject        = gdi32.NewProc("GetObjectW")
+	procGetDC            = user32.NewProc("GetDC")
+	procReleaseDC        = user32.NewProc("ReleaseDC")
+)
+
+// dibRGBColors is the DIB_RGB_COLORS usage of the color table.
+const dibRGBColors = 0
+
+// bitmapInfoHeader is the BITMAPINFOHEADER structure. Without compression,
+// 32 bpp bitmaps have no color table, so it is a whole BITMAPINFO.
+type bitmapInfoHeader struct {
+	Size          uint32
+	Width         int32
+	Height        int32
+	Planes        uint16
+	BitCount      uint16
+	Compression   uint32
+	SizeImage     uint32
+	XPelsPerMeter int32
+	YPelsPerMeter int32
+	ClrUsed       uint32
+	ClrImportant  uint32
+}
+
+// bitmap is the BITMAP structure filled by GetObject.
+type bitmap struct {
+	Type       int32
+	Width      int32
+	Height     int32
+	WidthBytes int32
+	Planes     uint16
+	BitsPixel  uint16
+	Bits       uintptr
+}
+
+// header32 returns the header of a top-down 32 bpp bitmap of w×h pixels,
+// whose rows are w*4 bytes long with no padding.
+func header32(w, h int) bitmapInfoHeader {
+	return bitmapInfoHeader{
+		Size:     uint32(unsafe.Sizeof(bitmapInfoHeader{})),
+		Width:    int32(w),
+		Height:   -int32(h),
+		Planes:   1,
+		BitCount: 32,
+	}
+}
+
+// swapRB swaps the first and third byte of the 4-byte pixels of pix,
+// between RGBA and the BGRA order of GDI.
+func swapRB(pix []byte) {
+	for i := 0; i+3 < len(pix); i += 4 {
+		pix[i], pix[i+2] = pix[i+2], pix[i]
+	}
+}
+
+// CreateDIBSection returns a new 32 bpp DIB section holding the pixels of
+// m, with premultiplied alpha as AlphaBlend reads it. The bitmap must be
+// released with DeleteObject.
+func CreateDIBSection(m image.Image) (syscall.Handle, error) {
+	b := m.Bounds()
+	if b.Empty() {
+		return 0, fmt.Errorf("gdi: image is empty")
+	}
+
+	hdr := header32(b.Dx(), b.Dy())
+	var bits unsafe.Pointer
+	h, _, err := procCreateDIBSection.Call(0, uintptr(unsafe.Pointer(&hdr)), dibRGBColors, uintptr(unsafe.Pointer(&bits)), 0, 0)
+	if h == 0 {
+		return 0, fmt.Errorf("gdi: CreateDIBSection: %v", err)
+	}
+
+	// draw straight into the memory of the section
+	n := b.Dx() * b.Dy() * 4
+	dst := &image.RGBA{
+		Pix:    (*[1 << 30]byte)(bits)[:n:n],
+		Stride: b.Dx() * 4,
+		Rect:   image.Rect(0, 0, b.Dx(), b.Dy()),
+	}
+	draw.Draw(dst, dst.Rect, m, b.Min, draw.Src)
+	swapRB(dst.Pix)
+
+	return syscall.Handle(h), nil
+}
+
+// DeleteObject releases the bitmap h.
+func DeleteObject(h syscall.Handle) error {
+	if r, _, err := procDeleteObject.Call(uintptr(h)); r == 0 {
+		return fmt.Errorf("gdi: DeleteObject: %v", err)
+	}
+
+	return nil
+}
+
+// Image returns a copy of the pixels of the bitmap h, of any depth, read
+// with GetDIBits as 32 bpp. The mode is the meaning of the fourth byte,
+// which GDI drawing functions other than AlphaBlend leave zero: use
+// bmp.AlphaIgnore for screen captures. The image is an *image.NRGBA if the
+// byte is straight alpha, as with bmp.AlphaStraight and with bmp.AlphaAuto
+// unless the byte is zero for every pixel, an *image.RGBA otherwise.
+func Image(h syscall.Handle, mode bmp.AlphaMode) (image.Image, error) {
+	var bm bitmap
+	if r, _, err := procGetObject.Call(uintptr(h), unsafe.Sizeof(bm), uintptr(unsafe.Pointer(&bm))); r == 0 {
+		return nil, fmt.Errorf("gdi: GetObject: %v", err)
+	}
+	w, ht := int(bm.Width), int(bm.Height)
+	if ht < 0 {
+		ht = -ht
+	}
+	if w == 0 || ht == 0 {
+		return nil, fmt.Errorf("gdi: bitmap is empty")
+	}
+
+	dc, _, err := procGetDC.Call(0)
+	if dc == 0 {
+		return nil, fmt.Errorf("gdi: GetDC: %v", err)
+	}
+	defer procReleaseDC.Call(0, dc)
+
+	hdr := header32(w, ht)
+	rect := image.Rect(0, 0, w, ht)
+	pix := make([]byte, w*ht*4)
+	if r, _, err := procGetDIBits.Call(dc, uintptr(h), 0, uintptr(ht), uintptr(unsafe.Pointer(&pix[0])), uintptr(unsafe.Pointer(&hdr)), dibRGBColors); r == 0 {
+		return nil, fmt.Errorf("gdi: GetDIBits: %v", err)
+	}
+	swapRB(pix)
+
+	if mode == bmp.AlphaAuto {
+		mode = bmp.AlphaIgnore
+		for i := 3; i < len(pix); i += 4 {
+			if pix[i] != 0 {
+				mode = bmp.AlphaStraight
+				break
+			}
+		}
+	}
+
+	switch mode {
+	case bmp.AlphaStraight:
+		return &image.NRGBA{Pix: pix, Stride: w * 4, Rect: rect}, nil
+	case bmp.AlphaIgnore:
+		for i := 3; i < len(pix); i += 4 {
+			pix[i] = 0xff
+		}
+	}
+
+	return &image.RGBA{Pix: pix, Stride: w * 4, Rect: rect}, nil
+}
@@ -0,0 +1,44 @@
+//go:build windows
+// +build windows
+
+package gdi
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+func TestDIBSection(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	src.SetNRGBA(0, 0, color.NRGBA{0x10, 0x20, 0x30, 0xff})
+	src.SetNRGBA(2, 1, color.NRGBA{0xff, 0, 0, 0x80})
+
+	h, err := CreateDIBSection(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteObject(h)
+
+	m, err := Image(h, bmp.AlphaPremultiplied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds() != src.Bounds() {
+		t.Fatalf("bounds %v, expected %v", m.Bounds(), src.Bounds())
+	}
+	for _, p := range []image.Point{{0, 0}, {1, 0}, {2, 1}} {
+		if got, want := color.RGBAModel.Convert(m.At(p.X, p.Y)), color.RGBAModel.Convert(src.At(p.X, p.Y)); got != want {
+			t.Errorf("pixel %v = %v, expected %v", p, got, want)
+		}
+	}
+
+	if m, err = Image(h, bmp.AlphaIgnore); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := m.At(1, 0).RGBA(); a != 0xffff {
+		t.Errorf("alpha %#x with AlphaIgnore, expected opaque", a)
+	}
+}
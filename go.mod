@@ -1,3 +1,5 @@
 module github.com/entooone/go-bmp
 
 go 1.13
+
+require golang.org/x/image v0.5.0
@@ -0,0 +1,100 @@
+package bmp
+
+import (
+	"image"
+	"io"
+	"math/bits"
+)
+
+// grayMask returns the mask of the single channel of 16 bpp bit fields
+// whose non-zero color masks are all equal, such as the dumps of depth and
+// infrared cameras, and false for other pixels.
+func grayMask(key UnpackerKey) (uint16, bool) {
+	if key.BitsPerPixel != 16 || key.Compression != CompressionBitfields || key.AlphaMask != 0 {
+		return 0, false
+	}
+
+	var mask uint32
+	for _, m := range []uint32{key.RedMask, key.GreenMask, key.BlueMask} {
+		switch {
+		case m == 0:
+		case mask == 0:
+			mask = m
+		case m != mask:
+			return 0, false
+		}
+	}
+
+	// a single run of bits of the pixel
+	if mask == 0 || mask > 0xffff || bits.OnesCount32(mask>>uint(bits.TrailingZeros32(mask))+1) != 1 {
+		return 0, false
+	}
+
+	return uint16(mask), true
+}
+
+// scaleSample scales the n-bit sample v to 16 bits, so that the maximum
+// maps to 0xffff.
+func scaleSample(v uint32, n uint) uint16 {
+	max := uint32(1)<<n - 1
+	return uint16((v*0xffff + max/2) / max)
+}
+
+// grayUnpacker unpacks 16 bpp pixels of a single channel to gray RGBA
+// samples, of 8 bits like the rows of the other true color pixels.
+type grayUnpacker uint16
+
+func (u grayUnpacker) Unpack(p, src []byte) {
+	mask := uint32(u)
+	shift, n := uint(bits.TrailingZeros32(mask)), uint(bits.OnesCount32(mask))
+	for i, j := 0, 0; i < len(p); i, j = i+4, j+2 {
+		v := uint32(src[j]) | uint32(src[j+1])<<8
+		y := uint8(scaleSample(v&mask>>shift, n) >> 8)
+		p[i], p[i+1], p[i+2], p[i+3] = y, y, y, 0xff
+	}
+}
+
+// decodeGray16 decodes the pixel data of 16 bpp single channel images into
+// an image.Gray16, keeping the precision of the channel.
+func (d *Decoder) decodeGray16() error {
+	stride, err := d.stride(d.width*2, 1)
+	if err != nil {
+		return err
+	}
+
+	m := &image.Gray16{
+		Pix:    make([]uint8, stride*d.height),
+		Stride: stride,
+		Rect:   image.Rect(0, 0, d.width, d.height),
+	}
+	mask := uint32(d.gray)
+	shift, n := uint(bits.TrailingZeros32(mask)), uint(bits.OnesCount32(mask))
+	for row := 0; row < d.height; row++ {
+		if _, err := io.ReadFull(d.r, d.row); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		p := m.Pix[d.rowY(row)*stride:]
+		for x := 0; x < d.width; x++ {
+			v := uint32(d.row[2*x]) | uint32(d.row[2*x+1])<<8
+			y := scaleSample(v&mask>>shift, n)
+			dx := x
+			if d.mirrored {
+				dx = d.width - 1 - x
+			}
+			// big-endian, as image.Gray16 stores them
+			p[2*dx], p[2*dx+1] = uint8(y>>8), uint8(y)
+		}
+		d.rowDone(row + 1)
+	}
+
+	d.image = m
+	if d.opts.model != nil {
+		d.image, err = convert(d.image, d.opts.model)
+	}
+
+	return err
+}
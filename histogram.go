@@ -0,0 +1,80 @@
+package bmp
+
+import (
+	"image/color"
+	"io"
+)
+
+// Histogram holds pixel counts of an image.
+type Histogram struct {
+	// Pixels is the number of pixels counted.
+	Pixels uint64
+	// R, G, B and A count the pixels per non-premultiplied 8-bit sample.
+	R, G, B, A [256]uint64
+	// Index counts the pixels per palette index, for paletted images only.
+	Index [256]uint64
+	// Paletted reports whether Index was filled.
+	Paletted bool
+}
+
+// Add adds the counts of o to h, to gather statistics over several images.
+func (h *Histogram) Add(o *Histogram) {
+	h.Pixels += o.Pixels
+	for i := 0; i < 256; i++ {
+		h.R[i] += o.R[i]
+		h.G[i] += o.G[i]
+		h.B[i] += o.B[i]
+		h.A[i] += o.A[i]
+		h.Index[i] += o.Index[i]
+	}
+	h.Paletted = h.Paletted || o.Paletted
+}
+
+// DecodeHistogram reads a BMP image from r and returns its histogram. The
+// rows are counted as they are read, so memory usage does not depend on
+// the image height.
+func DecodeHistogram(r io.Reader, opts ...Option) (*Histogram, error) {
+	d := NewDecoder(r, opts...)
+	d.path = "histogram"
+	rr := d.Rows()
+
+	h := &Histogram{Paletted: d.palette != nil}
+	for rr.Next() {
+		row := rr.Row()
+		if h.Paletted {
+			for _, idx := range row {
+				h.Index[idx]++
+			}
+		} else {
+			for i := 0; i < len(row); i += 4 {
+				h.R[row[i]]++
+				h.G[row[i+1]]++
+				h.B[row[i+2]]++
+				h.A[row[i+3]]++
+			}
+		}
+		h.Pixels += uint64(d.width)
+	}
+	if err := rr.Err(); err != nil {
+		return nil, err
+	}
+
+	if h.Paletted {
+		// count the channels once per palette entry
+		for idx, n := range h.Index {
+			if n == 0 {
+				continue
+			}
+			var c color.NRGBA
+			if idx < len(d.palette) {
+				c = color.NRGBAModel.Convert(d.palette[idx]).(color.NRGBA)
+			}
+			h.R[c.R] += n
+			h.G[c.G] += n
+			h.B[c.B] += n
+			h.A[c.A] += n
+		}
+	}
+
+	return h, nil
+}
@@ -0,0 +1,62 @@
+package bmp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDecodeHistogram(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		m.SetNRGBA(i%3, i/3, color.NRGBA{uint8(i % 2), 10, 20, 0x80})
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(32)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := DecodeHistogram(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Paletted || h.Pixels != 6 || h.R[0] != 3 || h.R[1] != 3 || h.G[10] != 6 || h.B[20] != 6 || h.A[0x80] != 6 {
+		t.Errorf("unexpected histogram: %+v", h)
+	}
+}
+
+func TestDecodeHistogramPaletted(t *testing.T) {
+	m := testPaletted(4, 2, 16)
+	m.Pix = []uint8{0, 1, 1, 2, 2, 2, 2, 15}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(4)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := DecodeHistogram(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Paletted || h.Pixels != 8 || h.Index[0] != 1 || h.Index[1] != 2 || h.Index[2] != 4 || h.Index[15] != 1 {
+		t.Errorf("unexpected index counts: %v", h.Index[:16])
+	}
+
+	c := color.NRGBAModel.Convert(m.Palette[2]).(color.NRGBA)
+	if h.R[c.R] < 4 || h.A[0xff] != 8 {
+		t.Errorf("unexpected channel counts")
+	}
+
+	var total Histogram
+	total.Add(h)
+	total.Add(h)
+	if total.Pixels != 16 || total.Index[2] != 8 || !total.Paletted {
+		t.Errorf("unexpected sum: %d pixels, %d", total.Pixels, total.Index[2])
+	}
+}
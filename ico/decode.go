@@ -0,0 +1,146 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+// Entry is an image of an icon or cursor file.
+type Entry struct {
+	Image image.Image
+	// HotX and HotY are the hotspot of cursor images, zero for icons.
+	HotX, HotY int
+}
+
+// Decode reads an icon or cursor file from r and returns its images, in the
+// order of the directory. BMP images are returned with the transparency of
+// their AND mask, or of their alpha channel for 32 bpp images using it.
+// PNG images are decoded as they are.
+func Decode(r io.Reader) ([]Entry, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < dirLen {
+		return nil, io.ErrUnexpectedEOF
+	}
+	typ := binary.LittleEndian.Uint16(b[2:4])
+	if binary.LittleEndian.Uint16(b[0:2]) != 0 || typ != 1 && typ != 2 {
+		return nil, fmt.Errorf("ico: invalid header")
+	}
+
+	n := int(binary.LittleEndian.Uint16(b[4:6]))
+	if len(b) < dirLen+entryLen*n {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	entries := make([]Entry, n)
+	for i := range entries {
+		e := b[dirLen+entryLen*i:]
+		size := binary.LittleEndian.Uint32(e[8:12])
+		offset := binary.LittleEndian.Uint32(e[12:16])
+		if uint64(offset)+uint64(size) > uint64(len(b)) {
+			return nil, fmt.Errorf("ico: image %d out of the file (offset: %d, size: %d)", i, offset, size)
+		}
+
+		m, err := decodeEntry(b[offset : offset+size])
+		if err != nil {
+			return nil, fmt.Errorf("ico: image %d: %v", i, err)
+		}
+		entries[i].Image = m
+		if typ == 2 {
+			// the planes and bit count fields hold the hotspot of cursors
+			entries[i].HotX = int(binary.LittleEndian.Uint16(e[4:6]))
+			entries[i].HotY = int(binary.LittleEndian.Uint16(e[6:8]))
+		}
+	}
+
+	return entries, nil
+}
+
+// decodeEntry decodes the PNG image or the DIB with an AND mask in b.
+func decodeEntry(b []byte) (image.Image, error) {
+	if bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")) {
+		return png.Decode(bytes.NewReader(b))
+	}
+
+	if len(b) < 40 || binary.LittleEndian.Uint32(b) < 40 {
+		return nil, fmt.Errorf("unsupported DIB header")
+	}
+
+	// the height covers the color bitmap and the mask
+	dib := append([]byte(nil), b...)
+	height := int32(binary.LittleEndian.Uint32(dib[8:12])) / 2
+	binary.LittleEndian.PutUint32(dib[8:12], uint32(height))
+
+	d := bmp.NewDIBDecoder(bytes.NewReader(dib))
+	meta, err := d.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	m, err := d.Image()
+	if err != nil {
+		return nil, err
+	}
+
+	r := m.Bounds()
+	dst := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			dst.Set(x, y, m.At(x, y))
+		}
+	}
+
+	if meta.BitsPerPixel == 32 && !transparent(dst) {
+		// the alpha channel is used rather than the mask
+		return dst, nil
+	}
+
+	colors := meta.ColorsUsed
+	if colors == 0 && meta.BitsPerPixel <= 8 {
+		colors = 1 << uint(meta.BitsPerPixel)
+	}
+	offset := meta.HeaderSize + colors*4 + (r.Dx()*meta.BitsPerPixel+31)/32*4*r.Dy()
+	if meta.Compression == bmp.CompressionBitfields && meta.HeaderSize == 40 {
+		offset += 12
+	}
+
+	stride := (r.Dx() + 31) / 32 * 4
+	if offset > len(b) || len(b)-offset < stride*r.Dy() {
+		return nil, fmt.Errorf("AND mask is truncated")
+	}
+	mask := b[offset:]
+	for y := 0; y < r.Dy(); y++ {
+		row := mask[(r.Dy()-1-y)*stride:]
+		for x := 0; x < r.Dx(); x++ {
+			c := dst.NRGBAAt(x, y)
+			c.A = 0xff
+			if row[x>>3]&(0x80>>uint(x&7)) != 0 {
+				c = color.NRGBA{}
+			}
+			dst.SetNRGBA(x, y, c)
+		}
+	}
+
+	return dst, nil
+}
+
+// transparent reports whether all the pixels of m are fully transparent.
+func transparent(m *image.NRGBA) bool {
+	for i := 3; i < len(m.Pix); i += 4 {
+		if m.Pix[i] != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,172 @@
+// Package ico reads and writes Windows icon and cursor files.
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+
+	bmp "github.com/entooone/go-bmp"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	fileHeaderLen = 14
+	dirLen        = 6
+	entryLen      = 16
+)
+
+// Encode writes the images to w as an icon file, one entry per image, in
+// the given order. Each image is stored as a 32 bpp BMP with its alpha
+// channel and an AND mask hiding the fully transparent pixels, which every
+// version of Windows reads. Images must be at most 256×256 pixels.
+func Encode(w io.Writer, images ...image.Image) error {
+	return encode(w, images, false)
+}
+
+// BuildIcon scales img to each of sizes, from 1 to 256 pixels, with
+// bmp.Scale and returns the icon file of the scaled images, such as a
+// favicon.ico. Images which are not square are fitted in the squares,
+// centered over transparent pixels. The 256×256 image is stored as PNG, as
+// read for large icons since Windows Vista, the others like Encode does.
+func BuildIcon(img image.Image, sizes []int) ([]byte, error) {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil, fmt.Errorf("ico: image is empty")
+	}
+
+	images := make([]image.Image, len(sizes))
+	for i, n := range sizes {
+		if n < 1 || n > 256 {
+			return nil, fmt.Errorf("ico: image size out of range (got: %d)", n)
+		}
+
+		w, h := n, n
+		if b.Dx() > b.Dy() {
+			h = max1(n * b.Dy() / b.Dx())
+		} else {
+			w = max1(n * b.Dx() / b.Dy())
+		}
+		m, err := bmp.Scale(img, w, h, xdraw.CatmullRom)
+		if err != nil {
+			return nil, err
+		}
+
+		dst := image.NewRGBA(image.Rect(0, 0, n, n))
+		draw.Draw(dst, m.Bounds().Add(image.Pt((n-w)/2, (n-h)/2)), m, image.Point{}, draw.Src)
+		images[i] = dst
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, images, true); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+
+	return n
+}
+
+// encode writes the images as an icon file, storing the 256×256 ones as PNG
+// if largePNG is set.
+func encode(w io.Writer, images []image.Image, largePNG bool) error {
+	if len(images) == 0 || len(images) > 0xffff {
+		return fmt.Errorf("ico: number of images out of range (got: %d)", len(images))
+	}
+
+	entries := make([][]byte, len(images))
+	for i, m := range images {
+		encodeFn := encodeEntry
+		if largePNG && m.Bounds().Size() == image.Pt(256, 256) {
+			encodeFn = encodePNG
+		}
+		b, err := encodeFn(m)
+		if err != nil {
+			return err
+		}
+		entries[i] = b
+	}
+
+	head := make([]byte, dirLen+entryLen*len(images))
+	binary.LittleEndian.PutUint16(head[2:4], 1)
+	binary.LittleEndian.PutUint16(head[4:6], uint16(len(images)))
+
+	offset := len(head)
+	for i, m := range images {
+		e := head[dirLen+entryLen*i : dirLen+entryLen*(i+1)]
+		size := m.Bounds().Size()
+		// 256 is stored as 0
+		e[0], e[1] = uint8(size.X), uint8(size.Y)
+		binary.LittleEndian.PutUint16(e[4:6], 1)
+		binary.LittleEndian.PutUint16(e[6:8], 32)
+		binary.LittleEndian.PutUint32(e[8:12], uint32(len(entries[i])))
+		binary.LittleEndian.PutUint32(e[12:16], uint32(offset))
+		offset += len(entries[i])
+	}
+
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	for _, b := range entries {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodePNG returns m encoded as PNG, with its alpha channel.
+func encodePNG(m image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeEntry returns the DIB of m as stored in icons: the header has twice
+// the height of the image, covering the color bitmap followed by the mask.
+func encodeEntry(m image.Image) ([]byte, error) {
+	r := m.Bounds()
+	if r.Dx() < 1 || r.Dy() < 1 || r.Dx() > 256 || r.Dy() > 256 {
+		return nil, fmt.Errorf("ico: image size out of range (got: %dx%d)", r.Dx(), r.Dy())
+	}
+
+	var buf bytes.Buffer
+	e := bmp.NewEncoder(&buf)
+	e.SetDepth(32)
+	if err := e.Encode(m); err != nil {
+		return nil, err
+	}
+
+	// rows of the mask are padded to 4 bytes
+	maskStride := (r.Dx() + 31) / 32 * 4
+	mask := make([]byte, maskStride*r.Dy())
+	for y := 0; y < r.Dy(); y++ {
+		// bottom-up like the color bitmap
+		row := mask[(r.Dy()-1-y)*maskStride:]
+		for x := 0; x < r.Dx(); x++ {
+			if _, _, _, a := m.At(r.Min.X+x, r.Min.Y+y).RGBA(); a == 0 {
+				row[x>>3] |= 0x80 >> uint(x&7)
+			}
+		}
+	}
+
+	b := append(buf.Bytes()[fileHeaderLen:], mask...)
+	binary.LittleEndian.PutUint32(b[8:12], uint32(2*r.Dy()))
+	binary.LittleEndian.PutUint32(b[20:24], uint32(len(b)-int(binary.LittleEndian.Uint32(b[0:4]))))
+
+	return b, nil
+}
@@ -0,0 +1,169 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+func TestEncode(t *testing.T) {
+	small := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	small.SetNRGBA(3, 0, color.NRGBA{0x10, 0x20, 0x30, 0xff})
+	large := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, small, large); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	if ct, _ := bmp.SniffContentType(b); ct != bmp.ContentTypeICO {
+		t.Errorf("content type %q, expected %q", ct, bmp.ContentTypeICO)
+	}
+	if n := binary.LittleEndian.Uint16(b[4:6]); n != 2 {
+		t.Fatalf("%d entries, expected 2", n)
+	}
+
+	tests := []struct {
+		size     uint8
+		height   int32
+		maskLine int
+	}{
+		{16, 32, 4},
+		{0, 512, 32},
+	}
+
+	for i, tt := range tests {
+		e := b[dirLen+entryLen*i:]
+		if e[0] != tt.size || e[1] != tt.size {
+			t.Errorf("entry %d: size %dx%d, expected %d", i, e[0], e[1], tt.size)
+		}
+
+		n := binary.LittleEndian.Uint32(e[8:12])
+		offset := binary.LittleEndian.Uint32(e[12:16])
+		dib := b[offset : offset+n]
+		if h := int32(binary.LittleEndian.Uint32(dib[8:12])); h != tt.height {
+			t.Errorf("entry %d: DIB height %d, expected %d", i, h, tt.height)
+		}
+
+		side := int(tt.height) / 2
+		if expected := 40 + side*side*4 + side*tt.maskLine; int(n) != expected {
+			t.Errorf("entry %d: %d bytes, expected %d", i, n, expected)
+		}
+	}
+
+	// the opaque pixel of the top row is the only one not masked
+	mask := b[binary.LittleEndian.Uint32(b[dirLen+12:])+40+16*16*4:]
+	if top := mask[15*4 : 16*4]; !bytes.Equal(top, []byte{0xef, 0xff, 0, 0}) {
+		t.Errorf("mask of the top row is %x", top)
+	}
+
+	if err := Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 257, 1))); err == nil {
+		t.Errorf("expected an error for a 257 pixel wide image")
+	}
+}
+
+func TestBuildIcon(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+
+	b, err := BuildIcon(src, []int{16, 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, expected 2", len(entries))
+	}
+	for i, n := range []int{16, 256} {
+		m := entries[i].Image
+		if size := m.Bounds().Size(); size != image.Pt(n, n) {
+			t.Errorf("entry %d: size %v, expected %dx%d", i, size, n, n)
+		}
+		// the wide image is centered vertically
+		if _, _, _, a := m.At(n/2, 0).RGBA(); a != 0 {
+			t.Errorf("entry %d: top pixel is not transparent", i)
+		}
+		if _, _, _, a := m.At(n/2, n/2).RGBA(); a != 0xffff {
+			t.Errorf("entry %d: center pixel is not opaque", i)
+		}
+	}
+
+	// the large image is stored as PNG
+	offset := binary.LittleEndian.Uint32(b[dirLen+entryLen+12:])
+	if !bytes.HasPrefix(b[offset:], []byte("\x89PNG")) {
+		t.Errorf("256 pixel entry is not a PNG")
+	}
+
+	if _, err := BuildIcon(src, []int{257}); err == nil {
+		t.Errorf("expected an error for a 257 pixel size")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	m.SetNRGBA(1, 0, color.NRGBA{0x10, 0x20, 0x30, 0xff})
+	m.SetNRGBA(2, 1, color.NRGBA{0x40, 0x50, 0x60, 0x80})
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, m, image.NewNRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Image.Bounds().Dx() != 8 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	got := entries[0].Image
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if a, b := color.NRGBAModel.Convert(got.At(x, y)), m.NRGBAAt(x, y); a != b {
+				t.Errorf("pixel (%d, %d) is %v, expected %v", x, y, a, b)
+			}
+		}
+	}
+
+	// a 24 bpp cursor relying on the mask
+	var dib bytes.Buffer
+	e := bmp.NewEncoder(&dib)
+	if err := e.Encode(image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	img := append(dib.Bytes()[14:], 0x40, 0, 0, 0, 0x80, 0, 0, 0)
+	binary.LittleEndian.PutUint32(img[8:], 4)
+
+	cur := make([]byte, dirLen+entryLen)
+	binary.LittleEndian.PutUint16(cur[2:], 2)
+	binary.LittleEndian.PutUint16(cur[4:], 1)
+	binary.LittleEndian.PutUint16(cur[dirLen+4:], 1)
+	binary.LittleEndian.PutUint16(cur[dirLen+6:], 2)
+	binary.LittleEndian.PutUint32(cur[dirLen+8:], uint32(len(img)))
+	binary.LittleEndian.PutUint32(cur[dirLen+12:], uint32(len(cur)))
+
+	entries, err = Decode(bytes.NewReader(append(cur, img...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].HotX != 1 || entries[0].HotY != 2 {
+		t.Errorf("hotspot (%d, %d), expected (1, 2)", entries[0].HotX, entries[0].HotY)
+	}
+	// the top row is stored last
+	for x, a := range []uint8{0, 0xff, 0xff, 0} {
+		if _, _, _, alpha := entries[0].Image.At(x%2, x/2).RGBA(); uint8(alpha>>8) != a {
+			t.Errorf("pixel (%d, %d) has alpha %d, expected %d", x%2, x/2, alpha>>8, a)
+		}
+	}
+}
@@ -0,0 +1,690 @@
+package bmp
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// RGB is an opaque 24-bit color.
+type RGB struct {
+	R, G, B uint8
+}
+
+// RGBA implements color.Color.
+func (c RGB) RGBA() (r, g, b, a uint32) {
+	r = uint32(c.R)
+	r |= r << 8
+	g = uint32(c.G)
+	g |= g << 8
+	b = uint32(c.B)
+	b |= b << 8
+	return r, g, b, 0xffff
+}
+
+// RGBModel converts colors to RGB, compositing translucent colors over
+// black.
+var RGBModel = color.ModelFunc(rgbModel)
+
+func rgbModel(c color.Color) color.Color {
+	if _, ok := c.(RGB); ok {
+		return c
+	}
+
+	r, g, b, _ := c.RGBA()
+	return RGB{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+}
+
+// RGB24 is an in-memory image of RGB colors, using 3 bytes per pixel
+// instead of the 4 bytes of image.RGBA.
+type RGB24 struct {
+	// Pix holds the image's pixels, in R, G, B order. The pixel at
+	// (x, y) starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*3].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// NewRGB24 returns a new RGB24 image with the given bounds.
+func NewRGB24(r image.Rectangle) *RGB24 {
+	return &RGB24{
+		Pix:    make([]uint8, 3*r.Dx()*r.Dy()),
+		Stride: 3 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// ColorModel implements image.Image.
+func (p *RGB24) ColorModel() color.Model { return RGBModel }
+
+// Bounds implements image.Image.
+func (p *RGB24) Bounds() image.Rectangle { return p.Rect }
+
+// At implements image.Image.
+func (p *RGB24) At(x, y int) color.Color {
+	return p.RGBAt(x, y)
+}
+
+// RGBAt returns the color of the pixel at (x, y).
+func (p *RGB24) RGBAt(x, y int) RGB {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return RGB{}
+	}
+
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+3 : i+3]
+	return RGB{s[0], s[1], s[2]}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds
+// to the pixel at (x, y).
+func (p *RGB24) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+}
+
+// Set implements draw.Image.
+func (p *RGB24) Set(x, y int, c color.Color) {
+	p.SetRGB(x, y, RGBModel.Convert(c).(RGB))
+}
+
+// SetRGB sets the color of the pixel at (x, y).
+func (p *RGB24) SetRGB(x, y int, c RGB) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+3 : i+3]
+	s[0], s[1], s[2] = c.R, c.G, c.B
+}
+
+// SubImage returns an image representing the portion of p visible through
+// r. The returned value shares pixels with the original image.
+func (p *RGB24) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &RGB24{}
+	}
+
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &RGB24{
+		Pix:    p.Pix[i:],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque reports whether the image is fully opaque, which is always true.
+func (p *RGB24) Opaque() bool {
+	return true
+}
+
+// BGRA is a 32-bit alpha-premultiplied color stored in B, G, R, A order.
+type BGRA struct {
+	B, G, R, A uint8
+}
+
+// RGBA implements color.Color.
+func (c BGRA) RGBA() (r, g, b, a uint32) {
+	return color.RGBA{c.R, c.G, c.B, c.A}.RGBA()
+}
+
+// BGRAModel converts colors to BGRA.
+var BGRAModel = color.ModelFunc(bgraModel)
+
+func bgraModel(c color.Color) color.Color {
+	if _, ok := c.(BGRA); ok {
+		return c
+	}
+
+	r, g, b, a := c.RGBA()
+	return BGRA{uint8(b >> 8), uint8(g >> 8), uint8(r >> 8), uint8(a >> 8)}
+}
+
+// BGRA32 is an in-memory image of BGRA colors. Its pixels have the layout
+// of the B8G8R8A8 surfaces of Win32, DirectX and Vulkan.
+type BGRA32 struct {
+	// Pix holds the image's pixels, in B, G, R, A order. The pixel at
+	// (x, y) starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*4].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// NewBGRA32 returns a new BGRA32 image with the given bounds.
+func NewBGRA32(r image.Rectangle) *BGRA32 {
+	return &BGRA32{
+		Pix:    make([]uint8, 4*r.Dx()*r.Dy()),
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// ToBGRA32 returns a copy of m as a BGRA32 image.
+func ToBGRA32(m image.Image) *BGRA32 {
+	b := m.Bounds()
+	dst := NewBGRA32(b)
+
+	if src, ok := m.(*image.RGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			s := src.Pix[src.PixOffset(b.Min.X, y):][:4*b.Dx()]
+			packBGRA(dst.Pix[dst.PixOffset(b.Min.X, y):], s)
+		}
+		return dst
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(x, y, m.At(x, y))
+		}
+	}
+
+	return dst
+}
+
+// ColorModel implements image.Image.
+func (p *BGRA32) ColorModel() color.Model { return BGRAModel }
+
+// Bounds implements image.Image.
+func (p *BGRA32) Bounds() image.Rectangle { return p.Rect }
+
+// At implements image.Image.
+func (p *BGRA32) At(x, y int) color.Color {
+	return p.BGRAAt(x, y)
+}
+
+// BGRAAt returns the color of the pixel at (x, y).
+func (p *BGRA32) BGRAAt(x, y int) BGRA {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return BGRA{}
+	}
+
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4 : i+4]
+	return BGRA{s[0], s[1], s[2], s[3]}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds
+// to the pixel at (x, y).
+func (p *BGRA32) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+}
+
+// Set implements draw.Image.
+func (p *BGRA32) Set(x, y int, c color.Color) {
+	p.SetBGRA(x, y, BGRAModel.Convert(c).(BGRA))
+}
+
+// SetBGRA sets the color of the pixel at (x, y).
+func (p *BGRA32) SetBGRA(x, y int, c BGRA) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4 : i+4]
+	s[0], s[1], s[2], s[3] = c.B, c.G, c.R, c.A
+}
+
+// SubImage returns an image representing the portion of p visible through
+// r. The returned value shares pixels with the original image.
+func (p *BGRA32) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &BGRA32{}
+	}
+
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &BGRA32{
+		Pix:    p.Pix[i:],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *BGRA32) Opaque() bool {
+	if p.Rect.Empty() {
+		return true
+	}
+
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i := p.PixOffset(p.Rect.Min.X, y)
+		for j := i + 3; j < i+4*p.Rect.Dx(); j += 4 {
+			if p.Pix[j] != 0xff {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// RGB565 is an opaque 16-bit color holding 5 bits of red, 6 bits of green
+// and 5 bits of blue, from the most significant bit.
+type RGB565 uint16
+
+// RGBA implements color.Color.
+func (c RGB565) RGBA() (r, g, b, a uint32) {
+	v := uint16(c)
+	r = uint32(expand5(v >> 11))
+	r |= r << 8
+	g = uint32(expand6(v >> 5))
+	g |= g << 8
+	b = uint32(expand5(v))
+	b |= b << 8
+	return r, g, b, 0xffff
+}
+
+// RGB565Model converts colors to RGB565, compositing translucent colors
+// over black.
+var RGB565Model = color.ModelFunc(rgb565Model)
+
+func rgb565Model(c color.Color) color.Color {
+	if _, ok := c.(RGB565); ok {
+		return c
+	}
+
+	r, g, b, _ := c.RGBA()
+	return toRGB565(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+}
+
+func toRGB565(r, g, b uint8) RGB565 {
+	return RGB565(uint16(r>>3)<<11 | uint16(g>>2)<<5 | uint16(b>>3))
+}
+
+// RGB16 is an in-memory image of RGB565 colors, the pixel format of many
+// embedded displays.
+type RGB16 struct {
+	// Pix holds the image's pixels as little-endian RGB565 values. The
+	// pixel at (x, y) starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*2].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// NewRGB16 returns a new RGB16 image with the given bounds.
+func NewRGB16(r image.Rectangle) *RGB16 {
+	return &RGB16{
+		Pix:    make([]uint8, 2*r.Dx()*r.Dy()),
+		Stride: 2 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// ColorModel implements image.Image.
+func (p *RGB16) ColorModel() color.Model { return RGB565Model }
+
+// Bounds implements image.Image.
+func (p *RGB16) Bounds() image.Rectangle { return p.Rect }
+
+// At implements image.Image.
+func (p *RGB16) At(x, y int) color.Color {
+	return p.RGB565At(x, y)
+}
+
+// RGB565At returns the color of the pixel at (x, y).
+func (p *RGB16) RGB565At(x, y int) RGB565 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+
+	i := p.PixOffset(x, y)
+	return RGB565(uint16(p.Pix[i]) | uint16(p.Pix[i+1])<<8)
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds
+// to the pixel at (x, y).
+func (p *RGB16) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*2
+}
+
+// Set implements draw.Image.
+func (p *RGB16) Set(x, y int, c color.Color) {
+	p.SetRGB565(x, y, RGB565Model.Convert(c).(RGB565))
+}
+
+// SetRGB565 sets the color of the pixel at (x, y).
+func (p *RGB16) SetRGB565(x, y int, c RGB565) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	p.Pix[i], p.Pix[i+1] = uint8(c), uint8(c>>8)
+}
+
+// SubImage returns an image representing the portion of p visible through
+// r. The returned value shares pixels with the original image.
+func (p *RGB16) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &RGB16{}
+	}
+
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &RGB16{
+		Pix:    p.Pix[i:],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque reports whether the image is fully opaque, which is always true.
+func (p *RGB16) Opaque() bool {
+	return true
+}
+
+// target is an image whose pixels are packed from decoded rows converted
+// to premultiplied 8-bit RGBA samples.
+type target struct {
+	img    image.Image
+	pix    []byte
+	stride int
+	pack   func(dst, rgba []byte)
+}
+
+// nativeTarget returns the target for the color model requested with
+// WithColorModel if the decoder can produce it without an intermediate
+// image, nil otherwise.
+func (d *Decoder) nativeTarget(r image.Rectangle) (*target, error) {
+	switch d.opts.model {
+	case RGBModel:
+		stride, err := d.stride(d.width*3, 1)
+		if err != nil {
+			return nil, err
+		}
+		m := &RGB24{Pix: make([]uint8, stride*r.Dy()), Stride: stride, Rect: r}
+		return &target{m, m.Pix, m.Stride, packRGB}, nil
+	case BGRAModel:
+		stride, err := d.stride(d.width*4, 1)
+		if err != nil {
+			return nil, err
+		}
+		m := &BGRA32{Pix: make([]uint8, stride*r.Dy()), Stride: stride, Rect: r}
+		return &target{m, m.Pix, m.Stride, packBGRA}, nil
+	case RGB565Model:
+		stride, err := d.stride(d.width*2, 1)
+		if err != nil {
+			return nil, err
+		}
+		m := &RGB16{Pix: make([]uint8, stride*r.Dy()), Stride: stride, Rect: r}
+		return &target{m, m.Pix, m.Stride, packRGB565}, nil
+	}
+
+	return nil, nil
+}
+
+func packRGB(dst, rgba []byte) {
+	for i, j := 0, 0; i < len(rgba); i, j = i+4, j+3 {
+		dst[j], dst[j+1], dst[j+2] = rgba[i], rgba[i+1], rgba[i+2]
+	}
+}
+
+func packRGB565(dst, rgba []byte) {
+	for i, j := 0, 0; i < len(rgba); i, j = i+4, j+2 {
+		c := toRGB565(rgba[i], rgba[i+1], rgba[i+2])
+		dst[j], dst[j+1] = uint8(c), uint8(c>>8)
+	}
+}
+
+func packBGRA(dst, rgba []byte) {
+	for i := 0; i < len(rgba); i += 4 {
+		dst[i], dst[i+1], dst[i+2], dst[i+3] = rgba[i+2], rgba[i+1], rgba[i], rgba[i+3]
+	}
+}
+
+// decodeTarget decodes the pixel data into t.
+func (d *Decoder) decodeTarget(t *target) error {
+	row := make([]byte, d.rowLen())
+	rgba := make([]byte, d.width*4)
+
+	for n := 0; n < d.height; n++ {
+		y := d.rowY(n)
+		if err := d.readRow(row); err != nil {
+			return err
+		}
+		t.pack(t.pix[y*t.stride:], d.rgbaRow(rgba, row))
+		d.rowDone(n + 1)
+	}
+	d.image = t.img
+
+	return nil
+}
+
+// rgbaRow converts an unpacked row to premultiplied 8-bit RGBA samples,
+// using dst as storage if needed.
+func (d *Decoder) rgbaRow(dst, row []byte) []byte {
+	switch {
+	case d.palette != nil:
+		for x, idx := range row {
+			var c color.RGBA
+			if int(idx) < len(d.palette) {
+				c = color.RGBAModel.Convert(d.palette[idx]).(color.RGBA)
+			}
+			dst[x*4], dst[x*4+1], dst[x*4+2], dst[x*4+3] = c.R, c.G, c.B, c.A
+		}
+		return dst
+	case d.rowModel() == color.NRGBAModel:
+		for i := 0; i < len(row); i += 4 {
+			c := color.RGBAModel.Convert(color.NRGBA{row[i], row[i+1], row[i+2], row[i+3]}).(color.RGBA)
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = c.R, c.G, c.B, c.A
+		}
+		return dst
+	}
+
+	return row
+}
+
+// Bitmap is an in-memory image of 1-bit palette indices, packed 8 pixels per
+// byte from the most significant bit like the rows of 1 bpp BMP images.
+type Bitmap struct {
+	// Pix holds the image's pixels as bits. The pixel at (x, y) is the bit
+	// 7-x&7 of Pix[(y-Rect.Min.Y)*Stride + x>>3 - Rect.Min.X>>3], so that
+	// sub-images share the bytes of their parent.
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+	// Palette is the image's palette, of at most 2 colors.
+	Palette color.Palette
+}
+
+// NewBitmap returns a new Bitmap image with the given bounds and palette.
+func NewBitmap(r image.Rectangle, p color.Palette) *Bitmap {
+	stride := (r.Max.X+7)>>3 - r.Min.X>>3
+	return &Bitmap{
+		Pix:     make([]uint8, stride*r.Dy()),
+		Stride:  stride,
+		Rect:    r,
+		Palette: p,
+	}
+}
+
+// ColorModel implements image.Image.
+func (p *Bitmap) ColorModel() color.Model { return p.Palette }
+
+// Bounds implements image.Image.
+func (p *Bitmap) Bounds() image.Rectangle { return p.Rect }
+
+// At implements image.Image.
+func (p *Bitmap) At(x, y int) color.Color {
+	if len(p.Palette) == 0 {
+		return nil
+	}
+
+	idx := p.ColorIndexAt(x, y)
+	if int(idx) >= len(p.Palette) {
+		return color.Black
+	}
+
+	return p.Palette[idx]
+}
+
+// byteOffset returns the index of the byte of Pix holding the pixel at
+// (x, y).
+func (p *Bitmap) byteOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + x>>3 - p.Rect.Min.X>>3
+}
+
+// ColorIndexAt returns the palette index of the pixel at (x, y).
+func (p *Bitmap) ColorIndexAt(x, y int) uint8 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+
+	return p.Pix[p.byteOffset(x, y)] >> uint(7-x&7) & 1
+}
+
+// SetColorIndex sets the palette index of the pixel at (x, y), which must
+// be 0 or 1.
+func (p *Bitmap) SetColorIndex(x, y int, index uint8) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i, bit := p.byteOffset(x, y), uint8(0x80)>>uint(x&7)
+	if index&1 != 0 {
+		p.Pix[i] |= bit
+	} else {
+		p.Pix[i] &^= bit
+	}
+}
+
+// Set implements draw.Image.
+func (p *Bitmap) Set(x, y int, c color.Color) {
+	p.SetColorIndex(x, y, uint8(p.Palette.Index(c)))
+}
+
+// SubImage returns an image representing the portion of p visible through
+// r. The returned value shares pixels with the original image.
+func (p *Bitmap) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &Bitmap{Palette: p.Palette}
+	}
+
+	i := p.byteOffset(r.Min.X, r.Min.Y)
+	return &Bitmap{
+		Pix:     p.Pix[i:],
+		Stride:  p.Stride,
+		Rect:    r,
+		Palette: p.Palette,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *Bitmap) Opaque() bool {
+	for _, c := range p.Palette {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decodeBitmap decodes the pixel data of a 1 bpp image into a Bitmap,
+// copying the stored rows as they are.
+func (d *Decoder) decodeBitmap() error {
+	rowLen := (d.width + 7) / 8
+	stride, err := d.stride(rowLen, 1)
+	if err != nil {
+		return err
+	}
+
+	m := &Bitmap{
+		Pix:     make([]uint8, stride*d.height),
+		Stride:  stride,
+		Rect:    image.Rect(0, 0, d.width, d.height),
+		Palette: d.palette,
+	}
+
+	var indices []byte
+	if d.opts.counter != nil {
+		indices = make([]byte, d.width)
+	}
+
+	// bits past the width are cleared
+	mask := uint8(0xff) << uint(rowLen*8-d.width)
+	for n := 0; n < d.height; n++ {
+		if _, err := io.ReadFull(d.r, d.row); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		p := m.Pix[d.rowY(n)*stride:]
+		copy(p[:rowLen], d.row)
+		p[rowLen-1] &= mask
+		if d.opts.strictPalette && len(d.palette) < 2 {
+			for _, b := range p[:rowLen] {
+				if b != 0 {
+					return fmt.Errorf("bmp: palette index out of range (got: 1, colors: %d)", len(d.palette))
+				}
+			}
+		}
+		if indices != nil {
+			palettedUnpacker(1).Unpack(indices, p)
+			d.countRow(indices)
+		}
+		d.rowDone(n + 1)
+	}
+	d.image = m
+
+	return nil
+}
+
+// bitmap reports whether the image is decoded into a Bitmap, as requested
+// with WithBitmap.
+func (d *Decoder) bitmap() bool {
+	return d.opts.bitmap && d.bpp == 1 && d.rle == nil && !d.mirrored
+}
+
+// alphaMask reports whether the image is decoded into an image.Alpha, as
+// requested with WithAlphaMask.
+func (d *Decoder) alphaMask() bool {
+	return d.opts.alphaMask && d.bpp == 8 && d.palette != nil
+}
+
+// decodeAlphaMask decodes the pixel data of an 8 bpp image into an
+// image.Alpha, whose values are the gray levels of the palette entries.
+func (d *Decoder) decodeAlphaMask() error {
+	stride, err := d.stride(d.width, 1)
+	if err != nil {
+		return err
+	}
+
+	var levels [256]uint8
+	for i, c := range d.palette {
+		levels[i] = color.GrayModel.Convert(c).(color.Gray).Y
+	}
+
+	m := &image.Alpha{
+		Pix:    make([]uint8, stride*d.height),
+		Stride: stride,
+		Rect:   image.Rect(0, 0, d.width, d.height),
+	}
+	for n := 0; n < d.height; n++ {
+		p := m.Pix[d.rowY(n)*stride : d.rowY(n)*stride+d.width]
+		if err := d.readRow(p); err != nil {
+			return err
+		}
+		for x, idx := range p {
+			p[x] = levels[idx]
+		}
+		d.rowDone(n + 1)
+	}
+	d.image = m
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package bmp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDecodeRGB24(t *testing.T) {
+	src := testImage(5, 3)
+	src.SetNRGBA(1, 1, color.NRGBA{0xff, 0x80, 0x40, 0x80})
+
+	for _, depth := range []int{24, 32} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(depth)
+		if err := e.Encode(src); err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := Decode(bytes.NewReader(buf.Bytes()), WithColorModel(RGBModel))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, ok := img.(*RGB24)
+		if !ok {
+			t.Fatalf("%d bpp: image type = %T, expected *RGB24", depth, img)
+		}
+		if len(m.Pix) != 5*3*3 {
+			t.Errorf("%d bpp: len(Pix) = %d, expected %d", depth, len(m.Pix), 5*3*3)
+		}
+
+		expected := image.NewRGBA(src.Bounds())
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 5; x++ {
+				expected.Set(x, y, src.At(x, y))
+			}
+		}
+		if depth == 24 {
+			// the alpha of the 24 bpp image has been dropped already
+			expected.SetRGBA(1, 1, color.RGBA{0x80, 0x40, 0x20, 0xff})
+		}
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 5; x++ {
+				c, e := m.RGBAt(x, y), expected.RGBAAt(x, y)
+				if c.R != e.R || c.G != e.G || c.B != e.B {
+					t.Errorf("%d bpp: pixel (%d, %d) = %v, expected %v", depth, x, y, c, e)
+				}
+			}
+		}
+	}
+}
+
+func TestRGB24SubImage(t *testing.T) {
+	m := NewRGB24(image.Rect(0, 0, 4, 4))
+	m.Set(2, 3, color.RGBA{1, 2, 3, 0xff})
+
+	sub := m.SubImage(image.Rect(2, 2, 4, 4)).(*RGB24)
+	if c := sub.RGBAt(2, 3); c != (RGB{1, 2, 3}) {
+		t.Errorf("sub image pixel = %v, expected {1 2 3}", c)
+	}
+	if c := sub.RGBAt(0, 0); c != (RGB{}) {
+		t.Errorf("pixel outside the sub image = %v, expected zero", c)
+	}
+}
+
+func TestDecodeBGRA32(t *testing.T) {
+	src := testImage(5, 3)
+	src.SetNRGBA(1, 1, color.NRGBA{0xff, 0x80, 0x40, 0x80})
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(32)
+	if err := e.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Decode(bytes.NewReader(buf.Bytes()), WithColorModel(BGRAModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok := img.(*BGRA32)
+	if !ok {
+		t.Fatalf("image type = %T, expected *BGRA32", img)
+	}
+	if m.Opaque() {
+		t.Error("image is opaque, expected translucent pixels")
+	}
+	if c := m.BGRAAt(1, 1); c != (BGRA{0x20, 0x40, 0x80, 0x80}) {
+		t.Errorf("pixel (1, 1) = %v, expected {32 64 128 128}", c)
+	}
+	if !sameImage(m, ToBGRA32(src)) {
+		t.Error("decoded image differs from ToBGRA32 of the source")
+	}
+}
+
+func TestToBGRA32(t *testing.T) {
+	src := image.NewRGBA(image.Rect(1, 1, 3, 2))
+	src.SetRGBA(1, 1, color.RGBA{1, 2, 3, 4})
+	src.SetRGBA(2, 1, color.RGBA{5, 6, 7, 0xff})
+
+	m := ToBGRA32(src)
+	if m.Rect != src.Rect {
+		t.Errorf("bounds = %v, expected %v", m.Rect, src.Rect)
+	}
+	if expected := []uint8{3, 2, 1, 4, 7, 6, 5, 0xff}; !bytes.Equal(m.Pix, expected) {
+		t.Errorf("Pix = %v, expected %v", m.Pix, expected)
+	}
+	if !sameImage(m, src) {
+		t.Error("ToBGRA32 changed the colors")
+	}
+}
+
+func TestDecodeBitmap(t *testing.T) {
+	for _, width := range []int{1, 7, 8, 9, 33} {
+		m := testPaletted(width, 3, 2)
+
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(1)
+		if err := e.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := Decode(bytes.NewReader(buf.Bytes()), WithBitmap(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, ok := img.(*Bitmap)
+		if !ok {
+			t.Fatalf("image type = %T, expected *Bitmap", img)
+		}
+		if b.Stride != (width+7)/8 {
+			t.Errorf("width %d: stride = %d, expected %d", width, b.Stride, (width+7)/8)
+		}
+		for y := 0; y < 3; y++ {
+			for x := 0; x < width; x++ {
+				if idx := b.ColorIndexAt(x, y); idx != m.ColorIndexAt(x, y) {
+					t.Errorf("width %d: index at (%d, %d) = %d, expected %d", width, x, y, idx, m.ColorIndexAt(x, y))
+				}
+			}
+		}
+
+		// encoding the bitmap gives back the same file
+		var again bytes.Buffer
+		e = NewEncoder(&again)
+		e.SetDepth(1)
+		if err := e.Encode(b); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+			t.Errorf("width %d: encoded bitmap differs from the encoded paletted image", width)
+		}
+	}
+}
+
+func TestDecodeAlphaMask(t *testing.T) {
+	gray := make(color.Palette, 256)
+	for i := range gray {
+		gray[i] = color.Gray{uint8(255 - i)}
+	}
+	m := image.NewPaletted(image.Rect(0, 0, 5, 3), gray)
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 17)
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(8)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(buf.Bytes()), WithAlphaMask(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ColorModel != color.AlphaModel {
+		t.Errorf("config color model = %v, expected color.AlphaModel", cfg.ColorModel)
+	}
+
+	img, err := Decode(bytes.NewReader(buf.Bytes()), WithAlphaMask(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, ok := img.(*image.Alpha)
+	if !ok {
+		t.Fatalf("image type = %T, expected *image.Alpha", img)
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			if got, want := a.AlphaAt(x, y).A, 255-m.ColorIndexAt(x, y); got != want {
+				t.Errorf("alpha at (%d, %d) = %d, expected %d", x, y, got, want)
+			}
+		}
+	}
+
+	// other depths are not affected
+	buf.Reset()
+	if err := NewEncoder(&buf).Encode(testImage(5, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := Decode(bytes.NewReader(buf.Bytes()), WithAlphaMask(true)); err != nil {
+		t.Fatal(err)
+	} else if _, ok := img.(*image.Alpha); ok {
+		t.Error("24 bpp image decoded as *image.Alpha")
+	}
+}
+
+func TestBitmapSubImage(t *testing.T) {
+	m := NewBitmap(image.Rect(-3, 0, 20, 2), color.Palette{color.Black, color.White})
+	m.Set(5, 1, color.White)
+	m.SetColorIndex(-2, 0, 1)
+
+	sub := m.SubImage(image.Rect(3, 1, 9, 2)).(*Bitmap)
+	if c := sub.At(5, 1); c != color.White {
+		t.Errorf("sub image pixel = %v, expected white", c)
+	}
+	if idx := sub.ColorIndexAt(4, 1); idx != 0 {
+		t.Errorf("sub image index at (4, 1) = %d, expected 0", idx)
+	}
+
+	sub.SetColorIndex(8, 1, 1)
+	if idx := m.ColorIndexAt(8, 1); idx != 1 {
+		t.Error("sub image does not share pixels with its parent")
+	}
+	if idx := m.ColorIndexAt(-2, 0); idx != 1 {
+		t.Errorf("index at (-2, 0) = %d, expected 1", idx)
+	}
+}
+
+func TestDecodeRGB565(t *testing.T) {
+	key := UnpackerKey{16, CompressionBitfields, 0xf800, 0x07e0, 0x001f, 0}
+	rows := []byte{0x34, 0x12, 0x00, 0xf8, 0xe0, 0x07, 0x1f, 0x00}
+
+	m, err := Decode(bytes.NewReader(rawFile(key, 4, 1, nil, rows)), WithColorModel(RGB565Model))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, ok := m.(*RGB16)
+	if !ok {
+		t.Fatalf("got %T, want *RGB16", m)
+	}
+	// the stored pixels are preserved
+	if !bytes.Equal(p.Pix, rows) {
+		t.Errorf("Pix = %x, want %x", p.Pix, rows)
+	}
+	if c := p.RGB565At(1, 0); c != 0xf800 {
+		t.Errorf("RGB565At(1, 0) = %#x", c)
+	}
+	if c := color.RGBAModel.Convert(p.At(1, 0)); c != (color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("At(1, 0) = %v", c)
+	}
+}
+
+func TestRGB16(t *testing.T) {
+	p := NewRGB16(image.Rect(0, 0, 3, 2))
+	p.Set(1, 1, color.RGBA{0xff, 0x80, 0x10, 0xff})
+	if c := p.RGB565At(1, 1); c != RGB565(0x1f<<11|0x20<<5|0x02) {
+		t.Errorf("RGB565At = %#x", c)
+	}
+
+	sub := p.SubImage(image.Rect(1, 1, 3, 2)).(*RGB16)
+	if sub.RGB565At(1, 1) != p.RGB565At(1, 1) || !sub.Opaque() {
+		t.Error("sub-image does not share pixels")
+	}
+}
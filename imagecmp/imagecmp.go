@@ -0,0 +1,142 @@
+// Package imagecmp measures the differences between images, to check lossy
+// conversions against tolerances.
+//
+// The measures use the 8-bit premultiplied red, green, blue and alpha
+// samples of the pixels. Images must have the same size, their bounds may
+// start at different points.
+package imagecmp
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// MaxDelta returns the largest absolute difference between the samples of
+// the same pixel and channel of a and b.
+func MaxDelta(a, b image.Image) (int, error) {
+	max := 0
+	err := eachPixel(a, b, func(p, q [4]float64) {
+		for i := range p {
+			if d := int(math.Abs(p[i] - q[i])); d > max {
+				max = d
+			}
+		}
+	})
+
+	return max, err
+}
+
+// PSNR returns the peak signal-to-noise ratio between a and b in decibels,
+// +Inf for identical images. Values above 40 dB are hard to tell apart.
+func PSNR(a, b image.Image) (float64, error) {
+	var sum float64
+	n := 0
+	err := eachPixel(a, b, func(p, q [4]float64) {
+		for i := range p {
+			d := p[i] - q[i]
+			sum += d * d
+		}
+		n += 4
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if sum == 0 {
+		return math.Inf(1), nil
+	}
+
+	return 10 * math.Log10(255*255/(sum/float64(n))), nil
+}
+
+// SSIM returns the mean structural similarity index between the luma of a
+// and b, computed over 8×8 windows moved by 4 pixels, or over the whole
+// image if smaller. It ranges from -1 to 1 for identical images.
+func SSIM(a, b image.Image) (float64, error) {
+	const (
+		window = 8
+		step   = 4
+		c1     = (0.01 * 255) * (0.01 * 255)
+		c2     = (0.03 * 255) * (0.03 * 255)
+	)
+
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+	la, lb := make([]float64, 0, w*h), make([]float64, 0, w*h)
+	err := eachPixel(a, b, func(p, q [4]float64) {
+		la = append(la, luma(p))
+		lb = append(lb, luma(q))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	ww, wh := window, window
+	if w < ww {
+		ww = w
+	}
+	if h < wh {
+		wh = h
+	}
+
+	var sum float64
+	n := 0
+	for y0 := 0; y0+wh <= h; y0 += step {
+		for x0 := 0; x0+ww <= w; x0 += step {
+			var ma, mb, va, vb, cov float64
+			for y := y0; y < y0+wh; y++ {
+				for x := x0; x < x0+ww; x++ {
+					ma += la[y*w+x]
+					mb += lb[y*w+x]
+				}
+			}
+			k := float64(ww * wh)
+			ma, mb = ma/k, mb/k
+
+			for y := y0; y < y0+wh; y++ {
+				for x := x0; x < x0+ww; x++ {
+					da, db := la[y*w+x]-ma, lb[y*w+x]-mb
+					va += da * da
+					vb += db * db
+					cov += da * db
+				}
+			}
+			va, vb, cov = va/k, vb/k, cov/k
+
+			sum += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			n++
+		}
+	}
+
+	return sum / float64(n), nil
+}
+
+// luma returns the Rec. 601 luma of the samples p.
+func luma(p [4]float64) float64 {
+	return 0.299*p[0] + 0.587*p[1] + 0.114*p[2]
+}
+
+// eachPixel calls fn with the samples of each pixel of a and b, row by row.
+func eachPixel(a, b image.Image, fn func(p, q [4]float64)) error {
+	ra, rb := a.Bounds(), b.Bounds()
+	if ra.Size() != rb.Size() {
+		return fmt.Errorf("imagecmp: images have different sizes (%v and %v)", ra.Size(), rb.Size())
+	}
+	if ra.Empty() {
+		return fmt.Errorf("imagecmp: images are empty")
+	}
+
+	for y := 0; y < ra.Dy(); y++ {
+		for x := 0; x < ra.Dx(); x++ {
+			fn(samples(a, ra.Min.X+x, ra.Min.Y+y), samples(b, rb.Min.X+x, rb.Min.Y+y))
+		}
+	}
+
+	return nil
+}
+
+// samples returns the 8-bit samples of the pixel (x, y) of m.
+func samples(m image.Image, x, y int) [4]float64 {
+	r, g, b, a := m.At(x, y).RGBA()
+	return [4]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8), float64(a >> 8)}
+}
@@ -0,0 +1,75 @@
+package imagecmp
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func gradient(w, h int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.SetRGBA(x, y, color.RGBA{uint8(x * 8), uint8(y * 8), 0x80, 0xff})
+		}
+	}
+
+	return m
+}
+
+func TestIdentical(t *testing.T) {
+	a := gradient(16, 16)
+	// same pixels, other origin
+	b := &image.RGBA{Pix: a.Pix, Stride: a.Stride, Rect: a.Rect.Add(image.Pt(3, 5))}
+
+	if d, err := MaxDelta(a, b); err != nil || d != 0 {
+		t.Errorf("MaxDelta = %d, %v, expected 0", d, err)
+	}
+	if p, err := PSNR(a, b); err != nil || !math.IsInf(p, 1) {
+		t.Errorf("PSNR = %v, %v, expected +Inf", p, err)
+	}
+	if s, err := SSIM(a, b); err != nil || math.Abs(s-1) > 1e-9 {
+		t.Errorf("SSIM = %v, %v, expected 1", s, err)
+	}
+}
+
+func TestDifferent(t *testing.T) {
+	a := gradient(16, 16)
+	b := gradient(16, 16)
+	for i := 0; i < len(b.Pix); i += 4 {
+		b.Pix[i] += 4
+	}
+	b.Pix[0] = 30
+
+	if d, _ := MaxDelta(a, b); d != 30 {
+		t.Errorf("MaxDelta = %d, expected 30", d)
+	}
+
+	// noise lowers the PSNR and the SSIM more than a small shift
+	noisy := gradient(16, 16)
+	for i := 0; i < len(noisy.Pix); i += 4 {
+		if i%8 == 0 {
+			noisy.Pix[i+1] += 40
+		}
+	}
+	pb, _ := PSNR(a, b)
+	pn, _ := PSNR(a, noisy)
+	if pb < 30 || pn >= pb {
+		t.Errorf("PSNR of the shifted image %v, of the noisy image %v", pb, pn)
+	}
+	sb, _ := SSIM(a, b)
+	sn, _ := SSIM(a, noisy)
+	if sb < 0.9 || sn >= sb {
+		t.Errorf("SSIM of the shifted image %v, of the noisy image %v", sb, sn)
+	}
+
+	// smaller than a window
+	if s, err := SSIM(gradient(3, 2), gradient(3, 2)); err != nil || math.Abs(s-1) > 1e-9 {
+		t.Errorf("SSIM of small images = %v, %v, expected 1", s, err)
+	}
+
+	if _, err := PSNR(a, gradient(8, 8)); err == nil {
+		t.Errorf("expected an error for images of different sizes")
+	}
+}
@@ -0,0 +1,40 @@
+//go:build go1.23
+// +build go1.23
+
+package bmp
+
+import (
+	"io"
+	"iter"
+)
+
+// All returns an iterator over the remaining rows, yielding the y
+// coordinate and the pixels of each row as Y and Row do. The row is
+// overwritten at the next iteration. Check Err once the loop ends.
+func (rr *RowReader) All() iter.Seq2[int, []byte] {
+	return func(yield func(int, []byte) bool) {
+		for rr.Next() {
+			if !yield(rr.y, rr.buf) {
+				return
+			}
+		}
+	}
+}
+
+// Rows reads a BMP image from r and returns an iterator over its rows, in
+// the order they are stored, like a RowReader, along with a function
+// returning the error that stopped the iteration, if any:
+//
+//	rows, errf := bmp.Rows(r)
+//	for y, row := range rows {
+//		...
+//	}
+//	if err := errf(); err != nil {
+//		...
+//	}
+//
+// The iterator can only be used once.
+func Rows(r io.Reader, opts ...Option) (iter.Seq2[int, []byte], func() error) {
+	rr := NewDecoder(r, opts...).Rows()
+	return rr.All(), rr.Err
+}
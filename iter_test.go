@@ -0,0 +1,54 @@
+//go:build go1.23
+// +build go1.23
+
+package bmp
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+func TestRowsIter(t *testing.T) {
+	src := testImage(4, 3)
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, errf := Rows(bytes.NewReader(buf.Bytes()))
+	var ys []int
+	for y, row := range rows {
+		ys = append(ys, y)
+		if len(row) != 4*4 {
+			t.Fatalf("row %d is %d bytes long, expected 16", y, len(row))
+		}
+		if c := color.NRGBAModel.Convert(src.At(0, y)).(color.NRGBA); !bytes.Equal(row[:4], []byte{c.R, c.G, c.B, c.A}) {
+			t.Errorf("row %d starts with %v", y, row[:4])
+		}
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	// bottom-up
+	if len(ys) != 3 || ys[0] != 2 || ys[2] != 0 {
+		t.Errorf("rows %v, expected [2 1 0]", ys)
+	}
+
+	// stopping early leaves the reader on the next row
+	rr := NewDecoder(bytes.NewReader(buf.Bytes())).Rows()
+	for range rr.All() {
+		break
+	}
+	if !rr.Next() || rr.Y() != 1 {
+		t.Errorf("next row %d, expected 1", rr.Y())
+	}
+
+	rows, errf = Rows(bytes.NewReader(buf.Bytes()[:60]))
+	for range rows {
+	}
+	if errf() == nil {
+		t.Error("expected an error for a truncated file")
+	}
+}
@@ -0,0 +1,123 @@
+package bmp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// Warning is a violation of the format tolerated by the lenient decoder.
+type Warning struct {
+	// Offset is the position in the file of the offending field or data.
+	Offset int64
+	// Message describes the violation.
+	Message string
+}
+
+// String returns the offset and the message of the warning.
+func (w Warning) String() string {
+	return fmt.Sprintf("offset %d: %s", w.Offset, w.Message)
+}
+
+// Lint reads a BMP image from r with WithLenient and reports the
+// violations of the format it tolerated, such as a wrong file size, non-zero
+// reserved fields, data between the color table and the pixel data or a
+// color table too large for the depth, so that archival tools can flag
+// files without rejecting them. It reads r to the end. The error is not
+// nil if the image cannot be decoded even leniently.
+func Lint(r io.Reader) ([]Warning, error) {
+	cr := &countingReader{r: r}
+	d := NewDecoder(cr, WithLenient(true))
+	if _, err := d.Image(); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, cr); err != nil {
+		return nil, err
+	}
+
+	// the violations of the headers are the ones the decoder tolerated
+	warnings := append([]Warning(nil), d.warnings...)
+	m := d.meta
+	if int64(m.FileSize) != cr.n && !m.hasQuirk(QuirkHeaderFileSize) {
+		warnings = append(warnings, Warning{2, fmt.Sprintf("file size is %d bytes, header says %d", cr.n, m.FileSize)})
+	}
+	if m.Reserved != 0 {
+		warnings = append(warnings, Warning{6, fmt.Sprintf("reserved fields are not zero (got: %#x)", m.Reserved)})
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Offset < warnings[j].Offset
+	})
+
+	return warnings, nil
+}
+
+// Warnings returns the violations of the format tolerated by WithLenient
+// while reading the headers, in the order of the file: the quirks worked
+// around, a zero pixel offset, a negative width, a color table too large
+// for the depth, RLE data of unknown size, data between the color table
+// and the pixel data and rows not padded to 4 bytes. Lint reports them
+// along with the violations found in the rest of the file.
+func (d *Decoder) Warnings() ([]Warning, error) {
+	if err := d.advance(stageConfig); err != nil {
+		return nil, err
+	}
+
+	return d.warnings, nil
+}
+
+// headerWarnings collects the violations tolerated while reading the
+// headers in lenient mode and passes them to the handler set by
+// WithWarningHandler.
+func (d *Decoder) headerWarnings() {
+	if !d.opts.lenient {
+		return
+	}
+
+	warnf := func(offset int64, format string, args ...interface{}) {
+		d.warnings = append(d.warnings, Warning{Offset: offset, Message: fmt.Sprintf(format, args...)})
+	}
+
+	m := d.meta
+	for _, q := range m.Quirks {
+		warnf(quirks[q].offset, "%s bug of %s", q, q.Writer())
+	}
+	if m.PixelOffset == 0 && !d.dib {
+		warnf(10, "pixel offset is zero")
+	}
+	if m.Mirrored {
+		warnf(18, "width is negative")
+	}
+	if max := 1 << uint(d.bpp); d.bpp <= 8 && m.ColorsUsed > max {
+		warnf(46, "color table has %d entries, %d bpp allows %d", m.ColorsUsed, d.bpp, max)
+	}
+	if (m.Compression == CompressionRLE8 || m.Compression == CompressionRLE4) && m.ImageSize == 0 {
+		warnf(34, "RLE compressed image does not declare the size of the pixel data")
+	}
+	if padded := (d.width*d.bpp + 31) / 32 * 4; len(d.row) < padded && d.codec == nil {
+		warnf(d.dataOffset, "rows are %d bytes long instead of %d, not padded to 4 bytes", len(d.row), padded)
+	}
+
+	// the end of the color table as declared: the decoder skips the
+	// entries past the palette and the optional table of true color images
+	// with the gap
+	tableEnd := d.dataOffset - int64(d.gap)
+	switch {
+	case d.palette == nil:
+		tableEnd += int64(d.numColor * d.entryLen)
+	case m.ColorsUsed > d.numColor:
+		tableEnd += int64((m.ColorsUsed - d.numColor) * d.entryLen)
+	}
+	if tableEnd < d.dataOffset && !d.profileInGap(tableEnd, d.dataOffset) {
+		warnf(tableEnd, "%d bytes between the color table and the pixel data", d.dataOffset-tableEnd)
+	}
+
+	sort.SliceStable(d.warnings, func(i, j int) bool {
+		return d.warnings[i].Offset < d.warnings[j].Offset
+	})
+	if fn := d.opts.warn; fn != nil {
+		for _, w := range d.warnings {
+			fn(w)
+		}
+	}
+}
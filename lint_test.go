@@ -0,0 +1,106 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(1)
+	if err := e.Encode(testPaletted(5, 3, 2)); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+	offset := binary.LittleEndian.Uint32(clean[10:14])
+
+	// 3 entries for 1 bpp and 4 bytes of garbage before the pixel data
+	dirty := append(append(append([]byte{}, clean[:offset]...), 0, 0, 0, 0, 1, 2, 3, 4), clean[offset:]...)
+	binary.LittleEndian.PutUint32(dirty[2:6], 1234)
+	binary.LittleEndian.PutUint16(dirty[6:8], 1)
+	binary.LittleEndian.PutUint32(dirty[10:14], offset+8)
+	binary.LittleEndian.PutUint32(dirty[46:50], 3)
+
+	warnings, err := Lint(bytes.NewReader(clean))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("clean file: warnings = %v", warnings)
+	}
+
+	warnings, err = Lint(bytes.NewReader(dirty))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		offset int64
+		text   string
+	}{
+		{2, "file size"},
+		{6, "reserved"},
+		{46, "color table has 3 entries"},
+		{int64(offset) + 4, "4 bytes between"},
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings = %v, want %d", warnings, len(want))
+	}
+	for i, w := range want {
+		if warnings[i].Offset != w.offset || !strings.Contains(warnings[i].Message, w.text) {
+			t.Errorf("warning %d = %v, want %q at offset %d", i, warnings[i], w.text, w.offset)
+		}
+	}
+
+	if _, err := Decode(bytes.NewReader(dirty)); err == nil {
+		t.Error("strict decode of the linted file succeeded")
+	}
+	m, err := Decode(bytes.NewReader(dirty), WithLenient(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(m, testPaletted(5, 3, 2)) {
+		t.Error("lenient decode of the linted file differs")
+	}
+	if _, err := Lint(bytes.NewReader(dirty[:offset])); err == nil {
+		t.Error("linting a file without pixel data succeeded")
+	}
+}
+
+func TestDecoderWarnings(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(1)
+	if err := e.Encode(testPaletted(5, 3, 2)); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	// a zero pixel offset and a negative width
+	binary.LittleEndian.PutUint32(b[10:14], 0)
+	width := int32(-5)
+	binary.LittleEndian.PutUint32(b[18:22], uint32(width))
+
+	var handled []Warning
+	d := NewDecoder(bytes.NewReader(b), WithLenient(true), WithWarningHandler(func(w Warning) {
+		handled = append(handled, w)
+	}))
+	warnings, err := d.Warnings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 2 || warnings[0].Offset != 10 || warnings[1].Offset != 18 {
+		t.Errorf("warnings = %v, want the pixel offset and the width", warnings)
+	}
+	if len(handled) != len(warnings) {
+		t.Errorf("handler called with %v", handled)
+	}
+	if _, err := d.Image(); err != nil {
+		t.Fatal(err)
+	}
+
+	if warnings, err := NewDecoder(bytes.NewReader(b)).Warnings(); err == nil || warnings != nil {
+		t.Errorf("strict decode: warnings = %v, err = %v", warnings, err)
+	}
+}
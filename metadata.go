@@ -0,0 +1,77 @@
+package bmp
+
+// Compression is the compression method of the pixel data (biCompression).
+type Compression uint32
+
+// Compression methods defined by the BMP format.
+const (
+	CompressionRGB            Compression = 0
+	CompressionRLE8           Compression = 1
+	CompressionRLE4           Compression = 2
+	CompressionBitfields      Compression = 3
+	CompressionJPEG           Compression = 4
+	CompressionPNG            Compression = 5
+	CompressionAlphaBitfields Compression = 6
+)
+
+// Header is a version of the DIB header.
+type Header int
+
+// DIB header versions.
+const (
+	// HeaderInfo is the 40-byte BITMAPINFOHEADER.
+	HeaderInfo Header = iota
+	// HeaderCore is the 12-byte BITMAPCOREHEADER of OS/2 and Windows 2.x,
+	// with 16-bit dimensions and 3-byte color table entries.
+	HeaderCore
+	// HeaderV4 is the 108-byte BITMAPV4HEADER, which adds the channel masks
+	// and the color space.
+	HeaderV4
+	// HeaderV5 is the 124-byte BITMAPV5HEADER, which adds the rendering
+	// intent and the location of a color profile.
+	HeaderV5
+)
+
+// Metadata holds the header fields of a BMP image as stored in the file.
+type Metadata struct {
+	// FileSize is the size of the file in bytes (bfSize).
+	FileSize uint32
+	// Reserved holds the reserved fields (bfReserved1 and bfReserved2),
+	// which should be zero.
+	Reserved uint32
+	// PixelOffset is the offset of the pixel data (bfOffBits).
+	PixelOffset uint32
+	// HeaderSize is the length of the DIB header (biSize).
+	HeaderSize int
+	// Height is the height as stored (biHeight), negative for top-down
+	// images.
+	Height int32
+	// TopDown reports whether the rows are stored from top to bottom, as
+	// Encoder.SetTopDown writes them, rather than from bottom to top.
+	TopDown bool
+	// Mirrored reports whether the pixels of each row are stored from
+	// right to left, which a few broken writers mark with a negative width
+	// (biWidth). Such images are only decoded by WithLenient.
+	Mirrored bool
+	// BitsPerPixel is the number of bits per pixel (biBitCount).
+	BitsPerPixel int
+	// Compression is the compression method (biCompression).
+	Compression Compression
+	// ImageSize is the size of the pixel data in bytes (biSizeImage).
+	ImageSize uint32
+	// XPelsPerMeter and YPelsPerMeter are the resolution of the image.
+	XPelsPerMeter int32
+	YPelsPerMeter int32
+	// ColorsUsed is the number of color table entries (biClrUsed).
+	ColorsUsed int
+	// ColorsImportant is the number of important colors (biClrImportant).
+	ColorsImportant int
+	// TransparentIndex is the index of the color table entry marked as
+	// transparent by Encoder.SetTransparentIndex, whose fourth byte is zero
+	// while the one of every other entry is 0xff, or -1.
+	TransparentIndex int
+	// ColorSpace holds the color space of V4 and V5 headers, nil otherwise.
+	ColorSpace *ColorSpace
+	// Quirks lists the known writer bugs worked around by WithLenient.
+	Quirks []Quirk
+}
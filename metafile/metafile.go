@@ -0,0 +1,212 @@
+// Package metafile extracts the bitmaps embedded in Windows metafiles, WMF
+// and EMF, as found in old office documents and clipboard dumps.
+package metafile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+// Bitmap is a bitmap embedded in a metafile record.
+type Bitmap struct {
+	// Record is the index of the record holding the bitmap, counting the
+	// header record.
+	Record int
+	// Type is the record function of WMF files or the record type of EMF
+	// files, such as 0x0f43 for META_STRETCHDIB or 81 for
+	// EMR_STRETCHDIBITS.
+	Type  uint32
+	Image image.Image
+}
+
+const (
+	// placeableKey starts the placeable header preceding some WMF files.
+	placeableKey = 0x9ac6cdd7
+	// emfSignature is the signature of EMF headers, " EMF".
+	emfSignature = 0x464d4520
+)
+
+// usage of color tables
+const dibRGBColors = 0
+
+// Bitmaps reads a WMF or EMF file from r and returns the bitmaps of its
+// blitting, DIB and pattern brush records, in the order they are stored.
+// Bitmaps whose color table holds indices into the logical palette of the
+// metafile are skipped, as their colors cannot be told.
+func Bitmaps(r io.Reader, opts ...bmp.Option) ([]Bitmap, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) >= 44 && binary.LittleEndian.Uint32(b) == 1 && binary.LittleEndian.Uint32(b[40:]) == emfSignature {
+		return emfBitmaps(b, opts)
+	}
+
+	return wmfBitmaps(b, opts)
+}
+
+// decodeDIB decodes the DIB made of the header and color table bmi and of
+// the pixel data bits.
+func decodeDIB(bmi, bits []byte, opts []bmp.Option) (image.Image, error) {
+	return bmp.DecodeDIB(io.MultiReader(bytes.NewReader(bmi), bytes.NewReader(bits)), opts...)
+}
+
+// WMF record functions holding DIBs
+const (
+	metaDIBCreatePatternBrush = 0x0142
+	metaDIBBitBlt             = 0x0940
+	metaDIBStretchBlt         = 0x0b41
+	metaSetDIBToDev           = 0x0d33
+	metaStretchDIB            = 0x0f43
+	metaEOF                   = 0x0000
+
+	// bsPattern is the brush style of pattern brushes made of device
+	// dependent bitmaps.
+	bsPattern = 3
+)
+
+func wmfBitmaps(b []byte, opts []bmp.Option) ([]Bitmap, error) {
+	le := binary.LittleEndian
+
+	if len(b) >= 22 && le.Uint32(b) == placeableKey {
+		b = b[22:]
+	}
+	if len(b) < 18 {
+		return nil, fmt.Errorf("metafile: file too short")
+	}
+	if typ, words := le.Uint16(b), le.Uint16(b[2:]); typ != 1 && typ != 2 || words != 9 {
+		return nil, fmt.Errorf("metafile: invalid WMF header")
+	}
+
+	var bitmaps []Bitmap
+	for i, p := 1, 18; p+6 <= len(b); i++ {
+		size := uint64(le.Uint32(b[p:])) * 2
+		fn := le.Uint16(b[p+4:])
+		if size < 6 || uint64(len(b)-p) < size {
+			return nil, fmt.Errorf("metafile: record %d has an invalid size (got: %d bytes)", i, size)
+		}
+		rec := b[p : p+int(size)]
+		p += int(size)
+
+		// offsets of the DIB and of the color usage, if any, in the record
+		offset, usage := 0, -1
+		switch fn {
+		case metaEOF:
+			return bitmaps, nil
+		case metaStretchDIB:
+			offset, usage = 6+22, 10
+		case metaDIBStretchBlt, metaDIBBitBlt:
+			// records of blits without bitmap have the size given by the
+			// high byte of their function, in words
+			if size == uint64(fn>>8+3)*2 {
+				continue
+			}
+			offset = 6 + 20
+			if fn == metaDIBBitBlt {
+				offset = 6 + 16
+			}
+		case metaSetDIBToDev:
+			offset, usage = 6+18, 6
+		case metaDIBCreatePatternBrush:
+			offset, usage = 6+4, 8
+			if len(rec) >= 8 && le.Uint16(rec[6:]) == bsPattern {
+				// device dependent bitmap
+				continue
+			}
+		default:
+			continue
+		}
+		if offset >= len(rec) {
+			return nil, fmt.Errorf("metafile: record %d too short (got: %d bytes)", i, len(rec))
+		}
+		if usage >= 0 && le.Uint16(rec[usage:]) != dibRGBColors {
+			continue
+		}
+
+		m, err := bmp.DecodeDIB(bytes.NewReader(rec[offset:]), opts...)
+		if err != nil {
+			return nil, fmt.Errorf("metafile: record %d: %v", i, err)
+		}
+		bitmaps = append(bitmaps, Bitmap{Record: i, Type: uint32(fn), Image: m})
+	}
+
+	return bitmaps, nil
+}
+
+// EMF record types holding DIBs
+const (
+	emrBitBlt                  = 76
+	emrStretchBlt              = 77
+	emrMaskBlt                 = 78
+	emrPlgBlt                  = 79
+	emrSetDIBitsToDevice       = 80
+	emrStretchDIBits           = 81
+	emrCreateMonoBrush         = 93
+	emrCreateDIBPatternBrushPt = 94
+	emrAlphaBlend              = 114
+	emrTransparentBlt          = 116
+	emrEOF                     = 14
+)
+
+// emfDIBFields gives the offsets in the records of the fields locating
+// their DIB: offBmi, cbBmi, offBits, cbBits, then the color usage.
+var emfDIBFields = map[uint32][5]int{
+	emrBitBlt:                  {84, 88, 92, 96, 80},
+	emrStretchBlt:              {84, 88, 92, 96, 80},
+	emrMaskBlt:                 {84, 88, 92, 96, 80},
+	emrAlphaBlend:              {84, 88, 92, 96, 80},
+	emrTransparentBlt:          {84, 88, 92, 96, 80},
+	emrPlgBlt:                  {96, 100, 104, 108, 92},
+	emrSetDIBitsToDevice:       {48, 52, 56, 60, 64},
+	emrStretchDIBits:           {48, 52, 56, 60, 64},
+	emrCreateMonoBrush:         {16, 20, 24, 28, 12},
+	emrCreateDIBPatternBrushPt: {16, 20, 24, 28, 12},
+}
+
+func emfBitmaps(b []byte, opts []bmp.Option) ([]Bitmap, error) {
+	le := binary.LittleEndian
+
+	var bitmaps []Bitmap
+	for i, p := 0, 0; p+8 <= len(b); i++ {
+		typ := le.Uint32(b[p:])
+		size := le.Uint32(b[p+4:])
+		if size < 8 || uint64(len(b)-p) < uint64(size) {
+			return nil, fmt.Errorf("metafile: record %d has an invalid size (got: %d bytes)", i, size)
+		}
+		rec := b[p : p+int(size)]
+		p += int(size)
+
+		if typ == emrEOF {
+			break
+		}
+		f, ok := emfDIBFields[typ]
+		if !ok || len(rec) < f[4]+4 || len(rec) < f[3]+4 {
+			continue
+		}
+
+		offBmi, cbBmi := le.Uint32(rec[f[0]:]), le.Uint32(rec[f[1]:])
+		offBits, cbBits := le.Uint32(rec[f[2]:]), le.Uint32(rec[f[3]:])
+		// monochrome brushes use DIB_PAL_INDICES for their 2 colors
+		if cbBmi == 0 || typ != emrCreateMonoBrush && le.Uint32(rec[f[4]:]) != dibRGBColors {
+			continue
+		}
+		if uint64(offBmi)+uint64(cbBmi) > uint64(len(rec)) || uint64(offBits)+uint64(cbBits) > uint64(len(rec)) {
+			return nil, fmt.Errorf("metafile: record %d has a DIB out of range", i)
+		}
+
+		m, err := decodeDIB(rec[offBmi:offBmi+cbBmi], rec[offBits:offBits+cbBits], opts)
+		if err != nil {
+			return nil, fmt.Errorf("metafile: record %d: %v", i, err)
+		}
+		bitmaps = append(bitmaps, Bitmap{Record: i, Type: typ, Image: m})
+	}
+
+	return bitmaps, nil
+}
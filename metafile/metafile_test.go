@@ -0,0 +1,148 @@
+package metafile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+// testDIB returns a headerless 24 bpp DIB of a 1×1 image of color c.
+func testDIB(t *testing.T, c color.RGBA) []byte {
+	m := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	m.SetRGBA(0, 0, c)
+
+	var buf bytes.Buffer
+	if err := bmp.NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()[14:]
+}
+
+// wmfRecord returns a WMF record of function fn with the given parameters.
+func wmfRecord(fn uint16, params []byte) []byte {
+	if len(params)%2 != 0 {
+		params = append(params, 0)
+	}
+	b := make([]byte, 6, 6+len(params))
+	binary.LittleEndian.PutUint32(b, uint32(3+len(params)/2))
+	binary.LittleEndian.PutUint16(b[4:], fn)
+
+	return append(b, params...)
+}
+
+func TestWMFBitmaps(t *testing.T) {
+	red, blue := color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff}
+
+	header := make([]byte, 18)
+	binary.LittleEndian.PutUint16(header, 1)
+	binary.LittleEndian.PutUint16(header[2:], 9)
+
+	placeable := make([]byte, 22)
+	binary.LittleEndian.PutUint32(placeable, placeableKey)
+
+	stretch := make([]byte, 22)
+	palColors := make([]byte, 22)
+	binary.LittleEndian.PutUint16(palColors[4:], 1)
+
+	b := bytes.Join([][]byte{
+		placeable,
+		header,
+		wmfRecord(0x0201, make([]byte, 4)),
+		wmfRecord(metaStretchDIB, append(stretch, testDIB(t, red)...)),
+		// without bitmap
+		wmfRecord(metaDIBBitBlt, make([]byte, 18)),
+		wmfRecord(metaDIBBitBlt, append(make([]byte, 16), testDIB(t, blue)...)),
+		wmfRecord(metaStretchDIB, append(palColors, testDIB(t, red)...)),
+		wmfRecord(metaEOF, nil),
+	}, nil)
+
+	bitmaps, err := Bitmaps(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		record int
+		typ    uint32
+		c      color.RGBA
+	}{
+		{2, metaStretchDIB, red},
+		{4, metaDIBBitBlt, blue},
+	}
+	if len(bitmaps) != len(expected) {
+		t.Fatalf("%d bitmaps, expected %d", len(bitmaps), len(expected))
+	}
+	for i, e := range expected {
+		got := bitmaps[i]
+		if got.Record != e.record || got.Type != e.typ || color.RGBAModel.Convert(got.Image.At(0, 0)) != e.c {
+			t.Errorf("bitmap %d: record %d of type %#x with %v, expected record %d of type %#x with %v", i, got.Record, got.Type, got.Image.At(0, 0), e.record, e.typ, e.c)
+		}
+	}
+}
+
+// emfRecord returns an EMF record of type typ, whose fixed part is n bytes
+// long, holding dib at the offsets given by the fields of typ.
+func emfRecord(typ uint32, n int, dib []byte) []byte {
+	le := binary.LittleEndian
+
+	// the header, with its color table, is 40 bytes long for 24 bpp
+	b := make([]byte, n, n+len(dib))
+	le.PutUint32(b, typ)
+	le.PutUint32(b[4:], uint32(n+len(dib)))
+	f := emfDIBFields[typ]
+	le.PutUint32(b[f[0]:], uint32(n))
+	le.PutUint32(b[f[1]:], 40)
+	le.PutUint32(b[f[2]:], uint32(n+40))
+	le.PutUint32(b[f[3]:], uint32(len(dib)-40))
+
+	return append(b, dib...)
+}
+
+func TestEMFBitmaps(t *testing.T) {
+	le := binary.LittleEndian
+	red, blue := color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff}
+
+	header := make([]byte, 88)
+	le.PutUint32(header, 1)
+	le.PutUint32(header[4:], 88)
+	le.PutUint32(header[40:], emfSignature)
+
+	pattern := make([]byte, 100)
+	le.PutUint32(pattern, emrBitBlt)
+	le.PutUint32(pattern[4:], 100)
+
+	eof := make([]byte, 20)
+	le.PutUint32(eof, emrEOF)
+	le.PutUint32(eof[4:], 20)
+
+	b := bytes.Join([][]byte{
+		header,
+		emfRecord(emrStretchDIBits, 80, testDIB(t, red)),
+		pattern,
+		emfRecord(emrBitBlt, 100, testDIB(t, blue)),
+		eof,
+	}, nil)
+
+	bitmaps, err := Bitmaps(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bitmaps) != 2 {
+		t.Fatalf("%d bitmaps, expected 2", len(bitmaps))
+	}
+	if got := bitmaps[0]; got.Record != 1 || got.Type != emrStretchDIBits || color.RGBAModel.Convert(got.Image.At(0, 0)) != red {
+		t.Errorf("unexpected first bitmap: %+v", got)
+	}
+	if got := bitmaps[1]; got.Record != 3 || got.Type != emrBitBlt || color.RGBAModel.Convert(got.Image.At(0, 0)) != blue {
+		t.Errorf("unexpected second bitmap: %+v", got)
+	}
+
+	if _, err := Bitmaps(bytes.NewReader(b[:len(b)-30])); err == nil {
+		t.Errorf("expected an error for a truncated record")
+	}
+}
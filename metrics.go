@@ -0,0 +1,116 @@
+package bmp
+
+import (
+	"errors"
+	"expvar"
+	"io"
+	"strings"
+	"time"
+)
+
+// Metrics receives measurements of the decoders, so that services can feed
+// them to their monitoring, such as expvar or Prometheus. Implementations
+// must be safe for concurrent use.
+type Metrics interface {
+	// Decoded is called once per image whose pixel data was decoded, or
+	// failed to, with err. path names the API used: "image", "rows",
+	// "stream", "histogram", "raw", "scaled", "mipmaps", "concurrent",
+	// "tiles" or "sanitize". n is the number of bytes read and elapsed the
+	// time since the decoder was created.
+	Decoded(path string, n int64, elapsed time.Duration, err error)
+}
+
+// ErrorKind classifies err for failure counters: "limit" for
+// ErrLimitExceeded, "rle" for ErrInvalidRLE, "compressed" for
+// ErrCompressed, "truncated" for io.ErrUnexpectedEOF, "format" for other
+// errors of this package, "io" for errors of the reader and "" for nil.
+func ErrorKind(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrLimitExceeded):
+		return "limit"
+	case errors.Is(err, ErrInvalidRLE):
+		return "rle"
+	case errors.Is(err, ErrCompressed):
+		return "compressed"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "truncated"
+	case strings.HasPrefix(err.Error(), "bmp: "):
+		return "format"
+	}
+
+	return "io"
+}
+
+// ExpvarMetrics is a Metrics maintaining the counters of an expvar.Map:
+// "decoded" and "failed" images, "bytes" read, "failed.<kind>" by
+// ErrorKind, and "decoded.<path>" and "ns.<path>", the number of images and
+// the total time in nanoseconds by path.
+type ExpvarMetrics struct {
+	m *expvar.Map
+}
+
+// NewExpvarMetrics returns an ExpvarMetrics publishing its counters under
+// name. Like expvar.Publish, it panics if name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	return &ExpvarMetrics{m: expvar.NewMap(name)}
+}
+
+// Map returns the map of the counters.
+func (e *ExpvarMetrics) Map() *expvar.Map {
+	return e.m
+}
+
+// Decoded implements Metrics.
+func (e *ExpvarMetrics) Decoded(path string, n int64, elapsed time.Duration, err error) {
+	e.m.Add("bytes", n)
+	e.m.Add("decoded."+path, 1)
+	e.m.Add("ns."+path, int64(elapsed))
+
+	if err != nil {
+		e.m.Add("failed", 1)
+		e.m.Add("failed."+ErrorKind(err), 1)
+		return
+	}
+	e.m.Add("decoded", 1)
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// report calls the Metrics and the Tracer set with WithMetrics and
+// WithTracer once, with the path set on d or path otherwise.
+func (d *Decoder) report(path string, err error) {
+	m, t := d.opts.metrics, d.opts.tracer
+	if m == nil && t == nil || d.reported {
+		return
+	}
+	d.reported = true
+
+	if d.path != "" {
+		path = d.path
+	}
+	end := time.Now()
+	if m != nil {
+		m.Decoded(path, d.counter.n+d.extraRead, end.Sub(d.start), err)
+	}
+	if t != nil {
+		s := Span{Name: "bmp.Decode", Path: path, Start: d.start, End: end, Err: err}
+		if d.stage >= stageConfig {
+			s.Width, s.Height = d.width, d.height
+			s.BitsPerPixel, s.Compression = d.meta.BitsPerPixel, d.meta.Compression
+		}
+		t.Span(s)
+	}
+}
@@ -0,0 +1,140 @@
+package bmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type decodedCall struct {
+	path string
+	n    int64
+	err  error
+}
+
+type recordingMetrics struct {
+	mu    sync.Mutex
+	calls []decodedCall
+}
+
+func (r *recordingMetrics) Decoded(path string, n int64, elapsed time.Duration, err error) {
+	r.mu.Lock()
+	r.calls = append(r.calls, decodedCall{path, n, err})
+	r.mu.Unlock()
+}
+
+func encodeRGBA(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(32)
+	if err := e.Encode(image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestMetrics(t *testing.T) {
+	b := encodeRGBA(t, 4, 3)
+
+	tests := []struct {
+		path   string
+		decode func(opt Option) error
+	}{
+		{"image", func(opt Option) error {
+			_, err := Decode(bytes.NewReader(b), opt)
+			return err
+		}},
+		{"rows", func(opt Option) error {
+			rr := NewDecoder(bytes.NewReader(b), opt).Rows()
+			for rr.Next() {
+			}
+			return rr.Err()
+		}},
+		{"histogram", func(opt Option) error {
+			_, err := DecodeHistogram(bytes.NewReader(b), opt)
+			return err
+		}},
+		{"concurrent", func(opt Option) error {
+			_, err := DecodeConcurrent(bytes.NewReader(b), 2, opt)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		var m recordingMetrics
+		if err := tt.decode(WithMetrics(&m)); err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if len(m.calls) != 1 {
+			t.Fatalf("%s: %d calls, want 1", tt.path, len(m.calls))
+		}
+		if c := m.calls[0]; c.path != tt.path || c.n != int64(len(b)) || c.err != nil {
+			t.Errorf("%s: got %+v, want %d bytes", tt.path, c, len(b))
+		}
+	}
+}
+
+func TestMetricsFailure(t *testing.T) {
+	b := encodeRGBA(t, 4, 3)
+
+	var m recordingMetrics
+	if _, err := Decode(bytes.NewReader(b[:len(b)-1]), WithMetrics(&m)); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(m.calls) != 1 || ErrorKind(m.calls[0].err) != "truncated" {
+		t.Errorf("unexpected calls: %+v", m.calls)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{ErrLimitExceeded, "limit"},
+		{fmt.Errorf("rows: %w", ErrInvalidRLE), "rle"},
+		{ErrCompressed, "compressed"},
+		{io.ErrUnexpectedEOF, "truncated"},
+		{errors.New("bmp: unsupported compression method"), "format"},
+		{errors.New("read failed"), "io"},
+	}
+
+	for _, tt := range tests {
+		if got := ErrorKind(tt.err); got != tt.want {
+			t.Errorf("ErrorKind(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// expvarRuns keeps the names of the maps unique under -count.
+var expvarRuns int
+
+func TestExpvarMetrics(t *testing.T) {
+	expvarRuns++
+	e := NewExpvarMetrics(fmt.Sprintf("bmp_test_metrics_%d", expvarRuns))
+	e.Decoded("image", 100, time.Millisecond, nil)
+	e.Decoded("image", 10, time.Millisecond, io.ErrUnexpectedEOF)
+
+	for key, want := range map[string]string{
+		"bytes":            "110",
+		"decoded":          "1",
+		"failed":           "1",
+		"failed.truncated": "1",
+		"decoded.image":    "2",
+		"ns.image":         "2000000",
+	} {
+		v := e.Map().Get(key)
+		if v == nil || v.String() != want {
+			t.Errorf("%s = %v, want %s", key, v, want)
+		}
+	}
+}
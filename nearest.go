@@ -0,0 +1,117 @@
+package bmp
+
+import "image/color"
+
+// nearestCacheBits is the log2 of the number of entries of the nearest
+// color cache.
+const nearestCacheBits = 14
+
+// nearest finds the nearest palette entries of colors, like
+// color.Palette.Index but without its per-call interface conversions, and
+// remembers the results in a direct-mapped cache since photos repeat many
+// colors.
+//
+// With a transparent entry, translucent colors are matched on their
+// straight color, weighted by their alpha, rather than on their
+// premultiplied one, which darkens the anti-aliased edges of shapes.
+type nearest struct {
+	colors [][4]int32
+	cache  []nearestEntry
+	// transparent is the index of the transparent entry, or -1
+	transparent int
+}
+
+type nearestEntry struct {
+	key uint32
+	idx uint8
+	ok  bool
+}
+
+// newNearest returns the matcher of the palette p, whose entry at index
+// transparent, if not negative, is the transparent color.
+func newNearest(p color.Palette, transparent int) *nearest {
+	n := &nearest{
+		colors:      make([][4]int32, len(p)),
+		cache:       make([]nearestEntry, 1<<nearestCacheBits),
+		transparent: transparent,
+	}
+
+	for i, c := range p {
+		if transparent >= 0 {
+			nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+			n.colors[i] = [4]int32{int32(nc.R), int32(nc.G), int32(nc.B), int32(nc.A)}
+			continue
+		}
+		r, g, b, a := c.RGBA()
+		n.colors[i] = [4]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8), int32(a >> 8)}
+	}
+
+	return n
+}
+
+// index returns the index of the palette entry nearest to c.
+func (n *nearest) index(c color.RGBA) uint8 {
+	key := uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+	// Fibonacci hashing spreads neighboring colors over the cache
+	e := &n.cache[(key*2654435769)>>(32-nearestCacheBits)]
+	if e.ok && e.key == key {
+		return e.idx
+	}
+
+	var best int
+	if n.transparent >= 0 {
+		best = n.weightedIndex(c)
+	} else {
+		best = n.euclideanIndex(c)
+	}
+
+	*e = nearestEntry{key, uint8(best), true}
+
+	return uint8(best)
+}
+
+// euclideanIndex returns the index of the entry nearest to c in Euclidean
+// distance.
+func (n *nearest) euclideanIndex(c color.RGBA) int {
+	best, bestDist := 0, int32(1<<31-1)
+	for i, p := range n.colors {
+		dr, dg, db, da := int32(c.R)-p[0], int32(c.G)-p[1], int32(c.B)-p[2], int32(c.A)-p[3]
+		dist := dr*dr + dg*dg + db*db + da*da
+		if dist < bestDist {
+			best, bestDist = i, dist
+			if dist == 0 {
+				break
+			}
+		}
+	}
+
+	return best
+}
+
+// weightedIndex returns the transparent entry for colors less than half
+// opaque, and otherwise the index of the other entry nearest to the
+// straight color of c, the distance of the color samples being weighted by
+// the alpha of c.
+func (n *nearest) weightedIndex(c color.RGBA) int {
+	if c.A < 0x80 {
+		return n.transparent
+	}
+	nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+
+	best, bestDist := n.transparent, int32(1<<31-1)
+	for i, p := range n.colors {
+		if i == n.transparent {
+			continue
+		}
+		dr, dg, db, da := int32(nc.R)-p[0], int32(nc.G)-p[1], int32(nc.B)-p[2], int32(nc.A)-p[3]
+		dist := (dr*dr+dg*dg+db*db)*int32(nc.A)/0xff + da*da
+		if dist < bestDist {
+			best, bestDist = i, dist
+			if dist == 0 {
+				break
+			}
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,77 @@
+package bmp
+
+import (
+	"image/color"
+	"io/ioutil"
+	"testing"
+)
+
+func TestNearest(t *testing.T) {
+	p := testPaletted(1, 1, 200).Palette
+	n := newNearest(p, -1)
+
+	dist := func(c color.RGBA, i int) int {
+		q := color.RGBAModel.Convert(p[i]).(color.RGBA)
+		dr, dg, db, da := int(c.R)-int(q.R), int(c.G)-int(q.G), int(c.B)-int(q.B), int(c.A)-int(q.A)
+		return dr*dr + dg*dg + db*db + da*da
+	}
+
+	for pass := 0; pass < 2; pass++ {
+		for i := 0; i < 4096; i++ {
+			c := color.RGBA{uint8(i * 7), uint8(i * 13), uint8(i >> 4), 0xff}
+			got := int(n.index(c))
+			if d, expected := dist(c, got), dist(c, p.Index(c)); d != expected {
+				t.Fatalf("pass %d: color %v maps to a distance of %d, expected %d", pass, c, d, expected)
+			}
+		}
+	}
+}
+
+func TestNearestTransparent(t *testing.T) {
+	p := color.Palette{
+		color.RGBA{0, 0, 0, 0xff},
+		color.RGBA{0xff, 0xff, 0xff, 0xff},
+		color.RGBA{0x60, 0x60, 0x60, 0xff},
+	}
+	n := newNearest(p, 0)
+
+	tests := []struct {
+		c        color.NRGBA
+		expected uint8
+	}{
+		{color.NRGBA{0xff, 0xff, 0xff, 0xff}, 1},
+		// an anti-aliased edge keeps its color rather than getting darker
+		{color.NRGBA{0xff, 0xff, 0xff, 0x90}, 1},
+		{color.NRGBA{0x50, 0x50, 0x50, 0xc0}, 2},
+		{color.NRGBA{0xff, 0xff, 0xff, 0x40}, 0},
+		// the transparent color is not matched by opaque pixels
+		{color.NRGBA{0, 0, 0, 0xff}, 2},
+	}
+
+	for _, tt := range tests {
+		if got := n.index(color.RGBAModel.Convert(tt.c).(color.RGBA)); got != tt.expected {
+			t.Errorf("color %v maps to %d, expected %d", tt.c, got, tt.expected)
+		}
+	}
+
+	// without a transparent entry, premultiplied colors are compared
+	if got := newNearest(p, -1).index(color.RGBAModel.Convert(tests[1].c).(color.RGBA)); got != 2 {
+		t.Errorf("translucent white maps to %d without a transparent entry, expected 2", got)
+	}
+}
+
+func BenchmarkEncode8(b *testing.B) {
+	m := testImage(256, 256)
+	for i := range m.Pix {
+		m.Pix[i] ^= uint8(i * 31)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e := NewEncoder(ioutil.Discard)
+		e.SetDepth(8)
+		if err := e.Encode(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
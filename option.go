@@ -0,0 +1,361 @@
+package bmp
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"sync"
+)
+
+// ErrLimitExceeded is returned when an image exceeds one of the configured
+// Limits.
+var ErrLimitExceeded = errors.New("bmp: limit exceeded")
+
+// Limits bounds the resources a decode is allowed to consume. A zero field
+// means no limit.
+type Limits struct {
+	// MaxPixels is the maximum value of width*height.
+	MaxPixels int64
+	// MaxPaletteEntries is the maximum number of color table entries.
+	MaxPaletteEntries int
+	// MaxFileSize is the maximum number of bytes read from the input.
+	MaxFileSize int64
+	// MaxRLERatio is the maximum ratio of pixels to bytes of RLE
+	// compressed pixel data, as declared by the header or left in the
+	// input if fewer. It rejects tiny files expanding to huge images
+	// before any allocation.
+	MaxRLERatio int64
+}
+
+// DefaultMaxPixels is the default value of Limits.MaxPixels, large enough
+// for any legitimate image but refusing headers that would make
+// image.Decode allocate gigabytes.
+const DefaultMaxPixels = 1 << 28
+
+// DefaultMaxRLERatio is the default value of Limits.MaxRLERatio. Encoders
+// ending every row with a marker stay below 128 pixels per byte; larger
+// ratios need deltas or an early end of bitmap skipping most of the image.
+const DefaultMaxRLERatio = 1 << 12
+
+var (
+	defaultLimitsMu sync.RWMutex
+	defaultLimits   = Limits{MaxPixels: DefaultMaxPixels, MaxRLERatio: DefaultMaxRLERatio}
+)
+
+// SetDefaultLimits sets the limits used by decodes without a WithLimits
+// option, including the ones started through image.Decode and
+// image.DecodeConfig. The initial limits only set MaxPixels to
+// DefaultMaxPixels and MaxRLERatio to DefaultMaxRLERatio;
+// SetDefaultLimits(Limits{}) removes every limit.
+func SetDefaultLimits(l Limits) {
+	defaultLimitsMu.Lock()
+	defaultLimits = l
+	defaultLimitsMu.Unlock()
+}
+
+// DefaultLimits returns the limits set by SetDefaultLimits.
+func DefaultLimits() Limits {
+	defaultLimitsMu.RLock()
+	defer defaultLimitsMu.RUnlock()
+
+	return defaultLimits
+}
+
+// Option configures Decode, DecodeConfig and NewDecoder.
+type Option func(*options)
+
+type options struct {
+	limits   Limits
+	lenient  bool
+	model    color.Model
+	progress func(rows, height int)
+
+	paletteAlpha bool
+	toSRGB       bool
+	bottomUp     bool
+	order        ChannelOrder
+	stride       int
+	align        int
+	linear       bool
+	bitmap       bool
+
+	strictPalette bool
+	metrics       Metrics
+	tracer        Tracer
+	transformer   ColorTransformer
+	alpha         AlphaMode
+	inputSize     int64
+	alphaMask     bool
+	counter       *ColorCounter
+	budget        int64
+	warn          func(w Warning)
+}
+
+func newOptions(opts []Option) options {
+	o := options{limits: DefaultLimits(), alpha: AlphaAuto}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithLimits sets the resource limits applied while decoding, overriding the
+// default limits.
+func WithLimits(l Limits) Option {
+	return func(o *options) {
+		o.limits = l
+	}
+}
+
+// WithLenient makes the decoder tolerate common violations of the format,
+// such as a gap between the color table and the pixel data or a zero pixel
+// offset, in which case the pixel data is assumed to follow the color
+// table. Color table entries past the number of colors of the depth are
+// skipped, and a negative width is taken as rows stored from right to left,
+// which are mirrored back. The known bugs of a few writers, listed by the
+// Quirk constants, are worked around as well. Uncompressed rows are read
+// with 2-byte or no padding when the pixel data is too short for 4-byte
+// padding and matches those. Decoder.Warnings lists what was tolerated.
+func WithLenient(lenient bool) Option {
+	return func(o *options) {
+		o.lenient = lenient
+	}
+}
+
+// WithColorModel converts the decoded image to the given color model.
+// Supported models are the ones from image/color, color.Palette, RGBModel,
+// BGRAModel and RGB565Model. RGBModel, BGRAModel and RGB565Model images are
+// decoded directly, without an intermediate image.
+func WithColorModel(m color.Model) Option {
+	return func(o *options) {
+		o.model = m
+	}
+}
+
+// WithProgress registers a callback invoked after each decoded row with the
+// number of rows decoded so far and the image height.
+func WithProgress(fn func(rows, height int)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// WithPaletteAlpha makes the decoder use the fourth byte of the color table
+// entries as alpha, which yields translucent paletted images. The byte is
+// still ignored if it is zero for every entry, as written by most encoders.
+func WithPaletteAlpha(enabled bool) Option {
+	return func(o *options) {
+		o.paletteAlpha = enabled
+	}
+}
+
+// WithSRGBConversion makes the decoder convert the pixels of images with
+// calibrated RGB endpoints (see ColorSpace) to sRGB. Other images are not
+// affected.
+func WithSRGBConversion(enabled bool) Option {
+	return func(o *options) {
+		o.toSRGB = enabled
+	}
+}
+
+// WithBottomUpRows makes DecodeStream accept bottom-up images and deliver
+// their rows in reversed order.
+func WithBottomUpRows(allowed bool) Option {
+	return func(o *options) {
+		o.bottomUp = allowed
+	}
+}
+
+// WithChannelOrder sets the order of the samples of each pixel in the
+// buffer returned by DecodeRaw.
+func WithChannelOrder(order ChannelOrder) Option {
+	return func(o *options) {
+		o.order = order
+	}
+}
+
+// WithStride sets the stride (in bytes) between the rows of the decoded
+// image, which must be at least the length of a row. Only images decoded
+// directly, without conversion by WithColorModel, and DecodeRaw are
+// affected.
+func WithStride(stride int) Option {
+	return func(o *options) {
+		o.stride = stride
+	}
+}
+
+// WithRowAlignment pads the rows of the decoded image to a multiple of
+// align bytes, which must be a power of two, for instance to use them for
+// SIMD processing. It applies to the same images as WithStride, which takes
+// precedence.
+func WithRowAlignment(align int) Option {
+	return func(o *options) {
+		o.align = align
+	}
+}
+
+// WithLinearLight makes DecodeScaled and DecodeMipmaps convert the samples
+// from sRGB to linear light before filtering and back after, so that
+// downscaled images keep the brightness of the original instead of
+// darkening high contrast areas.
+func WithLinearLight(enabled bool) Option {
+	return func(o *options) {
+		o.linear = enabled
+	}
+}
+
+// UntrustedLimits returns the limits set by WithUntrusted.
+func UntrustedLimits() Limits {
+	return Limits{
+		MaxPixels:         1 << 26,
+		MaxPaletteEntries: 256,
+		MaxFileSize:       1 << 29,
+		MaxRLERatio:       1024,
+	}
+}
+
+// WithUntrusted hardens the decoder for arbitrary user uploads: it sets
+// UntrustedLimits, rejects palette indices past the color table and
+// disables the lenient parsing and the heuristics of WithLenient,
+// WithPaletteAlpha and AlphaAuto, so that the pixel offset and the sizes
+// declared by the headers must be exact and the pixel data is read once,
+// without buffering. Options following it can relax it again.
+func WithUntrusted() Option {
+	return func(o *options) {
+		o.limits = UntrustedLimits()
+		o.lenient = false
+		o.paletteAlpha = false
+		o.strictPalette = true
+		o.alpha = AlphaStraight
+	}
+}
+
+// WithBitmap makes 1 bpp uncompressed images decode into a Bitmap, which
+// takes 8 times less memory than the image.Paletted returned by default.
+// WithColorModel does not apply to them.
+func WithBitmap(enabled bool) Option {
+	return func(o *options) {
+		o.bitmap = enabled
+	}
+}
+
+// WithAlphaMask makes 8 bpp paletted images, such as the masks and
+// stencils stored with a grayscale palette, decode into an image.Alpha
+// holding the gray level of the palette entry of each pixel, which saves a
+// conversion pass before compositing. WithColorModel does not apply to
+// them.
+func WithAlphaMask(enabled bool) Option {
+	return func(o *options) {
+		o.alphaMask = enabled
+	}
+}
+
+// WithMetrics reports the decoding of each image to m. It costs a call to
+// time.Now and the counting of the bytes read.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithTracer records a span to t for each image whose pixel data was
+// decoded, or failed to.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// WithColorTransformer converts the decoded colors with t, after the
+// conversion of WithSRGBConversion if both are set.
+func WithColorTransformer(t ColorTransformer) Option {
+	return func(o *options) {
+		o.transformer = t
+	}
+}
+
+// AlphaMode is the meaning given to the fourth byte of 32 bpp pixels
+// without an alpha mask, which the format reserves.
+type AlphaMode int
+
+// Alpha modes. Writers disagree on the reserved byte: many leave it zero,
+// others store straight or premultiplied alpha in it.
+const (
+	// AlphaStraight treats the byte as non-premultiplied alpha.
+	AlphaStraight AlphaMode = iota
+	// AlphaIgnore makes every pixel opaque.
+	AlphaIgnore
+	// AlphaPremultiplied treats the byte as alpha the color samples are
+	// premultiplied by. They are un-premultiplied while decoding.
+	AlphaPremultiplied
+	// AlphaAuto treats the byte as non-premultiplied alpha, unless it is
+	// zero for every pixel, in which case it is ignored as with
+	// AlphaIgnore. The pixel data is scanned before it is decoded, which
+	// reads it twice from an io.Seeker and buffers it otherwise.
+	AlphaAuto
+)
+
+// String returns the name of the mode.
+func (m AlphaMode) String() string {
+	switch m {
+	case AlphaStraight:
+		return "straight"
+	case AlphaIgnore:
+		return "ignore"
+	case AlphaPremultiplied:
+		return "premultiplied"
+	case AlphaAuto:
+		return "auto"
+	}
+
+	return fmt.Sprintf("AlphaMode(%d)", int(m))
+}
+
+// WithAlphaMode sets the meaning of the reserved fourth byte of 32 bpp
+// pixels, for the images without bit fields and the ones whose bit fields
+// have no alpha mask. The default is AlphaAuto.
+func WithAlphaMode(m AlphaMode) Option {
+	return func(o *options) {
+		o.alpha = m
+	}
+}
+
+// WithInputSize sets the length in bytes of the input, starting with the
+// file header, so that images whose uncompressed pixel data cannot fit in
+// it are rejected before the image is allocated. Without it, the length is
+// taken from readers with a Len method, such as bytes.Reader, or from
+// seekers such as os.File.
+func WithInputSize(n int64) Option {
+	return func(o *options) {
+		o.inputSize = n
+	}
+}
+
+// WithColorCounter counts the distinct colors of the decoded rows with c,
+// for instance to choose the depth of an image or to audit a dataset
+// without keeping the images. The pixels decoded by DecodeTiles and
+// Sanitize are not counted.
+func WithColorCounter(c *ColorCounter) Option {
+	return func(o *options) {
+		o.counter = c
+	}
+}
+
+// WithMemoryBudget sets the number of bytes of decoded pixels DecodeAuto
+// may hold at once, so that the strategy is chosen per image from a single
+// setting. Zero, the default, means no budget.
+func WithMemoryBudget(n int64) Option {
+	return func(o *options) {
+		o.budget = n
+	}
+}
+
+// WithWarningHandler calls fn with each violation of the format tolerated
+// by WithLenient, as returned by Decoder.Warnings, once the headers are
+// read, so that callers can log what was tolerated.
+func WithWarningHandler(fn func(w Warning)) Option {
+	return func(o *options) {
+		o.warn = fn
+	}
+}
@@ -0,0 +1,214 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+// ParserEvents are the callbacks of a Parser. Nil callbacks are skipped.
+type ParserEvents struct {
+	// Config is called once the headers and the color table are parsed.
+	Config func(config image.Config, meta Metadata) error
+	// Row is called for each row, in the order and format of RowReader.
+	// The row slice is reused after Row returns.
+	Row func(y int, row []byte) error
+}
+
+// Parser decodes a BMP image pushed to it with Write, calling its events as
+// soon as enough bytes are available. It suits network streams where a
+// blocking io.Reader is awkward. Everything up to the pixel offset is
+// buffered, which is bounded by the largest headers, color table and
+// embedded profile, then only the incomplete row, so the pixel data must
+// be uncompressed. With AlphaAuto, the rows of 32 bpp images whose fourth
+// byte is reserved are held until a pixel has a non-zero one, or until the
+// last row if none has.
+type Parser struct {
+	events ParserEvents
+	opts   []Option
+	d      *Decoder
+	buf    []byte
+	row    []byte
+	rows   int
+	// scanned counts the rows held for AlphaAuto
+	scanned int
+	total   int64
+	err     error
+}
+
+// NewParser returns a Parser calling ev.
+func NewParser(ev ParserEvents, opts ...Option) *Parser {
+	return &Parser{events: ev, opts: opts}
+}
+
+// Write consumes the next bytes of the image. Bytes following the pixel
+// data are ignored. Errors are sticky: once Write fails, it keeps failing
+// with the same error.
+func (p *Parser) Write(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+
+	p.total += int64(len(b))
+	if max := newOptions(p.opts).limits.MaxFileSize; max > 0 && p.total > max {
+		p.err = fmt.Errorf("%w: file is larger than the maximum size", ErrLimitExceeded)
+		return 0, p.err
+	}
+
+	if p.d != nil && p.rows == p.d.height {
+		return len(b), nil
+	}
+
+	p.buf = append(p.buf, b...)
+	if p.d == nil {
+		if p.err = p.parseHeader(); p.err != nil || p.d == nil {
+			return len(b), p.err
+		}
+	}
+
+	p.err = p.parseRows()
+	return len(b), p.err
+}
+
+// parseHeader decodes the headers once the pixel offset is reached.
+func (p *Parser) parseHeader() error {
+	const fileHeaderLen = 14
+
+	if len(p.buf) >= 2 && string(p.buf[:2]) != "BM" {
+		return fmt.Errorf("bmp: invalid file signature (got: %q)", p.buf[:2])
+	}
+	if len(p.buf) < fileHeaderLen+4 {
+		return nil
+	}
+	max, ok := maxPixelOffset(p.buf)
+	if !ok {
+		return nil
+	}
+	off := binary.LittleEndian.Uint32(p.buf[10:14])
+	if uint64(off) > max {
+		return fmt.Errorf("bmp: pixel offset is past the headers and the color table (got: %d, max: %d)", off, max)
+	}
+	if uint64(len(p.buf)) < uint64(off) {
+		return nil
+	}
+
+	r := bytes.NewReader(p.buf)
+	d := NewDecoder(r, p.opts...)
+	// the buffer ends wherever the last write did
+	d.partial = true
+	if _, err := d.Config(); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			// the pixel offset is wrong, wait for more data
+			return nil
+		}
+		return err
+	}
+
+	if d.compressed() {
+		return ErrCompressed
+	}
+
+	p.d = d
+	p.buf = p.buf[len(p.buf)-r.Len():]
+	p.row = make([]byte, d.rowLen())
+
+	if p.events.Config != nil {
+		return p.events.Config(d.config, d.meta)
+	}
+
+	return nil
+}
+
+// maxProfileLen bounds the embedded profiles a Parser buffers before the
+// pixel data, which are a few kilobytes in practice.
+const maxProfileLen = 1 << 24
+
+// maxPixelOffset returns the largest pixel offset of the file starting with
+// b, which holds at least the length of the DIB header: the file header,
+// the DIB header, the bit field masks, the largest color table and the
+// embedded profile of V5 headers. ok is false if more of the DIB header is
+// needed to tell.
+func maxPixelOffset(b []byte) (max uint64, ok bool) {
+	const fileHeaderLen = 14
+
+	dibLen := uint64(binary.LittleEndian.Uint32(b[14:18]))
+	if dibLen > v5HeaderLen {
+		// rejected by the decoder
+		dibLen = v5HeaderLen
+	}
+	max = fileHeaderLen + dibLen + 12 + 256*4
+	if dibLen < v5HeaderLen {
+		return max, true
+	}
+
+	if len(b) < fileHeaderLen+v5HeaderLen {
+		return 0, false
+	}
+	if ColorSpaceType(binary.LittleEndian.Uint32(b[fileHeaderLen+56:])) == ColorSpaceProfileEmbedded {
+		size := uint64(binary.LittleEndian.Uint32(b[fileHeaderLen+116:]))
+		if size > maxProfileLen {
+			size = maxProfileLen
+		}
+		max += size
+	}
+
+	return max, true
+}
+
+// parseRows emits the complete rows in the buffer.
+func (p *Parser) parseRows() error {
+	d := p.d
+	stride := len(d.row)
+
+	if d.autoAlpha() {
+		n := len(p.buf) / stride
+		if n > d.height {
+			n = d.height
+		}
+		zero := d.zeroAlpha(bytes.NewReader(p.buf[p.scanned*stride : n*stride]))
+		p.scanned = n
+		if zero && n < d.height {
+			return nil
+		}
+		d.alphaResolved = true
+		d.setZeroAlpha(zero)
+	}
+
+	i := 0
+	for ; p.rows < d.height && len(p.buf)-i >= stride; i += stride {
+		if err := d.convertRow(p.row, p.buf[i:i+stride]); err != nil {
+			return err
+		}
+		p.rows++
+		d.rowDone(p.rows)
+
+		if p.events.Row != nil {
+			if err := p.events.Row(d.rowY(p.rows-1), p.row); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.buf = append(p.buf[:0], p.buf[i:]...)
+	if p.rows == d.height {
+		p.buf = nil
+	}
+
+	return nil
+}
+
+// Close reports whether the whole image has been written, returning
+// io.ErrUnexpectedEOF if it has not.
+func (p *Parser) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if p.d == nil || p.rows < p.d.height {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
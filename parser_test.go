@@ -0,0 +1,113 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestParser(t *testing.T) {
+	src := testImage(5, 4)
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	for _, chunk := range []int{1, 7, len(data)} {
+		var config image.Config
+		m := image.NewRGBA(src.Bounds())
+		p := NewParser(ParserEvents{
+			Config: func(c image.Config, meta Metadata) error {
+				config = c
+				return nil
+			},
+			Row: func(y int, row []byte) error {
+				copy(m.Pix[y*m.Stride:], row)
+				return nil
+			},
+		})
+
+		for i := 0; i < len(data); i += chunk {
+			end := i + chunk
+			if end > len(data) {
+				end = len(data)
+			}
+			if _, err := p.Write(data[i:end]); err != nil {
+				t.Fatal(err)
+			}
+			if end < len(data) {
+				if err := p.Close(); err != io.ErrUnexpectedEOF {
+					t.Fatalf("chunk %d: Close before the end = %v, expected %v", chunk, err, io.ErrUnexpectedEOF)
+				}
+			}
+		}
+
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != 5 || config.Height != 4 {
+			t.Errorf("chunk %d: config = %dx%d, expected 5x4", chunk, config.Width, config.Height)
+		}
+		if !sameImage(m, src) {
+			t.Errorf("chunk %d: image differs from the source", chunk)
+		}
+	}
+}
+
+func TestParserInvalid(t *testing.T) {
+	p := NewParser(ParserEvents{})
+	if _, err := p.Write(bytes.Repeat([]byte{'X'}, 64)); err == nil {
+		t.Fatal("expected an error for an invalid header")
+	}
+	if _, err := p.Write([]byte{0}); err == nil {
+		t.Error("expected the error to be sticky")
+	}
+}
+
+func TestParserLenientStride(t *testing.T) {
+	// 5 pixels of 24 bpp take 15 bytes, padded to 16
+	src := testImage(5, 4)
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	m := image.NewRGBA(src.Bounds())
+	p := NewParser(ParserEvents{
+		Row: func(y int, row []byte) error {
+			copy(m.Pix[y*m.Stride:], row)
+			return nil
+		},
+	}, WithLenient(true))
+	// the first chunk holds 60 bytes of pixel data, 15 per row, which must
+	// not be taken for unpadded rows
+	for _, chunk := range [][]byte{data[:54+60], data[54+60:]} {
+		if _, err := p.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(m, src) {
+		t.Error("image differs from the source")
+	}
+}
+
+func TestParserForgedOffset(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(testImage(5, 4)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data[10:14], 0xffffffff)
+
+	p := NewParser(ParserEvents{})
+	if _, err := p.Write(data[:54]); err == nil {
+		t.Error("expected an error for a pixel offset past the largest headers")
+	}
+}
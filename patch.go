@@ -0,0 +1,110 @@
+package bmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// HeaderPatch lists the header fields changed by PatchHeader. Nil or zero
+// fields are left as they are.
+type HeaderPatch struct {
+	// XDPI and YDPI replace the resolution, in dots per inch, as set by
+	// Encoder.SetResolution.
+	XDPI, YDPI int
+	// ColorSpace replaces the color space of V4 and V5 headers, with the
+	// restrictions of Encoder.SetColorSpace.
+	ColorSpace *ColorSpace
+	// ColorsImportant replaces the number of important colors
+	// (biClrImportant).
+	ColorsImportant *int
+	// Reserved replaces the reserved fields of the file header
+	// (bfReserved1 and bfReserved2).
+	Reserved *uint32
+}
+
+// PatchHeader rewrites the header fields listed in p of the BMP file read
+// from rw at its current position, writing the headers back in place
+// without reading or moving the pixel data, so that the metadata of large
+// files is fixed quickly. The fields must exist in the header of the file:
+// BITMAPCOREHEADER has neither resolution nor important colors and only V4
+// and V5 headers have a color space, which cannot be replaced when it
+// locates a color profile.
+func PatchHeader(rw io.ReadWriteSeeker, p HeaderPatch) error {
+	const fileHeaderLen = 14
+
+	start, err := rw.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, fileHeaderLen+v5HeaderLen)
+	if _, err := io.ReadFull(rw, b[:fileHeaderLen+4]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if string(b[0:2]) != "BM" {
+		return fmt.Errorf("bmp: invalid file signature (got: %q)", b[0:2])
+	}
+	dibLen := int(binary.LittleEndian.Uint32(b[14:18]))
+	if dibLen != coreHeaderLen && dibLen < infoHeaderLen {
+		return fmt.Errorf("bmp: unsupported DIB header length (got: %d)", dibLen)
+	}
+	if dibLen > v5HeaderLen {
+		// the fields of larger headers are the ones of V5 headers
+		dibLen = v5HeaderLen
+	}
+	b = b[:fileHeaderLen+dibLen]
+	if _, err := io.ReadFull(rw, b[fileHeaderLen+4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if p.Reserved != nil {
+		binary.LittleEndian.PutUint32(b[6:10], *p.Reserved)
+	}
+
+	if p.XDPI != 0 || p.YDPI != 0 || p.ColorsImportant != nil {
+		if dibLen == coreHeaderLen {
+			return errors.New("bmp: core header has no resolution nor important colors")
+		}
+	}
+	if p.XDPI != 0 {
+		binary.LittleEndian.PutUint32(b[38:42], uint32(dpiToPPM(p.XDPI)))
+	}
+	if p.YDPI != 0 {
+		binary.LittleEndian.PutUint32(b[42:46], uint32(dpiToPPM(p.YDPI)))
+	}
+	if p.ColorsImportant != nil {
+		if *p.ColorsImportant < 0 {
+			return fmt.Errorf("bmp: number of important colors out of range (got: %d)", *p.ColorsImportant)
+		}
+		binary.LittleEndian.PutUint32(b[50:54], uint32(*p.ColorsImportant))
+	}
+
+	if p.ColorSpace != nil {
+		if dibLen < v4HeaderLen {
+			return fmt.Errorf("bmp: %d-byte header has no color space", dibLen)
+		}
+		if err := p.ColorSpace.checkWritable(); err != nil {
+			return err
+		}
+		switch ColorSpaceType(binary.LittleEndian.Uint32(b[fileHeaderLen+56:])) {
+		case ColorSpaceProfileLinked, ColorSpaceProfileEmbedded:
+			return errors.New("bmp: color space of an image with a color profile cannot be replaced")
+		}
+		writeColorSpace(b[fileHeaderLen+56:], *p.ColorSpace)
+	}
+
+	if _, err := rw.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = rw.Write(b)
+
+	return err
+}
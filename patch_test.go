@@ -0,0 +1,84 @@
+package bmp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPatchHeader(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(8)
+	e.SetColorSpace(ColorSpace{Type: ColorSpaceSRGB})
+	if err := e.Encode(testPaletted(5, 3, 16)); err != nil {
+		t.Fatal(err)
+	}
+	orig := buf.Bytes()
+
+	f, err := ioutil.TempFile("", "bmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(orig); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	important, reserved := 3, uint32(0x12345678)
+	err = PatchHeader(f, HeaderPatch{
+		XDPI:            300,
+		YDPI:            150,
+		ColorSpace:      &ColorSpace{Type: ColorSpaceWindows},
+		ColorsImportant: &important,
+		Reserved:        &reserved,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patched, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patched) != len(orig) || !bytes.Equal(patched[14+108:], orig[14+108:]) {
+		t.Error("bytes after the headers changed")
+	}
+
+	meta, err := NewDecoder(bytes.NewReader(patched)).Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.XPelsPerMeter != dpiToPPM(300) || meta.YPelsPerMeter != dpiToPPM(150) {
+		t.Errorf("resolution %dx%d", meta.XPelsPerMeter, meta.YPelsPerMeter)
+	}
+	if meta.ColorSpace == nil || meta.ColorSpace.Type != ColorSpaceWindows {
+		t.Errorf("color space %+v", meta.ColorSpace)
+	}
+	if meta.ColorsImportant != 3 || meta.Reserved != reserved {
+		t.Errorf("important colors %d, reserved %#x", meta.ColorsImportant, meta.Reserved)
+	}
+
+	// info headers have no color space
+	buf.Reset()
+	if err := NewEncoder(&buf).Encode(testImage(2, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := PatchHeader(f, HeaderPatch{ColorSpace: &ColorSpace{}}); err == nil {
+		t.Error("patching the color space of an info header succeeded")
+	}
+}
@@ -0,0 +1,225 @@
+// Package peres extracts the bitmap resources of Windows executables.
+package peres
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"unicode/utf16"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+const (
+	// resourceDir is the index of the resource directory among the data
+	// directories.
+	resourceDir = 2
+	// typeBitmap is the RT_BITMAP resource type.
+	typeBitmap = 2
+)
+
+// Bitmap is a bitmap resource.
+type Bitmap struct {
+	// ID is the integer identifier of the resource, zero if it is named.
+	ID int
+	// Name is the name of the resource, empty if it has an ID.
+	Name string
+	// Lang is the language identifier of the resource.
+	Lang int
+	// Image is the decoded bitmap.
+	Image image.Image
+}
+
+// Bitmaps reads the PE executable or DLL in r and returns its RT_BITMAP
+// resources, which are headerless DIBs, in the order of the resource
+// directory. It returns no bitmaps if the file has no resources.
+func Bitmaps(r io.ReaderAt, opts ...bmp.Option) ([]Bitmap, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dir pe.DataDirectory
+	switch h := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if h.NumberOfRvaAndSizes > resourceDir {
+			dir = h.DataDirectory[resourceDir]
+		}
+	case *pe.OptionalHeader64:
+		if h.NumberOfRvaAndSizes > resourceDir {
+			dir = h.DataDirectory[resourceDir]
+		}
+	}
+	if dir.VirtualAddress == 0 {
+		return nil, nil
+	}
+
+	var s *pe.Section
+	for _, sec := range f.Sections {
+		if dir.VirtualAddress >= sec.VirtualAddress && dir.VirtualAddress < sec.VirtualAddress+sec.VirtualSize {
+			s = sec
+			break
+		}
+	}
+	if s == nil {
+		return nil, fmt.Errorf("peres: resource directory outside of the sections (RVA: %#x)", dir.VirtualAddress)
+	}
+	data, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+	if int64(dir.VirtualAddress-s.VirtualAddress) >= int64(len(data)) {
+		return nil, fmt.Errorf("peres: resource directory outside of the section data (RVA: %#x)", dir.VirtualAddress)
+	}
+
+	rs := &resources{b: data[dir.VirtualAddress-s.VirtualAddress:], section: data, sectionBase: s.VirtualAddress}
+
+	types, err := rs.entries(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var bitmaps []Bitmap
+	for _, t := range types {
+		if t.name != nil || t.id != typeBitmap || !t.dir {
+			continue
+		}
+
+		names, err := rs.entries(t.offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			if !n.dir {
+				continue
+			}
+			langs, err := rs.entries(n.offset)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, l := range langs {
+				if l.dir {
+					continue
+				}
+				b, err := rs.data(l.offset)
+				if err != nil {
+					return nil, err
+				}
+
+				bm := Bitmap{ID: int(n.id), Lang: int(l.id)}
+				if n.name != nil {
+					bm.ID, bm.Name = 0, string(utf16.Decode(n.name))
+				}
+				if bm.Image, err = bmp.DecodeDIB(bytes.NewReader(b), opts...); err != nil {
+					return nil, fmt.Errorf("peres: bitmap %s: %v", bm.label(), err)
+				}
+				bitmaps = append(bitmaps, bm)
+			}
+		}
+	}
+
+	return bitmaps, nil
+}
+
+// label returns the name or the ID of b.
+func (b *Bitmap) label() string {
+	if b.Name != "" {
+		return fmt.Sprintf("%q", b.Name)
+	}
+
+	return fmt.Sprint(b.ID)
+}
+
+// resources reads a resource directory.
+type resources struct {
+	// b holds the directory, from its root
+	b []byte
+	// section holds the section of the directory, which data entries
+	// address by RVA
+	section     []byte
+	sectionBase uint32
+}
+
+// entry is an entry of a resource directory.
+type entry struct {
+	id   uint32
+	name []uint16
+	// dir reports whether offset is the one of a subdirectory rather than
+	// a data entry
+	dir    bool
+	offset uint32
+}
+
+// entries returns the entries of the directory at offset.
+func (rs *resources) entries(offset uint32) ([]entry, error) {
+	const dirLen, entryLen = 16, 8
+
+	if uint64(offset)+dirLen > uint64(len(rs.b)) {
+		return nil, fmt.Errorf("peres: resource directory out of range (offset: %#x)", offset)
+	}
+	h := rs.b[offset:]
+	n := int(binary.LittleEndian.Uint16(h[12:14])) + int(binary.LittleEndian.Uint16(h[14:16]))
+	if len(h) < dirLen+n*entryLen {
+		return nil, fmt.Errorf("peres: resource directory truncated (offset: %#x)", offset)
+	}
+
+	entries := make([]entry, n)
+	for i := range entries {
+		e := h[dirLen+i*entryLen:]
+		name := binary.LittleEndian.Uint32(e[0:4])
+		off := binary.LittleEndian.Uint32(e[4:8])
+
+		entries[i].offset = off &^ (1 << 31)
+		entries[i].dir = off&(1<<31) != 0
+		if name&(1<<31) == 0 {
+			entries[i].id = name
+			continue
+		}
+
+		s, err := rs.name(name &^ (1 << 31))
+		if err != nil {
+			return nil, err
+		}
+		entries[i].name = s
+	}
+
+	return entries, nil
+}
+
+// name returns the UTF-16 string at offset.
+func (rs *resources) name(offset uint32) ([]uint16, error) {
+	if uint64(offset)+2 > uint64(len(rs.b)) {
+		return nil, fmt.Errorf("peres: resource name out of range (offset: %#x)", offset)
+	}
+	n := int(binary.LittleEndian.Uint16(rs.b[offset:]))
+	if len(rs.b)-int(offset)-2 < 2*n {
+		return nil, fmt.Errorf("peres: resource name truncated (offset: %#x)", offset)
+	}
+
+	s := make([]uint16, n)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint16(rs.b[int(offset)+2+2*i:])
+	}
+
+	return s, nil
+}
+
+// data returns the bytes of the data entry at offset.
+func (rs *resources) data(offset uint32) ([]byte, error) {
+	if uint64(offset)+16 > uint64(len(rs.b)) {
+		return nil, fmt.Errorf("peres: resource data entry out of range (offset: %#x)", offset)
+	}
+	rva := binary.LittleEndian.Uint32(rs.b[offset:])
+	size := binary.LittleEndian.Uint32(rs.b[offset+4:])
+
+	if rva < rs.sectionBase || uint64(rva-rs.sectionBase)+uint64(size) > uint64(len(rs.section)) {
+		return nil, fmt.Errorf("peres: resource data out of the section (RVA: %#x, size: %d)", rva, size)
+	}
+
+	return rs.section[rva-rs.sectionBase : rva-rs.sectionBase+size], nil
+}
@@ -0,0 +1,175 @@
+package peres
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+	"unicode/utf16"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+const (
+	rsrcRVA    = 0x1000
+	rsrcOffset = 0x200
+)
+
+// resource is a bitmap resource of a test executable.
+type resource struct {
+	id   uint32
+	name string
+	dib  []byte
+}
+
+// resourceSection returns a resource section holding bitmaps, in English.
+func resourceSection(bitmaps []resource) []byte {
+	le := binary.LittleEndian
+	dir := func(b []byte, named, ids int) []byte {
+		h := make([]byte, 16)
+		le.PutUint16(h[12:], uint16(named))
+		le.PutUint16(h[14:], uint16(ids))
+		return append(b, h...)
+	}
+	ent := func(b []byte, name, offset uint32) []byte {
+		e := make([]byte, 8)
+		le.PutUint32(e, name)
+		le.PutUint32(e[4:], offset)
+		return append(b, e...)
+	}
+
+	// root, type and name levels, then the language directories, the data
+	// entries, the names and the data
+	n := len(bitmaps)
+	typeDir := 16 + 8
+	langDirs := typeDir + 16 + 8*n
+	dataEntries := langDirs + (16+8)*n
+	names := dataEntries + 16*n
+
+	var strs []byte
+	nameOffsets := make([]uint32, n)
+	for i, r := range bitmaps {
+		if r.name == "" {
+			continue
+		}
+		nameOffsets[i] = uint32(names + len(strs))
+		u := utf16.Encode([]rune(r.name))
+		strs = append(strs, uint8(len(u)), uint8(len(u)>>8))
+		for _, c := range u {
+			strs = append(strs, uint8(c), uint8(c>>8))
+		}
+	}
+	dataStart := (names + len(strs) + 3) &^ 3
+
+	var b []byte
+	b = dir(b, 0, 1)
+	b = ent(b, typeBitmap, 1<<31|uint32(typeDir))
+	b = dir(b, 0, n)
+	for i, r := range bitmaps {
+		name := r.id
+		if r.name != "" {
+			name = 1<<31 | nameOffsets[i]
+		}
+		b = ent(b, name, 1<<31|uint32(langDirs+24*i))
+	}
+	for i := range bitmaps {
+		b = dir(b, 0, 1)
+		b = ent(b, 0x409, uint32(dataEntries+16*i))
+	}
+	offset := dataStart
+	for _, r := range bitmaps {
+		e := make([]byte, 16)
+		le.PutUint32(e, uint32(rsrcRVA+offset))
+		le.PutUint32(e[4:], uint32(len(r.dib)))
+		b = append(b, e...)
+		offset += (len(r.dib) + 3) &^ 3
+	}
+	b = append(b, strs...)
+	for _, r := range bitmaps {
+		b = append(b, make([]byte, (len(b)+3)&^3-len(b))...)
+		b = append(b, r.dib...)
+	}
+
+	return b
+}
+
+// executable returns a PE32 file with a single resource section.
+func executable(rsrc []byte) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	le.PutUint32(dos[0x3c:], 0x40)
+	buf.Write(dos)
+	buf.WriteString("PE\x00\x00")
+
+	binary.Write(&buf, le, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_I386,
+		NumberOfSections:     1,
+		SizeOfOptionalHeader: uint16(binary.Size(pe.OptionalHeader32{})),
+	})
+	h := pe.OptionalHeader32{
+		Magic:               0x10b,
+		SectionAlignment:    0x1000,
+		FileAlignment:       0x200,
+		NumberOfRvaAndSizes: 16,
+	}
+	h.DataDirectory[resourceDir] = pe.DataDirectory{VirtualAddress: rsrcRVA, Size: uint32(len(rsrc))}
+	binary.Write(&buf, le, h)
+	binary.Write(&buf, le, pe.SectionHeader32{
+		Name:             [8]uint8{'.', 'r', 's', 'r', 'c'},
+		VirtualSize:      uint32(len(rsrc)),
+		VirtualAddress:   rsrcRVA,
+		SizeOfRawData:    uint32(len(rsrc)),
+		PointerToRawData: rsrcOffset,
+	})
+
+	buf.Write(make([]byte, rsrcOffset-buf.Len()))
+	buf.Write(rsrc)
+
+	return buf.Bytes()
+}
+
+// dib returns m encoded as a headerless 24 bpp DIB.
+func dib(t *testing.T, m image.Image) []byte {
+	var buf bytes.Buffer
+	if err := bmp.NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()[14:]
+}
+
+func TestBitmaps(t *testing.T) {
+	red := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	red.SetRGBA(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+	blue := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	blue.SetRGBA(0, 0, color.RGBA{0, 0, 0xff, 0xff})
+
+	exe := executable(resourceSection([]resource{
+		{id: 101, dib: dib(t, red)},
+		{name: "LOGO", dib: dib(t, blue)},
+	}))
+
+	bitmaps, err := Bitmaps(bytes.NewReader(exe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bitmaps) != 2 {
+		t.Fatalf("%d bitmaps, expected 2", len(bitmaps))
+	}
+
+	if b := bitmaps[0]; b.ID != 101 || b.Name != "" || b.Lang != 0x409 || b.Image.Bounds() != red.Rect || b.Image.At(0, 0) != red.At(0, 0) {
+		t.Errorf("unexpected first bitmap: %+v", b)
+	}
+	if b := bitmaps[1]; b.ID != 0 || b.Name != "LOGO" || b.Image.At(0, 0) != blue.At(0, 0) {
+		t.Errorf("unexpected second bitmap: %+v", b)
+	}
+
+	if _, err := Bitmaps(bytes.NewReader(exe[:0x100])); err == nil {
+		t.Errorf("expected an error for a truncated file")
+	}
+}
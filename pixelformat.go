@@ -0,0 +1,86 @@
+package bmp
+
+import "math/bits"
+
+// Channel locates a color channel in the pixels of a PixelFormat.
+type Channel struct {
+	// Mask selects the bits of the channel in the little-endian value of a
+	// pixel, zero if the pixels have no such channel.
+	Mask uint32
+	// Shift is the position of the lowest bit of Mask.
+	Shift int
+	// Width is the number of bits of Mask.
+	Width int
+}
+
+// newChannel returns the channel of the bits of mask.
+func newChannel(mask uint32) Channel {
+	if mask == 0 {
+		return Channel{}
+	}
+
+	return Channel{
+		Mask:  mask,
+		Shift: bits.TrailingZeros32(mask),
+		Width: bits.OnesCount32(mask),
+	}
+}
+
+// PixelFormat describes the pixel data of an image as stored in the file,
+// for the programs reading it themselves.
+type PixelFormat struct {
+	// BitsPerPixel is the number of bits per pixel (biBitCount).
+	BitsPerPixel int
+	// Red, Green, Blue and Alpha are the channels of true color pixels,
+	// from the bit fields or the defaults of the depth: 5-5-5 at 16 bpp
+	// and 8 bits per channel at 24 and 32 bpp, whose fourth byte is
+	// reserved (see WithAlphaMode). They are zero for paletted images.
+	Red, Green, Blue, Alpha Channel
+	// Paletted reports whether the pixels are indices into the color
+	// table, which has Colors entries.
+	Paletted bool
+	Colors   int
+	// Stride is the length in bytes of the rows, padded to 4 bytes unless
+	// WithLenient found shorter rows, once decompressed for RLE images. It is zero for JPEG, PNG and the other
+	// compressions whose data is not made of rows.
+	Stride int
+	// TopDown reports whether the rows are stored from top to bottom and
+	// Mirrored whether the pixels of the rows are stored from right to
+	// left, as in Metadata.
+	TopDown  bool
+	Mirrored bool
+}
+
+// PixelFormat returns the layout of the pixel data of the image.
+func (d *Decoder) PixelFormat() (PixelFormat, error) {
+	if err := d.advance(stageConfig); err != nil {
+		return PixelFormat{}, err
+	}
+
+	f := PixelFormat{
+		BitsPerPixel: d.bpp,
+		TopDown:      d.topDown,
+		Mirrored:     d.mirrored,
+	}
+	if d.codec != nil {
+		return f, nil
+	}
+	f.Stride = len(d.row)
+
+	k := d.key
+	switch {
+	case d.bpp <= 8:
+		f.Paletted = true
+		f.Colors = d.numColor
+		return f, nil
+	case k.Compression != CompressionRGB:
+	case d.bpp == 16:
+		k.RedMask, k.GreenMask, k.BlueMask = 0x7c00, 0x03e0, 0x001f
+	default:
+		k.RedMask, k.GreenMask, k.BlueMask = 0xff0000, 0x00ff00, 0x0000ff
+	}
+	f.Red, f.Green, f.Blue = newChannel(k.RedMask), newChannel(k.GreenMask), newChannel(k.BlueMask)
+	f.Alpha = newChannel(k.AlphaMask)
+
+	return f, nil
+}
@@ -0,0 +1,58 @@
+package bmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPixelFormat(t *testing.T) {
+	tests := []struct {
+		depth  int
+		stride int
+		red    Channel
+		alpha  Channel
+		colors int
+	}{
+		{1, 4, Channel{}, Channel{}, 2},
+		{8, 8, Channel{}, Channel{}, 16},
+		{24, 16, Channel{0xff0000, 16, 8}, Channel{}, 0},
+		{32, 20, Channel{0xff0000, 16, 8}, Channel{}, 0},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetDepth(tt.depth)
+		e.SetTopDown(true)
+		n := tt.colors
+		if n == 0 {
+			n = 16
+		}
+		if err := e.Encode(testPaletted(5, 2, n)); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := NewDecoder(&buf).PixelFormat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.BitsPerPixel != tt.depth || f.Stride != tt.stride || !f.TopDown {
+			t.Errorf("%d bpp: got %d bpp, stride %d, top-down %v", tt.depth, f.BitsPerPixel, f.Stride, f.TopDown)
+		}
+		if f.Red != tt.red || f.Alpha != tt.alpha {
+			t.Errorf("%d bpp: red %+v, alpha %+v", tt.depth, f.Red, f.Alpha)
+		}
+		if f.Paletted != (tt.colors > 0) || f.Colors != tt.colors {
+			t.Errorf("%d bpp: paletted %v with %d colors", tt.depth, f.Paletted, f.Colors)
+		}
+	}
+
+	key := UnpackerKey{16, CompressionBitfields, 0xf800, 0x07e0, 0x001f, 0}
+	f, err := NewDecoder(bytes.NewReader(rawFile(key, 1, 1, nil, []byte{0, 0, 0, 0}))).PixelFormat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Green != (Channel{0x07e0, 5, 6}) {
+		t.Errorf("green of 5-6-5 pixels %+v", f.Green)
+	}
+}
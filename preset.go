@@ -0,0 +1,68 @@
+package bmp
+
+import "image/color"
+
+// Preset is a combination of encoder settings expressing an intent rather
+// than a format.
+type Preset int
+
+// Encoder presets.
+const (
+	// PresetDefault restores the settings of NewEncoder: uncompressed
+	// 24 bpp images.
+	PresetDefault Preset = iota
+	// PresetFast writes uncompressed true color images without looking at
+	// the pixels: 24 bpp for the color models which are always opaque, such
+	// as the ones of image.Gray, image.YCbCr or RGB24, and 32 bpp otherwise.
+	PresetFast
+	// PresetSmallest writes the smallest lossless file Encode can produce:
+	// the depth is chosen like DepthAuto, the duplicate colors of palettes
+	// are merged and 4 and 8 bpp images are RLE compressed if it makes them
+	// smaller.
+	PresetSmallest
+)
+
+// depthTrueColor makes Encode pick 24 or 32 bpp from the color model of
+// the image, for PresetFast.
+const depthTrueColor = -1
+
+// SetPreset sets the depth, the compression and the palette settings of
+// p. The settings can still be changed by the other methods afterwards.
+// PresetFast and PresetSmallest choose the depth per image, so they
+// require Encode.
+func (e *Encoder) SetPreset(p Preset) {
+	e.compression = CompressionRGB
+	e.autoRLE = false
+	e.dedup = false
+
+	switch p {
+	case PresetFast:
+		e.depth = depthTrueColor
+	case PresetSmallest:
+		e.depth = DepthAuto
+		e.autoRLE = true
+		e.dedup = true
+	default:
+		e.depth = 24
+	}
+}
+
+// trueColorDepth returns 24 bpp if the colors of model m are always
+// opaque, 32 bpp otherwise.
+func trueColorDepth(m color.Model) int {
+	switch m {
+	case color.GrayModel, color.Gray16Model, color.YCbCrModel, color.CMYKModel, RGBModel, RGB565Model:
+		return 24
+	}
+
+	if p, ok := m.(color.Palette); ok {
+		for _, c := range p {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return 32
+			}
+		}
+		return 24
+	}
+
+	return 32
+}
@@ -0,0 +1,158 @@
+// Package quantize builds palettes for paletted images.
+package quantize
+
+import (
+	"image"
+	"image/color"
+)
+
+// Octree is a draw.Quantizer building palettes with an octree: colors are
+// inserted into a tree indexed by the bits of their red, green and blue
+// samples, whose deepest nodes are merged until no more leaves than
+// requested remain. Each leaf yields the average of its colors.
+//
+// Images with no more distinct colors than requested are quantized
+// exactly.
+type Octree struct {
+	// Transparent leaves the pixels less than half opaque out of the
+	// palette, for encoders mapping them to a transparent color of their
+	// own, and quantizes the others on their straight color, as opaque
+	// colors.
+	Transparent bool
+}
+
+// maxDepth is the depth of the leaves holding a single 8-bit color.
+const maxDepth = 8
+
+type node struct {
+	children [8]int32 // indices in the arena, 0 for none
+	leaf     bool
+	n        uint64
+	r, g, b  uint64
+	a        uint64
+}
+
+// tree is an octree stored in an arena, the root being at index 0.
+type tree struct {
+	nodes     []node
+	reducible [maxDepth][]int32 // inner nodes by depth
+	leaves    int
+	max       int
+}
+
+func newTree(max int) *tree {
+	t := &tree{nodes: make([]node, 1, 1024), max: max}
+	t.reducible[0] = append(t.reducible[0], 0)
+	return t
+}
+
+func (t *tree) add(c color.RGBA) {
+	i := int32(0)
+	for depth := 0; !t.nodes[i].leaf; depth++ {
+		shift := uint(maxDepth - 1 - depth)
+		k := (c.R>>shift&1)<<2 | (c.G>>shift&1)<<1 | c.B>>shift&1
+
+		child := t.nodes[i].children[k]
+		if child == 0 {
+			child = int32(len(t.nodes))
+			t.nodes = append(t.nodes, node{leaf: depth+1 == maxDepth})
+			t.nodes[i].children[k] = child
+			if depth+1 == maxDepth {
+				t.leaves++
+			} else {
+				t.reducible[depth+1] = append(t.reducible[depth+1], child)
+			}
+		}
+		i = child
+	}
+
+	n := &t.nodes[i]
+	n.n++
+	n.r += uint64(c.R)
+	n.g += uint64(c.G)
+	n.b += uint64(c.B)
+	n.a += uint64(c.A)
+
+	for t.leaves > t.max {
+		t.reduce()
+	}
+}
+
+// reduce merges the children of the deepest inner node into it.
+func (t *tree) reduce() {
+	depth := maxDepth - 1
+	for len(t.reducible[depth]) == 0 {
+		depth--
+	}
+
+	list := t.reducible[depth]
+	i := list[len(list)-1]
+	t.reducible[depth] = list[:len(list)-1]
+
+	n := &t.nodes[i]
+	for k, child := range n.children {
+		if child == 0 {
+			continue
+		}
+		c := &t.nodes[child]
+		n.n += c.n
+		n.r += c.r
+		n.g += c.g
+		n.b += c.b
+		n.a += c.a
+		n.children[k] = 0
+		t.leaves--
+	}
+	n.leaf = true
+	t.leaves++
+}
+
+func (t *tree) palette(p color.Palette, i int32) color.Palette {
+	n := &t.nodes[i]
+	if n.leaf {
+		if n.n == 0 {
+			return p
+		}
+		return append(p, color.RGBA{
+			R: uint8((n.r + n.n/2) / n.n),
+			G: uint8((n.g + n.n/2) / n.n),
+			B: uint8((n.b + n.n/2) / n.n),
+			A: uint8((n.a + n.n/2) / n.n),
+		})
+	}
+
+	for _, child := range n.children {
+		if child != 0 {
+			p = t.palette(p, child)
+		}
+	}
+
+	return p
+}
+
+// Quantize implements draw.Quantizer, appending up to cap(p)-len(p) colors
+// to p.
+func (o Octree) Quantize(p color.Palette, m image.Image) color.Palette {
+	max := cap(p) - len(p)
+	if max <= 0 {
+		return p
+	}
+
+	t := newTree(max)
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(m.At(x, y)).(color.RGBA)
+			if o.Transparent {
+				if c.A < 0x80 {
+					continue
+				}
+				nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+				c = color.RGBA{nc.R, nc.G, nc.B, 0xff}
+			}
+			t.add(c)
+		}
+	}
+
+	return t.palette(p, 0)
+}
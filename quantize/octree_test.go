@@ -0,0 +1,95 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/entooone/go-bmp/imagecmp"
+)
+
+var _ draw.Quantizer = Octree{}
+
+func TestOctreeExact(t *testing.T) {
+	colors := []color.RGBA{{0xff, 0, 0, 0xff}, {0, 0xff, 0, 0xff}, {0, 0, 0xff, 0xff}, {0x12, 0x34, 0x56, 0xff}}
+	m := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < 64; i++ {
+		m.SetRGBA(i%8, i/8, colors[i%len(colors)])
+	}
+
+	p := Octree{}.Quantize(make(color.Palette, 0, 16), m)
+	if len(p) != len(colors) {
+		t.Fatalf("len(palette) = %d, expected %d", len(p), len(colors))
+	}
+	for _, c := range colors {
+		if p[p.Index(c)] != c {
+			t.Errorf("color %v is missing from the palette", c)
+		}
+	}
+}
+
+func TestOctreeReduce(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			m.SetRGBA(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), uint8(x + y), 0xff})
+		}
+	}
+
+	for _, n := range []int{1, 2, 16, 256} {
+		prefix := color.Palette{color.Black}
+		p := Octree{}.Quantize(append(make(color.Palette, 0, n+1), prefix...), m)
+		if len(p) < 2 || len(p) > n+1 {
+			t.Errorf("%d colors: len(palette) = %d", n, len(p))
+		}
+		if p[0] != color.Black {
+			t.Errorf("%d colors: existing colors were modified", n)
+		}
+	}
+
+	// the quality increases with the palette size
+	var last float64
+	for i, n := range []int{4, 16, 64, 256} {
+		p := Octree{}.Quantize(make(color.Palette, 0, n), m)
+		q := image.NewPaletted(m.Rect, p)
+		draw.Src.Draw(q, q.Rect, m, image.Point{})
+
+		psnr, err := imagecmp.PSNR(m, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && psnr <= last {
+			t.Errorf("%d colors: PSNR %v is not greater than %v", n, psnr, last)
+		}
+		last = psnr
+	}
+	if last < 35 {
+		t.Errorf("256 colors: PSNR %v is below 35 dB", last)
+	}
+}
+
+func TestOctreeTransparent(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	m.SetNRGBA(0, 0, color.NRGBA{0xff, 0, 0, 0xff})
+	m.SetNRGBA(1, 0, color.NRGBA{0, 0xff, 0, 0x7f})
+	m.SetNRGBA(2, 0, color.NRGBA{0, 0, 0xff, 0x80})
+
+	p := Octree{Transparent: true}.Quantize(make(color.Palette, 0, 16), m)
+	want := color.Palette{color.RGBA{0, 0, 0xff, 0xff}, color.RGBA{0xff, 0, 0, 0xff}}
+	if len(p) != len(want) {
+		t.Fatalf("palette = %v, expected %v", p, want)
+	}
+	for _, c := range want {
+		if p[p.Index(c)] != c {
+			t.Errorf("color %v is missing from the palette", c)
+		}
+	}
+}
+
+func TestOctreeFull(t *testing.T) {
+	p := color.Palette{color.Black, color.White}
+	if q := (Octree{}).Quantize(p, image.NewRGBA(image.Rect(0, 0, 1, 1))); len(q) != 2 {
+		t.Errorf("len(palette) = %d, expected the palette to be unchanged", len(q))
+	}
+}
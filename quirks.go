@@ -0,0 +1,120 @@
+package bmp
+
+import "fmt"
+
+// Quirk is a known bug of a BMP writer, recognized from the fields of the
+// headers. The lenient decoder works around the quirks it recognizes and
+// reports them in Metadata.Quirks.
+type Quirk int
+
+// Known quirks.
+const (
+	// QuirkHeaderFileSize is a file size (bfSize) of 54 bytes, the length
+	// of the headers rather than of the file. The decoder does not rely on
+	// the file size, so the quirk is only reported.
+	QuirkHeaderFileSize Quirk = iota
+	// QuirkTopDownRLE is an RLE compressed image stored top-down, which the
+	// format forbids. The rows are decoded in the stored order.
+	QuirkTopDownRLE
+	// Quirk16BitAlphaMask is a 16 bpp bit fields image declaring a 1-bit
+	// alpha mask over 5-5-5 channels, whose alpha bit is left zero. The
+	// alpha mask is ignored, unless an unpacker is registered for it.
+	Quirk16BitAlphaMask
+)
+
+// quirkEntry describes a quirk.
+type quirkEntry struct {
+	name   string
+	writer string
+	desc   string
+	// offset is the position in the file of the field showing the quirk
+	offset int64
+	// match reports whether the headers read by d show the quirk
+	match func(d *Decoder) bool
+}
+
+var quirks = [...]quirkEntry{
+	QuirkHeaderFileSize: {
+		name:   "header-file-size",
+		writer: "Paint",
+		desc:   "the file size field holds the length of the headers",
+		offset: 2,
+		match: func(d *Decoder) bool {
+			return !d.dib && d.meta.FileSize == 14+infoHeaderLen
+		},
+	},
+	QuirkTopDownRLE: {
+		name:   "top-down-rle",
+		writer: "GIMP",
+		desc:   "RLE compressed rows are stored top-down, which the format forbids",
+		offset: 22,
+		match: func(d *Decoder) bool {
+			c := d.meta.Compression
+			return d.topDown && (c == CompressionRLE8 || c == CompressionRLE4)
+		},
+	},
+	Quirk16BitAlphaMask: {
+		name:   "16bpp-alpha-mask",
+		writer: "Photoshop",
+		desc:   "a 1-bit alpha mask is declared but the alpha bit is left zero",
+		offset: 66,
+		match: func(d *Decoder) bool {
+			if d.key != (UnpackerKey{16, CompressionBitfields, 0x7c00, 0x03e0, 0x001f, 0x8000}) {
+				return false
+			}
+			_, ok := lookupUnpacker(d.key)
+			return !ok
+		},
+	},
+}
+
+// String returns the name of the quirk.
+func (q Quirk) String() string {
+	if q < 0 || int(q) >= len(quirks) {
+		return fmt.Sprintf("Quirk(%d)", int(q))
+	}
+
+	return quirks[q].name
+}
+
+// Writer returns the name of the writer known for the quirk, which is not
+// necessarily the one of the file.
+func (q Quirk) Writer() string {
+	if q < 0 || int(q) >= len(quirks) {
+		return ""
+	}
+
+	return quirks[q].writer
+}
+
+// Description explains the quirk in a sentence.
+func (q Quirk) Description() string {
+	if q < 0 || int(q) >= len(quirks) {
+		return ""
+	}
+
+	return quirks[q].desc
+}
+
+// quirk reports whether the image shows q and the decoder works around it,
+// which is only the case in lenient mode. The quirks worked around are
+// recorded in the metadata.
+func (d *Decoder) quirk(q Quirk) bool {
+	if !d.opts.lenient || !quirks[q].match(d) {
+		return false
+	}
+	d.meta.Quirks = append(d.meta.Quirks, q)
+
+	return true
+}
+
+// hasQuirk reports whether q has been worked around.
+func (m *Metadata) hasQuirk(q Quirk) bool {
+	for _, mq := range m.Quirks {
+		if mq == q {
+			return true
+		}
+	}
+
+	return false
+}
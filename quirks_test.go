@@ -0,0 +1,78 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestQuirks(t *testing.T) {
+	src := testPaletted(5, 3, 16)
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(8)
+	e.SetCompression(CompressionRLE8)
+	if err := e.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	rle := buf.Bytes()
+	// the rows are stored top-down, the height being negated
+	height := int32(-3)
+	binary.LittleEndian.PutUint32(rle[22:26], uint32(height))
+	binary.LittleEndian.PutUint32(rle[2:6], 54)
+
+	if _, err := Decode(bytes.NewReader(rle)); err == nil {
+		t.Error("strict decode of a top-down RLE image succeeded")
+	}
+	d := NewDecoder(bytes.NewReader(rle), WithLenient(true))
+	m, err := d.Image()
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := d.Metadata()
+	if want := []Quirk{QuirkHeaderFileSize, QuirkTopDownRLE}; !reflect.DeepEqual(meta.Quirks, want) {
+		t.Errorf("quirks = %v, want %v", meta.Quirks, want)
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			if got, want := m.At(x, y), src.At(x, 2-y); got != want {
+				t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	// a 1-bit alpha mask whose bit is never set
+	key := UnpackerKey{16, CompressionBitfields, 0x7c00, 0x03e0, 0x001f, 0x8000}
+	file := rawFile(key, 2, 1, nil, []byte{0x00, 0x7c, 0x1f, 0x00})
+	if _, err := Decode(bytes.NewReader(file)); err == nil {
+		t.Error("strict decode of a 16 bpp image with an alpha mask succeeded")
+	}
+	d = NewDecoder(bytes.NewReader(file), WithLenient(true))
+	if m, err = d.Image(); err != nil {
+		t.Fatal(err)
+	}
+	if c := color.NRGBAModel.Convert(m.At(0, 0)); c != (color.NRGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("pixel (0, 0) = %v, want opaque red", c)
+	}
+	if meta, _ = d.Metadata(); !reflect.DeepEqual(meta.Quirks, []Quirk{Quirk16BitAlphaMask}) {
+		t.Errorf("quirks = %v, want [%v]", meta.Quirks, Quirk16BitAlphaMask)
+	}
+
+	warnings, err := Lint(bytes.NewReader(rle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 2 || warnings[0].Offset != 2 || warnings[1].Offset != 22 {
+		t.Errorf("warnings = %v, want the 2 quirks", warnings)
+	}
+
+	if s := QuirkTopDownRLE.String(); s != "top-down-rle" {
+		t.Errorf("String = %q", s)
+	}
+	if w := Quirk(-1).Writer(); w != "" {
+		t.Errorf("Writer of an unknown quirk = %q", w)
+	}
+}
@@ -0,0 +1,147 @@
+package bmp
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// ChannelOrder is the order of the samples of a pixel in a RawImage.
+type ChannelOrder int
+
+// Channel orders, named after the order of the bytes in memory.
+const (
+	OrderRGBA ChannelOrder = iota
+	OrderBGRA
+	OrderARGB
+	OrderABGR
+)
+
+// String returns the name of the order.
+func (o ChannelOrder) String() string {
+	switch o {
+	case OrderRGBA:
+		return "RGBA"
+	case OrderBGRA:
+		return "BGRA"
+	case OrderARGB:
+		return "ARGB"
+	case OrderABGR:
+		return "ABGR"
+	}
+
+	return fmt.Sprintf("ChannelOrder(%d)", int(o))
+}
+
+// permute reorders the RGBA pixels of p in place.
+func (o ChannelOrder) permute(p []byte) {
+	switch o {
+	case OrderBGRA:
+		for i := 0; i+3 < len(p); i += 4 {
+			p[i], p[i+2] = p[i+2], p[i]
+		}
+	case OrderARGB:
+		for i := 0; i+3 < len(p); i += 4 {
+			p[i], p[i+1], p[i+2], p[i+3] = p[i+3], p[i], p[i+1], p[i+2]
+		}
+	case OrderABGR:
+		for i := 0; i+3 < len(p); i += 4 {
+			p[i], p[i+1], p[i+2], p[i+3] = p[i+3], p[i+2], p[i+1], p[i]
+		}
+	}
+}
+
+// RawImage is the pixel data of an image as a single buffer, in the layout
+// expected by graphics APIs such as OpenGL.
+type RawImage struct {
+	// Pix holds the non-premultiplied samples of the pixels, in the order
+	// given by Order, starting with the bottom row. The pixel at (x, y) starts at
+	// Pix[(Height-1-y)*Stride + x*4].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent
+	// pixels.
+	Stride int
+	// Width and Height are the dimensions of the image.
+	Width, Height int
+	// Order is the order of the samples of each pixel.
+	Order ChannelOrder
+}
+
+// DecodeRaw reads a BMP image and returns its pixels bottom-up, as
+// glTexImage2D expects them, with rows padded to a multiple of align bytes
+// (GL_UNPACK_ALIGNMENT), unless WithStride or WithRowAlignment is set. Rows
+// are decoded directly into the returned
+// buffer, in the channel order set with WithChannelOrder (RGBA by default).
+func DecodeRaw(r io.Reader, align int, opts ...Option) (_ *RawImage, err error) {
+	if align <= 0 || align&(align-1) != 0 {
+		return nil, fmt.Errorf("bmp: row alignment must be a power of two (got: %d)", align)
+	}
+
+	d := NewDecoder(r, opts...)
+	d.path = "raw"
+	defer func() { d.report("raw", err) }()
+	order := d.opts.order
+	if order < OrderRGBA || order > OrderABGR {
+		return nil, fmt.Errorf("bmp: unsupported channel order (got: %d)", int(order))
+	}
+
+	if _, err := d.Config(); err != nil {
+		return nil, err
+	}
+	if err := d.resolveAlpha(); err != nil {
+		return nil, err
+	}
+
+	stride, err := d.stride(d.width*4, align)
+	if err != nil {
+		return nil, err
+	}
+	m := &RawImage{
+		Pix:    make([]uint8, stride*d.height),
+		Stride: stride,
+		Width:  d.width,
+		Height: d.height,
+		Order:  order,
+	}
+
+	readRow := d.readRow
+	if d.plain24() {
+		readRow = d.readRow24
+	}
+
+	var row, lut []byte
+	if d.palette != nil {
+		row = make([]byte, d.rowLen())
+		lut = make([]byte, 4*len(d.palette))
+		for i, c := range d.palette {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			lut[i*4], lut[i*4+1], lut[i*4+2], lut[i*4+3] = n.R, n.G, n.B, n.A
+		}
+		order.permute(lut)
+	}
+
+	for n := 0; n < d.height; n++ {
+		y := d.rowY(n)
+		p := m.Pix[(d.height-1-y)*stride : (d.height-1-y)*stride+d.width*4]
+
+		if lut == nil {
+			// true color rows are opaque RGBA or NRGBA samples already
+			if err := readRow(p); err != nil {
+				return nil, err
+			}
+			order.permute(p)
+		} else {
+			if err := readRow(row); err != nil {
+				return nil, err
+			}
+			for x, idx := range row {
+				if i := int(idx) * 4; i < len(lut) {
+					copy(p[x*4:x*4+4], lut[i:i+4])
+				}
+			}
+		}
+		d.rowDone(n + 1)
+	}
+
+	return m, nil
+}
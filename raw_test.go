@@ -0,0 +1,86 @@
+package bmp
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+func TestDecodeRaw(t *testing.T) {
+	src := testImage(3, 2)
+	src.SetNRGBA(1, 1, color.NRGBA{0xff, 0x80, 0x40, 0x80})
+
+	for _, depth := range []int{24, 32} {
+		for _, topDown := range []bool{false, true} {
+			var buf bytes.Buffer
+			e := NewEncoder(&buf)
+			e.SetDepth(depth)
+			e.SetTopDown(topDown)
+			if err := e.Encode(src); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := DecodeRaw(bytes.NewReader(buf.Bytes()), 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Stride != 16 || len(m.Pix) != 32 {
+				t.Errorf("%d bpp: stride = %d, len(Pix) = %d, expected 16 and 32", depth, m.Stride, len(m.Pix))
+			}
+
+			for y := 0; y < 2; y++ {
+				for x := 0; x < 3; x++ {
+					i := (1-y)*m.Stride + x*4
+					c := color.NRGBA{m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3]}
+					e := src.NRGBAAt(x, y)
+					if depth == 24 {
+						// translucent pixels are composited over black
+						r := color.RGBAModel.Convert(e).(color.RGBA)
+						e = color.NRGBA{r.R, r.G, r.B, 0xff}
+					}
+					if c != e {
+						t.Errorf("%d bpp, top-down %v: pixel (%d, %d) = %v, expected %v", depth, topDown, x, y, c, e)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := DecodeRaw(bytes.NewReader(nil), 3); err == nil {
+		t.Error("expected an error for an alignment of 3")
+	}
+}
+
+func TestDecodeRawChannelOrder(t *testing.T) {
+	src := testImage(2, 1)
+	src.SetNRGBA(0, 0, color.NRGBA{1, 2, 3, 4})
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(32)
+	if err := e.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	for order, expected := range map[ChannelOrder][]byte{
+		OrderRGBA: {1, 2, 3, 4},
+		OrderBGRA: {3, 2, 1, 4},
+		OrderARGB: {4, 1, 2, 3},
+		OrderABGR: {4, 3, 2, 1},
+	} {
+		m, err := DecodeRaw(bytes.NewReader(buf.Bytes()), 1, WithChannelOrder(order))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Order != order {
+			t.Errorf("%v: Order = %v", order, m.Order)
+		}
+		if !bytes.Equal(m.Pix[:4], expected) {
+			t.Errorf("%v: pixel = %v, expected %v", order, m.Pix[:4], expected)
+		}
+	}
+
+	if _, err := DecodeRaw(bytes.NewReader(buf.Bytes()), 1, WithChannelOrder(ChannelOrder(4))); err == nil {
+		t.Error("expected an error for an unknown channel order")
+	}
+}
@@ -0,0 +1,134 @@
+package riff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/entooone/go-bmp/ico"
+)
+
+// jiffies returns the duration of n jiffies, the 1/60 s unit of the
+// display rates of animated cursors.
+func jiffies(n uint32) time.Duration {
+	return time.Duration(n) * time.Second / 60
+}
+
+// Cursor is an animated cursor.
+type Cursor struct {
+	// Frames holds the images of each frame, an icon or cursor file which
+	// may have several sizes. Cursor images carry their hotspot.
+	Frames [][]ico.Entry
+	// Steps is the sequence of frames to show.
+	Steps []Step
+}
+
+// Step is a step of the animation of a cursor.
+type Step struct {
+	// Frame is the index of the frame in Cursor.Frames.
+	Frame int
+	// Duration is how long the frame is shown.
+	Duration time.Duration
+}
+
+// flags of the anih chunk
+const (
+	aniIcon     = 1
+	aniSequence = 2
+)
+
+// DecodeANI reads an animated cursor (.ani) from r, a RIFF container of
+// icon or cursor files.
+func DecodeANI(r io.Reader) (*Cursor, error) {
+	var (
+		c      Cursor
+		header []byte
+		rates  []uint32
+		seq    []uint32
+	)
+
+	err := Walk(r, func(ch Chunk) error {
+		switch {
+		case ch.List == "ACON" && ch.ID == "anih":
+			if ch.Size < 36 {
+				return fmt.Errorf("riff: anih chunk too short (got: %d bytes)", ch.Size)
+			}
+			header = make([]byte, 36)
+			if _, err := io.ReadFull(ch.Data, header); err != nil {
+				return err
+			}
+			if binary.LittleEndian.Uint32(header[32:36])&aniIcon == 0 {
+				return fmt.Errorf("riff: raw cursor frames are not supported")
+			}
+		case ch.List == "ACON" && (ch.ID == "rate" || ch.ID == "seq "):
+			v, err := readUint32s(ch)
+			if err != nil {
+				return err
+			}
+			if ch.ID == "rate" {
+				rates = v
+			} else {
+				seq = v
+			}
+		case ch.List == "fram" && ch.ID == "icon":
+			entries, err := ico.Decode(ch.Data)
+			if err != nil {
+				return fmt.Errorf("riff: frame %d: %v", len(c.Frames), err)
+			}
+			c.Frames = append(c.Frames, entries)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, fmt.Errorf("riff: missing anih chunk")
+	}
+
+	steps := int(binary.LittleEndian.Uint32(header[8:12]))
+	rate := binary.LittleEndian.Uint32(header[28:32])
+	sequenced := binary.LittleEndian.Uint32(header[32:36])&aniSequence != 0
+	if !sequenced {
+		steps = len(c.Frames)
+	}
+
+	for i := 0; i < steps; i++ {
+		s := Step{Frame: i, Duration: jiffies(rate)}
+		if sequenced {
+			if i >= len(seq) {
+				return nil, fmt.Errorf("riff: sequence has %d steps (expected: %d)", len(seq), steps)
+			}
+			s.Frame = int(seq[i])
+		}
+		if i < len(rates) {
+			s.Duration = jiffies(rates[i])
+		}
+		if s.Frame >= len(c.Frames) {
+			return nil, fmt.Errorf("riff: step %d shows frame %d out of %d", i, s.Frame, len(c.Frames))
+		}
+		c.Steps = append(c.Steps, s)
+	}
+
+	return &c, nil
+}
+
+// readUint32s reads the little-endian integers filling a chunk.
+func readUint32s(ch Chunk) ([]uint32, error) {
+	// the number of steps is bounded by the size of the chunk
+	b, err := ioutil.ReadAll(ch.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	v := make([]uint32, len(b)/4)
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
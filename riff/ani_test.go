@@ -0,0 +1,75 @@
+package riff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/entooone/go-bmp/ico"
+)
+
+// uint32s returns the little-endian encoding of v.
+func uint32s(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], x)
+	}
+
+	return b
+}
+
+func TestDecodeANI(t *testing.T) {
+	var frames [][]byte
+	for _, c := range []color.NRGBA{{0xff, 0, 0, 0xff}, {0, 0xff, 0, 0xff}} {
+		m := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+		m.SetNRGBA(0, 0, c)
+
+		var buf bytes.Buffer
+		if err := ico.Encode(&buf, m); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, chunk("icon", buf.Bytes()))
+	}
+
+	// 3 steps, 2 frames, icon frames with a sequence
+	anih := uint32s(36, 2, 3, 0, 0, 0, 0, 10, aniIcon|aniSequence)
+	b := list("RIFF", "ACON",
+		list("LIST", "INFO", chunk("INAM", []byte("test\x00"))),
+		chunk("anih", anih),
+		chunk("rate", uint32s(6, 12, 6)),
+		chunk("seq ", uint32s(0, 1, 0)),
+		list("LIST", "fram", frames...),
+	)
+
+	c, err := DecodeANI(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Step{{0, 100 * time.Millisecond}, {1, 200 * time.Millisecond}, {0, 100 * time.Millisecond}}
+	if !reflect.DeepEqual(c.Steps, expected) {
+		t.Errorf("steps %v, expected %v", c.Steps, expected)
+	}
+	if len(c.Frames) != 2 {
+		t.Fatalf("%d frames, expected 2", len(c.Frames))
+	}
+	if got := color.NRGBAModel.Convert(c.Frames[1][0].Image.At(0, 0)); got != (color.NRGBA{0, 0xff, 0, 0xff}) {
+		t.Errorf("frame 1 starts with %v", got)
+	}
+
+	// without a sequence, every frame is shown in order at the default rate
+	binary.LittleEndian.PutUint32(anih[32:], aniIcon)
+	b = list("RIFF", "ACON", chunk("anih", anih), list("LIST", "fram", frames...))
+	c, err = DecodeANI(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []Step{{0, 10 * time.Second / 60}, {1, 10 * time.Second / 60}}
+	if !reflect.DeepEqual(c.Steps, expected) {
+		t.Errorf("steps %v, expected %v", c.Steps, expected)
+	}
+}
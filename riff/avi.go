@@ -0,0 +1,101 @@
+package riff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+
+	bmp "github.com/entooone/go-bmp"
+)
+
+// maxFormatLen bounds the length of the stream formats read in memory: a
+// V5 header followed by a full color table.
+const maxFormatLen = 124 + 256*4 + 16
+
+// DecodeAVIFrames reads an AVI file from r and calls fn with each frame of
+// the video streams storing DIBs: uncompressed, bit fields or RLE frames,
+// whose format is a BITMAPINFOHEADER. stream and frame are the numbers of
+// the stream in the file and of the frame in the stream. Empty frames,
+// which repeat the previous one, are counted but not passed to fn. Streams
+// in other formats, such as MJPEG, are skipped.
+func DecodeAVIFrames(r io.Reader, fn func(stream, frame int, m image.Image) error, opts ...bmp.Option) error {
+	var (
+		formats [][]byte
+		frames  []int
+		video   bool
+	)
+
+	return Walk(r, func(c Chunk) error {
+		switch {
+		case c.List == "strl" && c.ID == "strh":
+			var typ [4]byte
+			if _, err := io.ReadFull(c.Data, typ[:]); err != nil {
+				return fmt.Errorf("riff: short stream header: %v", err)
+			}
+			video = string(typ[:]) == "vids"
+			formats = append(formats, nil)
+			frames = append(frames, 0)
+		case c.List == "strl" && c.ID == "strf":
+			if !video || len(formats) == 0 {
+				return nil
+			}
+			if c.Size > maxFormatLen {
+				return fmt.Errorf("riff: stream format too long (got: %d bytes)", c.Size)
+			}
+			b, err := ioutil.ReadAll(c.Data)
+			if err != nil {
+				return err
+			}
+			if dibFormat(b) {
+				formats[len(formats)-1] = b
+			}
+		case c.List == "movi" || c.List == "rec ":
+			n, ok := streamNumber(c.ID)
+			if !ok || n >= len(formats) || formats[n] == nil {
+				return nil
+			}
+
+			frame := frames[n]
+			frames[n]++
+			if c.Size == 0 {
+				return nil
+			}
+
+			m, err := bmp.DecodeDIB(io.MultiReader(bytes.NewReader(formats[n]), c.Data), opts...)
+			if err != nil {
+				return fmt.Errorf("riff: stream %d, frame %d: %v", n, frame, err)
+			}
+			return fn(n, frame, m)
+		}
+
+		return nil
+	})
+}
+
+// dibFormat reports whether the stream format b is a DIB header whose
+// compression the package decodes.
+func dibFormat(b []byte) bool {
+	if len(b) < 20 || binary.LittleEndian.Uint32(b) < 40 {
+		return false
+	}
+
+	switch bmp.Compression(binary.LittleEndian.Uint32(b[16:20])) {
+	case bmp.CompressionRGB, bmp.CompressionRLE8, bmp.CompressionRLE4, bmp.CompressionBitfields:
+		return true
+	}
+
+	return false
+}
+
+// streamNumber returns the stream number of a data chunk ID, such as 0 for
+// "00db".
+func streamNumber(id string) (int, bool) {
+	if len(id) != 4 || id[0] < '0' || id[0] > '9' || id[1] < '0' || id[1] > '9' {
+		return 0, false
+	}
+
+	return int(id[0]-'0')*10 + int(id[1]-'0'), true
+}
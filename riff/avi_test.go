@@ -0,0 +1,77 @@
+package riff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// streamList returns the strl list of a stream of the given type and
+// format.
+func streamList(typ string, format []byte) []byte {
+	strh := make([]byte, 56)
+	copy(strh, typ)
+
+	return list("LIST", "strl", chunk("strh", strh), chunk("strf", format))
+}
+
+func TestDecodeAVIFrames(t *testing.T) {
+	// 2×1 24 bpp frames
+	format := make([]byte, 40)
+	binary.LittleEndian.PutUint32(format[0:], 40)
+	binary.LittleEndian.PutUint32(format[4:], 2)
+	binary.LittleEndian.PutUint32(format[8:], 1)
+	binary.LittleEndian.PutUint16(format[12:], 1)
+	binary.LittleEndian.PutUint16(format[14:], 24)
+
+	mjpeg := append([]byte(nil), format...)
+	copy(mjpeg[16:], "MJPG")
+
+	b := list("RIFF", "AVI ",
+		list("LIST", "hdrl",
+			chunk("avih", make([]byte, 56)),
+			streamList("vids", format),
+			streamList("auds", make([]byte, 16)),
+			streamList("vids", mjpeg),
+		),
+		list("LIST", "movi",
+			chunk("00db", []byte{1, 2, 3, 4, 5, 6, 0, 0}),
+			chunk("01wb", []byte{0, 0}),
+			chunk("02dc", []byte{0xff, 0xd8}),
+			chunk("00db", nil),
+			list("LIST", "rec ", chunk("00db", []byte{7, 8, 9, 10, 11, 12, 0, 0})),
+		),
+		chunk("idx1", nil),
+	)
+
+	type frame struct {
+		stream, n int
+		c         color.RGBA
+	}
+	var frames []frame
+	err := DecodeAVIFrames(bytes.NewReader(b), func(stream, n int, m image.Image) error {
+		if m.Bounds() != image.Rect(0, 0, 2, 1) {
+			t.Errorf("frame %d has bounds %v", n, m.Bounds())
+		}
+		frames = append(frames, frame{stream, n, color.RGBAModel.Convert(m.At(0, 0)).(color.RGBA)})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []frame{
+		{0, 0, color.RGBA{3, 2, 1, 0xff}},
+		{0, 2, color.RGBA{9, 8, 7, 0xff}},
+	}
+	if len(frames) != len(expected) {
+		t.Fatalf("decoded %v, expected %v", frames, expected)
+	}
+	for i := range frames {
+		if frames[i] != expected[i] {
+			t.Errorf("frame %d: %v, expected %v", i, frames[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package riff reads RIFF containers, such as AVI videos and animated
+// cursors, and decodes the bitmaps they hold.
+package riff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Chunk is a data chunk of a RIFF container.
+type Chunk struct {
+	// ID is the four character code of the chunk, such as "00db".
+	ID string
+	// List is the type of the innermost list holding the chunk, such as
+	// "movi", or the form type of the container for top-level chunks.
+	List string
+	// Size is the length of the data.
+	Size uint32
+	// Data reads the data of the chunk. It is only valid until the function
+	// it is passed to returns.
+	Data io.Reader
+}
+
+// Walk reads the RIFF containers in r and calls fn for each data chunk, in
+// the order they are stored, descending into lists. Containers following
+// the first one, like the AVIX extensions of large AVI files, are read as
+// well. Data not read by fn is skipped.
+func Walk(r io.Reader, fn func(c Chunk) error) error {
+	var head [12]byte
+	for first := true; ; first = false {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			if err == io.EOF && !first {
+				return nil
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if string(head[:4]) != "RIFF" {
+			return fmt.Errorf("riff: invalid signature (got: %q)", head[:4])
+		}
+
+		size := binary.LittleEndian.Uint32(head[4:8])
+		if size < 4 {
+			return fmt.Errorf("riff: container too short (got: %d bytes)", size)
+		}
+		if err := walkList(io.LimitReader(r, int64(size)-4), string(head[8:12]), fn); err != nil {
+			return err
+		}
+		if size&1 != 0 {
+			if err := skip(r, 1); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// walkList reads the chunks of a list of type list until the end of r.
+func walkList(r io.Reader, list string, fn func(c Chunk) error) error {
+	var head [8]byte
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		id := string(head[:4])
+		size := binary.LittleEndian.Uint32(head[4:8])
+		data := &io.LimitedReader{R: r, N: int64(size)}
+
+		if id == "LIST" {
+			var typ [4]byte
+			if _, err := io.ReadFull(data, typ[:]); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			if err := walkList(data, string(typ[:]), fn); err != nil {
+				return err
+			}
+		} else if err := fn(Chunk{ID: id, List: list, Size: size, Data: data}); err != nil {
+			return err
+		}
+
+		// skip what was not read and the padding of odd sizes
+		if _, err := io.Copy(ioutil.Discard, data); err != nil {
+			return err
+		}
+		if data.N > 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if size&1 != 0 {
+			// writers sometimes omit the padding of the last chunk
+			if err := skip(r, 1); err != nil && err != io.ErrUnexpectedEOF {
+				return err
+			}
+		}
+	}
+}
+
+// skip discards n bytes of r.
+func skip(r io.Reader, n int64) error {
+	if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	return nil
+}
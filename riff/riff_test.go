@@ -0,0 +1,73 @@
+package riff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// chunk returns a chunk holding data, padded to an even length.
+func chunk(id string, data []byte) []byte {
+	b := make([]byte, 8, 8+len(data)+1)
+	copy(b, id)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(data)))
+	b = append(b, data...)
+	if len(data)&1 != 0 {
+		b = append(b, 0)
+	}
+
+	return b
+}
+
+// list returns a chunk of the given id and type holding chunks.
+func list(id, typ string, chunks ...[]byte) []byte {
+	return chunk(id, append([]byte(typ), bytes.Join(chunks, nil)...))
+}
+
+func TestWalk(t *testing.T) {
+	b := list("RIFF", "TEST",
+		chunk("abcd", []byte{1, 2, 3}),
+		list("LIST", "sub ", chunk("efgh", []byte{4}), chunk("ijkl", nil)),
+		chunk("mnop", []byte{5, 6}),
+	)
+	b = append(b, list("RIFF", "NEXT", chunk("qrst", []byte{7}))...)
+
+	type visit struct {
+		id, list string
+		data     []byte
+	}
+	var visits []visit
+	err := Walk(bytes.NewReader(b), func(c Chunk) error {
+		if c.ID == "mnop" {
+			// left unread
+			visits = append(visits, visit{c.ID, c.List, nil})
+			return nil
+		}
+		data, err := ioutil.ReadAll(c.Data)
+		visits = append(visits, visit{c.ID, c.List, data})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []visit{
+		{"abcd", "TEST", []byte{1, 2, 3}},
+		{"efgh", "sub ", []byte{4}},
+		{"ijkl", "sub ", []byte{}},
+		{"mnop", "TEST", nil},
+		{"qrst", "NEXT", []byte{7}},
+	}
+	if !reflect.DeepEqual(visits, expected) {
+		t.Errorf("visited %v, expected %v", visits, expected)
+	}
+
+	if err := Walk(bytes.NewReader(b[:20]), func(Chunk) error { return nil }); err == nil {
+		t.Errorf("expected an error for a truncated container")
+	}
+	if err := Walk(bytes.NewReader([]byte("RIFX\x04\x00\x00\x00TEST")), func(Chunk) error { return nil }); err == nil {
+		t.Errorf("expected an error for an invalid signature")
+	}
+}
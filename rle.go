@@ -0,0 +1,187 @@
+package bmp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrInvalidRLE is wrapped by the errors reporting malformed RLE
+// compressed pixel data.
+var ErrInvalidRLE = errors.New("bmp: invalid RLE data")
+
+// RLEError reports malformed RLE compressed pixel data.
+type RLEError struct {
+	// X and Y are the position of the error, in stored row order: Y is
+	// the index of the stored row, from the bottom of the image.
+	X, Y   int
+	Reason string
+}
+
+func (e *RLEError) Error() string {
+	return fmt.Sprintf("bmp: invalid RLE data at (%d, %d): %s", e.X, e.Y, e.Reason)
+}
+
+// Unwrap returns ErrInvalidRLE.
+func (e *RLEError) Unwrap() error {
+	return ErrInvalidRLE
+}
+
+// checkRLESize validates the size of the RLE data against the image
+// dimensions. The size is the declared one, or the rest of the input if
+// known and shorter.
+func (d *Decoder) checkRLESize() error {
+	size := int64(d.meta.ImageSize)
+	if size == 0 && !d.opts.lenient {
+		return errors.New("bmp: RLE compressed images must declare the size of the pixel data")
+	}
+
+	rem, ok, err := d.remaining()
+	if err != nil {
+		return err
+	}
+	if ok && (size == 0 || rem < size) {
+		size = rem
+	}
+	if size <= 0 {
+		// unknown, or no data at all, which decodes as an error
+		return nil
+	}
+
+	pixels := int64(d.width) * int64(d.height)
+	if max := d.opts.limits.MaxRLERatio; max > 0 && pixels/size > max {
+		return fmt.Errorf("%w: %d bytes of RLE data expand to %d pixels (max ratio: %d)", ErrLimitExceeded, size, pixels, max)
+	}
+
+	return nil
+}
+
+// rleReader decompresses RLE4 and RLE8 pixel data row by row. The output
+// never exceeds the bounds of the image: runs and deltas reaching past them
+// are errors, and pixels skipped by deltas and end of line or end of bitmap
+// markers are left at index 0.
+type rleReader struct {
+	r      *bufio.Reader
+	bpp    int
+	width  int
+	height int
+	n      int // index of the next stored row
+	x      int // start of the next row, after a delta
+	skip   int // blank rows before the next row, after a delta
+	eob    bool
+	buf    [2]byte
+}
+
+// newRLEReader returns a reader of the RLE data of r, which is at most size
+// bytes long if size is not zero.
+func newRLEReader(r io.Reader, size uint32, bpp, width, height int) *rleReader {
+	if size != 0 {
+		r = io.LimitReader(r, int64(size))
+	}
+
+	return &rleReader{r: bufio.NewReader(r), bpp: bpp, width: width, height: height}
+}
+
+func (rr *rleReader) errorf(x int, format string, args ...interface{}) error {
+	return &RLEError{X: x, Y: rr.n, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (rr *rleReader) read(p []byte) error {
+	if _, err := io.ReadFull(rr.r, p); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	return nil
+}
+
+// readRow decodes the next stored row as palette indices into dst.
+func (rr *rleReader) readRow(dst []byte) error {
+	for i := range dst {
+		dst[i] = 0
+	}
+	defer func() { rr.n++ }()
+
+	if rr.eob {
+		return nil
+	}
+	if rr.skip > 0 {
+		rr.skip--
+		return nil
+	}
+
+	x := rr.x
+	rr.x = 0
+	for {
+		if err := rr.read(rr.buf[:]); err != nil {
+			return err
+		}
+		count, value := int(rr.buf[0]), rr.buf[1]
+
+		switch {
+		case count > 0:
+			// encoded run
+			if count > rr.width-x {
+				return rr.errorf(x, "run of %d pixels overflows the row", count)
+			}
+			for i := 0; i < count; i++ {
+				dst[x+i] = rr.runIndex(value, i)
+			}
+			x += count
+		case value == 0:
+			// end of line
+			return nil
+		case value == 1:
+			// end of bitmap
+			rr.eob = true
+			return nil
+		case value == 2:
+			// delta, always moving forward
+			if err := rr.read(rr.buf[:]); err != nil {
+				return err
+			}
+			dx, dy := int(rr.buf[0]), int(rr.buf[1])
+			if dx > rr.width-x || dy >= rr.height-rr.n {
+				return rr.errorf(x, "delta (%d, %d) moves out of the image", dx, dy)
+			}
+			if dy == 0 {
+				x += dx
+				continue
+			}
+			rr.x, rr.skip = x+dx, dy-1
+			return nil
+		default:
+			// absolute mode, padded to 16 bits
+			count = int(value)
+			if count > rr.width-x {
+				return rr.errorf(x, "absolute run of %d pixels overflows the row", count)
+			}
+			n := (count*rr.bpp + 7) / 8
+			var data [256]byte
+			if err := rr.read(data[:(n+1)&^1]); err != nil {
+				return err
+			}
+			for i := 0; i < count; i++ {
+				dst[x+i] = storedIndex(data[:n], i, rr.bpp)
+			}
+			x += count
+		}
+	}
+}
+
+// runIndex returns the index of the i-th pixel of an encoded run of value.
+func (rr *rleReader) runIndex(value byte, i int) byte {
+	if rr.bpp == 8 {
+		return value
+	}
+
+	// RLE4 runs alternate between the two nibbles
+	if i%2 == 0 {
+		return value >> 4
+	}
+
+	return value & 0xf
+}
@@ -0,0 +1,168 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"testing"
+)
+
+// rleImage returns a BMP file with the given RLE pixel data.
+func rleImage(bpp, width, height int, data []byte) []byte {
+	colors := 1 << uint(bpp)
+	offset := 14 + 40 + colors*4
+
+	b := make([]byte, offset, offset+len(data))
+	copy(b, "BM")
+	binary.LittleEndian.PutUint32(b[2:], uint32(offset+len(data)))
+	binary.LittleEndian.PutUint32(b[10:], uint32(offset))
+	binary.LittleEndian.PutUint32(b[14:], 40)
+	binary.LittleEndian.PutUint32(b[18:], uint32(width))
+	binary.LittleEndian.PutUint32(b[22:], uint32(height))
+	binary.LittleEndian.PutUint16(b[26:], 1)
+	binary.LittleEndian.PutUint16(b[28:], uint16(bpp))
+	compression := CompressionRLE8
+	if bpp == 4 {
+		compression = CompressionRLE4
+	}
+	binary.LittleEndian.PutUint32(b[30:], uint32(compression))
+	binary.LittleEndian.PutUint32(b[34:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(b[46:], uint32(colors))
+	for i := 0; i < colors; i++ {
+		b[54+i*4] = uint8(i)
+	}
+
+	return append(b, data...)
+}
+
+func TestDecodeRLE(t *testing.T) {
+	tests := []struct {
+		name   string
+		bpp    int
+		width  int
+		height int
+		data   []byte
+		pix    []uint8 // top-down
+	}{
+		{
+			"rle8", 8, 5, 2,
+			[]byte{
+				3, 7, 0, 0, // bottom row: run of 7, end of line
+				0, 3, 1, 2, 3, 0, 2, 9, 0, 1, // absolute run, run of 9, end of bitmap
+			},
+			[]uint8{
+				1, 2, 3, 9, 9,
+				7, 7, 7, 0, 0,
+			},
+		},
+		{
+			"rle8 delta", 8, 4, 3,
+			[]byte{
+				1, 5, 0, 2, 1, 2, 1, 6, // delta to the top row
+				0, 1,
+			},
+			[]uint8{
+				0, 0, 6, 0,
+				0, 0, 0, 0,
+				5, 0, 0, 0,
+			},
+		},
+		{
+			"rle4", 4, 6, 1,
+			[]byte{3, 0x12, 0, 3, 0x34, 0x50, 0, 1},
+			[]uint8{1, 2, 1, 3, 4, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		img, err := Decode(bytes.NewReader(rleImage(tt.bpp, tt.width, tt.height, tt.data)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if pix := img.(*image.Paletted).Pix; !bytes.Equal(pix, tt.pix) {
+			t.Errorf("%s: pixels = %v, expected %v", tt.name, pix, tt.pix)
+		}
+	}
+}
+
+func TestDecodeRLERoundTrip(t *testing.T) {
+	m := testPaletted(37, 9, 200)
+	for i := range m.Pix {
+		if i%5 < 3 {
+			m.Pix[i] = 7
+		}
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(8)
+	e.SetCompression(CompressionRLE8)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img.(*image.Paletted).Pix, m.Pix) {
+		t.Error("decoded indices differ from the source")
+	}
+}
+
+func TestDecodeRLEInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"run overflow", []byte{5, 1, 0, 1}},
+		{"absolute overflow", []byte{0, 5, 1, 2, 3, 4, 5, 0}},
+		{"delta past the row", []byte{2, 1, 0, 2, 3, 0}},
+		{"delta past the image", []byte{0, 2, 0, 2}},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(rleImage(8, 4, 2, tt.data)))
+		var rleErr *RLEError
+		if !errors.As(err, &rleErr) || !errors.Is(err, ErrInvalidRLE) {
+			t.Errorf("%s: error = %v, expected an RLEError", tt.name, err)
+		}
+	}
+
+	// the data cannot extend past the declared size
+	b := rleImage(8, 4, 1, []byte{4, 1})
+	b = append(b, 0, 1)
+	if _, err := Decode(bytes.NewReader(b)); err == nil {
+		t.Error("expected an error for data past the declared size")
+	}
+}
+
+func TestDecodeRLERatio(t *testing.T) {
+	// a few bytes claiming a 30000×30000 image
+	b := rleImage(8, 30000, 30000, []byte{0, 1})
+
+	_, err := DecodeConfig(bytes.NewReader(b), WithLimits(Limits{MaxRLERatio: 1024}))
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("error = %v, expected %v", err, ErrLimitExceeded)
+	}
+
+	b = rleImage(8, 64, 64, []byte{0, 1})
+	if _, err := Decode(bytes.NewReader(b), WithLimits(Limits{MaxRLERatio: 4096})); err != nil {
+		t.Error(err)
+	}
+
+	// the default limits reject a 16000×16000 image of about 1 KB, whether
+	// the header declares 2 bytes of data or more than the file holds
+	data := make([]byte, 1024)
+	data[len(data)-1] = 1
+	b = rleImage(8, 16000, 16000, data)
+	for _, size := range []uint32{2, 1 << 30} {
+		binary.LittleEndian.PutUint32(b[34:], size)
+		if _, err := Decode(bytes.NewReader(b)); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("declared size %d: error = %v, expected %v", size, err, ErrLimitExceeded)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package bmp
+
+import (
+	"image/color"
+	"io"
+)
+
+// Sanitize reads a BMP image from r and writes it to w in a minimal,
+// canonical form, neutralizing data hidden outside of the pixels: the
+// headers are rewritten with zeroed reserved and resolution fields, the
+// color table is trimmed after the highest used index, and neither the
+// gap before the pixel data, the row padding nor the bytes after the
+// pixel data are copied. The color space and the embedded profile are
+// kept, as found by Decoder.ColorSource, so that the colors do not
+// change.
+//
+// Pixels are kept exactly, in the same row order. 16 bpp images are
+// widened to 24 bpp, except the ones of a single channel, which keep
+// their samples and mask, and RLE compressed images are decompressed.
+func Sanitize(w io.Writer, r io.Reader, opts ...Option) (err error) {
+	d := NewDecoder(r, opts...)
+	d.path = "sanitize"
+	defer func() { d.report("sanitize", err) }()
+	if _, err := d.Config(); err != nil {
+		return err
+	}
+	if d.codec != nil {
+		return d.errCodecRows()
+	}
+	src, err := d.ColorSource()
+	if err != nil {
+		return err
+	}
+	d.stage = stagePixels
+	if err := d.resolveAlpha(); err != nil {
+		return err
+	}
+
+	e := NewEncoder(w)
+	e.SetTopDown(d.topDown)
+	e.SetColorSource(src)
+
+	if d.gray != 0 {
+		return sanitizeGray(e, d)
+	}
+
+	if d.palette == nil {
+		if d.rowModel() == color.NRGBAModel {
+			e.SetDepth(32)
+		}
+		if err := e.WriteHeader(d.width, d.height, nil); err != nil {
+			return err
+		}
+
+		row := make([]byte, d.rowLen())
+		for n := 0; n < d.height; n++ {
+			if err := d.readRow(row); err != nil {
+				return err
+			}
+			if err := e.WriteRow(row); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// the used colors are only known once all the rows are read
+	rows := make([]byte, d.width*d.height)
+	used := 0
+	for n := 0; n < d.height; n++ {
+		dst := rows[n*d.width : (n+1)*d.width]
+		if d.rle != nil {
+			if err := d.rle.readRow(dst); err != nil {
+				return err
+			}
+		} else {
+			if _, err := io.ReadFull(d.r, d.row); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			for x := range dst {
+				dst[x] = storedIndex(d.row, x, d.bpp)
+			}
+			if d.mirrored {
+				d.mirrorRow(dst)
+			}
+		}
+
+		for _, idx := range dst {
+			if int(idx) >= used {
+				used = int(idx) + 1
+			}
+		}
+	}
+
+	// indices past the color table get black entries, as decoders
+	// disagree on their color
+	p := append(color.Palette(nil), d.palette...)
+	if used < len(p) {
+		p = p[:used]
+	}
+	for len(p) < used {
+		p = append(p, color.Black)
+	}
+
+	e.SetDepth(d.bpp)
+	if err := e.WriteHeader(d.width, d.height, p); err != nil {
+		return err
+	}
+	for n := 0; n < d.height; n++ {
+		if err := e.WriteRow(rows[n*d.width : (n+1)*d.width]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sanitizeGray writes the 16 bpp single channel image of d to e, with the
+// bits outside of the channel cleared.
+func sanitizeGray(e *Encoder, d *Decoder) error {
+	e.SetDepth(16)
+	e.grayMask = d.gray
+	if err := e.WriteHeader(d.width, d.height, nil); err != nil {
+		return err
+	}
+
+	row := make([]byte, d.width*2)
+	for n := 0; n < d.height; n++ {
+		if _, err := io.ReadFull(d.r, d.row); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		for x := 0; x < d.width; x++ {
+			v := (uint16(d.row[2*x]) | uint16(d.row[2*x+1])<<8) & d.gray
+			dx := x
+			if d.mirrored {
+				dx = d.width - 1 - x
+			}
+			row[2*dx], row[2*dx+1] = uint8(v), uint8(v>>8)
+		}
+		if err := e.WriteRow(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storedIndex returns the palette index of the pixel x of a stored row of
+// a bpp bits per pixel image, pixels being packed from the most
+// significant bit.
+func storedIndex(src []byte, x, bpp int) byte {
+	bit := x * bpp
+	shift := uint(8 - bpp - bit%8)
+	return src[bit/8] >> shift & (1<<uint(bpp) - 1)
+}
@@ -0,0 +1,149 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	src := testImage(5, 3)
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetResolution(300, 300)
+	if err := e.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	// hide data in the reserved fields, the gap, the padding and the tail
+	b := buf.Bytes()
+	b[6], b[7] = 'x', 'y'
+	b = append(b[:54], append([]byte("gap!"), b[54:]...)...)
+	binary.LittleEndian.PutUint32(b[10:14], 58)
+	b[58+15] = 'p'
+	b = append(b, "trailer"...)
+
+	var out bytes.Buffer
+	if err := Sanitize(&out, bytes.NewReader(b), WithLenient(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected bytes.Buffer
+	if err := NewEncoder(&expected).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), expected.Bytes()) {
+		t.Errorf("sanitized image:\n%x\nexpected:\n%x", out.Bytes(), expected.Bytes())
+	}
+}
+
+func TestSanitizePaletted(t *testing.T) {
+	// 4 bpp with a 16 color table, using only the first 3 colors
+	m := testPaletted(7, 2, 16)
+	for i := range m.Pix {
+		m.Pix[i] %= 3
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(4)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := Sanitize(&out, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	trimmed := image.NewPaletted(m.Rect, m.Palette[:3])
+	copy(trimmed.Pix, m.Pix)
+	var expected bytes.Buffer
+	e = NewEncoder(&expected)
+	e.SetDepth(4)
+	if err := e.Encode(trimmed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), expected.Bytes()) {
+		t.Errorf("sanitized image:\n%x\nexpected:\n%x", out.Bytes(), expected.Bytes())
+	}
+
+	// 2 bpp images keep their depth
+	m = testPaletted(7, 2, 4)
+	buf.Reset()
+	e = NewEncoder(&buf)
+	e.SetDepth(2)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := Sanitize(&out, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if bpp := binary.LittleEndian.Uint16(out.Bytes()[28:30]); bpp != 2 {
+		t.Errorf("2 bpp image sanitized to %d bpp", bpp)
+	}
+	got, err := Decode(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(got, m) {
+		t.Error("sanitized 2 bpp image differs")
+	}
+}
+
+func TestSanitizeGray16(t *testing.T) {
+	// 12-bit samples, with data hidden in the top bits
+	key := UnpackerKey{16, CompressionBitfields, 0x0fff, 0x0fff, 0x0fff, 0}
+	src := rawFile(key, 2, 2, nil, []byte{0x01, 0xf0, 0xff, 0x0f, 0x00, 0x08, 0x34, 0x52})
+	want, err := Decode(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := Sanitize(&out, bytes.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	b := out.Bytes()
+	if bpp := binary.LittleEndian.Uint16(b[28:30]); bpp != 16 {
+		t.Errorf("sanitized to %d bpp, expected 16", bpp)
+	}
+	if mask := binary.LittleEndian.Uint32(b[54:58]); mask != 0x0fff {
+		t.Errorf("mask = %#x, expected 0xfff", mask)
+	}
+	if hi := b[len(b)-1]; hi != 0x02 {
+		t.Errorf("high byte of the last sample = %#x, expected the bits outside of the mask cleared", hi)
+	}
+
+	got, err := Decode(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := got.(*image.Gray16)
+	if !ok {
+		t.Fatalf("decoded a %T, expected an *image.Gray16", got)
+	}
+	if !bytes.Equal(g.Pix, want.(*image.Gray16).Pix) {
+		t.Errorf("samples %x, expected %x", g.Pix, want.(*image.Gray16).Pix)
+	}
+}
+
+func TestStoredIndex(t *testing.T) {
+	row := []byte{0xb4, 0x2f}
+	for _, tt := range []struct {
+		bpp     int
+		indices []byte
+	}{
+		{1, []byte{1, 0, 1, 1, 0, 1, 0, 0, 0, 0, 1, 0, 1, 1, 1, 1}},
+		{4, []byte{0xb, 0x4, 0x2, 0xf}},
+		{8, []byte{0xb4, 0x2f}},
+	} {
+		for x, expected := range tt.indices {
+			if idx := storedIndex(row, x, tt.bpp); idx != expected {
+				t.Errorf("%d bpp: index %d = %d, expected %d", tt.bpp, x, idx, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package bmp
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// tap is the contribution of a source pixel to a destination pixel.
+type tap struct {
+	i int
+	w float32
+}
+
+// kernelTaps computes, for each of the n destination pixels, the source
+// pixels among srcN and their normalized weights.
+func kernelTaps(srcN, n int, k *draw.Kernel) [][]tap {
+	scale := float64(srcN) / float64(n)
+	// widen the kernel when downscaling to avoid aliasing
+	support, ks := k.Support, 1.0
+	if scale > 1 {
+		support, ks = support*scale, scale
+	}
+
+	taps := make([][]tap, n)
+	for d := range taps {
+		center := (float64(d)+0.5)*scale - 0.5
+		lo := int(math.Ceil(center - support))
+		hi := int(math.Floor(center + support))
+
+		var sum float32
+		for s := lo; s <= hi; s++ {
+			t := math.Abs(float64(s)-center) / ks
+			if t >= k.Support {
+				continue
+			}
+
+			w := float32(k.At(t))
+			if w == 0 {
+				continue
+			}
+
+			// clamp to the edges
+			i := s
+			if i < 0 {
+				i = 0
+			} else if i >= srcN {
+				i = srcN - 1
+			}
+			taps[d] = append(taps[d], tap{i, w})
+			sum += w
+		}
+
+		if sum == 0 {
+			// nearest pixel
+			i := int(center + 0.5)
+			if i < 0 {
+				i = 0
+			} else if i >= srcN {
+				i = srcN - 1
+			}
+			taps[d] = []tap{{i, 1}}
+			continue
+		}
+
+		for i := range taps[d] {
+			taps[d][i].w /= sum
+		}
+	}
+
+	return taps
+}
+
+// nearestTaps returns taps selecting the nearest source pixel.
+func nearestTaps(srcN, n int) [][]tap {
+	taps := make([][]tap, n)
+	for d := range taps {
+		taps[d] = []tap{{(2*d + 1) * srcN / (2 * n), 1}}
+	}
+
+	return taps
+}
+
+// scaler resamples rows streamed in any order into an image, keeping only
+// the destination rows whose source rows have not all been seen yet.
+type scaler struct {
+	dst    *image.RGBA
+	xTaps  [][]tap
+	yUsers [][]tap // for each source row, the destination rows using it
+	remain []int   // for each destination row, the missing source rows
+	acc    map[int][]float32
+	row    []float32
+	linear bool // samples are in linear light
+}
+
+func newScaler(sw, sh, w, h int, kernel draw.Interpolator, linear bool) *scaler {
+	var xTaps, yTaps [][]tap
+	switch k := kernel.(type) {
+	case *draw.Kernel:
+		xTaps, yTaps = kernelTaps(sw, w, k), kernelTaps(sh, h, k)
+	default:
+		if kernel == draw.NearestNeighbor {
+			xTaps, yTaps = nearestTaps(sw, w), nearestTaps(sh, h)
+		} else {
+			xTaps, yTaps = kernelTaps(sw, w, draw.BiLinear), kernelTaps(sh, h, draw.BiLinear)
+		}
+	}
+
+	s := &scaler{
+		dst:    image.NewRGBA(image.Rect(0, 0, w, h)),
+		xTaps:  xTaps,
+		yUsers: make([][]tap, sh),
+		remain: make([]int, h),
+		acc:    make(map[int][]float32),
+		row:    make([]float32, w*4),
+		linear: linear,
+	}
+
+	for y, taps := range yTaps {
+		for _, t := range taps {
+			if len(s.yUsers[t.i]) == 0 || s.yUsers[t.i][len(s.yUsers[t.i])-1].i != y {
+				s.remain[y]++
+			}
+			s.yUsers[t.i] = append(s.yUsers[t.i], tap{y, t.w})
+		}
+	}
+
+	return s
+}
+
+// add resamples the source row sy, given as premultiplied samples in
+// [0, 1], into the destination rows using it.
+func (s *scaler) add(sy int, src []float32) {
+	for x, taps := range s.xTaps {
+		var r, g, b, a float32
+		for _, t := range taps {
+			p := src[t.i*4 : t.i*4+4]
+			r += p[0] * t.w
+			g += p[1] * t.w
+			b += p[2] * t.w
+			a += p[3] * t.w
+		}
+		s.row[x*4], s.row[x*4+1], s.row[x*4+2], s.row[x*4+3] = r, g, b, a
+	}
+
+	users := s.yUsers[sy]
+	for i, u := range users {
+		acc, ok := s.acc[u.i]
+		if !ok {
+			acc = make([]float32, len(s.row))
+			s.acc[u.i] = acc
+		}
+		for j, v := range s.row {
+			acc[j] += v * u.w
+		}
+
+		// a source row may appear several times because of edge clamping
+		if i+1 < len(users) && users[i+1].i == u.i {
+			continue
+		}
+
+		if s.remain[u.i]--; s.remain[u.i] == 0 {
+			s.finish(u.i, acc)
+			delete(s.acc, u.i)
+		}
+	}
+}
+
+// finish stores the accumulated destination row y.
+func (s *scaler) finish(y int, acc []float32) {
+	p := s.dst.Pix[y*s.dst.Stride : y*s.dst.Stride+len(acc)]
+	for i := 0; i < len(acc); i += 4 {
+		a := clamp01(acc[i+3])
+		if s.linear && a > 0 {
+			// back to premultiplied sRGB
+			for j := i; j < i+3; j++ {
+				acc[j] = float32(linearToSRGB(float64(acc[j]/a))) * a
+			}
+		}
+		// premultiplied colors cannot exceed alpha
+		p[i] = uint8(math.Min(float64(clamp01(acc[i])), float64(a))*0xff + 0.5)
+		p[i+1] = uint8(math.Min(float64(clamp01(acc[i+1])), float64(a))*0xff + 0.5)
+		p[i+2] = uint8(math.Min(float64(clamp01(acc[i+2])), float64(a))*0xff + 0.5)
+		p[i+3] = uint8(a*0xff + 0.5)
+	}
+}
+
+func clamp01(v float32) float32 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 1:
+		return 1
+	}
+
+	return v
+}
+
+// linearLUT maps 8-bit sRGB samples to linear light.
+var linearLUT = func() (lut [256]float32) {
+	for i := range lut {
+		lut[i] = float32(srgbToLinear(float64(i) / 0xff))
+	}
+	return lut
+}()
+
+// premultipliedRow converts a row returned by RowReader to premultiplied
+// samples in [0, 1], in linear light if WithLinearLight is set.
+func (d *Decoder) premultipliedRow(dst []float32, row []byte) {
+	linear := d.opts.linear
+
+	if d.palette != nil {
+		for x, idx := range row[:d.width] {
+			if int(idx) >= len(d.palette) {
+				dst[x*4], dst[x*4+1], dst[x*4+2], dst[x*4+3] = 0, 0, 0, 0
+				continue
+			}
+
+			if linear {
+				c := color.NRGBAModel.Convert(d.palette[idx]).(color.NRGBA)
+				a := float32(c.A) / 0xff
+				dst[x*4] = linearLUT[c.R] * a
+				dst[x*4+1] = linearLUT[c.G] * a
+				dst[x*4+2] = linearLUT[c.B] * a
+				dst[x*4+3] = a
+				continue
+			}
+
+			r, g, b, a := d.palette[idx].RGBA()
+			dst[x*4] = float32(r) / 0xffff
+			dst[x*4+1] = float32(g) / 0xffff
+			dst[x*4+2] = float32(b) / 0xffff
+			dst[x*4+3] = float32(a) / 0xffff
+		}
+		return
+	}
+
+	// rows of 16 and 24 bpp images are opaque, so premultiplied or not
+	// makes no difference
+	for i, v := range row[:d.width*4] {
+		switch {
+		case i%4 == 3:
+			dst[i] = float32(v) / 0xff
+		case linear:
+			dst[i] = linearLUT[v] * float32(row[i|3]) / 0xff
+		default:
+			dst[i] = float32(v) / 0xff * float32(row[i|3]) / 0xff
+		}
+	}
+}
+
+// rowModel returns the color model of the true color rows returned by
+// RowReader.
+func (d *Decoder) rowModel() color.Model {
+	if d.unpacker.opaque {
+		return color.RGBAModel
+	}
+
+	return color.NRGBAModel
+}
+
+// DecodeScaled reads a BMP image and returns it resized to w×h pixels
+// using the given interpolator. Rows are resampled as they are read, so the
+// full size image is never allocated. Kernels such as draw.CatmullRom are
+// applied exactly; draw.NearestNeighbor picks the nearest pixels and other
+// interpolators fall back to draw.BiLinear. WithLinearLight filters in
+// linear light.
+func DecodeScaled(r io.Reader, w, h int, kernel draw.Interpolator, opts ...Option) (_ *image.RGBA, err error) {
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("bmp: width and height must be greater than zero (width: %d, height: %d)", w, h)
+	}
+
+	d := NewDecoder(r, opts...)
+	d.path = "scaled"
+	defer func() { d.report("scaled", err) }()
+	if _, err := d.Config(); err != nil {
+		return nil, err
+	}
+
+	dst, err := d.scaleRows([]*scaler{newScaler(d.width, d.height, w, h, kernel, d.opts.linear)})
+	if err != nil {
+		return nil, err
+	}
+
+	return dst[0], nil
+}
+
+// boxKernel averages the source pixels covered by a destination pixel.
+var boxKernel = &draw.Kernel{
+	Support: 0.5,
+	At: func(t float64) float64 {
+		return 1
+	},
+}
+
+// DecodeMipmaps reads a BMP image and returns its mipmap chain: the full
+// size image followed by successive halvings down to 1×1, each level being
+// the box-filtered average of the source pixels it covers. A positive
+// levels limits the number of returned images. All levels are built while
+// reading the rows once. WithLinearLight averages in linear light.
+func DecodeMipmaps(r io.Reader, levels int, opts ...Option) (_ []*image.RGBA, err error) {
+	d := NewDecoder(r, opts...)
+	d.path = "mipmaps"
+	defer func() { d.report("mipmaps", err) }()
+	if _, err := d.Config(); err != nil {
+		return nil, err
+	}
+
+	var scalers []*scaler
+	for w, h := d.width, d.height; levels <= 0 || len(scalers) < levels; w, h = w/2, h/2 {
+		if w < 1 {
+			w = 1
+		}
+		if h < 1 {
+			h = 1
+		}
+		scalers = append(scalers, newScaler(d.width, d.height, w, h, boxKernel, d.opts.linear))
+
+		if w == 1 && h == 1 {
+			break
+		}
+	}
+
+	return d.scaleRows(scalers)
+}
+
+// scaleRows reads the pixel data, feeding every row to each scaler.
+func (d *Decoder) scaleRows(scalers []*scaler) ([]*image.RGBA, error) {
+	src := make([]float32, d.width*4)
+
+	rows := d.Rows()
+	for rows.Next() {
+		d.premultipliedRow(src, rows.Row())
+		for _, s := range scalers {
+			s.add(rows.Y(), src)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	dst := make([]*image.RGBA, len(scalers))
+	for i, s := range scalers {
+		dst[i] = s.dst
+	}
+
+	return dst, nil
+}
+
+// Scale returns m resized to w×h pixels with the resampling of
+// DecodeScaled, for images which are not read from a BMP file.
+func Scale(m image.Image, w, h int, kernel draw.Interpolator) (*image.RGBA, error) {
+	b := m.Bounds()
+	if w <= 0 || h <= 0 || b.Empty() {
+		return nil, fmt.Errorf("bmp: width and height must be greater than zero (width: %d, height: %d, source: %v)", w, h, b.Size())
+	}
+
+	s := newScaler(b.Dx(), b.Dy(), w, h, kernel, false)
+	src := make([]float32, b.Dx()*4)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := m.At(x, y).RGBA()
+			p := src[(x-b.Min.X)*4:]
+			p[0], p[1], p[2], p[3] = float32(r)/0xffff, float32(g)/0xffff, float32(bl)/0xffff, float32(a)/0xffff
+		}
+		s.add(y-b.Min.Y, src)
+	}
+
+	return s.dst, nil
+}
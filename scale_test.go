@@ -0,0 +1,133 @@
+package bmp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"github.com/entooone/go-bmp/imagecmp"
+	"golang.org/x/image/draw"
+)
+
+func TestDecodeScaled(t *testing.T) {
+	src := testImage(16, 12)
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []draw.Interpolator{draw.NearestNeighbor, draw.BiLinear, draw.CatmullRom} {
+		scaled, err := DecodeScaled(bytes.NewReader(buf.Bytes()), 8, 6, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := image.NewRGBA(image.Rect(0, 0, 8, 6))
+		k.Scale(expected, expected.Bounds(), full, full.Bounds(), draw.Src, nil)
+
+		// the edges are handled differently, compare the interior only
+		interior := image.Rect(1, 1, 7, 5)
+		if d, err := imagecmp.MaxDelta(scaled.SubImage(interior), expected.SubImage(interior)); err != nil || d > 2 {
+			t.Errorf("%T: samples differ by %d, %v", k, d, err)
+		}
+	}
+}
+
+func TestDecodeScaledTruncated(t *testing.T) {
+	// the header declares 8192×8192 pixels but the pixel data is missing
+	b := rawFile(UnpackerKey{BitsPerPixel: 1}, 8192, 8192, color.Palette{color.Black, color.White}, nil)
+	if _, err := DecodeScaled(bytes.NewReader(b), 8192, 8192, draw.BiLinear, WithUntrusted()); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("error = %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDecodeMipmaps(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	// a black column pair on the left
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			copy(src.Pix[y*src.Stride+x*4:], []uint8{0, 0, 0, 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	levels, err := DecodeMipmaps(bytes.NewReader(buf.Bytes()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []image.Point{{4, 2}, {2, 1}, {1, 1}}
+	if len(levels) != len(sizes) {
+		t.Fatalf("got %d levels, expected %d", len(levels), len(sizes))
+	}
+	for i, l := range levels {
+		if l.Bounds().Size() != sizes[i] {
+			t.Errorf("level %d: size = %v, expected %v", i, l.Bounds().Size(), sizes[i])
+		}
+	}
+
+	if !bytes.Equal(levels[0].Pix, src.Pix) {
+		t.Error("level 0 differs from the source image")
+	}
+	if p := levels[1].Pix; p[0] != 0 || p[4] != 0xff {
+		t.Errorf("level 1 = %v, expected black and white", p)
+	}
+	if c := levels[2].RGBAAt(0, 0); c.R < 0x7f || c.R > 0x80 {
+		t.Errorf("level 2 = %v, expected mid gray", c)
+	}
+
+	if levels, err = DecodeMipmaps(bytes.NewReader(buf.Bytes()), 2); err != nil || len(levels) != 2 {
+		t.Errorf("limited levels: got %d levels (err: %v), expected 2", len(levels), err)
+	}
+}
+
+func TestDecodeMipmapsTruncated(t *testing.T) {
+	b := rawFile(UnpackerKey{BitsPerPixel: 1}, 8192, 8192, color.Palette{color.Black, color.White}, nil)
+	if _, err := DecodeMipmaps(bytes.NewReader(b), 0, WithUntrusted()); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("error = %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDecodeMipmapsLinearLight(t *testing.T) {
+	// a black and white checkerboard averages to 50% gray in linear light
+	src := image.NewGray(image.Rect(0, 0, 2, 2))
+	src.Pix[0], src.Pix[3] = 0xff, 0xff
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		linear bool
+		gray   uint8
+	}{
+		{false, 0x80},
+		{true, 0xbc},
+	} {
+		levels, err := DecodeMipmaps(bytes.NewReader(buf.Bytes()), 0, WithLinearLight(tt.linear))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c := levels[1].RGBAAt(0, 0); c != (color.RGBA{tt.gray, tt.gray, tt.gray, 0xff}) {
+			t.Errorf("linear %v: average = %v, expected gray %#x", tt.linear, c, tt.gray)
+		}
+	}
+}
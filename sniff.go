@@ -0,0 +1,79 @@
+package bmp
+
+import "encoding/binary"
+
+// Content types returned by SniffContentType.
+const (
+	// ContentTypeBMP is the type of BMP files, as reported by
+	// http.DetectContentType.
+	ContentTypeBMP = "image/bmp"
+	// ContentTypeDIB is the type of headerless bitmaps starting with the
+	// DIB header, as found on the clipboard and in resources.
+	ContentTypeDIB = "image/x-dib"
+	// ContentTypeICO is the type of icon files, as reported by
+	// http.DetectContentType.
+	ContentTypeICO = "image/x-icon"
+	// ContentTypeCUR is the type of cursor files.
+	ContentTypeCUR = "image/x-win-bitmap"
+)
+
+// SniffContentType returns the content type of the data starting with
+// prefix, for the bitmap variants of this package. It reports false if the
+// data is none of them. The first 16 bytes are enough to tell them apart.
+func SniffContentType(prefix []byte) (string, bool) {
+	if len(prefix) >= 2 && prefix[0] == 'B' && prefix[1] == 'M' {
+		return ContentTypeBMP, true
+	}
+
+	// ICONDIR: reserved, type (1 for icons, 2 for cursors) and count
+	if len(prefix) >= 6 && prefix[0] == 0 && prefix[1] == 0 && prefix[3] == 0 &&
+		binary.LittleEndian.Uint16(prefix[4:6]) > 0 {
+
+		switch prefix[2] {
+		case 1:
+			return ContentTypeICO, true
+		case 2:
+			return ContentTypeCUR, true
+		}
+	}
+
+	if sniffDIB(prefix) {
+		return ContentTypeDIB, true
+	}
+
+	return "", false
+}
+
+// sniffDIB reports whether b starts with a plausible DIB header.
+func sniffDIB(b []byte) bool {
+	if len(b) < 4 {
+		return false
+	}
+
+	var planes, bpp uint16
+	switch binary.LittleEndian.Uint32(b[0:4]) {
+	case coreHeaderLen:
+		if len(b) < coreHeaderLen {
+			return false
+		}
+		planes, bpp = binary.LittleEndian.Uint16(b[8:10]), binary.LittleEndian.Uint16(b[10:12])
+	case infoHeaderLen, 52, 56, 60, 96, 108, 112, 120, 124:
+		if len(b) < 16 {
+			return false
+		}
+		planes, bpp = binary.LittleEndian.Uint16(b[12:14]), binary.LittleEndian.Uint16(b[14:16])
+	default:
+		return false
+	}
+
+	if planes != 1 {
+		return false
+	}
+
+	switch bpp {
+	case 1, 2, 4, 8, 16, 24, 32:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"net/http"
+	"testing"
+)
+
+func TestSniffContentType(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(testImage(2, 2)); err != nil {
+		t.Fatal(err)
+	}
+	bmp := buf.Bytes()
+
+	tests := []struct {
+		name   string
+		prefix []byte
+		typ    string
+		ok     bool
+	}{
+		{"bmp", bmp, ContentTypeBMP, true},
+		{"dib", bmp[14:], ContentTypeDIB, true},
+		{"ico", []byte{0, 0, 1, 0, 1, 0}, ContentTypeICO, true},
+		{"cur", []byte{0, 0, 2, 0, 1, 0}, ContentTypeCUR, true},
+		{"empty icon directory", []byte{0, 0, 1, 0, 0, 0}, "", false},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), "", false},
+		{"short", []byte("B"), "", false},
+	}
+
+	for _, tt := range tests {
+		typ, ok := SniffContentType(tt.prefix)
+		if typ != tt.typ || ok != tt.ok {
+			t.Errorf("%s: SniffContentType = %q, %v, expected %q, %v", tt.name, typ, ok, tt.typ, tt.ok)
+		}
+	}
+
+	// agree with the standard library where it knows the type
+	for _, b := range [][]byte{bmp, {0, 0, 1, 0, 1, 0}} {
+		typ, _ := SniffContentType(b)
+		if std := http.DetectContentType(b); std != typ {
+			t.Errorf("SniffContentType = %q, http.DetectContentType = %q", typ, std)
+		}
+	}
+}
+
+func TestSniffHeaderV3(t *testing.T) {
+	src := testImage(2, 2)
+	src.SetNRGBA(1, 1, color.NRGBA{0x10, 0x20, 0x30, 0x40})
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(32)
+	e.SetAlphaBitfields(true)
+	if err := e.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+
+	// the 56-byte BITMAPV3INFOHEADER ends with the alpha mask of V4 headers
+	v4 := buf.Bytes()
+	dib := append(append([]byte(nil), v4[14:14+56]...), v4[14+108:]...)
+	binary.LittleEndian.PutUint32(dib[0:4], 56)
+
+	if typ, ok := SniffContentType(dib); typ != ContentTypeDIB || !ok {
+		t.Fatalf("SniffContentType = %q, %v, expected %q", typ, ok, ContentTypeDIB)
+	}
+	m, err := DecodeDIB(bytes.NewReader(dib))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(m, src) {
+		t.Error("decoded image differs")
+	}
+}
@@ -0,0 +1,87 @@
+package bmp
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// ChannelStats summarizes the 8-bit samples of a channel.
+type ChannelStats struct {
+	Min, Max     uint8
+	Mean, StdDev float64
+}
+
+// Stats holds the statistics of the non-premultiplied channels of an
+// image.
+type Stats struct {
+	R, G, B, A ChannelStats
+}
+
+// Stats returns the statistics of the channels counted by h.
+func (h *Histogram) Stats() Stats {
+	return Stats{
+		R: channelStats(&h.R, h.Pixels),
+		G: channelStats(&h.G, h.Pixels),
+		B: channelStats(&h.B, h.Pixels),
+		A: channelStats(&h.A, h.Pixels),
+	}
+}
+
+func channelStats(counts *[256]uint64, n uint64) ChannelStats {
+	var s ChannelStats
+	if n == 0 {
+		return s
+	}
+
+	s.Min = 0xff
+	var sum, sq float64
+	for v, c := range counts {
+		if c == 0 {
+			continue
+		}
+		if uint8(v) < s.Min {
+			s.Min = uint8(v)
+		}
+		s.Max = uint8(v)
+		sum += float64(v) * float64(c)
+		sq += float64(v) * float64(v) * float64(c)
+	}
+
+	s.Mean = sum / float64(n)
+	s.StdDev = math.Sqrt(math.Max(0, sq/float64(n)-s.Mean*s.Mean))
+
+	return s
+}
+
+// DecodeStats reads a BMP image from r and returns the statistics of its
+// channels, computed while the rows are read like DecodeHistogram. A
+// maximum of zero reveals an all-black channel, a large share of 255 in
+// the histogram clipped highlights.
+func DecodeStats(r io.Reader, opts ...Option) (Stats, error) {
+	h, err := DecodeHistogram(r, opts...)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return h.Stats(), nil
+}
+
+// ImageStats returns the statistics of the channels of a decoded image.
+func ImageStats(m image.Image) Stats {
+	var h Histogram
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			h.R[c.R]++
+			h.G[c.G]++
+			h.B[c.B]++
+			h.A[c.A]++
+		}
+	}
+	h.Pixels = uint64(b.Dx() * b.Dy())
+
+	return h.Stats()
+}
@@ -0,0 +1,40 @@
+package bmp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestDecodeStats(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i, v := range []uint8{10, 20, 30, 40} {
+		m.SetNRGBA(i%2, i/2, color.NRGBA{v, 0, 0xff, 0xff})
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := DecodeStats(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.R.Min != 10 || s.R.Max != 40 || s.R.Mean != 25 || math.Abs(s.R.StdDev-math.Sqrt(125)) > 1e-9 {
+		t.Errorf("unexpected red statistics: %+v", s.R)
+	}
+	if s.G != (ChannelStats{}) {
+		t.Errorf("unexpected green statistics: %+v", s.G)
+	}
+	if s.B != (ChannelStats{0xff, 0xff, 0xff, 0}) {
+		t.Errorf("unexpected blue statistics: %+v", s.B)
+	}
+
+	if is := ImageStats(m); is != s {
+		t.Errorf("ImageStats = %+v, expected %+v", is, s)
+	}
+}
@@ -0,0 +1,42 @@
+package bmp
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrBottomUp is returned by DecodeStream for images whose rows are stored
+// from bottom to top, unless WithBottomUpRows is set.
+var ErrBottomUp = errors.New("bmp: rows are stored bottom-up")
+
+// DecodeStream reads a BMP image strictly sequentially, calling fn with the
+// y coordinate and the pixels of each row in the format of RowReader.Row.
+// Only a single row is held in memory, and the row slice is reused after fn
+// returns.
+//
+// Rows are delivered from top to bottom. Since most BMP images are stored
+// bottom-up, DecodeStream fails with ErrBottomUp before reading their pixel
+// data, unless WithBottomUpRows(true) is set, in which case their rows are
+// delivered from bottom to top.
+func DecodeStream(r io.Reader, fn func(y int, row []byte) error, opts ...Option) (err error) {
+	d := NewDecoder(r, opts...)
+	d.path = "stream"
+	defer func() { d.report("stream", err) }()
+
+	if _, err := d.Config(); err != nil {
+		return err
+	}
+
+	if !d.topDown && !d.opts.bottomUp {
+		return ErrBottomUp
+	}
+
+	rows := d.Rows()
+	for rows.Next() {
+		if err := fn(rows.Y(), rows.Row()); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,52 @@
+package bmp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeStream(t *testing.T) {
+	src := testImage(4, 3)
+
+	for _, topDown := range []bool{false, true} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetTopDown(topDown)
+		if err := e.Encode(src); err != nil {
+			t.Fatal(err)
+		}
+
+		var ys []int
+		fn := func(y int, row []byte) error {
+			ys = append(ys, y)
+			if !bytes.Equal(row, src.Pix[y*src.Stride:(y+1)*src.Stride]) {
+				t.Errorf("top-down %v: row %d differs", topDown, y)
+			}
+			return nil
+		}
+
+		err := DecodeStream(bytes.NewReader(buf.Bytes()), fn)
+		if !topDown {
+			if !errors.Is(err, ErrBottomUp) {
+				t.Errorf("bottom-up: err = %v, expected ErrBottomUp", err)
+			}
+			if len(ys) != 0 {
+				t.Errorf("bottom-up: got rows %v before the error", ys)
+			}
+
+			err = DecodeStream(bytes.NewReader(buf.Bytes()), fn, WithBottomUpRows(true))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []int{0, 1, 2}
+		if !topDown {
+			expected = []int{2, 1, 0}
+		}
+		if len(ys) != len(expected) || ys[0] != expected[0] || ys[2] != expected[2] {
+			t.Errorf("top-down %v: rows = %v, expected %v", topDown, ys, expected)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package bmp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// ErrCompressed is returned by the random access APIs when the pixel data
+// is compressed and rows cannot be located without decoding the previous
+// ones.
+var ErrCompressed = errors.New("bmp: random access to compressed pixel data")
+
+// DecodeTiles reads a BMP image from r and calls fn for each tileW×tileH
+// tile, from left to right and top to bottom. Tiles on the right and bottom
+// edges may be smaller. Only the bytes of the current tile are read, so
+// memory usage is bounded by the tile size regardless of the image size.
+//
+// The bounds of each tile are rect, in the coordinates of the whole image.
+// The pixel data must be uncompressed.
+func DecodeTiles(r io.ReaderAt, tileW, tileH int, fn func(rect image.Rectangle, tile image.Image) error, opts ...Option) (err error) {
+	if tileW <= 0 || tileH <= 0 {
+		return fmt.Errorf("bmp: tile width and height must be greater than zero (width: %d, height: %d)", tileW, tileH)
+	}
+
+	d := NewDecoder(io.NewSectionReader(r, 0, math.MaxInt64), opts...)
+	d.path = "tiles"
+	defer func() { d.report("tiles", err) }()
+	if _, err := d.Config(); err != nil {
+		return err
+	}
+
+	if d.compressed() {
+		return ErrCompressed
+	}
+	if err := d.resolveAlpha(); err != nil {
+		return err
+	}
+
+	stride := int64(len(d.row))
+	for y0 := 0; y0 < d.height; y0 += tileH {
+		for x0 := 0; x0 < d.width; x0 += tileW {
+			rect := image.Rect(x0, y0, x0+tileW, y0+tileH).Intersect(image.Rect(0, 0, d.width, d.height))
+
+			// the columns of the tile as stored
+			minX, maxX := rect.Min.X, rect.Max.X
+			if d.mirrored {
+				minX, maxX = d.width-maxX, d.width-minX
+			}
+
+			// read whole bytes, starting at a byte boundary for bpp < 8
+			start := minX * d.bpp / 8
+			end := (maxX*d.bpp + 7) / 8
+			skip := minX - start*8/d.bpp
+			src := make([]byte, end-start)
+
+			tile, pix, tileStride := d.newImage(rect, 0)
+			row := make([]byte, (rect.Dx()+skip)*d.pixelLen())
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				// the mapping between stored rows and y is its own inverse
+				n := d.rowY(y)
+
+				if k, err := r.ReadAt(src, d.dataOffset+int64(n)*stride+int64(start)); k < len(src) {
+					if err == io.EOF {
+						err = io.ErrUnexpectedEOF
+					}
+					return err
+				}
+				d.extraRead += int64(len(src))
+
+				if err := d.unpackRow(row, src); err != nil {
+					return err
+				}
+				if d.mirrored {
+					d.mirrorRow(row[skip*d.pixelLen():])
+				}
+				copy(pix[(y-rect.Min.Y)*tileStride:], row[skip*d.pixelLen():])
+			}
+
+			if err := fn(rect, tile); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package bmp
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestDecodeTiles(t *testing.T) {
+	for _, topDown := range []bool{false, true} {
+		src := testImage(7, 5)
+
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetTopDown(topDown)
+		if err := e.Encode(src); err != nil {
+			t.Fatal(err)
+		}
+
+		full, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var rects []image.Rectangle
+		err = DecodeTiles(bytes.NewReader(buf.Bytes()), 3, 2, func(rect image.Rectangle, tile image.Image) error {
+			rects = append(rects, rect)
+			if tile.Bounds() != rect {
+				t.Errorf("tile bounds = %v, expected %v", tile.Bounds(), rect)
+			}
+			if !sameImage(tile, full.(*image.RGBA).SubImage(rect)) {
+				t.Errorf("top-down %v: tile %v differs", topDown, rect)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(rects) != 9 || rects[8] != image.Rect(6, 4, 7, 5) {
+			t.Errorf("tiles = %v, expected 3x3 tiles ending at (6,4)-(7,5)", rects)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package bmp
+
+import "time"
+
+// Tracer records the spans of decodes and encodes, for instance in
+// distributed traces. The package does not depend on OpenTelemetry: a
+// trace.Tracer is adapted by creating the span after the fact with the
+// timestamps of the Span:
+//
+//	func (t otelTracer) Span(s bmp.Span) {
+//		_, span := t.tracer.Start(t.ctx, s.Name,
+//			trace.WithTimestamp(s.Start),
+//			trace.WithAttributes(
+//				attribute.Int("bmp.width", s.Width),
+//				attribute.Int("bmp.height", s.Height),
+//				attribute.Int("bmp.bpp", s.BitsPerPixel),
+//				attribute.Int("bmp.compression", int(s.Compression)),
+//			))
+//		if s.Err != nil {
+//			span.RecordError(s.Err)
+//			span.SetStatus(codes.Error, s.Err.Error())
+//		}
+//		span.End(trace.WithTimestamp(s.End))
+//	}
+//
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	Span(s Span)
+}
+
+// Span describes a decode or an encode.
+type Span struct {
+	// Name is "bmp.Decode" or "bmp.Encode".
+	Name string
+	// Path is the decoding API, as passed to Metrics.Decoded, and empty
+	// for encodes.
+	Path       string
+	Start, End time.Time
+	// Width, Height, BitsPerPixel and Compression are zero if the header
+	// could not be decoded.
+	Width, Height int
+	BitsPerPixel  int
+	Compression   Compression
+	Err           error
+}
@@ -0,0 +1,57 @@
+package bmp
+
+import (
+	"bytes"
+	"image"
+	"sync"
+	"testing"
+)
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (r *recordingTracer) Span(s Span) {
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+}
+
+func TestTracer(t *testing.T) {
+	var tr recordingTracer
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(32)
+	e.SetTracer(&tr)
+	if err := e.Encode(image.NewNRGBA(image.Rect(0, 0, 5, 3))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(&buf, WithTracer(&tr)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tr.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(tr.spans))
+	}
+	for i, name := range []string{"bmp.Encode", "bmp.Decode"} {
+		s := tr.spans[i]
+		if s.Name != name || s.Width != 5 || s.Height != 3 || s.BitsPerPixel != 32 || s.Err != nil || s.End.Before(s.Start) {
+			t.Errorf("unexpected span: %+v", s)
+		}
+	}
+	if tr.spans[1].Path != "image" {
+		t.Errorf("path = %q, want image", tr.spans[1].Path)
+	}
+}
+
+func TestTracerFailure(t *testing.T) {
+	var tr recordingTracer
+	if _, err := Decode(bytes.NewReader([]byte("BM")), WithTracer(&tr)); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(tr.spans) != 1 || tr.spans[0].Err == nil || tr.spans[0].Width != 0 {
+		t.Errorf("unexpected spans: %+v", tr.spans)
+	}
+}
@@ -0,0 +1,125 @@
+package bmp
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// ColorTransformer converts the colors of decoded images, so that a color
+// management module, such as a binding of an ICC library, can be plugged in
+// without the package depending on it.
+type ColorTransformer interface {
+	// TransformRow converts a row of 8-bit, non-premultiplied RGBA samples
+	// in place from the color space described by src. The colors of
+	// paletted images are converted once, as a single row. DecodeConcurrent
+	// calls it from several goroutines.
+	TransformRow(src ColorSource, row []byte)
+}
+
+// ColorSource describes the color space of a decoded image.
+type ColorSource struct {
+	// ColorSpace holds the color space of V4 and V5 headers, nil
+	// otherwise.
+	ColorSpace *ColorSpace
+	// Profile is the embedded ICC profile of a V5 header. It is only
+	// available when stored before the pixel data, nil otherwise.
+	Profile []byte
+}
+
+// ColorSource returns the color space of the image with its embedded
+// profile, to be carried to the encoded copies of the image with
+// Encoder.SetColorSource. Unlike the ColorSource given to a
+// ColorTransformer, it holds the profiles stored after the pixel data, as
+// Encoder writes them, when the input is an io.ReaderAt and an io.Seeker,
+// such as an *os.File, which are read without moving the input.
+func (d *Decoder) ColorSource() (ColorSource, error) {
+	if err := d.advance(stageConfig); err != nil {
+		return ColorSource{}, err
+	}
+
+	if d.profile == nil {
+		if err := d.readProfileAt(); err != nil {
+			return ColorSource{}, err
+		}
+	}
+
+	return d.colorSource(), nil
+}
+
+// readProfileAt reads the embedded profile stored after the pixel data, if
+// the input is an io.ReaderAt whose position is known.
+func (d *Decoder) readProfileAt() error {
+	const fileHeaderLen = 14
+
+	start, end, ok := d.profileRange()
+	ra, isReaderAt := d.src.(io.ReaderAt)
+	if !ok || !isReaderAt || !d.originOK || start < d.dataOffset {
+		return nil
+	}
+	if max := d.opts.limits.MaxFileSize; max > 0 && end > max {
+		return fmt.Errorf("%w: profile ends at byte %d (max: %d)", ErrLimitExceeded, end, max)
+	}
+
+	offset := d.origin + start
+	if d.dib {
+		offset -= fileHeaderLen
+	}
+	b := make([]byte, end-start)
+	if _, err := ra.ReadAt(b, offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+	d.profile = b
+
+	return nil
+}
+
+func (d *Decoder) colorSource() ColorSource {
+	return ColorSource{ColorSpace: d.meta.ColorSpace, Profile: d.profile}
+}
+
+// transformPalette converts the colors of p in place with t.
+func transformPalette(t ColorTransformer, src ColorSource, p color.Palette) {
+	row := make([]byte, 4*len(p))
+	for i, c := range p {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		row[4*i], row[4*i+1], row[4*i+2], row[4*i+3] = n.R, n.G, n.B, n.A
+	}
+
+	t.TransformRow(src, row)
+
+	for i := range p {
+		n := color.NRGBA{row[4*i], row[4*i+1], row[4*i+2], row[4*i+3]}
+		if n.A == 0xff {
+			p[i] = color.RGBA{n.R, n.G, n.B, 0xff}
+		} else {
+			p[i] = n
+		}
+	}
+}
+
+// profileRange returns the location of the embedded profile within the
+// file, or ok false if there is none.
+func (d *Decoder) profileRange() (start, end int64, ok bool) {
+	const fileHeaderLen = 14
+
+	cs := d.meta.ColorSpace
+	if cs == nil || cs.Type != ColorSpaceProfileEmbedded || cs.ProfileSize == 0 {
+		return 0, 0, false
+	}
+	start = fileHeaderLen + int64(cs.ProfileOffset)
+
+	return start, start + int64(cs.ProfileSize), true
+}
+
+// profileInGap reports whether the embedded profile lies between the end
+// of the headers and the color table, expected, and the pixel data.
+func (d *Decoder) profileInGap(expected, offset int64) bool {
+	start, end, ok := d.profileRange()
+
+	return ok && start >= expected && end <= offset
+}
@@ -0,0 +1,160 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+// v5Image returns a 24 bpp BMP with a V5 header embedding profile between
+// the header and its single row of pixels, given as RGB triples.
+func v5Image(profile []byte, pixels ...[3]uint8) []byte {
+	const headerLen = 14 + 124
+	offset := headerLen + len(profile)
+
+	stride := (len(pixels)*3 + 3) &^ 3
+	b := make([]byte, offset+stride)
+	copy(b[0:2], "BM")
+	binary.LittleEndian.PutUint32(b[2:6], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[10:14], uint32(offset))
+	binary.LittleEndian.PutUint32(b[14:18], 124)
+	binary.LittleEndian.PutUint32(b[18:22], uint32(len(pixels)))
+	binary.LittleEndian.PutUint32(b[22:26], 1)
+	binary.LittleEndian.PutUint16(b[26:28], 1)
+	binary.LittleEndian.PutUint16(b[28:30], 24)
+	binary.LittleEndian.PutUint32(b[70:74], uint32(ColorSpaceProfileEmbedded))
+	binary.LittleEndian.PutUint32(b[126:130], 124)
+	binary.LittleEndian.PutUint32(b[130:134], uint32(len(profile)))
+	copy(b[headerLen:], profile)
+	for i, p := range pixels {
+		// BGR order
+		b[offset+3*i], b[offset+3*i+1], b[offset+3*i+2] = p[2], p[1], p[0]
+	}
+
+	return b
+}
+
+// invertTransformer inverts the colors and records the sources.
+type invertTransformer struct {
+	sources []ColorSource
+}
+
+func (t *invertTransformer) TransformRow(src ColorSource, row []byte) {
+	t.sources = append(t.sources, src)
+	for i := 0; i+3 < len(row); i += 4 {
+		row[i], row[i+1], row[i+2] = ^row[i], ^row[i+1], ^row[i+2]
+	}
+}
+
+func TestColorTransformer(t *testing.T) {
+	var tr invertTransformer
+	m, err := Decode(bytes.NewReader(v5Image([]byte("profile"), [3]uint8{0, 0x10, 0xff}, [3]uint8{})), WithColorTransformer(&tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := color.RGBAModel.Convert(m.At(0, 0)); c != (color.RGBA{0xff, 0xef, 0, 0xff}) {
+		t.Errorf("got %v", c)
+	}
+	if len(tr.sources) != 1 {
+		t.Fatalf("TransformRow called %d times, want 1", len(tr.sources))
+	}
+	src := tr.sources[0]
+	if src.ColorSpace == nil || src.ColorSpace.Type != ColorSpaceProfileEmbedded || string(src.Profile) != "profile" {
+		t.Errorf("unexpected source: %+v", src)
+	}
+}
+
+func TestColorTransformerPalette(t *testing.T) {
+	m := testPaletted(2, 1, 16)
+	m.Pix = []uint8{0, 1}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetDepth(4)
+	if err := e.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	var tr invertTransformer
+	got, err := Decode(&buf, WithColorTransformer(&tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tr.sources) != 1 || tr.sources[0].ColorSpace != nil {
+		t.Errorf("unexpected sources: %+v", tr.sources)
+	}
+	for x := 0; x < 2; x++ {
+		r0, g0, b0, _ := m.At(x, 0).RGBA()
+		r1, g1, b1, _ := got.At(x, 0).RGBA()
+		if r1 != 0xffff-r0 || g1 != 0xffff-g0 || b1 != 0xffff-b0 {
+			t.Errorf("pixel %d not inverted: %v, %v", x, m.At(x, 0), got.At(x, 0))
+		}
+	}
+}
+
+func TestProfileGapStrict(t *testing.T) {
+	// the gap holding the profile is accepted without WithLenient
+	b := v5Image([]byte("profile"), [3]uint8{})
+	if _, err := Decode(bytes.NewReader(b), WithUntrusted()); err != nil {
+		t.Fatal(err)
+	}
+
+	// but not if the profile is stored elsewhere
+	binary.LittleEndian.PutUint32(b[126:130], uint32(len(b)-14))
+	if _, err := Decode(bytes.NewReader(b)); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestColorSourceCarry(t *testing.T) {
+	icc := []byte("not really an ICC profile")
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetProfile(icc)
+	if err := e.Encode(testImage(3, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the profile follows the pixel data
+	src, err := NewDecoder(bytes.NewReader(buf.Bytes())).ColorSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src.Profile, icc) {
+		t.Fatalf("profile %q, expected %q", src.Profile, icc)
+	}
+	if src, _ := NewDecoder(bytes.NewBuffer(buf.Bytes())).ColorSource(); src.Profile != nil {
+		t.Errorf("profile after the pixel data read from a stream")
+	}
+
+	var out bytes.Buffer
+	if err := Sanitize(&out, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if src, err = NewDecoder(bytes.NewReader(out.Bytes())).ColorSource(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src.Profile, icc) {
+		t.Errorf("sanitized profile %q, expected %q", src.Profile, icc)
+	}
+
+	cs := ColorSpace{Type: ColorSpaceCalibratedRGB, Gamma: [3]float64{2.2, 2.2, 2.2}}
+	cs.Endpoints[0] = CIEXYZ{0.64, 0.33, 0.03}
+	buf.Reset()
+	e = NewEncoder(&buf)
+	e.SetColorSource(ColorSource{ColorSpace: &cs})
+	if err := e.Encode(testImage(3, 2)); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := NewDecoder(&buf).Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := meta.ColorSpace; got == nil || got.Type != cs.Type || got.Gamma[1] < 2.19 || got.Gamma[1] > 2.21 {
+		t.Errorf("color space %+v, expected %+v", got, cs)
+	}
+}
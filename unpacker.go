@@ -0,0 +1,263 @@
+package bmp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// PixelUnpacker converts the stored pixels of a row to unpacked pixels.
+type PixelUnpacker interface {
+	// Unpack converts the stored row src into dst, whose length determines
+	// the number of pixels. Pixels of paletted formats (up to 8 bpp) are
+	// unpacked to one palette index each, others to 4 bytes of
+	// non-premultiplied 8-bit RGBA samples.
+	Unpack(dst, src []byte)
+}
+
+// UnpackerKey identifies the formats of stored pixels.
+type UnpackerKey struct {
+	BitsPerPixel int
+	// Compression is CompressionRGB for plain pixels; RLE compressed images
+	// use the unpacker of plain pixels for their decompressed indices.
+	Compression Compression
+	// RedMask, GreenMask, BlueMask and AlphaMask are the channel masks of
+	// the bit fields compressions, zero otherwise.
+	RedMask, GreenMask, BlueMask, AlphaMask uint32
+}
+
+// unpackerEntry is a registered unpacker.
+type unpackerEntry struct {
+	u PixelUnpacker
+	// opaque reports whether the unpacked samples are always opaque
+	opaque bool
+}
+
+var (
+	unpackersMu sync.RWMutex
+	unpackers   = map[UnpackerKey]unpackerEntry{}
+)
+
+func init() {
+	for _, bpp := range []int{1, 2, 4, 8} {
+		unpackers[UnpackerKey{BitsPerPixel: bpp}] = unpackerEntry{palettedUnpacker(bpp), false}
+	}
+
+	unpackers[UnpackerKey{BitsPerPixel: 16}] = unpackerEntry{unpackFunc(unpack16), true}
+	unpackers[UnpackerKey{BitsPerPixel: 24}] = unpackerEntry{unpackFunc(unpack24), true}
+	unpackers[UnpackerKey{BitsPerPixel: 32}] = unpackerEntry{unpackFunc(unpack32), false}
+
+	// bit fields laid out like the plain formats
+	unpackers[UnpackerKey{16, CompressionBitfields, 0x7c00, 0x03e0, 0x001f, 0}] = unpackers[UnpackerKey{BitsPerPixel: 16}]
+	unpackers[UnpackerKey{16, CompressionBitfields, 0xf800, 0x07e0, 0x001f, 0}] = unpackerEntry{unpackFunc(unpack565), true}
+	for _, alpha := range []uint32{0, 0xff000000} {
+		unpackers[UnpackerKey{32, CompressionBitfields, 0xff0000, 0xff00, 0xff, alpha}] = unpackers[UnpackerKey{BitsPerPixel: 32}]
+	}
+}
+
+// RegisterUnpacker registers u for the pixels identified by key, so that
+// images in a format the package does not support can be decoded. It
+// panics if an unpacker is already registered for key.
+func RegisterUnpacker(key UnpackerKey, u PixelUnpacker) {
+	unpackersMu.Lock()
+	defer unpackersMu.Unlock()
+
+	if _, ok := unpackers[key]; ok {
+		panic(fmt.Sprintf("bmp: RegisterUnpacker called twice for %+v", key))
+	}
+	unpackers[key] = unpackerEntry{u: u}
+}
+
+// lookupUnpacker returns the unpacker registered for key.
+func lookupUnpacker(key UnpackerKey) (unpackerEntry, bool) {
+	unpackersMu.RLock()
+	defer unpackersMu.RUnlock()
+
+	e, ok := unpackers[key]
+	return e, ok
+}
+
+// unpackFunc is a PixelUnpacker calling itself.
+type unpackFunc func(dst, src []byte)
+
+func (f unpackFunc) Unpack(dst, src []byte) { f(dst, src) }
+
+// palettedUnpacker unpacks the indices of the given number of bits per
+// pixel, packed from the most significant bits of each byte. The last byte
+// of a row may hold fewer pixels than it has room for.
+type palettedUnpacker int
+
+func (u palettedUnpacker) Unpack(p, src []byte) {
+	bpp := uint(u)
+	perByte := 8 / int(bpp)
+	mask := byte(1)<<bpp - 1
+	for x := range p {
+		shift := 8 - bpp*uint(x%perByte+1)
+		p[x] = src[x/perByte] >> shift & mask
+	}
+}
+
+// expand5 and expand6 scale 5 and 6-bit samples to 8 bits, replicating
+// their high bits so that the maximum maps to 0xff.
+func expand5(v uint16) uint8 {
+	v &= 0x1f
+	return uint8(v<<3 | v>>2)
+}
+
+func expand6(v uint16) uint8 {
+	v &= 0x3f
+	return uint8(v<<2 | v>>4)
+}
+
+// unpack16 unpacks little-endian 5-5-5 pixels, the top bit being unused.
+func unpack16(p, src []byte) {
+	for i, j := 0, 0; i < len(p); i, j = i+4, j+2 {
+		v := uint16(src[j]) | uint16(src[j+1])<<8
+		p[i] = expand5(v >> 10)
+		p[i+1] = expand5(v >> 5)
+		p[i+2] = expand5(v)
+		p[i+3] = 0xff
+	}
+}
+
+// unpack565 unpacks little-endian 5-6-5 pixels.
+func unpack565(p, src []byte) {
+	for i, j := 0, 0; i < len(p); i, j = i+4, j+2 {
+		v := uint16(src[j]) | uint16(src[j+1])<<8
+		p[i] = expand5(v >> 11)
+		p[i+1] = expand6(v >> 5)
+		p[i+2] = expand5(v)
+		p[i+3] = 0xff
+	}
+}
+
+func unpack24(p, src []byte) {
+	for i, j := 0, 0; i < len(p); i, j = i+4, j+3 {
+		// BGR order
+		p[i] = src[j+2]
+		p[i+1] = src[j+1]
+		p[i+2] = src[j]
+		p[i+3] = 0xff
+	}
+}
+
+func unpack32(p, src []byte) {
+	for i := 0; i < len(p); i += 4 {
+		// BGRA order
+		p[i] = src[i+2]
+		p[i+1] = src[i+1]
+		p[i+2] = src[i]
+		p[i+3] = src[i+3]
+	}
+}
+
+// unpack32Opaque unpacks 32 bpp pixels whose fourth byte is ignored.
+func unpack32Opaque(p, src []byte) {
+	for i := 0; i < len(p); i += 4 {
+		p[i] = src[i+2]
+		p[i+1] = src[i+1]
+		p[i+2] = src[i]
+		p[i+3] = 0xff
+	}
+}
+
+// unpack32Premultiplied unpacks 32 bpp pixels holding premultiplied
+// samples, clamping the samples greater than alpha.
+func unpack32Premultiplied(p, src []byte) {
+	unpack32(p, src)
+	for i := 0; i < len(p); i += 4 {
+		for j := i; j < i+3; j++ {
+			if p[j] > p[i+3] {
+				p[j] = p[i+3]
+			}
+		}
+	}
+	unpremultiply(p, p)
+}
+
+// reservedAlphaUnpacker returns the unpacker of the 32 bpp pixels
+// identified by key for the given AlphaMode, and false if their fourth
+// byte is not reserved.
+func reservedAlphaUnpacker(key UnpackerKey, m AlphaMode) (unpackerEntry, bool) {
+	if !reservedAlpha(key) {
+		return unpackerEntry{}, false
+	}
+
+	switch m {
+	case AlphaIgnore:
+		return unpackerEntry{unpackFunc(unpack32Opaque), true}, true
+	case AlphaPremultiplied:
+		return unpackerEntry{unpackFunc(unpack32Premultiplied), false}, true
+	}
+
+	return unpackerEntry{}, false
+}
+
+// reservedAlpha reports whether the fourth byte of the 32 bpp pixels
+// identified by key is reserved.
+func reservedAlpha(key UnpackerKey) bool {
+	return key == (UnpackerKey{BitsPerPixel: 32}) || key == (UnpackerKey{32, CompressionBitfields, 0xff0000, 0xff00, 0xff, 0})
+}
+
+// autoAlpha reports whether the reserved byte of the pixels is alpha
+// unless zero for every pixel, which the pixel data has not told yet.
+func (d *Decoder) autoAlpha() bool {
+	return d.opts.alpha == AlphaAuto && !d.alphaResolved && d.codec == nil && reservedAlpha(d.key)
+}
+
+// resolveAlpha scans the pixel data before it is decoded, for AlphaAuto,
+// and makes the pixels opaque if their reserved byte is zero for every
+// pixel. Seekable inputs are read again from the start of the pixel data;
+// the rows scanned from the others are buffered for the decode.
+func (d *Decoder) resolveAlpha() error {
+	if !d.autoAlpha() {
+		return nil
+	}
+	d.alphaResolved = true
+
+	if s, ok := d.src.(io.Seeker); ok {
+		if cur, err := s.Seek(0, io.SeekCurrent); err == nil {
+			d.setZeroAlpha(d.zeroAlpha(d.src))
+			_, err := s.Seek(cur, io.SeekStart)
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	d.setZeroAlpha(d.zeroAlpha(io.TeeReader(d.r, &buf)))
+	d.r = io.MultiReader(&buf, d.r)
+
+	return nil
+}
+
+// zeroAlpha reports whether the fourth byte of every pixel of the stored
+// rows read from r is zero. A short read ends the scan, leaving the error
+// to the decode.
+func (d *Decoder) zeroAlpha(r io.Reader) bool {
+	for n := 0; n < d.height; n++ {
+		if _, err := io.ReadFull(r, d.row); err != nil {
+			return true
+		}
+		for i := 3; i < d.width*4; i += 4 {
+			if d.row[i] != 0 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// setZeroAlpha makes the pixels opaque if zero is true, as with
+// AlphaIgnore.
+func (d *Decoder) setZeroAlpha(zero bool) {
+	if !zero {
+		return
+	}
+
+	d.unpacker = unpackerEntry{unpackFunc(unpack32Opaque), true}
+	if d.opts.model == nil {
+		d.config.ColorModel = d.rowModel()
+	}
+}
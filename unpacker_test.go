@@ -0,0 +1,382 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// rawFile returns a BMP file with a V4 header, the given palette and rows
+// of stored pixel data, which must be padded.
+func rawFile(key UnpackerKey, width, height int, p color.Palette, rows []byte) []byte {
+	offset := 14 + 108 + len(p)*4
+
+	b := make([]byte, offset, offset+len(rows))
+	copy(b, "BM")
+	binary.LittleEndian.PutUint32(b[2:], uint32(offset+len(rows)))
+	binary.LittleEndian.PutUint32(b[10:], uint32(offset))
+	binary.LittleEndian.PutUint32(b[14:], 108)
+	binary.LittleEndian.PutUint32(b[18:], uint32(width))
+	binary.LittleEndian.PutUint32(b[22:], uint32(height))
+	binary.LittleEndian.PutUint16(b[26:], 1)
+	binary.LittleEndian.PutUint16(b[28:], uint16(key.BitsPerPixel))
+	binary.LittleEndian.PutUint32(b[30:], uint32(key.Compression))
+	binary.LittleEndian.PutUint32(b[46:], uint32(len(p)))
+	binary.LittleEndian.PutUint32(b[54:], key.RedMask)
+	binary.LittleEndian.PutUint32(b[58:], key.GreenMask)
+	binary.LittleEndian.PutUint32(b[62:], key.BlueMask)
+	binary.LittleEndian.PutUint32(b[66:], key.AlphaMask)
+	for i, c := range p {
+		r, g, bl, _ := c.RGBA()
+		b[122+i*4], b[122+i*4+1], b[122+i*4+2] = uint8(bl>>8), uint8(g>>8), uint8(r>>8)
+	}
+
+	return append(b, rows...)
+}
+
+// register registers u unless a previous run of the tests did.
+func register(key UnpackerKey, u PixelUnpacker) {
+	if _, ok := lookupUnpacker(key); !ok {
+		RegisterUnpacker(key, u)
+	}
+}
+
+func TestRegisterUnpacker(t *testing.T) {
+	// 2 bpp indices
+	key2 := UnpackerKey{BitsPerPixel: 2}
+	register(key2, unpackFunc(func(dst, src []byte) {
+		for x := range dst {
+			dst[x] = src[x/4] >> uint(6-2*(x%4)) & 3
+		}
+	}))
+
+	// 32 bpp RGBA instead of BGRA
+	keyRGBA := UnpackerKey{32, CompressionBitfields, 0xff, 0xff00, 0xff0000, 0xff000000}
+	register(keyRGBA, unpackFunc(func(dst, src []byte) {
+		copy(dst, src)
+	}))
+
+	p := color.Palette{
+		color.RGBA{0, 0, 0, 0xff},
+		color.RGBA{0x10, 0, 0, 0xff},
+		color.RGBA{0x20, 0, 0, 0xff},
+		color.RGBA{0x30, 0, 0, 0xff},
+	}
+
+	tests := []struct {
+		name     string
+		file     []byte
+		expected image.Image
+	}{
+		{
+			"2 bpp",
+			rawFile(key2, 5, 1, p, []byte{0x1b, 0xc0, 0, 0}),
+			&image.Paletted{Pix: []uint8{0, 1, 2, 3, 3}, Stride: 5, Rect: image.Rect(0, 0, 5, 1), Palette: p},
+		},
+		{
+			"32 bpp RGBA",
+			rawFile(keyRGBA, 2, 1, nil, []byte{1, 2, 3, 4, 5, 6, 7, 8}),
+			&image.NRGBA{Pix: []uint8{1, 2, 3, 4, 5, 6, 7, 8}, Stride: 8, Rect: image.Rect(0, 0, 2, 1)},
+		},
+	}
+
+	for _, tt := range tests {
+		m, err := Decode(bytes.NewReader(tt.file))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !sameImage(m, tt.expected) {
+			t.Errorf("%s: unexpected image", tt.name)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic registering an unpacker twice")
+		}
+	}()
+	RegisterUnpacker(UnpackerKey{BitsPerPixel: 24}, unpackFunc(unpack24))
+}
+
+func TestUnsupportedBitfields(t *testing.T) {
+	key := UnpackerKey{32, CompressionBitfields, 0xff00, 0xff, 0xff0000, 0}
+	if _, err := Decode(bytes.NewReader(rawFile(key, 1, 1, nil, make([]byte, 4)))); err == nil {
+		t.Errorf("expected an error for unregistered bit fields")
+	}
+}
+
+func TestDecode16(t *testing.T) {
+	tests := []struct {
+		key  UnpackerKey
+		rows []byte
+		want []color.RGBA
+	}{
+		// 5-5-5: white, red, green, blue
+		{UnpackerKey{BitsPerPixel: 16}, []byte{0xff, 0x7f, 0x00, 0x7c, 0xe0, 0x03, 0x1f, 0x00},
+			[]color.RGBA{{0xff, 0xff, 0xff, 0xff}, {0xff, 0, 0, 0xff}, {0, 0xff, 0, 0xff}, {0, 0, 0xff, 0xff}}},
+		{UnpackerKey{16, CompressionBitfields, 0x7c00, 0x03e0, 0x001f, 0}, []byte{0x10, 0x42, 0, 0, 0, 0, 0, 0},
+			[]color.RGBA{{0x84, 0x84, 0x84, 0xff}, {0, 0, 0, 0xff}, {0, 0, 0, 0xff}, {0, 0, 0, 0xff}}},
+		// 5-6-5: white, red, green, blue
+		{UnpackerKey{16, CompressionBitfields, 0xf800, 0x07e0, 0x001f, 0}, []byte{0xff, 0xff, 0x00, 0xf8, 0xe0, 0x07, 0x1f, 0x00},
+			[]color.RGBA{{0xff, 0xff, 0xff, 0xff}, {0xff, 0, 0, 0xff}, {0, 0xff, 0, 0xff}, {0, 0, 0xff, 0xff}}},
+	}
+
+	for _, tt := range tests {
+		m, err := Decode(bytes.NewReader(rawFile(tt.key, 4, 1, nil, tt.rows)))
+		if err != nil {
+			t.Fatalf("%+v: %v", tt.key, err)
+		}
+		for x, want := range tt.want {
+			if got := color.RGBAModel.Convert(m.At(x, 0)); got != want {
+				t.Errorf("%+v: pixel %d = %v, want %v", tt.key, x, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeGray16(t *testing.T) {
+	tests := []struct {
+		key  UnpackerKey
+		rows []byte
+		want []uint16
+	}{
+		// 12-bit depth samples: 0, maximum, half
+		{UnpackerKey{16, CompressionBitfields, 0x0fff, 0, 0, 0}, []byte{0, 0, 0xff, 0x0f, 0xff, 0x07, 0, 0},
+			[]uint16{0, 0xffff, 0x7ff7, 0}},
+		{UnpackerKey{16, CompressionBitfields, 0xffff, 0xffff, 0xffff, 0}, []byte{0x34, 0x12, 0xff, 0xff, 0, 0, 0, 0},
+			[]uint16{0x1234, 0xffff, 0, 0}},
+		{UnpackerKey{16, CompressionBitfields, 0, 0xff00, 0, 0}, []byte{0x12, 0x80, 0, 0, 0, 0, 0, 0},
+			[]uint16{0x8080, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		file := rawFile(tt.key, 4, 1, nil, tt.rows)
+		m, err := Decode(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("%+v: %v", tt.key, err)
+		}
+		g, ok := m.(*image.Gray16)
+		if !ok {
+			t.Fatalf("%+v: decoded a %T", tt.key, m)
+		}
+		for x, want := range tt.want {
+			if got := g.Gray16At(x, 0).Y; got != want {
+				t.Errorf("%+v: pixel %d = %#x, want %#x", tt.key, x, got, want)
+			}
+		}
+
+		if cfg, _ := DecodeConfig(bytes.NewReader(file)); cfg.ColorModel != color.Gray16Model {
+			t.Errorf("%+v: color model %v", tt.key, cfg.ColorModel)
+		}
+		// rows have 8-bit samples
+		rr := NewDecoder(bytes.NewReader(file)).Rows()
+		if !rr.Next() || rr.Row()[4] != uint8(tt.want[1]>>8) {
+			t.Errorf("%+v: row %v", tt.key, rr.Row())
+		}
+	}
+
+	// channels of different masks are colors
+	key := UnpackerKey{16, CompressionBitfields, 0x0fff, 0xf000, 0, 0}
+	if _, err := Decode(bytes.NewReader(rawFile(key, 1, 1, nil, make([]byte, 4)))); err == nil {
+		t.Errorf("expected an error for unregistered bit fields")
+	}
+}
+
+func TestDecodeAlphaMode(t *testing.T) {
+	// BGRA: half transparent red stored premultiplied, then a pixel whose
+	// color exceeds its alpha
+	rows := []byte{0x00, 0x00, 0x80, 0x80, 0x40, 0x40, 0x40, 0x20}
+	tests := []struct {
+		mode AlphaMode
+		want []color.NRGBA
+	}{
+		{AlphaStraight, []color.NRGBA{{0x80, 0, 0, 0x80}, {0x40, 0x40, 0x40, 0x20}}},
+		{AlphaIgnore, []color.NRGBA{{0x80, 0, 0, 0xff}, {0x40, 0x40, 0x40, 0xff}}},
+		{AlphaPremultiplied, []color.NRGBA{{0xff, 0, 0, 0x80}, {0xff, 0xff, 0xff, 0x20}}},
+	}
+
+	for _, key := range []UnpackerKey{{BitsPerPixel: 32}, {32, CompressionBitfields, 0xff0000, 0xff00, 0xff, 0}} {
+		for _, tt := range tests {
+			m, err := Decode(bytes.NewReader(rawFile(key, 2, 1, nil, rows)), WithAlphaMode(tt.mode))
+			if err != nil {
+				t.Fatalf("%v: %v", tt.mode, err)
+			}
+			for x, want := range tt.want {
+				if got := color.NRGBAModel.Convert(m.At(x, 0)); got != want {
+					t.Errorf("%+v, %v: pixel %d = %v, want %v", key, tt.mode, x, got, want)
+				}
+			}
+		}
+	}
+
+	// an explicit alpha mask is not affected
+	key := UnpackerKey{32, CompressionBitfields, 0xff0000, 0xff00, 0xff, 0xff000000}
+	m, err := Decode(bytes.NewReader(rawFile(key, 2, 1, nil, rows)), WithAlphaMode(AlphaIgnore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := color.NRGBAModel.Convert(m.At(0, 0)), (color.NRGBA{0x80, 0, 0, 0x80}); got != want {
+		t.Errorf("alpha mask: pixel 0 = %v, want %v", got, want)
+	}
+
+	// by default, a reserved byte zero for every pixel is not alpha
+	zero := rawFile(UnpackerKey{BitsPerPixel: 32}, 2, 1, nil, []byte{0x00, 0x00, 0x80, 0x00, 0x40, 0x40, 0x40, 0x00})
+	if m, _, err = image.Decode(bytes.NewReader(zero)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := color.NRGBAModel.Convert(m.At(0, 0)), (color.NRGBA{0x80, 0, 0, 0xff}); got != want {
+		t.Errorf("zero alpha: pixel 0 = %v, want %v", got, want)
+	}
+	if m, err = Decode(bytes.NewReader(zero), WithColorModel(BGRAModel)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := color.NRGBAModel.Convert(m.At(1, 0)), (color.NRGBA{0x40, 0x40, 0x40, 0xff}); got != want {
+		t.Errorf("zero alpha, BGRA: pixel 1 = %v, want %v", got, want)
+	}
+	raw, err := DecodeRaw(bytes.NewReader(zero), 1, WithChannelOrder(OrderARGB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Pix[0] != 0xff || raw.Pix[4] != 0xff {
+		t.Errorf("zero alpha, raw ARGB: pixels %x", raw.Pix)
+	}
+	if m, err = Decode(bytes.NewReader(zero), WithAlphaMode(AlphaStraight)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := m.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("zero alpha, straight: alpha = %#x, want 0", a)
+	}
+}
+
+func TestDecodeAutoAlpha(t *testing.T) {
+	// the reserved byte is zero for every pixel of the first file, and only
+	// for the first row of the second one
+	zero := rawFile(UnpackerKey{BitsPerPixel: 32}, 2, 2, nil, []byte{
+		0x00, 0x00, 0x80, 0x00, 0x40, 0x40, 0x40, 0x00,
+		0x00, 0x00, 0x80, 0x00, 0x40, 0x40, 0x40, 0x00,
+	})
+	alpha := rawFile(UnpackerKey{BitsPerPixel: 32}, 2, 2, nil, []byte{
+		0x00, 0x00, 0x80, 0x00, 0x40, 0x40, 0x40, 0x00,
+		0x00, 0x00, 0x80, 0x80, 0x40, 0x40, 0x40, 0x00,
+	})
+
+	// the alpha of pixel (1, 1) from each entry point
+	entries := map[string]func(b []byte) (uint8, error){
+		"Rows": func(b []byte) (uint8, error) {
+			rr := NewDecoder(bytes.NewReader(b)).Rows()
+			rr.Next()
+			return rr.Row()[7], rr.Err()
+		},
+		"Rows without seeking": func(b []byte) (uint8, error) {
+			rr := NewDecoder(io.MultiReader(bytes.NewReader(b))).Rows()
+			rr.Next()
+			return rr.Row()[7], rr.Err()
+		},
+		"DecodeStream": func(b []byte) (a uint8, err error) {
+			err = DecodeStream(bytes.NewReader(b), func(y int, row []byte) error {
+				if y == 1 {
+					a = row[7]
+				}
+				return nil
+			}, WithBottomUpRows(true))
+			return a, err
+		},
+		"DecodeScaled": func(b []byte) (uint8, error) {
+			m, err := DecodeScaled(bytes.NewReader(b), 2, 2, draw.NearestNeighbor)
+			if err != nil {
+				return 0, err
+			}
+			return m.Pix[1*m.Stride+7], nil
+		},
+		"DecodeMipmaps": func(b []byte) (uint8, error) {
+			m, err := DecodeMipmaps(bytes.NewReader(b), 0)
+			if err != nil {
+				return 0, err
+			}
+			return m[0].Pix[1*m[0].Stride+7], nil
+		},
+		"DecodeTiles": func(b []byte) (a uint8, err error) {
+			err = DecodeTiles(bytes.NewReader(b), 1, 1, func(rect image.Rectangle, tile image.Image) error {
+				if rect.Min == image.Pt(1, 1) {
+					_, _, _, a32 := tile.At(1, 1).RGBA()
+					a = uint8(a32 >> 8)
+				}
+				return nil
+			})
+			return a, err
+		},
+		"DecodeConcurrent": func(b []byte) (uint8, error) {
+			m, err := DecodeConcurrent(bytes.NewReader(b), 2)
+			if err != nil {
+				return 0, err
+			}
+			_, _, _, a := m.At(1, 1).RGBA()
+			return uint8(a >> 8), nil
+		},
+		"Parser": func(b []byte) (a uint8, err error) {
+			p := NewParser(ParserEvents{Row: func(y int, row []byte) error {
+				if y == 1 {
+					a = row[7]
+				}
+				return nil
+			}})
+			for i := range b {
+				if _, err := p.Write(b[i : i+1]); err != nil {
+					return 0, err
+				}
+			}
+			return a, p.Close()
+		},
+	}
+
+	for name, fn := range entries {
+		if a, err := fn(zero); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if a != 0xff {
+			t.Errorf("%s: zero alpha decoded as %#x, want 0xff", name, a)
+		}
+		if a, err := fn(alpha); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if a != 0 {
+			t.Errorf("%s: alpha decoded as %#x, want 0", name, a)
+		}
+	}
+}
+
+func TestDecodePalettedWidths(t *testing.T) {
+	for _, bpp := range []int{1, 2, 4, 8} {
+		p := make(color.Palette, 1<<uint(bpp))
+		for i := range p {
+			p[i] = color.RGBA{uint8(i), uint8(i), uint8(i), 0xff}
+		}
+
+		for width := 1; width <= 33; width++ {
+			stride := (width*bpp + 31) / 32 * 4
+			rows := make([]byte, 2*stride)
+			want := make([]uint8, 2*width)
+			for y := 0; y < 2; y++ {
+				for x := 0; x < width; x++ {
+					idx := uint8((x*7 + y*3) % len(p))
+					bit := x * bpp
+					rows[y*stride+bit/8] |= idx << uint(8-bpp-bit%8)
+					// rows are stored bottom-up
+					want[(1-y)*width+x] = idx
+				}
+			}
+
+			m, err := Decode(bytes.NewReader(rawFile(UnpackerKey{BitsPerPixel: bpp}, width, 2, p, rows)))
+			if err != nil {
+				t.Fatalf("%d bpp, width %d: %v", bpp, width, err)
+			}
+			if got := m.(*image.Paletted).Pix; !bytes.Equal(got, want) {
+				t.Errorf("%d bpp, width %d: got %v, want %v", bpp, width, got, want)
+			}
+		}
+	}
+}